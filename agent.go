@@ -188,9 +188,31 @@ func (c *controller) handleKeyChange(keys []*types.EncryptionKey) error {
 		return false
 	})
 
+	// The drivers may have derived table entries from the keys
+	c.updateDriverInfoInCluster()
+
 	return nil
 }
 
+// updateDriverInfoInCluster gossips again the driver table entries of the
+// endpoints joined on this node which their driver updated.
+func (c *controller) updateDriverInfoInCluster() {
+	c.Lock()
+	sandboxes := make([]*sandbox, 0, len(c.sandboxes))
+	for _, sb := range c.sandboxes {
+		sandboxes = append(sandboxes, sb)
+	}
+	c.Unlock()
+
+	for _, sb := range sandboxes {
+		for _, ep := range sb.getConnectedEndpoints() {
+			if err := ep.updateDriverInfoInCluster(); err != nil {
+				logrus.Warnf("Failed to update the driver table entries of endpoint %s: %v", ep.ID(), err)
+			}
+		}
+	}
+}
+
 func (c *controller) agentSetup(clusterProvider cluster.Provider) error {
 	agent := c.getAgent()
 
@@ -258,6 +280,60 @@ func (c *controller) getKeys(subsys string) ([][]byte, []uint64) {
 	return keys, tags
 }
 
+// getNetworkKeys returns the encryption keys of the network sorted as
+// getKeys does, the primary key first. No keys are returned if the keys of
+// the network are removed.
+func (c *controller) getNetworkKeys(nid string) ([][]byte, []uint64) {
+	c.Lock()
+	defer c.Unlock()
+
+	keys := [][]byte{}
+	tags := []uint64{}
+	if len(c.networkKeys[nid]) == 0 {
+		return keys, tags
+	}
+
+	sorted := append([]*types.EncryptionKey{}, c.networkKeys[nid]...)
+	sort.Sort(ByTime(sorted))
+	for _, key := range sorted {
+		keys = append(keys, key.Key)
+		tags = append(tags, key.LamportTime)
+	}
+
+	keys[0], keys[1] = keys[1], keys[0]
+	tags[0], tags[1] = tags[1], tags[0]
+	return keys, tags
+}
+
+// hasNetworkKeys returns whether the network is set encryption keys.
+func (c *controller) hasNetworkKeys(nid string) bool {
+	c.Lock()
+	defer c.Unlock()
+	_, ok := c.networkKeys[nid]
+	return ok
+}
+
+// pushNetworkKeys hands the current encryption keys of the network to its
+// driver.
+func (c *controller) pushNetworkKeys(n *network) error {
+	d, err := n.driver(true)
+	if err != nil {
+		return err
+	}
+
+	keyer, ok := d.(driverapi.NetworkKeyer)
+	if !ok {
+		return types.NotImplementedErrorf("driver %s does not support network encryption keys", n.networkType)
+	}
+
+	drvEnc := discoverapi.DriverEncryptionConfig{}
+	keys, tags := c.getNetworkKeys(n.id)
+	drvEnc.Keys = keys
+	drvEnc.Tags = tags
+
+	return keyer.SetNetworkKeys(n.id, drvEnc)
+}
+
 // getPrimaryKeyTag returns the primary key for a given subsystem from the
 // list of sorted key and the associated tag
 func (c *controller) getPrimaryKeyTag(subsys string) ([]byte, uint64, error) {
@@ -560,6 +636,42 @@ func (ep *endpoint) addDriverInfoToCluster() error {
 	return nil
 }
 
+func (ep *endpoint) updateDriverInfoInCluster() error {
+	n := ep.getNetwork()
+	if !n.isClusterEligible() {
+		return nil
+	}
+	if ep.joinInfo == nil {
+		return nil
+	}
+
+	agent := n.getController().getAgent()
+	if agent == nil {
+		return nil
+	}
+
+	d, err := n.driver(true)
+	if err != nil {
+		return err
+	}
+	updater, ok := d.(driverapi.TableEntryUpdater)
+	if !ok {
+		return nil
+	}
+
+	for _, te := range ep.joinInfo.driverTableEntries {
+		value, changed := updater.UpdateTableEntry(n.ID(), ep.ID(), te.tableName, te.key, te.value)
+		if !changed {
+			continue
+		}
+		if err := agent.networkDB.UpdateEntry(te.tableName, n.ID(), te.key, value); err != nil {
+			return err
+		}
+		te.value = value
+	}
+	return nil
+}
+
 func (ep *endpoint) deleteDriverInfoFromCluster() error {
 	n := ep.getNetwork()
 	if !n.isClusterEligible() {
@@ -620,6 +732,11 @@ func (ep *endpoint) addServiceInfoToCluster(sb *sandbox) error {
 		name = ep.MyAliases()[0]
 	}
 
+	var ip6 net.IP
+	if ep.Iface().AddressIPv6() != nil {
+		ip6 = ep.Iface().AddressIPv6().IP
+	}
+
 	var ingressPorts []*PortConfig
 	if ep.svcID != "" {
 		// This is a task part of a service
@@ -627,7 +744,7 @@ func (ep *endpoint) addServiceInfoToCluster(sb *sandbox) error {
 		if n.ingress {
 			ingressPorts = ep.ingressPorts
 		}
-		if err := c.addServiceBinding(ep.svcName, ep.svcID, n.ID(), ep.ID(), name, ep.virtualIP, ingressPorts, ep.svcAliases, ep.myAliases, ep.Iface().Address().IP, "addServiceInfoToCluster"); err != nil {
+		if err := c.addServiceBinding(ep.svcName, ep.svcID, n.ID(), ep.ID(), name, ep.virtualIP, ep.virtualIPv6, ingressPorts, ep.svcAliases, ep.myAliases, ep.Iface().Address().IP, ip6, ep.lbMode, true, "addServiceInfoToCluster"); err != nil {
 			return err
 		}
 	} else {
@@ -637,7 +754,7 @@ func (ep *endpoint) addServiceInfoToCluster(sb *sandbox) error {
 		}
 	}
 
-	buf, err := proto.Marshal(&EndpointRecord{
+	epRec := &EndpointRecord{
 		Name:            name,
 		ServiceName:     ep.svcName,
 		ServiceID:       ep.svcID,
@@ -647,7 +764,16 @@ func (ep *endpoint) addServiceInfoToCluster(sb *sandbox) error {
 		TaskAliases:     ep.myAliases,
 		EndpointIP:      ep.Iface().Address().IP.String(),
 		ServiceDisabled: false,
-	})
+		LBMode:          ep.lbMode,
+	}
+	if len(ep.virtualIPv6) != 0 {
+		epRec.VirtualIPv6 = ep.virtualIPv6.String()
+	}
+	if ip6 != nil {
+		epRec.EndpointIPv6 = ip6.String()
+	}
+
+	buf, err := proto.Marshal(epRec)
 	if err != nil {
 		return err
 	}
@@ -713,7 +839,11 @@ func (ep *endpoint) deleteServiceInfoFromCluster(sb *sandbox, fullRemove bool, m
 			if n.ingress {
 				ingressPorts = ep.ingressPorts
 			}
-			if err := c.rmServiceBinding(ep.svcName, ep.svcID, n.ID(), ep.ID(), name, ep.virtualIP, ingressPorts, ep.svcAliases, ep.myAliases, ep.Iface().Address().IP, "deleteServiceInfoFromCluster", true, fullRemove); err != nil {
+			var ip6 net.IP
+			if ep.Iface().AddressIPv6() != nil {
+				ip6 = ep.Iface().AddressIPv6().IP
+			}
+			if err := c.rmServiceBinding(ep.svcName, ep.svcID, n.ID(), ep.ID(), name, ep.virtualIP, ep.virtualIPv6, ingressPorts, ep.svcAliases, ep.myAliases, ep.Iface().Address().IP, ip6, "deleteServiceInfoFromCluster", true, fullRemove); err != nil {
 				return err
 			}
 		} else {
@@ -918,10 +1048,13 @@ func (c *controller) handleEpTableEvent(ev events.Event) {
 	svcName := epRec.ServiceName
 	svcID := epRec.ServiceID
 	vip := net.ParseIP(epRec.VirtualIP)
+	vip6 := net.ParseIP(epRec.VirtualIPv6)
 	ip := net.ParseIP(epRec.EndpointIP)
+	ip6 := net.ParseIP(epRec.EndpointIPv6)
 	ingressPorts := epRec.IngressPorts
 	serviceAliases := epRec.Aliases
 	taskAliases := epRec.TaskAliases
+	lbMode := epRec.LBMode
 
 	if containerName == "" || ip == nil {
 		logrus.Errorf("Invalid endpoint name/ip received while handling service table event %s", value)
@@ -933,7 +1066,7 @@ func (c *controller) handleEpTableEvent(ev events.Event) {
 		logrus.Debugf("handleEpTableEvent ADD %s R:%v", eid, epRec)
 		if svcID != "" {
 			// This is a remote task part of a service
-			if err := c.addServiceBinding(svcName, svcID, nid, eid, containerName, vip, ingressPorts, serviceAliases, taskAliases, ip, "handleEpTableEvent"); err != nil {
+			if err := c.addServiceBinding(svcName, svcID, nid, eid, containerName, vip, vip6, ingressPorts, serviceAliases, taskAliases, ip, ip6, lbMode, false, "handleEpTableEvent"); err != nil {
 				logrus.Errorf("failed adding service binding for %s epRec:%v err:%v", eid, epRec, err)
 				return
 			}
@@ -948,7 +1081,7 @@ func (c *controller) handleEpTableEvent(ev events.Event) {
 		logrus.Debugf("handleEpTableEvent DEL %s R:%v", eid, epRec)
 		if svcID != "" {
 			// This is a remote task part of a service
-			if err := c.rmServiceBinding(svcName, svcID, nid, eid, containerName, vip, ingressPorts, serviceAliases, taskAliases, ip, "handleEpTableEvent", true, true); err != nil {
+			if err := c.rmServiceBinding(svcName, svcID, nid, eid, containerName, vip, vip6, ingressPorts, serviceAliases, taskAliases, ip, ip6, "handleEpTableEvent", true, true); err != nil {
 				logrus.Errorf("failed removing service binding for %s epRec:%v err:%v", eid, epRec, err)
 				return
 			}
@@ -967,7 +1100,7 @@ func (c *controller) handleEpTableEvent(ev events.Event) {
 			return
 		}
 		// This is a remote task that is part of a service that is now disabled
-		if err := c.rmServiceBinding(svcName, svcID, nid, eid, containerName, vip, ingressPorts, serviceAliases, taskAliases, ip, "handleEpTableEvent", true, false); err != nil {
+		if err := c.rmServiceBinding(svcName, svcID, nid, eid, containerName, vip, vip6, ingressPorts, serviceAliases, taskAliases, ip, ip6, "handleEpTableEvent", true, false); err != nil {
 			logrus.Errorf("failed disabling service binding for %s epRec:%v err:%v", eid, epRec, err)
 			return
 		}
@@ -58,6 +58,29 @@ func (x PortConfig_Protocol) String() string {
 }
 func (PortConfig_Protocol) EnumDescriptor() ([]byte, []int) { return fileDescriptorAgent, []int{1, 0} }
 
+type PortConfig_TrafficPolicy int32
+
+const (
+	TrafficPolicyCluster PortConfig_TrafficPolicy = 0
+	TrafficPolicyLocal   PortConfig_TrafficPolicy = 1
+)
+
+var PortConfig_TrafficPolicy_name = map[int32]string{
+	0: "CLUSTER",
+	1: "LOCAL",
+}
+var PortConfig_TrafficPolicy_value = map[string]int32{
+	"CLUSTER": 0,
+	"LOCAL":   1,
+}
+
+func (x PortConfig_TrafficPolicy) String() string {
+	return proto.EnumName(PortConfig_TrafficPolicy_name, int32(x))
+}
+func (PortConfig_TrafficPolicy) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptorAgent, []int{1, 1}
+}
+
 // EndpointRecord specifies all the endpoint specific information that
 // needs to gossiped to nodes participating in the network.
 type EndpointRecord struct {
@@ -79,6 +102,12 @@ type EndpointRecord struct {
 	TaskAliases []string `protobuf:"bytes,8,rep,name=task_aliases,json=taskAliases" json:"task_aliases,omitempty"`
 	// Whether this enpoint's service has been disabled
 	ServiceDisabled bool `protobuf:"varint,9,opt,name=service_disabled,json=serviceDisabled,proto3" json:"service_disabled,omitempty"`
+	// IPv6 Virtual IP of the service to which this endpoint belongs.
+	VirtualIPv6 string `protobuf:"bytes,10,opt,name=virtual_ipv6,json=virtualIpv6,proto3" json:"virtual_ipv6,omitempty"`
+	// IPv6 address assigned to this endpoint.
+	EndpointIPv6 string `protobuf:"bytes,11,opt,name=endpoint_ipv6,json=endpointIpv6,proto3" json:"endpoint_ipv6,omitempty"`
+	// Load balancing mode of the service. Empty means NAT.
+	LBMode string `protobuf:"bytes,12,opt,name=lb_mode,json=lbMode,proto3" json:"lb_mode,omitempty"`
 }
 
 func (m *EndpointRecord) Reset()                    { *m = EndpointRecord{} }
@@ -148,6 +177,27 @@ func (m *EndpointRecord) GetServiceDisabled() bool {
 	return false
 }
 
+func (m *EndpointRecord) GetVirtualIPv6() string {
+	if m != nil {
+		return m.VirtualIPv6
+	}
+	return ""
+}
+
+func (m *EndpointRecord) GetEndpointIPv6() string {
+	if m != nil {
+		return m.EndpointIPv6
+	}
+	return ""
+}
+
+func (m *EndpointRecord) GetLBMode() string {
+	if m != nil {
+		return m.LBMode
+	}
+	return ""
+}
+
 // PortConfig specifies an exposed port which can be
 // addressed using the given name. This can be later queried
 // using a service discovery api or a DNS SRV query. The node
@@ -168,6 +218,11 @@ type PortConfig struct {
 	// system. If specified it should be within the node port
 	// range and it should be available.
 	PublishedPort uint32 `protobuf:"varint,4,opt,name=published_port,json=publishedPort,proto3" json:"published_port,omitempty"`
+	// TrafficPolicy specifies which tasks receive the traffic of
+	// the published port. With the local policy a node only
+	// forwards it to the tasks running on itself, and the client
+	// source address is preserved.
+	TrafficPolicy PortConfig_TrafficPolicy `protobuf:"varint,5,opt,name=traffic_policy,json=trafficPolicy,proto3,enum=libnetwork.PortConfig_TrafficPolicy" json:"traffic_policy,omitempty"`
 }
 
 func (m *PortConfig) Reset()                    { *m = PortConfig{} }
@@ -202,16 +257,24 @@ func (m *PortConfig) GetPublishedPort() uint32 {
 	return 0
 }
 
+func (m *PortConfig) GetTrafficPolicy() PortConfig_TrafficPolicy {
+	if m != nil {
+		return m.TrafficPolicy
+	}
+	return TrafficPolicyCluster
+}
+
 func init() {
 	proto.RegisterType((*EndpointRecord)(nil), "libnetwork.EndpointRecord")
 	proto.RegisterType((*PortConfig)(nil), "libnetwork.PortConfig")
 	proto.RegisterEnum("libnetwork.PortConfig_Protocol", PortConfig_Protocol_name, PortConfig_Protocol_value)
+	proto.RegisterEnum("libnetwork.PortConfig_TrafficPolicy", PortConfig_TrafficPolicy_name, PortConfig_TrafficPolicy_value)
 }
 func (this *EndpointRecord) GoString() string {
 	if this == nil {
 		return "nil"
 	}
-	s := make([]string, 0, 13)
+	s := make([]string, 0, 16)
 	s = append(s, "&libnetwork.EndpointRecord{")
 	s = append(s, "Name: "+fmt.Sprintf("%#v", this.Name)+",\n")
 	s = append(s, "ServiceName: "+fmt.Sprintf("%#v", this.ServiceName)+",\n")
@@ -224,6 +287,9 @@ func (this *EndpointRecord) GoString() string {
 	s = append(s, "Aliases: "+fmt.Sprintf("%#v", this.Aliases)+",\n")
 	s = append(s, "TaskAliases: "+fmt.Sprintf("%#v", this.TaskAliases)+",\n")
 	s = append(s, "ServiceDisabled: "+fmt.Sprintf("%#v", this.ServiceDisabled)+",\n")
+	s = append(s, "VirtualIPv6: "+fmt.Sprintf("%#v", this.VirtualIPv6)+",\n")
+	s = append(s, "EndpointIPv6: "+fmt.Sprintf("%#v", this.EndpointIPv6)+",\n")
+	s = append(s, "LBMode: "+fmt.Sprintf("%#v", this.LBMode)+",\n")
 	s = append(s, "}")
 	return strings.Join(s, "")
 }
@@ -231,12 +297,13 @@ func (this *PortConfig) GoString() string {
 	if this == nil {
 		return "nil"
 	}
-	s := make([]string, 0, 8)
+	s := make([]string, 0, 9)
 	s = append(s, "&libnetwork.PortConfig{")
 	s = append(s, "Name: "+fmt.Sprintf("%#v", this.Name)+",\n")
 	s = append(s, "Protocol: "+fmt.Sprintf("%#v", this.Protocol)+",\n")
 	s = append(s, "TargetPort: "+fmt.Sprintf("%#v", this.TargetPort)+",\n")
 	s = append(s, "PublishedPort: "+fmt.Sprintf("%#v", this.PublishedPort)+",\n")
+	s = append(s, "TrafficPolicy: "+fmt.Sprintf("%#v", this.TrafficPolicy)+",\n")
 	s = append(s, "}")
 	return strings.Join(s, "")
 }
@@ -345,6 +412,24 @@ func (m *EndpointRecord) MarshalTo(dAtA []byte) (int, error) {
 		}
 		i++
 	}
+	if len(m.VirtualIPv6) > 0 {
+		dAtA[i] = 0x52
+		i++
+		i = encodeVarintAgent(dAtA, i, uint64(len(m.VirtualIPv6)))
+		i += copy(dAtA[i:], m.VirtualIPv6)
+	}
+	if len(m.EndpointIPv6) > 0 {
+		dAtA[i] = 0x5a
+		i++
+		i = encodeVarintAgent(dAtA, i, uint64(len(m.EndpointIPv6)))
+		i += copy(dAtA[i:], m.EndpointIPv6)
+	}
+	if len(m.LBMode) > 0 {
+		dAtA[i] = 0x62
+		i++
+		i = encodeVarintAgent(dAtA, i, uint64(len(m.LBMode)))
+		i += copy(dAtA[i:], m.LBMode)
+	}
 	return i, nil
 }
 
@@ -384,6 +469,11 @@ func (m *PortConfig) MarshalTo(dAtA []byte) (int, error) {
 		i++
 		i = encodeVarintAgent(dAtA, i, uint64(m.PublishedPort))
 	}
+	if m.TrafficPolicy != 0 {
+		dAtA[i] = 0x28
+		i++
+		i = encodeVarintAgent(dAtA, i, uint64(m.TrafficPolicy))
+	}
 	return i, nil
 }
 
@@ -440,6 +530,18 @@ func (m *EndpointRecord) Size() (n int) {
 	if m.ServiceDisabled {
 		n += 2
 	}
+	l = len(m.VirtualIPv6)
+	if l > 0 {
+		n += 1 + l + sovAgent(uint64(l))
+	}
+	l = len(m.EndpointIPv6)
+	if l > 0 {
+		n += 1 + l + sovAgent(uint64(l))
+	}
+	l = len(m.LBMode)
+	if l > 0 {
+		n += 1 + l + sovAgent(uint64(l))
+	}
 	return n
 }
 
@@ -459,6 +561,9 @@ func (m *PortConfig) Size() (n int) {
 	if m.PublishedPort != 0 {
 		n += 1 + sovAgent(uint64(m.PublishedPort))
 	}
+	if m.TrafficPolicy != 0 {
+		n += 1 + sovAgent(uint64(m.TrafficPolicy))
+	}
 	return n
 }
 
@@ -489,6 +594,9 @@ func (this *EndpointRecord) String() string {
 		`Aliases:` + fmt.Sprintf("%v", this.Aliases) + `,`,
 		`TaskAliases:` + fmt.Sprintf("%v", this.TaskAliases) + `,`,
 		`ServiceDisabled:` + fmt.Sprintf("%v", this.ServiceDisabled) + `,`,
+		`VirtualIPv6:` + fmt.Sprintf("%v", this.VirtualIPv6) + `,`,
+		`EndpointIPv6:` + fmt.Sprintf("%v", this.EndpointIPv6) + `,`,
+		`LBMode:` + fmt.Sprintf("%v", this.LBMode) + `,`,
 		`}`,
 	}, "")
 	return s
@@ -502,6 +610,7 @@ func (this *PortConfig) String() string {
 		`Protocol:` + fmt.Sprintf("%v", this.Protocol) + `,`,
 		`TargetPort:` + fmt.Sprintf("%v", this.TargetPort) + `,`,
 		`PublishedPort:` + fmt.Sprintf("%v", this.PublishedPort) + `,`,
+		`TrafficPolicy:` + fmt.Sprintf("%v", this.TrafficPolicy) + `,`,
 		`}`,
 	}, "")
 	return s
@@ -797,6 +906,93 @@ func (m *EndpointRecord) Unmarshal(dAtA []byte) error {
 				}
 			}
 			m.ServiceDisabled = bool(v != 0)
+		case 10:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field VirtualIPv6", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowAgent
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthAgent
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.VirtualIPv6 = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 11:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field EndpointIPv6", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowAgent
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthAgent
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.EndpointIPv6 = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 12:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field LBMode", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowAgent
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthAgent
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.LBMode = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipAgent(dAtA[iNdEx:])
@@ -933,6 +1129,25 @@ func (m *PortConfig) Unmarshal(dAtA []byte) error {
 					break
 				}
 			}
+		case 5:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field TrafficPolicy", wireType)
+			}
+			m.TrafficPolicy = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowAgent
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.TrafficPolicy |= (PortConfig_TrafficPolicy(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
 		default:
 			iNdEx = preIndex
 			skippy, err := skipAgent(dAtA[iNdEx:])
@@ -1062,34 +1277,44 @@ var (
 func init() { proto.RegisterFile("agent.proto", fileDescriptorAgent) }
 
 var fileDescriptorAgent = []byte{
-	// 459 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x6c, 0x91, 0x31, 0x6f, 0xd3, 0x4c,
-	0x18, 0xc7, 0xe3, 0xc4, 0x6f, 0x1b, 0x3f, 0x4e, 0x52, 0xeb, 0xf4, 0x0a, 0x59, 0x1e, 0x1c, 0x13,
-	0x09, 0x29, 0x48, 0x28, 0x95, 0xca, 0xd8, 0x89, 0x26, 0x0c, 0x5e, 0x90, 0x75, 0x4d, 0x59, 0x83,
-	0x13, 0x1f, 0xe6, 0x54, 0xe3, 0xb3, 0xee, 0xae, 0x65, 0x65, 0x03, 0xf5, 0x3b, 0x74, 0xe2, 0xcb,
-	0x30, 0x32, 0x32, 0x55, 0xd4, 0x9f, 0x80, 0x95, 0x0d, 0xdd, 0xf9, 0xae, 0x11, 0x52, 0xb7, 0xf3,
-	0xef, 0xff, 0x3b, 0xeb, 0xb9, 0xff, 0x03, 0x7e, 0x5e, 0x92, 0x5a, 0x2e, 0x1a, 0xce, 0x24, 0x43,
-	0x50, 0xd1, 0x6d, 0x4d, 0xe4, 0x27, 0xc6, 0x2f, 0xa3, 0xff, 0x4b, 0x56, 0x32, 0x8d, 0x8f, 0xd5,
-	0xa9, 0x33, 0x66, 0x7f, 0xfa, 0x30, 0x79, 0x5d, 0x17, 0x0d, 0xa3, 0xb5, 0xc4, 0x64, 0xc7, 0x78,
-	0x81, 0x10, 0xb8, 0x75, 0xfe, 0x91, 0x84, 0x4e, 0xe2, 0xcc, 0x3d, 0xac, 0xcf, 0xe8, 0x29, 0x8c,
-	0x04, 0xe1, 0xd7, 0x74, 0x47, 0x36, 0x3a, 0xeb, 0xeb, 0xcc, 0x37, 0xec, 0x8d, 0x52, 0x5e, 0x00,
-	0x58, 0x85, 0x16, 0xe1, 0x40, 0x09, 0x67, 0xe3, 0xf6, 0x6e, 0xea, 0x9d, 0x77, 0x34, 0x5d, 0x61,
-	0xcf, 0x08, 0x69, 0xa1, 0xec, 0x6b, 0xca, 0xe5, 0x55, 0x5e, 0x6d, 0x68, 0x13, 0xba, 0x7b, 0xfb,
-	0x6d, 0x47, 0xd3, 0x0c, 0x7b, 0x46, 0x48, 0x1b, 0x74, 0x0c, 0x3e, 0x31, 0x43, 0x2a, 0xfd, 0x3f,
-	0xad, 0x4f, 0xda, 0xbb, 0x29, 0xd8, 0xd9, 0xd3, 0x0c, 0x83, 0x55, 0xd2, 0x06, 0x9d, 0xc2, 0x98,
-	0xd6, 0x25, 0x27, 0x42, 0x6c, 0x1a, 0xc6, 0xa5, 0x08, 0x0f, 0x92, 0xc1, 0xdc, 0x3f, 0x79, 0xb2,
-	0xd8, 0x17, 0xb2, 0xc8, 0x18, 0x97, 0x4b, 0x56, 0xbf, 0xa7, 0x25, 0x1e, 0x19, 0x59, 0x21, 0x81,
-	0x42, 0x38, 0xcc, 0x2b, 0x9a, 0x0b, 0x22, 0xc2, 0xc3, 0x64, 0x30, 0xf7, 0xb0, 0xfd, 0x54, 0x35,
-	0xc8, 0x5c, 0x5c, 0x6e, 0x6c, 0x3c, 0xd4, 0xb1, 0xaf, 0xd8, 0x2b, 0xa3, 0x3c, 0x87, 0xc0, 0xd6,
-	0x50, 0x50, 0x91, 0x6f, 0x2b, 0x52, 0x84, 0x5e, 0xe2, 0xcc, 0x87, 0xf8, 0xc8, 0xf0, 0x95, 0xc1,
-	0xb3, 0x2f, 0x7d, 0x80, 0xfd, 0x10, 0x8f, 0xf6, 0x7e, 0x0a, 0x43, 0xbd, 0xa7, 0x1d, 0xab, 0x74,
-	0xe7, 0x93, 0x93, 0xe9, 0xe3, 0x4f, 0x58, 0x64, 0x46, 0xc3, 0x0f, 0x17, 0xd0, 0x14, 0x7c, 0x99,
-	0xf3, 0x92, 0x48, 0xdd, 0x81, 0x5e, 0xc9, 0x18, 0x43, 0x87, 0xd4, 0x4d, 0xf4, 0x0c, 0x26, 0xcd,
-	0xd5, 0xb6, 0xa2, 0xe2, 0x03, 0x29, 0x3a, 0xc7, 0xd5, 0xce, 0xf8, 0x81, 0x2a, 0x6d, 0xf6, 0x0e,
-	0x86, 0xf6, 0xef, 0x28, 0x84, 0xc1, 0x7a, 0x99, 0x05, 0xbd, 0xe8, 0xe8, 0xe6, 0x36, 0xf1, 0x2d,
-	0x5e, 0x2f, 0x33, 0x95, 0x5c, 0xac, 0xb2, 0xc0, 0xf9, 0x37, 0xb9, 0x58, 0x65, 0x28, 0x02, 0xf7,
-	0x7c, 0xb9, 0xce, 0x82, 0x7e, 0x14, 0xdc, 0xdc, 0x26, 0x23, 0x1b, 0x29, 0x16, 0xb9, 0x5f, 0xbf,
-	0xc5, 0xbd, 0xb3, 0xf0, 0xe7, 0x7d, 0xdc, 0xfb, 0x7d, 0x1f, 0x3b, 0x9f, 0xdb, 0xd8, 0xf9, 0xde,
-	0xc6, 0xce, 0x8f, 0x36, 0x76, 0x7e, 0xb5, 0xb1, 0xb3, 0x3d, 0xd0, 0xaf, 0x79, 0xf9, 0x37, 0x00,
-	0x00, 0xff, 0xff, 0x55, 0x29, 0x75, 0x5c, 0xd7, 0x02, 0x00, 0x00,
+	// 617 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x74, 0x92, 0xcf, 0x6e, 0x9b, 0x4c,
+	0x14, 0xc5, 0x4d, 0xec, 0xf8, 0xcf, 0xc5, 0x38, 0x68, 0x14, 0x45, 0x88, 0x05, 0x10, 0x7f, 0x5f,
+	0x24, 0x57, 0xaa, 0x1c, 0xc9, 0x55, 0xbd, 0xc9, 0x2a, 0xc1, 0x59, 0x58, 0x75, 0x5b, 0x34, 0x71,
+	0xba, 0xa5, 0xd8, 0x4c, 0x5c, 0x14, 0xc2, 0x20, 0x98, 0x50, 0x75, 0xd7, 0x65, 0xeb, 0x77, 0xf0,
+	0xaa, 0xcf, 0xd0, 0x77, 0xe8, 0xb2, 0xcb, 0xae, 0xac, 0x86, 0x27, 0xe8, 0x23, 0x54, 0x0c, 0x60,
+	0xc7, 0x52, 0xba, 0x62, 0xe6, 0x9c, 0xdf, 0x15, 0x97, 0xc3, 0x01, 0xd1, 0x59, 0x90, 0x80, 0xf5,
+	0xc3, 0x88, 0x32, 0x8a, 0xc0, 0xf7, 0x66, 0x01, 0x61, 0x1f, 0x69, 0x74, 0xab, 0x1e, 0x2e, 0xe8,
+	0x82, 0x72, 0xf9, 0x34, 0x3b, 0xe5, 0x44, 0xf7, 0x6b, 0x0d, 0x3a, 0x97, 0x81, 0x1b, 0x52, 0x2f,
+	0x60, 0x98, 0xcc, 0x69, 0xe4, 0x22, 0x04, 0xb5, 0xc0, 0xb9, 0x23, 0x8a, 0x60, 0x08, 0xbd, 0x16,
+	0xe6, 0x67, 0x74, 0x0c, 0xed, 0x98, 0x44, 0x89, 0x37, 0x27, 0x36, 0xf7, 0xf6, 0xb8, 0x27, 0x16,
+	0xda, 0x9b, 0x0c, 0x79, 0x0e, 0x50, 0x22, 0x9e, 0xab, 0x54, 0x33, 0xe0, 0x42, 0x4a, 0xd7, 0x7a,
+	0xeb, 0x2a, 0x57, 0xc7, 0x23, 0xdc, 0x2a, 0x80, 0xb1, 0x9b, 0xd1, 0x89, 0x17, 0xb1, 0x7b, 0xc7,
+	0xb7, 0xbd, 0x50, 0xa9, 0x6d, 0xe9, 0x77, 0xb9, 0x3a, 0xb6, 0x70, 0xab, 0x00, 0xc6, 0x21, 0x3a,
+	0x05, 0x91, 0x14, 0x4b, 0x66, 0xf8, 0x3e, 0xc7, 0x3b, 0xe9, 0x5a, 0x87, 0x72, 0xf7, 0xb1, 0x85,
+	0xa1, 0x44, 0xc6, 0x21, 0x3a, 0x03, 0xc9, 0x0b, 0x16, 0x11, 0x89, 0x63, 0x3b, 0xa4, 0x11, 0x8b,
+	0x95, 0xba, 0x51, 0xed, 0x89, 0x83, 0xa3, 0xfe, 0x36, 0x90, 0xbe, 0x45, 0x23, 0x66, 0xd2, 0xe0,
+	0xc6, 0x5b, 0xe0, 0x76, 0x01, 0x67, 0x52, 0x8c, 0x14, 0x68, 0x38, 0xbe, 0xe7, 0xc4, 0x24, 0x56,
+	0x1a, 0x46, 0xb5, 0xd7, 0xc2, 0xe5, 0x35, 0x8b, 0x81, 0x39, 0xf1, 0xad, 0x5d, 0xda, 0x4d, 0x6e,
+	0x8b, 0x99, 0x76, 0x5e, 0x20, 0xcf, 0x40, 0x2e, 0x63, 0x70, 0xbd, 0xd8, 0x99, 0xf9, 0xc4, 0x55,
+	0x5a, 0x86, 0xd0, 0x6b, 0xe2, 0x83, 0x42, 0x1f, 0x15, 0x32, 0x1a, 0x40, 0x7b, 0x9b, 0x41, 0x32,
+	0x54, 0x80, 0x7f, 0xd6, 0x41, 0xba, 0xd6, 0xc5, 0x4d, 0x0a, 0xc9, 0x10, 0x8b, 0x9b, 0x1c, 0x92,
+	0x21, 0x7a, 0x09, 0xd2, 0xa3, 0x24, 0x92, 0xa1, 0x22, 0xf2, 0x21, 0x39, 0x5d, 0xeb, 0xed, 0x6d,
+	0x16, 0xc9, 0x10, 0xb7, 0xb7, 0x69, 0x24, 0x43, 0xf4, 0x1f, 0x34, 0xfc, 0x99, 0x7d, 0x47, 0x5d,
+	0xa2, 0xb4, 0xf9, 0x00, 0xa4, 0x6b, 0xbd, 0x3e, 0xb9, 0x78, 0x4d, 0x5d, 0x82, 0xeb, 0xfe, 0x2c,
+	0x7b, 0x76, 0xbf, 0x57, 0x01, 0xb6, 0xa1, 0x3c, 0xd9, 0x83, 0x33, 0x68, 0xf2, 0xde, 0xcc, 0xa9,
+	0xcf, 0x3b, 0xd0, 0x19, 0xe8, 0x4f, 0x47, 0xda, 0xb7, 0x0a, 0x0c, 0x6f, 0x06, 0x90, 0x0e, 0x22,
+	0x73, 0xa2, 0x05, 0x61, 0xfc, 0x9f, 0xf0, 0x8a, 0x48, 0x18, 0x72, 0x29, 0x9b, 0x44, 0x27, 0xd0,
+	0x09, 0xef, 0x67, 0xbe, 0x17, 0x7f, 0x20, 0x6e, 0xce, 0xd4, 0x38, 0x23, 0x6d, 0x54, 0x8e, 0xbd,
+	0x82, 0x0e, 0x8b, 0x9c, 0x9b, 0x1b, 0x6f, 0x6e, 0x87, 0xd4, 0xf7, 0xe6, 0x9f, 0x78, 0x21, 0x3a,
+	0x83, 0xff, 0xff, 0xb1, 0xca, 0x34, 0x87, 0x2d, 0xce, 0x62, 0x89, 0x3d, 0xbe, 0x76, 0xdf, 0x43,
+	0xb3, 0x5c, 0x15, 0x29, 0x50, 0x9d, 0x9a, 0x96, 0x5c, 0x51, 0x0f, 0x96, 0x2b, 0x43, 0x2c, 0xe5,
+	0xa9, 0x69, 0x65, 0xce, 0xf5, 0xc8, 0x92, 0x85, 0x5d, 0xe7, 0x7a, 0x64, 0x21, 0x15, 0x6a, 0x57,
+	0xe6, 0xd4, 0x92, 0xf7, 0x54, 0x79, 0xb9, 0x32, 0xda, 0xa5, 0x95, 0x69, 0x6a, 0xed, 0xcb, 0x37,
+	0xad, 0xd2, 0xb5, 0x41, 0xda, 0xd9, 0x00, 0x9d, 0x40, 0xc3, 0x9c, 0x5c, 0x5f, 0x4d, 0x2f, 0xb1,
+	0x5c, 0x51, 0x95, 0xe5, 0xca, 0x38, 0xdc, 0xf1, 0x4d, 0xff, 0x3e, 0x66, 0x24, 0x42, 0xc7, 0xb0,
+	0x3f, 0x79, 0x6b, 0x9e, 0x4f, 0x64, 0x41, 0x3d, 0x5a, 0xae, 0x0c, 0xb4, 0x03, 0x4d, 0xe8, 0xdc,
+	0xf1, 0xf3, 0x17, 0x5c, 0x28, 0xbf, 0x1e, 0xb4, 0xca, 0x9f, 0x07, 0x4d, 0xf8, 0x9c, 0x6a, 0xc2,
+	0x8f, 0x54, 0x13, 0x7e, 0xa6, 0x9a, 0xf0, 0x3b, 0xd5, 0x84, 0x59, 0x9d, 0x67, 0xff, 0xe2, 0xef,
+	0x00, 0x73, 0x08, 0x75, 0xfb, 0x15, 0x04, 0x00, 0x00,
 }
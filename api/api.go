@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"strconv"
 	"strings"
@@ -41,19 +42,29 @@ const (
 	sbPIDQr  = "{" + urlSbPID + ":" + qregx + "}"
 	cnIDQr   = "{" + urlCnID + ":" + qregx + "}"
 	cnPIDQr  = "{" + urlCnPID + ":" + qregx + "}"
+	pgID     = "{" + urlPgID + ":" + regex + "}"
+	pgNameQr = "{" + urlPgName + ":" + qregx + "}"
+	hpQr     = "{" + urlHostPort + ":[0-9]+}"
+	protoQr  = "{" + urlProto + ":tcp|udp|sctp}"
+	hipQr    = "{" + urlHostIP + ":[0-9a-fA-F.:]+}"
 
 	// Internal URL variable name.They can be anything as
 	// long as they do not collide with query fields.
-	urlNwName = "network-name"
-	urlNwID   = "network-id"
-	urlNwPID  = "network-partial-id"
-	urlEpName = "endpoint-name"
-	urlEpID   = "endpoint-id"
-	urlEpPID  = "endpoint-partial-id"
-	urlSbID   = "sandbox-id"
-	urlSbPID  = "sandbox-partial-id"
-	urlCnID   = "container-id"
-	urlCnPID  = "container-partial-id"
+	urlNwName   = "network-name"
+	urlNwID     = "network-id"
+	urlNwPID    = "network-partial-id"
+	urlEpName   = "endpoint-name"
+	urlEpID     = "endpoint-id"
+	urlEpPID    = "endpoint-partial-id"
+	urlSbID     = "sandbox-id"
+	urlSbPID    = "sandbox-partial-id"
+	urlCnID     = "container-id"
+	urlCnPID    = "container-partial-id"
+	urlPgID     = "peering-id"
+	urlPgName   = "peering-name"
+	urlHostPort = "host-port"
+	urlProto    = "proto"
+	urlHostIP   = "host-ip"
 )
 
 // NewHTTPHandler creates and initialize the HTTP handler to serve the requests for libnetwork
@@ -117,6 +128,13 @@ func (h *httpHandler) initRouter() {
 			{"/sandboxes", []string{"partial-id", sbPIDQr}, procGetSandboxes},
 			{"/sandboxes", nil, procGetSandboxes},
 			{"/sandboxes/" + sbID, nil, procGetSandbox},
+			{"/ports", []string{"port", hpQr, "proto", protoQr, "ip", hipQr}, procGetPorts},
+			{"/ports", []string{"port", hpQr, "proto", protoQr}, procGetPorts},
+			{"/ports", []string{"port", hpQr}, procGetPorts},
+			{"/ports", nil, procGetPorts},
+			{"/peerings", []string{"name", pgNameQr}, procGetPeerings},
+			{"/peerings", nil, procGetPeerings},
+			{"/peerings/" + pgID, nil, procGetPeering},
 		},
 		"POST": {
 			{"/networks", nil, procCreateNetwork},
@@ -125,6 +143,7 @@ func (h *httpHandler) initRouter() {
 			{"/services", nil, procPublishService},
 			{"/services/" + epID + "/backend", nil, procAttachBackend},
 			{"/sandboxes", nil, procCreateSandbox},
+			{"/peerings", nil, procCreatePeering},
 		},
 		"DELETE": {
 			{"/networks/" + nwID, nil, procDeleteNetwork},
@@ -133,6 +152,7 @@ func (h *httpHandler) initRouter() {
 			{"/services/" + epID, nil, procUnpublishService},
 			{"/services/" + epID + "/backend/" + sbID, nil, procDetachBackend},
 			{"/sandboxes/" + sbID, nil, procDeleteSandbox},
+			{"/peerings/" + pgID, nil, procDeletePeering},
 		},
 	}
 
@@ -207,6 +227,42 @@ func buildEndpointResource(ep libnetwork.Endpoint) *endpointResource {
 	return r
 }
 
+func buildPortMappingResource(pm libnetwork.PortMapping) *portMappingResource {
+	r := &portMappingResource{
+		Proto:         pm.Proto.String(),
+		HostPort:      pm.HostPort,
+		ContainerPort: pm.Port,
+		Network:       pm.NetworkID,
+		Endpoint:      pm.EndpointID,
+		Sandbox:       pm.SandboxID,
+		ContainerID:   pm.ContainerID,
+	}
+	if pm.HostIP != nil {
+		r.HostIP = pm.HostIP.String()
+	}
+	if pm.IP != nil {
+		r.ContainerIP = pm.IP.String()
+	}
+	return r
+}
+
+func buildPeeringResource(p libnetwork.NetworkPeering) *peeringResource {
+	r := &peeringResource{}
+	if p != nil {
+		r.Name = p.Name()
+		r.ID = p.ID()
+		r.Network1, r.Network2 = p.Networks()
+		for _, rule := range p.Rules() {
+			pr := peeringRule{Port: rule.Port}
+			if rule.Proto != 0 {
+				pr.Proto = rule.Proto.String()
+			}
+			r.Rules = append(r.Rules, pr)
+		}
+	}
+	return r
+}
+
 func buildSandboxResource(sb libnetwork.Sandbox) *sandboxResource {
 	r := &sandboxResource{}
 	if sb != nil {
@@ -813,6 +869,135 @@ func procDeleteSandbox(c libnetwork.NetworkController, vars map[string]string, b
 	return nil, &successResponse
 }
 
+/****************
+ Ports interface
+*****************/
+func procGetPorts(c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
+	pq, errRsp := findPortQuerier(c)
+	if !errRsp.isOK() {
+		return nil, errRsp
+	}
+
+	hp, ok := vars[urlHostPort]
+	if !ok {
+		var list []*portMappingResource
+		for _, pm := range pq.PortMappings() {
+			list = append(list, buildPortMappingResource(pm))
+		}
+		return list, &successResponse
+	}
+
+	port, err := strconv.ParseUint(hp, 10, 16)
+	if err != nil {
+		return nil, &badQueryResponse
+	}
+	proto := types.Protocol(types.TCP)
+	if p, ok := vars[urlProto]; ok {
+		proto = types.ParseProtocol(p)
+	}
+	var hostIP net.IP
+	if ip, ok := vars[urlHostIP]; ok {
+		if hostIP = net.ParseIP(ip); hostIP == nil {
+			return nil, &badQueryResponse
+		}
+	}
+
+	pm, err := pq.PortOwner(hostIP, proto, uint16(port))
+	if err != nil {
+		if _, ok := err.(types.NotFoundError); ok {
+			return nil, &responseStatus{Status: "Resource not found: Port", StatusCode: http.StatusNotFound}
+		}
+		return nil, convertNetworkError(err)
+	}
+	return buildPortMappingResource(pm), &successResponse
+}
+
+/******************
+ Peering interface
+*******************/
+func procCreatePeering(c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
+	var create peeringCreate
+
+	err := json.Unmarshal(body, &create)
+	if err != nil {
+		return "", &responseStatus{Status: "Invalid body: " + err.Error(), StatusCode: http.StatusBadRequest}
+	}
+
+	var rules []types.PeeringRule
+	for _, r := range create.Rules {
+		rule := types.PeeringRule{Port: r.Port}
+		if r.Proto != "" {
+			if rule.Proto = types.ParseProtocol(r.Proto); rule.Proto == 0 {
+				return "", &responseStatus{Status: "Invalid peering protocol: " + r.Proto, StatusCode: http.StatusBadRequest}
+			}
+		}
+		rules = append(rules, rule)
+	}
+
+	pc, errRsp := findPeeringController(c)
+	if !errRsp.isOK() {
+		return "", errRsp
+	}
+
+	nw1, errRsp := findPeeredNetwork(c, create.Network1)
+	if !errRsp.isOK() {
+		return "", errRsp
+	}
+	nw2, errRsp := findPeeredNetwork(c, create.Network2)
+	if !errRsp.isOK() {
+		return "", errRsp
+	}
+
+	p, err := pc.NewNetworkPeering(create.Name, nw1, nw2, rules)
+	if err != nil {
+		return "", convertNetworkError(err)
+	}
+
+	return p.ID(), &createdResponse
+}
+
+func procGetPeering(c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
+	p, errRsp := findPeering(c, vars[urlPgID])
+	if !errRsp.isOK() {
+		return nil, errRsp
+	}
+	return buildPeeringResource(p), &successResponse
+}
+
+func procGetPeerings(c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
+	pc, errRsp := findPeeringController(c)
+	if !errRsp.isOK() {
+		return nil, errRsp
+	}
+
+	var list []*peeringResource
+
+	if name, ok := vars[urlPgName]; ok {
+		if p, err := pc.NetworkPeeringByName(name); err == nil {
+			list = append(list, buildPeeringResource(p))
+		}
+		return list, &successResponse
+	}
+
+	for _, p := range pc.NetworkPeerings() {
+		list = append(list, buildPeeringResource(p))
+	}
+	return list, &successResponse
+}
+
+func procDeletePeering(c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
+	p, errRsp := findPeering(c, vars[urlPgID])
+	if !errRsp.isOK() {
+		return nil, errRsp
+	}
+
+	if err := p.Delete(); err != nil {
+		return nil, convertNetworkError(err)
+	}
+
+	return nil, &successResponse
+}
+
 /***********
   Utilities
 ************/
@@ -876,6 +1061,53 @@ func findNetwork(c libnetwork.NetworkController, s string, by int) (libnetwork.N
 	return nw, &successResponse
 }
 
+// findPeeredNetwork looks for a network to peer by name, then by id.
+func findPeeredNetwork(c libnetwork.NetworkController, s string) (libnetwork.Network, *responseStatus) {
+	if s == "" {
+		return nil, &responseStatus{Status: "Missing network to peer", StatusCode: http.StatusBadRequest}
+	}
+	if nw, errRsp := findNetwork(c, s, byName); errRsp.isOK() {
+		return nw, errRsp
+	}
+	return findNetwork(c, s, byID)
+}
+
+// findPeeringController returns the controller if it manages network peerings.
+func findPeeringController(c libnetwork.NetworkController) (libnetwork.PeeringController, *responseStatus) {
+	pc, ok := c.(libnetwork.PeeringController)
+	if !ok {
+		return nil, &responseStatus{Status: "Network peerings are not supported", StatusCode: http.StatusNotImplemented}
+	}
+	return pc, &successResponse
+}
+
+func findPortQuerier(c libnetwork.NetworkController) (libnetwork.PortQuerier, *responseStatus) {
+	pq, ok := c.(libnetwork.PortQuerier)
+	if !ok {
+		return nil, &responseStatus{Status: "Port queries are not supported", StatusCode: http.StatusNotImplemented}
+	}
+	return pq, &successResponse
+}
+
+// findPeering looks for a network peering by id, then by name.
+func findPeering(c libnetwork.NetworkController, s string) (libnetwork.NetworkPeering, *responseStatus) {
+	pc, errRsp := findPeeringController(c)
+	if !errRsp.isOK() {
+		return nil, errRsp
+	}
+	p, err := pc.NetworkPeeringByID(s)
+	if _, ok := err.(types.NotFoundError); ok {
+		p, err = pc.NetworkPeeringByName(s)
+	}
+	if err != nil {
+		if _, ok := err.(types.NotFoundError); ok {
+			return nil, &responseStatus{Status: "Resource not found: Peering", StatusCode: http.StatusNotFound}
+		}
+		return nil, &responseStatus{Status: err.Error(), StatusCode: http.StatusBadRequest}
+	}
+	return p, &successResponse
+}
+
 func findSandbox(c libnetwork.NetworkController, s string, by int) (libnetwork.Sandbox, *responseStatus) {
 	var (
 		sb  libnetwork.Sandbox
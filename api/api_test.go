@@ -2387,3 +2387,84 @@ func TestFieldRegex(t *testing.T) {
 		t.Fatal("Unexpected match")
 	}
 }
+
+func TestProcGetPorts(t *testing.T) {
+	defer testutils.SetupTestOSContext(t)()
+
+	// Cleanup local datastore file
+	os.Remove(datastore.DefaultScopes("")[datastore.LocalScope].Client.Address)
+
+	c, err := libnetwork.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	nw, err := c.NewNetwork(bridgeNetType, "network", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nw.Delete()
+
+	ep, err := nw.CreateEndpoint("endpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep.Delete(false)
+
+	sb, err := c.NewSandbox("container", libnetwork.OptionPortMapping(getPortMapping()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sb.Delete()
+
+	if err := ep.Join(sb); err != nil {
+		t.Fatal(err)
+	}
+	defer ep.Leave(sb)
+
+	vars := make(map[string]string)
+	l, errRsp := procGetPorts(c, vars, nil)
+	if errRsp != &successResponse {
+		t.Fatalf("Unexpected failure: %v", errRsp)
+	}
+	if list := l.([]*portMappingResource); len(list) != len(getPortMapping()) {
+		t.Fatalf("Unexpected port mappings: %v", list)
+	}
+
+	vars[urlHostPort] = "23000"
+	r, errRsp := procGetPorts(c, vars, nil)
+	if errRsp != &successResponse {
+		t.Fatalf("Unexpected failure: %v", errRsp)
+	}
+	pm := r.(*portMappingResource)
+	if pm.Endpoint != ep.ID() || pm.Sandbox != sb.ID() || pm.ContainerID != "container" || pm.ContainerPort != 230 {
+		t.Fatalf("Unexpected owner of the host port: %v", pm)
+	}
+
+	vars[urlProto] = "udp"
+	_, errRsp = procGetPorts(c, vars, nil)
+	if errRsp.StatusCode != http.StatusNotFound {
+		t.Fatalf("Expected StatusNotFound, got: %v", errRsp)
+	}
+
+	vars[urlProto] = "tcp"
+	vars[urlHostIP] = "127.0.0.1"
+	_, errRsp = procGetPorts(c, vars, nil)
+	if errRsp != &successResponse {
+		t.Fatalf("Unexpected failure: %v", errRsp)
+	}
+
+	vars[urlHostIP] = "::ffff:invalid"
+	_, errRsp = procGetPorts(c, vars, nil)
+	if errRsp != &badQueryResponse {
+		t.Fatalf("Expected badQueryResponse, got: %v", errRsp)
+	}
+
+	delete(vars, urlHostIP)
+	vars[urlHostPort] = "70000"
+	_, errRsp = procGetPorts(c, vars, nil)
+	if errRsp != &badQueryResponse {
+		t.Fatalf("Expected badQueryResponse, got: %v", errRsp)
+	}
+}
@@ -28,6 +28,35 @@ type sandboxResource struct {
 	ContainerID string `json:"container_id"`
 }
 
+// portMappingResource is the body of the "get ports" http response message
+type portMappingResource struct {
+	Proto         string `json:"proto"`
+	HostIP        string `json:"host_ip"`
+	HostPort      uint16 `json:"host_port"`
+	ContainerIP   string `json:"container_ip"`
+	ContainerPort uint16 `json:"container_port"`
+	Network       string `json:"network"`
+	Endpoint      string `json:"endpoint"`
+	Sandbox       string `json:"sandbox"`
+	ContainerID   string `json:"container_id"`
+}
+
+// peeringResource is the body of the "get peering" http response message
+type peeringResource struct {
+	Name     string        `json:"name"`
+	ID       string        `json:"id"`
+	Network1 string        `json:"network1"`
+	Network2 string        `json:"network2"`
+	Rules    []peeringRule `json:"rules"`
+}
+
+// peeringRule limits the traffic of a network peering to a protocol and a
+// destination port
+type peeringRule struct {
+	Proto string `json:"proto"`
+	Port  uint16 `json:"port"`
+}
+
 /***********
   Body types
   ************/
@@ -89,6 +118,14 @@ type serviceDelete struct {
 	Force bool   `json:"force"`
 }
 
+// peeringCreate is the expected body of the "create peering" http request message
+type peeringCreate struct {
+	Name     string        `json:"name"`
+	Network1 string        `json:"network1"`
+	Network2 string        `json:"network2"`
+	Rules    []peeringRule `json:"rules"`
+}
+
 // extraHost represents the extra host object
 type extraHost struct {
 	Name    string `json:"name"`
@@ -9,14 +9,15 @@ import (
 	"os/signal"
 	"syscall"
 
+	"github.com/docker/libnetwork/proxy"
 	"github.com/ishidawataru/sctp"
 )
 
 func main() {
 	f := os.NewFile(3, "signal-parent")
-	host, container := parseHostContainerAddrs()
+	host, container, config := parseHostContainerAddrs()
 
-	p, err := NewProxy(host, container)
+	p, err := proxy.NewProxy(host, container, config)
 	if err != nil {
 		fmt.Fprintf(f, "1\n%s", err)
 		f.Close()
@@ -31,14 +32,19 @@ func main() {
 }
 
 // parseHostContainerAddrs parses the flags passed on reexec to create the TCP/UDP/SCTP
-// net.Addrs to map the host and container ports
-func parseHostContainerAddrs() (host net.Addr, container net.Addr) {
+// net.Addrs to map the host and container ports, and the proxy configuration
+func parseHostContainerAddrs() (host net.Addr, container net.Addr, config proxy.Config) {
 	var (
 		proto         = flag.String("proto", "tcp", "proxy protocol")
 		hostIP        = flag.String("host-ip", "", "host ip")
 		hostPort      = flag.Int("host-port", -1, "host port")
 		containerIP   = flag.String("container-ip", "", "container ip")
 		containerPort = flag.Int("container-port", -1, "container port")
+		proxyProtoVer = flag.Int("proxy-protocol", 0, "PROXY protocol header version sent to the container (1 or 2, 0 to disable)")
+		maxConns      = flag.Int("max-connections", 0, "maximum number of concurrent connections or UDP flows (0 for no limit)")
+		idleTimeout   = flag.Duration("idle-timeout", 0, "close the TCP connections idle for this long (0 to disable)")
+		udpTimeout    = flag.Duration("udp-flow-timeout", proxy.UDPConnTrackTimeout, "close the UDP flows without reply for this long")
+		singleFamily  = flag.Bool("single-family", false, "do not accept the other IP family on the unspecified host ip")
 	)
 
 	flag.Parse()
@@ -57,10 +63,16 @@ func parseHostContainerAddrs() (host net.Addr, container net.Addr) {
 		log.Fatalf("unsupported protocol %s", *proto)
 	}
 
-	return host, container
+	return host, container, proxy.Config{
+		ProxyProtocol:  *proxyProtoVer,
+		MaxConnections: *maxConns,
+		IdleTimeout:    *idleTimeout,
+		UDPFlowTimeout: *udpTimeout,
+		SingleFamily:   *singleFamily,
+	}
 }
 
-func handleStopSignals(p Proxy) {
+func handleStopSignals(p proxy.Proxy) {
 	s := make(chan os.Signal, 10)
 	signal.Notify(s, os.Interrupt, syscall.SIGTERM)
 
@@ -2,6 +2,7 @@ package config
 
 import (
 	"strings"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/docker/docker/pkg/discovery"
@@ -42,6 +43,20 @@ type DaemonCfg struct {
 	ClusterProvider        cluster.Provider
 	NetworkControlPlaneMTU int
 	DefaultAddressPool     []*ipamutils.NetworkToSplit
+	// DynamicPortRanges are the host port ranges to allocate from, by host IP
+	DynamicPortRanges map[string]string
+	// ReservedPorts are the host ports, or port ranges, never allocated
+	// automatically
+	ReservedPorts []string
+	// FirewallBackend is the backend programming the firewall rules,
+	// iptables or nftables
+	FirewallBackend string
+	// FirewallVerifyInterval is the interval of the verification of the
+	// firewall rules, zero disables the verification
+	FirewallVerifyInterval time.Duration
+	// FirewallRepair re-programs the missing and misordered rules found by
+	// the verification, which only reports them otherwise
+	FirewallRepair bool
 }
 
 // ClusterCfg represents cluster configuration
@@ -119,6 +134,22 @@ func OptionDefaultAddressPoolConfig(addressPool []*ipamutils.NetworkToSplit) Opt
 	}
 }
 
+// OptionDynamicPortRanges function returns an option setter for the host port
+// ranges to allocate from, by host IP
+func OptionDynamicPortRanges(ranges map[string]string) Option {
+	return func(c *Config) {
+		c.Daemon.DynamicPortRanges = ranges
+	}
+}
+
+// OptionReservedPorts function returns an option setter for the host ports
+// excluded from the automatic allocation
+func OptionReservedPorts(ports []string) Option {
+	return func(c *Config) {
+		c.Daemon.ReservedPorts = ports
+	}
+}
+
 // OptionDriverConfig returns an option setter for driver configuration.
 func OptionDriverConfig(networkType string, config map[string]interface{}) Option {
 	return func(c *Config) {
@@ -236,6 +267,25 @@ func OptionExperimental(exp bool) Option {
 	}
 }
 
+// OptionFirewallBackend function returns an option setter for the backend
+// programming the firewall rules
+func OptionFirewallBackend(backend string) Option {
+	return func(c *Config) {
+		logrus.Debugf("Option FirewallBackend: %s", backend)
+		c.Daemon.FirewallBackend = strings.TrimSpace(backend)
+	}
+}
+
+// OptionFirewallVerify function returns an option setter for the periodic
+// verification of the firewall rules
+func OptionFirewallVerify(interval time.Duration, repair bool) Option {
+	return func(c *Config) {
+		logrus.Debugf("Option FirewallVerify: interval %v, repair %v", interval, repair)
+		c.Daemon.FirewallVerifyInterval = interval
+		c.Daemon.FirewallRepair = repair
+	}
+}
+
 // OptionNetworkControlPlaneMTU function returns an option setter for control plane MTU
 func OptionNetworkControlPlaneMTU(exp int) Option {
 	return func(c *Config) {
@@ -68,6 +68,7 @@ import (
 	"github.com/docker/libnetwork/ipamapi"
 	"github.com/docker/libnetwork/netlabel"
 	"github.com/docker/libnetwork/osl"
+	"github.com/docker/libnetwork/portallocator"
 	"github.com/docker/libnetwork/types"
 	"github.com/sirupsen/logrus"
 )
@@ -143,6 +144,24 @@ type NetworkController interface {
 	IsDiagnosticEnabled() bool
 }
 
+// NetworkKeyer is implemented by the network controllers supporting networks
+// encrypted with their own keys.
+type NetworkKeyer interface {
+	// SetNetworkKeys configures the encryption keys of the overlay data path
+	// of a network encrypted with its own keys, no keys remove them
+	SetNetworkKeys(nid string, keys []*types.EncryptionKey) error
+}
+
+// PortQuerier is implemented by the network controllers reporting the host
+// ports published by their endpoints.
+type PortQuerier interface {
+	// PortMappings returns the host ports published by the endpoints of this host.
+	PortMappings() []PortMapping
+
+	// PortOwner returns the mapping holding the host port. If not found, a types.NotFoundError is returned.
+	PortOwner(hostIP net.IP, proto types.Protocol, port uint16) (PortMapping, error)
+}
+
 // NetworkWalker is a client provided function which will be used to walk the Networks.
 // When the function returns true, the walk will stop.
 type NetworkWalker func(nw Network) bool
@@ -174,8 +193,10 @@ type controller struct {
 	agentInitDone          chan struct{}
 	agentStopDone          chan struct{}
 	keys                   []*types.EncryptionKey
+	networkKeys            map[string][]*types.EncryptionKey
 	clusterConfigAvailable bool
 	DiagnosticServer       *diagnostic.Server
+	firewallVerifyStop     chan struct{}
 	sync.Mutex
 }
 
@@ -192,11 +213,25 @@ func New(cfgOptions ...config.Option) (NetworkController, error) {
 		sandboxes:        sandboxTable{},
 		svcRecords:       make(map[string]svcInfo),
 		serviceBindings:  make(map[serviceKey]*service),
+		networkKeys:      make(map[string][]*types.EncryptionKey),
 		agentInitDone:    make(chan struct{}),
 		networkLocker:    locker.New(),
 		DiagnosticServer: diagnostic.New(),
 	}
 	c.DiagnosticServer.Init()
+	c.DiagnosticServer.RegisterHandler(c, portsDiagPaths2Func)
+
+	if err := c.setFirewallBackend(); err != nil {
+		return nil, err
+	}
+
+	portCfg, err := parsePortAllocatorConfig(c.cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := portallocator.Get().SetConfig(portAllocatorConfigSource, portCfg); err != nil {
+		return nil, err
+	}
 
 	if err := c.initStores(); err != nil {
 		return nil, err
@@ -246,11 +281,14 @@ func New(cfgOptions ...config.Option) (NetworkController, error) {
 	c.sandboxCleanup(c.cfg.ActiveSandboxes)
 	c.cleanupLocalEndpoints()
 	c.networkCleanup()
+	c.restoreNetworkPeerings()
 
 	if err := c.startExternalKeyListener(); err != nil {
 		return nil, err
 	}
 
+	c.startFirewallVerifier()
+
 	return c, nil
 }
 
@@ -307,6 +345,38 @@ func (c *controller) SetKeys(keys []*types.EncryptionKey) error {
 	return c.handleKeyChange(keys)
 }
 
+// SetNetworkKeys sets the key ring of the network and hands it to the
+// network driver. The keys of networks not created yet are handed to the
+// driver on creation.
+func (c *controller) SetNetworkKeys(nid string, keys []*types.EncryptionKey) error {
+	for _, key := range keys {
+		if key.Subsystem != subsysIPSec {
+			return types.BadRequestErrorf("network key received for unrecognized subsystem %s", key.Subsystem)
+		}
+	}
+	if len(keys) != 0 && len(keys) != keyringSize {
+		return types.BadRequestErrorf("incorrect number of keys for network %s", nid)
+	}
+
+	c.Lock()
+	if len(keys) == 0 {
+		delete(c.networkKeys, nid)
+	} else {
+		c.networkKeys[nid] = keys
+	}
+	c.Unlock()
+
+	n, err := c.NetworkByID(nid)
+	if err != nil {
+		if _, ok := err.(ErrNoSuchNetwork); ok {
+			return nil
+		}
+		return err
+	}
+
+	return c.pushNetworkKeys(n.(*network))
+}
+
 func (c *controller) getAgent() *agent {
 	c.Lock()
 	defer c.Unlock()
@@ -452,6 +522,14 @@ func (c *controller) makeDriverConfig(ntype string) map[string]interface{} {
 	return config
 }
 
+// portAllocatorConfigSource identifies the host port allocation settings of
+// the controller among the ones of the drivers
+const portAllocatorConfigSource = "controller"
+
+func parsePortAllocatorConfig(cfg *config.Config) (portallocator.Config, error) {
+	return portallocator.ParseConfig(cfg.Daemon.DynamicPortRanges, cfg.Daemon.ReservedPorts)
+}
+
 var procReloadConfig = make(chan (bool), 1)
 
 func (c *controller) ReloadConfiguration(cfgOptions ...config.Option) error {
@@ -463,6 +541,12 @@ func (c *controller) ReloadConfiguration(cfgOptions ...config.Option) error {
 	update := false
 	cfg := config.ParseConfigOptions(cfgOptions...)
 
+	// The host port allocation settings can be changed at any time
+	portCfg, err := parsePortAllocatorConfig(cfg)
+	if err != nil {
+		return types.BadRequestErrorf("cannot accept new configuration: %v", err)
+	}
+
 	for s := range c.cfg.Scopes {
 		if _, ok := cfg.Scopes[s]; !ok {
 			return types.ForbiddenErrorf("cannot accept new configuration because it removes an existing datastore client")
@@ -481,6 +565,29 @@ func (c *controller) ReloadConfiguration(cfgOptions ...config.Option) error {
 			update = true
 		}
 	}
+
+	if err := portallocator.Get().SetConfig(portAllocatorConfigSource, portCfg); err != nil {
+		return types.BadRequestErrorf("cannot accept new configuration: %v", err)
+	}
+	c.Lock()
+	c.cfg.Daemon.DynamicPortRanges = cfg.Daemon.DynamicPortRanges
+	c.cfg.Daemon.ReservedPorts = cfg.Daemon.ReservedPorts
+	for ntype, drvCfg := range cfg.Daemon.DriverCfg {
+		c.cfg.Daemon.DriverCfg[ntype] = drvCfg
+	}
+	c.Unlock()
+
+	// The drivers apply again the settings which can be changed at runtime
+	c.drvRegistry.WalkDrivers(func(name string, driver driverapi.Driver, capability driverapi.Capability) bool {
+		if _, ok := cfg.Daemon.DriverCfg[name]; !ok {
+			return false
+		}
+		if err := driver.DiscoverNew(discoverapi.DriverConfigUpdate, c.makeDriverConfig(name)); err != nil {
+			logrus.Errorf("Failed to reload the configuration of driver %s: %v", name, err)
+		}
+		return false
+	})
+
 	if !update {
 		return nil
 	}
@@ -979,6 +1086,12 @@ func (c *controller) addNetwork(n *network) error {
 		return err
 	}
 
+	if c.hasNetworkKeys(n.id) {
+		if err := c.pushNetworkKeys(n); err != nil {
+			logrus.Warnf("Failed to set the encryption keys of network %s: %v", n.name, err)
+		}
+	}
+
 	n.startResolver()
 
 	return nil
@@ -1299,6 +1412,7 @@ func (c *controller) getIPAMDriver(name string) (ipamapi.Ipam, *ipamapi.Capabili
 }
 
 func (c *controller) Stop() {
+	c.stopFirewallVerifier()
 	c.closeStores()
 	c.stopExternalKeyListener()
 	osl.GC()
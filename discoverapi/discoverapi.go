@@ -22,6 +22,9 @@ const (
 	EncryptionKeysConfig
 	// EncryptionKeysUpdate represents an update to the datapath encryption key(s)
 	EncryptionKeysUpdate
+	// DriverConfigUpdate represents a reload of the driver configuration, the
+	// data is the driver configuration map
+	DriverConfigUpdate
 )
 
 // NodeDiscoveryData represents the structure backing the node discovery data json string
@@ -5,6 +5,7 @@ import (
 
 	"github.com/docker/docker/pkg/plugingetter"
 	"github.com/docker/libnetwork/discoverapi"
+	"github.com/docker/libnetwork/types"
 )
 
 // NetworkPluginEndpointType represents the Endpoint Type used by Plugin system
@@ -89,6 +90,33 @@ type Driver interface {
 	IsBuiltIn() bool
 }
 
+// Peerer is implemented by the drivers which can allow the traffic between
+// two of their networks across the isolation of the networks.
+type Peerer interface {
+	// PeerNetworks allows the traffic between the two networks, limited
+	// to the peering rules if any.
+	PeerNetworks(peeringID, nid1, nid2 string, rules []types.PeeringRule) error
+
+	// UnpeerNetworks revokes the network peering.
+	UnpeerNetworks(peeringID string) error
+}
+
+// TableEntryUpdater is implemented by the drivers whose table entries of the
+// joined endpoints change after the join, for example with the encryption keys.
+type TableEntryUpdater interface {
+	// UpdateTableEntry returns the current value of the table entry of the
+	// endpoint, and whether it differs from the gossiped value.
+	UpdateTableEntry(nid, eid, tableName, key string, value []byte) ([]byte, bool)
+}
+
+// NetworkKeyer is implemented by the drivers encrypting the traffic of a
+// network with its own keys rather than with the cluster keys.
+type NetworkKeyer interface {
+	// SetNetworkKeys sets the encryption keys of the network, the primary
+	// key first. No keys remove the keys of the network.
+	SetNetworkKeys(nid string, keys discoverapi.DriverEncryptionConfig) error
+}
+
 // NetworkInfo provides a go interface for drivers to provide network
 // specific information to libnetwork.
 type NetworkInfo interface {
@@ -11,17 +11,20 @@ import (
 	"strconv"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/docker/libnetwork/datastore"
 	"github.com/docker/libnetwork/discoverapi"
 	"github.com/docker/libnetwork/driverapi"
-	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/firewall"
 	"github.com/docker/libnetwork/netlabel"
 	"github.com/docker/libnetwork/netutils"
 	"github.com/docker/libnetwork/ns"
 	"github.com/docker/libnetwork/options"
 	"github.com/docker/libnetwork/osl"
+	"github.com/docker/libnetwork/portallocator"
 	"github.com/docker/libnetwork/portmapper"
+	"github.com/docker/libnetwork/proxy"
 	"github.com/docker/libnetwork/types"
 	"github.com/sirupsen/logrus"
 	"github.com/vishvananda/netlink"
@@ -57,8 +60,22 @@ type iptablesCleanFuncs []iptableCleanFunc
 type configuration struct {
 	EnableIPForwarding  bool
 	EnableIPTables      bool
+	EnableIP6Tables     bool
 	EnableUserlandProxy bool
 	UserlandProxyPath   string
+	// UserlandProxyMode selects whether the userland proxies run as
+	// docker-proxy processes ("external", the default) or as goroutines of
+	// the daemon ("in-process").
+	UserlandProxyMode string
+	// Limits of the userland proxies, zero values keep the proxy defaults
+	UserlandProxyMaxConnections int
+	UserlandProxyIdleTimeout    time.Duration
+	UserlandProxyUDPFlowTimeout time.Duration
+	// DynamicPortRanges are the host port ranges to allocate from when a
+	// binding does not specify the host port, by host IP. ReservedPorts are
+	// never allocated automatically. Both combine with the daemon settings.
+	DynamicPortRanges map[string]string
+	ReservedPorts     []string
 }
 
 // networkConfiguration for network specific configuration
@@ -72,6 +89,14 @@ type networkConfiguration struct {
 	DefaultBindingIP     net.IP
 	DefaultBridge        bool
 	ContainerIfacePrefix string
+	// GatewayMode is nat, the default, or routed for the networks whose
+	// subnets are routed to the host
+	GatewayMode string
+	// EgressSNATIPv4 and EgressSNATIPv6 are the host addresses the
+	// egress traffic is source NATed to, instead of being masqueraded to
+	// the address of the outgoing interface
+	EgressSNATIPv4 net.IP
+	EgressSNATIPv6 net.IP
 	// Internal fields set after ipam data parsing
 	AddressIPv4        *net.IPNet
 	AddressIPv6        *net.IPNet
@@ -80,6 +105,25 @@ type networkConfiguration struct {
 	dbIndex            uint64
 	dbExists           bool
 	Internal           bool
+	// VlanFiltering enables the VLAN filtering on the bridge, the
+	// endpoints being then members of their own VLANs
+	VlanFiltering bool
+	// Uplink is the host interface added to the bridge, carrying the
+	// UplinkVlans tagged. With UplinkMoveIP its IP configuration is moved
+	// to the bridge, and recorded in UplinkIPConfig to be restored.
+	Uplink         string
+	UplinkVlans    []uint16
+	UplinkMoveIP   bool
+	UplinkIPConfig *uplinkIPConfig
+	// STP, MulticastSnooping and MulticastQuerier enable, or disable, the
+	// spanning tree protocol, the multicast snooping and the multicast
+	// querier of the bridge. AgeingTime is the ageing time of its
+	// forwarding entries, in seconds. The unset tunables keep the kernel
+	// defaults.
+	STP               *bool
+	MulticastSnooping *bool
+	MulticastQuerier  *bool
+	AgeingTime        *uint32
 
 	BridgeIfaceCreator ifaceCreator
 }
@@ -96,6 +140,16 @@ const (
 // endpointConfiguration represents the user specified configuration for the sandbox endpoint
 type endpointConfiguration struct {
 	MacAddress net.HardwareAddr
+	// AccessVlan is the VLAN of the endpoint, untagged on its bridge port
+	AccessVlan uint16
+	// TrunkVlans are the VLANs the endpoint carries tagged
+	TrunkVlans []uint16
+	// Hairpin, Isolated and NeighSuppress set the hairpin mode, the
+	// isolation and the neighbor suppression of the bridge port of the
+	// endpoint, when not nil
+	Hairpin       *bool
+	Isolated      *bool
+	NeighSuppress *bool
 }
 
 // containerConfiguration represents the user specified configuration for a container
@@ -114,6 +168,7 @@ type bridgeEndpoint struct {
 	id              string
 	nid             string
 	srcName         string
+	hostIfName      string
 	addr            *net.IPNet
 	addrv6          *net.IPNet
 	macAddress      net.HardwareAddr
@@ -139,11 +194,14 @@ type bridgeNetwork struct {
 type driver struct {
 	config          *configuration
 	network         *bridgeNetwork
-	natChain        *iptables.ChainInfo
-	filterChain     *iptables.ChainInfo
-	isolationChain1 *iptables.ChainInfo
-	isolationChain2 *iptables.ChainInfo
+	natChain        *firewall.Chain
+	filterChain     *firewall.Chain
+	isolationChain1 *firewall.Chain
+	isolationChain2 *firewall.Chain
+	natChainV6      *firewall.Chain
+	filterChainV6   *firewall.Chain
 	networks        map[string]*bridgeNetwork
+	peerings        map[string]*networkPeering
 	store           datastore.DataStore
 	nlh             *netlink.Handle
 	configNetwork   sync.Mutex
@@ -152,7 +210,7 @@ type driver struct {
 
 // New constructs a new bridge driver
 func newDriver() *driver {
-	return &driver{networks: map[string]*bridgeNetwork{}, config: &configuration{}}
+	return &driver{networks: map[string]*bridgeNetwork{}, peerings: map[string]*networkPeering{}, config: &configuration{}}
 }
 
 // Init registers a new instance of bridge driver
@@ -192,6 +250,38 @@ func (c *networkConfiguration) Validate() error {
 			return &ErrInvalidGateway{}
 		}
 	}
+
+	switch c.GatewayMode {
+	case "", gatewayModeNAT:
+	case gatewayModeRouted:
+		if c.EgressSNATIPv4 != nil || c.EgressSNATIPv6 != nil {
+			return types.BadRequestErrorf("egress SNAT addresses are not supported in the routed gateway mode")
+		}
+	default:
+		return types.BadRequestErrorf("invalid gateway mode: %s", c.GatewayMode)
+	}
+
+	// The egress SNAT addresses replace the masquerading
+	if c.EgressSNATIPv4 != nil || c.EgressSNATIPv6 != nil {
+		if !c.EnableIPMasquerade {
+			return types.BadRequestErrorf("egress SNAT addresses require IP masquerading")
+		}
+		if c.EgressSNATIPv4 != nil && c.EgressSNATIPv4.To4() == nil {
+			return types.BadRequestErrorf("egress SNAT address %s is not an IPv4 address", c.EgressSNATIPv4)
+		}
+		if c.EgressSNATIPv6 != nil && c.EgressSNATIPv6.To4() != nil {
+			return types.BadRequestErrorf("egress SNAT address %s is not an IPv6 address", c.EgressSNATIPv6)
+		}
+	}
+
+	// The uplink VLANs are only filtered on a VLAN filtering bridge
+	if len(c.UplinkVlans) > 0 && (c.Uplink == "" || !c.VlanFiltering) {
+		return types.BadRequestErrorf("uplink VLANs require an uplink and VLAN filtering")
+	}
+
+	if c.UplinkMoveIP && c.Uplink == "" {
+		return types.BadRequestErrorf("moving the uplink IP configuration requires an uplink")
+	}
 	return nil
 }
 
@@ -218,6 +308,11 @@ func (c *networkConfiguration) Conflicts(o *networkConfiguration) error {
 		return errors.New("networks have overlapping IPv6")
 	}
 
+	// An interface is the uplink of a single bridge
+	if c.Uplink != "" && c.Uplink == o.Uplink {
+		return errors.New("networks have same uplink")
+	}
+
 	return nil
 }
 
@@ -253,6 +348,46 @@ func (c *networkConfiguration) fromLabels(labels map[string]string) error {
 			}
 		case netlabel.ContainerIfacePrefix:
 			c.ContainerIfacePrefix = value
+		case GatewayMode:
+			c.GatewayMode = value
+		case EgressSNATIPv4:
+			if c.EgressSNATIPv4 = net.ParseIP(value); c.EgressSNATIPv4 == nil {
+				return parseErr(label, value, "nil ip")
+			}
+		case EgressSNATIPv6:
+			if c.EgressSNATIPv6 = net.ParseIP(value); c.EgressSNATIPv6 == nil {
+				return parseErr(label, value, "nil ip")
+			}
+		case VlanFiltering:
+			if c.VlanFiltering, err = strconv.ParseBool(value); err != nil {
+				return parseErr(label, value, err.Error())
+			}
+		case Uplink:
+			c.Uplink = value
+		case UplinkMoveIP:
+			if c.UplinkMoveIP, err = strconv.ParseBool(value); err != nil {
+				return parseErr(label, value, err.Error())
+			}
+		case UplinkVlans:
+			if c.UplinkVlans, err = parseVlanList(value); err != nil {
+				return parseErr(label, value, err.Error())
+			}
+		case STP:
+			if c.STP, err = parseTunableBool(value); err != nil {
+				return parseErr(label, value, err.Error())
+			}
+		case MulticastSnooping:
+			if c.MulticastSnooping, err = parseTunableBool(value); err != nil {
+				return parseErr(label, value, err.Error())
+			}
+		case MulticastQuerier:
+			if c.MulticastQuerier, err = parseTunableBool(value); err != nil {
+				return parseErr(label, value, err.Error())
+			}
+		case AgeingTime:
+			if c.AgeingTime, err = parseAgeingTime(value); err != nil {
+				return parseErr(label, value, err.Error())
+			}
 		}
 	}
 
@@ -267,7 +402,7 @@ func (n *bridgeNetwork) registerIptCleanFunc(clean iptableCleanFunc) {
 	n.iptCleanFuncs = append(n.iptCleanFuncs, clean)
 }
 
-func (n *bridgeNetwork) getDriverChains() (*iptables.ChainInfo, *iptables.ChainInfo, *iptables.ChainInfo, *iptables.ChainInfo, error) {
+func (n *bridgeNetwork) getDriverChains() (*firewall.Chain, *firewall.Chain, *firewall.Chain, *firewall.Chain, error) {
 	n.Lock()
 	defer n.Unlock()
 
@@ -278,6 +413,17 @@ func (n *bridgeNetwork) getDriverChains() (*iptables.ChainInfo, *iptables.ChainI
 	return n.driver.natChain, n.driver.filterChain, n.driver.isolationChain1, n.driver.isolationChain2, nil
 }
 
+func (n *bridgeNetwork) getDriverIP6Chains() (*firewall.Chain, *firewall.Chain, error) {
+	n.Lock()
+	defer n.Unlock()
+
+	if n.driver == nil {
+		return nil, nil, types.BadRequestErrorf("no driver found")
+	}
+
+	return n.driver.natChainV6, n.driver.filterChainV6, nil
+}
+
 func (n *bridgeNetwork) getNetworkBridgeName() string {
 	n.Lock()
 	config := n.config
@@ -308,40 +454,49 @@ func (n *bridgeNetwork) isolateNetwork(others []*bridgeNetwork, enable bool) err
 	thisConfig := n.config
 	n.Unlock()
 
+	n.driver.Lock()
+	driverConfig := n.driver.config
+	n.driver.Unlock()
+
 	if thisConfig.Internal {
 		return nil
 	}
 
 	// Install the rules to isolate this network against each of the other networks
-	return setINC(thisConfig.BridgeName, enable)
+	if err := setINC(firewall.IPv4, thisConfig.BridgeName, enable); err != nil {
+		return err
+	}
+	if driverConfig.EnableIP6Tables && thisConfig.EnableIPv6 {
+		return setINC(firewall.IPv6, thisConfig.BridgeName, enable)
+	}
+	return nil
 }
 
 func (d *driver) configure(option map[string]interface{}) error {
 	var (
 		config          *configuration
 		err             error
-		natChain        *iptables.ChainInfo
-		filterChain     *iptables.ChainInfo
-		isolationChain1 *iptables.ChainInfo
-		isolationChain2 *iptables.ChainInfo
+		natChain        *firewall.Chain
+		filterChain     *firewall.Chain
+		isolationChain1 *firewall.Chain
+		isolationChain2 *firewall.Chain
+		natChainV6      *firewall.Chain
+		filterChainV6   *firewall.Chain
 	)
 
-	genericData, ok := option[netlabel.GenericData]
-	if !ok || genericData == nil {
-		return nil
+	config, err = parseDriverConfig(option)
+	if config == nil || err != nil {
+		return err
 	}
 
-	switch opt := genericData.(type) {
-	case options.Generic:
-		opaqueConfig, err := options.GenerateFromModel(opt, &configuration{})
-		if err != nil {
-			return err
-		}
-		config = opaqueConfig.(*configuration)
-	case *configuration:
-		config = opt
+	switch portmapper.ProxyMode(config.UserlandProxyMode) {
+	case "", portmapper.ProxyModeExternal, portmapper.ProxyModeInProcess:
 	default:
-		return &ErrInvalidDriverConfig{}
+		return ErrInvalidUserlandProxyMode(config.UserlandProxyMode)
+	}
+
+	if err := setPortAllocatorConfig(config); err != nil {
+		return err
 	}
 
 	if config.EnableIPTables {
@@ -355,8 +510,14 @@ func (d *driver) configure(option map[string]interface{}) error {
 		if err != nil {
 			return err
 		}
-		// Make sure on firewall reload, first thing being re-played is chains creation
-		iptables.OnReloaded(func() { logrus.Debugf("Recreating iptables chains on firewall reload"); setupIPChains(config) })
+
+		if config.EnableIP6Tables {
+			removeIP6Chains()
+			natChainV6, filterChainV6, err = setupIP6Chains(config)
+			if err != nil {
+				return err
+			}
+		}
 	}
 
 	if config.EnableIPForwarding {
@@ -372,9 +533,18 @@ func (d *driver) configure(option map[string]interface{}) error {
 	d.filterChain = filterChain
 	d.isolationChain1 = isolationChain1
 	d.isolationChain2 = isolationChain2
+	d.natChainV6 = natChainV6
+	d.filterChainV6 = filterChainV6
 	d.config = config
 	d.Unlock()
 
+	// The rules of the networks are verified, and re-programmed on
+	// firewall reload, from the rule set of the driver. The verification
+	// is serialized with the setup of the networks.
+	if config.EnableIPTables {
+		firewall.RegisterRuleSetWithLock(networkType, d.ruleSet, &d.configNetwork)
+	}
+
 	err = d.initStore(option)
 	if err != nil {
 		return err
@@ -383,6 +553,64 @@ func (d *driver) configure(option map[string]interface{}) error {
 	return nil
 }
 
+// parseDriverConfig returns the configuration of the driver options, nil if
+// there is none.
+func parseDriverConfig(option map[string]interface{}) (*configuration, error) {
+	genericData, ok := option[netlabel.GenericData]
+	if !ok || genericData == nil {
+		return nil, nil
+	}
+
+	switch opt := genericData.(type) {
+	case options.Generic:
+		opaqueConfig, err := options.GenerateFromModel(opt, &configuration{})
+		if err != nil {
+			return nil, err
+		}
+		return opaqueConfig.(*configuration), nil
+	case *configuration:
+		return opt, nil
+	default:
+		return nil, &ErrInvalidDriverConfig{}
+	}
+}
+
+// setPortAllocatorConfig applies the host port allocation settings of the
+// driver configuration.
+func setPortAllocatorConfig(config *configuration) error {
+	portCfg, err := portallocator.ParseConfig(config.DynamicPortRanges, config.ReservedPorts)
+	if err != nil {
+		return types.BadRequestErrorf("invalid port allocation settings: %v", err)
+	}
+	if err := portallocator.Get().SetConfig(networkType, portCfg); err != nil {
+		return types.BadRequestErrorf("invalid port allocation settings: %v", err)
+	}
+	return nil
+}
+
+// reloadConfig applies the settings of a reloaded driver configuration which
+// can be changed at runtime, the host port allocation ones.
+func (d *driver) reloadConfig(option map[string]interface{}) error {
+	config, err := parseDriverConfig(option)
+	if err != nil {
+		return err
+	}
+	if config == nil {
+		config = &configuration{}
+	}
+	if err := setPortAllocatorConfig(config); err != nil {
+		return err
+	}
+
+	d.Lock()
+	if d.config != nil {
+		d.config.DynamicPortRanges = config.DynamicPortRanges
+		d.config.ReservedPorts = config.ReservedPorts
+	}
+	d.Unlock()
+	return nil
+}
+
 func (d *driver) getNetwork(id string) (*bridgeNetwork, error) {
 	d.Lock()
 	defer d.Unlock()
@@ -643,6 +871,10 @@ func (d *driver) createNetwork(config *networkConfiguration) error {
 		bridge:     bridgeIface,
 		driver:     d,
 	}
+	if err := network.portMapper.SetProxyMode(portmapper.ProxyMode(d.config.UserlandProxyMode)); err != nil {
+		return err
+	}
+	network.portMapper.SetProxyLimits(d.config.UserlandProxyMaxConnections, d.config.UserlandProxyIdleTimeout, d.config.UserlandProxyUDPFlowTimeout)
 
 	d.Lock()
 	d.networks[config.ID] = network
@@ -673,6 +905,15 @@ func (d *driver) createNetwork(config *networkConfiguration) error {
 		return nil
 	}
 
+	// Add the uplink interface to the bridge, remembering whether it was
+	// attached to remove it on failure of the next steps.
+	uplinkAttached := false
+	setupUplinkStep := func(config *networkConfiguration, i *bridgeInterface) error {
+		attached, err := setupUplink(config, i)
+		uplinkAttached = attached
+		return err
+	}
+
 	// Prepare the bridge setup configuration
 	bridgeSetup := newBridgeSetup(config, bridgeIface)
 
@@ -703,6 +944,15 @@ func (d *driver) createNetwork(config *networkConfiguration) error {
 		// the case of a previously existing device.
 		{bridgeAlreadyExists, setupVerifyAndReconcile},
 
+		// Enable the VLAN filtering on the bridge
+		{config.VlanFiltering, setupVlanFiltering},
+
+		// Add the uplink interface to the bridge
+		{config.Uplink != "", setupUplinkStep},
+
+		// Carry the uplink VLANs on the uplink interface
+		{config.Uplink != "", setupUplinkVlans},
+
 		// Enable IPv6 Forwarding
 		{enableIPv6Forwarding, setupIPv6Forwarding},
 
@@ -712,9 +962,8 @@ func (d *driver) createNetwork(config *networkConfiguration) error {
 		// Setup IPTables.
 		{d.config.EnableIPTables, network.setupIPTables},
 
-		//We want to track firewalld configuration so that
-		//if it is started/reloaded, the rules can be applied correctly
-		{d.config.EnableIPTables, network.setupFirewalld},
+		// Setup IP6Tables.
+		{d.config.EnableIPTables && d.config.EnableIP6Tables && config.EnableIPv6, network.setupIP6Tables},
 
 		// Setup DefaultGatewayIPv4
 		{config.DefaultGatewayIPv4 != nil, setupGatewayIPv4},
@@ -735,7 +984,16 @@ func (d *driver) createNetwork(config *networkConfiguration) error {
 
 	// Apply the prepared list of steps, and abort at the first error.
 	bridgeSetup.queueStep(setupDeviceUp)
-	return bridgeSetup.apply()
+	if err := bridgeSetup.apply(); err != nil {
+		if uplinkAttached {
+			if errRb := removeUplink(d.nlh, config); errRb != nil {
+				logrus.Warnf("Failed to remove uplink %s on network %s setup failure: %v", config.Uplink, config.ID, errRb)
+			}
+			config.UplinkIPConfig = nil
+		}
+		return err
+	}
+	return nil
 }
 
 func (d *driver) DeleteNetwork(nid string) error {
@@ -763,6 +1021,8 @@ func (d *driver) deleteNetwork(nid string) error {
 	config := n.config
 	n.Unlock()
 
+	d.unpeerNetwork(nid)
+
 	// delele endpoints belong to this network
 	for _, ep := range n.endpoints {
 		if err := n.releasePorts(ep); err != nil {
@@ -777,6 +1037,10 @@ func (d *driver) deleteNetwork(nid string) error {
 		if err := d.storeDelete(ep); err != nil {
 			logrus.Warnf("Failed to remove bridge endpoint %s from store: %v", ep.id[0:7], err)
 		}
+
+		if err := d.deletePortAllocations(ep); err != nil {
+			logrus.Warnf("Failed to remove port allocations of bridge endpoint %s from store: %v", ep.id[0:7], err)
+		}
 	}
 
 	d.Lock()
@@ -795,6 +1059,12 @@ func (d *driver) deleteNetwork(nid string) error {
 		}
 	}()
 
+	if config.Uplink != "" {
+		if err := removeUplink(d.nlh, config); err != nil {
+			logrus.Warnf("Failed to remove uplink on network %s delete: %v", nid, err)
+		}
+	}
+
 	switch config.BridgeIfaceCreator {
 	case ifaceCreatedByLibnetwork, ifaceCreatorUnknown:
 		// We only delete the bridge if it was created by the bridge driver and
@@ -916,6 +1186,13 @@ func (d *driver) CreateEndpoint(nid, eid string, ifInfo driverapi.InterfaceInfo,
 		return err
 	}
 
+	n.Lock()
+	nwConfig := n.config
+	n.Unlock()
+	if err = checkEndpointVlans(nwConfig, epConfig); err != nil {
+		return err
+	}
+
 	// Create and add the endpoint
 	n.Lock()
 	endpoint := &bridgeEndpoint{id: eid, nid: nid, config: epConfig}
@@ -1005,8 +1282,18 @@ func (d *driver) CreateEndpoint(nid, eid string, ifInfo driverapi.InterfaceInfo,
 		}
 	}
 
+	if epConfig != nil {
+		if err = setPortVlans(d.nlh, host, epConfig.AccessVlan, epConfig.TrunkVlans); err != nil {
+			return err
+		}
+		if err = setPortTunables(host, epConfig); err != nil {
+			return err
+		}
+	}
+
 	// Store the sandbox side pipe interface parameters
 	endpoint.srcName = containerIfName
+	endpoint.hostIfName = hostIfName
 	endpoint.macAddress = ifInfo.MacAddress()
 	endpoint.addr = ifInfo.Address()
 	endpoint.addrv6 = ifInfo.AddressIPv6()
@@ -1119,6 +1406,12 @@ func (d *driver) DeleteEndpoint(nid, eid string) error {
 		logrus.Warnf("Failed to remove bridge endpoint %s from store: %v", ep.id[0:7], err)
 	}
 
+	// The endpoint may still hold the reservations of a previous run
+	n.portMapper.Allocator.ReleaseReservations(portallocator.Owner{NetworkID: nid, EndpointID: eid})
+	if err := d.deletePortAllocations(ep); err != nil {
+		logrus.Warnf("Failed to remove port allocations of bridge endpoint %s from store: %v", ep.id[0:7], err)
+	}
+
 	return nil
 }
 
@@ -1169,6 +1462,7 @@ func (d *driver) EndpointOperInfo(nid, eid string) (map[string]interface{}, erro
 			pmc = append(pmc, pm.GetCopy())
 		}
 		m[netlabel.PortMap] = pmc
+		m[netlabel.PortMapProxyStats] = n.proxyStats(pmc)
 	}
 
 	if len(ep.macAddress) != 0 {
@@ -1178,6 +1472,20 @@ func (d *driver) EndpointOperInfo(nid, eid string) (map[string]interface{}, erro
 	return m, nil
 }
 
+// proxyStats returns the counters of the userland proxies of the bindings,
+// nil for the bindings without an in-process proxy.
+func (n *bridgeNetwork) proxyStats(bindings []types.PortBinding) []*proxy.Stats {
+	all := n.portMapper.ProxyStats()
+	stats := make([]*proxy.Stats, len(bindings))
+	for i, b := range bindings {
+		key := fmt.Sprintf("%s/%s", b.Proto.String(), net.JoinHostPort(b.HostIP.String(), strconv.Itoa(int(b.HostPort))))
+		if s, ok := all[key]; ok {
+			stats[i] = &s
+		}
+	}
+	return stats
+}
+
 // Join method is invoked when a Sandbox is attached to an endpoint.
 func (d *driver) Join(nid, eid string, sboxKey string, jinfo driverapi.JoinInfo, options map[string]interface{}) error {
 	defer osl.InitOSContext()()
@@ -1293,6 +1601,10 @@ func (d *driver) ProgramExternalConnectivity(nid, eid string, options map[string
 		return fmt.Errorf("failed to update bridge endpoint %s to store: %v", endpoint.id[0:7], err)
 	}
 
+	if err = d.storePortAllocations(network, endpoint); err != nil {
+		return fmt.Errorf("failed to update port allocations of bridge endpoint %s to store: %v", endpoint.id[0:7], err)
+	}
+
 	if !network.config.EnableICC {
 		return d.link(network, endpoint, true)
 	}
@@ -1335,6 +1647,10 @@ func (d *driver) RevokeExternalConnectivity(nid, eid string) error {
 		return fmt.Errorf("failed to update bridge endpoint %s to store: %v", endpoint.id[0:7], err)
 	}
 
+	if err = d.storePortAllocations(network, endpoint); err != nil {
+		return fmt.Errorf("failed to update port allocations of bridge endpoint %s to store: %v", endpoint.id[0:7], err)
+	}
+
 	return nil
 }
 
@@ -1362,21 +1678,20 @@ func (d *driver) link(network *bridgeNetwork, endpoint *bridgeEndpoint, enable b
 				return err
 			}
 
-			l := newLink(parentEndpoint.addr.IP.String(),
-				endpoint.addr.IP.String(),
-				ec.ExposedPorts, network.config.BridgeName)
-			if enable {
-				err = l.Enable()
-				if err != nil {
-					return err
-				}
-				defer func() {
+			for _, l := range d.newLinks(parentEndpoint, endpoint, ec.ExposedPorts, network.config.BridgeName) {
+				if enable {
+					err = l.Enable()
 					if err != nil {
-						l.Disable()
+						return err
 					}
-				}()
-			} else {
-				l.Disable()
+					defer func(l *link) {
+						if err != nil {
+							l.Disable()
+						}
+					}(l)
+				} else {
+					l.Disable()
+				}
 			}
 		}
 	}
@@ -1395,27 +1710,42 @@ func (d *driver) link(network *bridgeNetwork, endpoint *bridgeEndpoint, enable b
 			continue
 		}
 
-		l := newLink(endpoint.addr.IP.String(),
-			childEndpoint.addr.IP.String(),
-			childEndpoint.extConnConfig.ExposedPorts, network.config.BridgeName)
-		if enable {
-			err = l.Enable()
-			if err != nil {
-				return err
-			}
-			defer func() {
+		for _, l := range d.newLinks(endpoint, childEndpoint, childEndpoint.extConnConfig.ExposedPorts, network.config.BridgeName) {
+			if enable {
+				err = l.Enable()
 				if err != nil {
-					l.Disable()
+					return err
 				}
-			}()
-		} else {
-			l.Disable()
+				defer func(l *link) {
+					if err != nil {
+						l.Disable()
+					}
+				}(l)
+			} else {
+				l.Disable()
+			}
 		}
 	}
 
 	return nil
 }
 
+// newLinks returns the links between the parent and child endpoints, on
+// IPv6 as well when both endpoints have an IPv6 address and ip6tables is
+// enabled.
+func (d *driver) newLinks(parent, child *bridgeEndpoint, ports []types.TransportPort, bridgeName string) []*link {
+	links := []*link{newLink(parent.addr.IP.String(), child.addr.IP.String(), ports, bridgeName)}
+
+	d.Lock()
+	enableIP6Tables := d.config.EnableIP6Tables
+	d.Unlock()
+
+	if enableIP6Tables && parent.addrv6 != nil && child.addrv6 != nil {
+		links = append(links, newLink(parent.addrv6.IP.String(), child.addrv6.IP.String(), ports, bridgeName))
+	}
+	return links
+}
+
 func (d *driver) Type() string {
 	return networkType
 }
@@ -1426,7 +1756,14 @@ func (d *driver) IsBuiltIn() bool {
 
 // DiscoverNew is a notification for a new discovery event, such as a new node joining a cluster
 func (d *driver) DiscoverNew(dType discoverapi.DiscoveryType, data interface{}) error {
-	return nil
+	if dType != discoverapi.DriverConfigUpdate {
+		return nil
+	}
+	option, ok := data.(map[string]interface{})
+	if !ok {
+		return types.BadRequestErrorf("invalid driver configuration: %v", data)
+	}
+	return d.reloadConfig(option)
 }
 
 // DiscoverDelete is a notification for a discovery delete event, such as a node leaving a cluster
@@ -1449,6 +1786,50 @@ func parseEndpointOptions(epOptions map[string]interface{}) (*endpointConfigurat
 		}
 	}
 
+	if opt, ok := epOptions[EndpointVlan]; ok {
+		value, ok := opt.(string)
+		if !ok {
+			return nil, &ErrInvalidEndpointConfig{}
+		}
+		vid, err := parseVlan(value)
+		if err != nil {
+			return nil, err
+		}
+		ec.AccessVlan = vid
+	}
+
+	if opt, ok := epOptions[EndpointVlanTrunk]; ok {
+		value, ok := opt.(string)
+		if !ok {
+			return nil, &ErrInvalidEndpointConfig{}
+		}
+		vids, err := parseVlanList(value)
+		if err != nil {
+			return nil, err
+		}
+		ec.TrunkVlans = vids
+	}
+
+	for label, tunable := range map[string]**bool{
+		EndpointHairpin:       &ec.Hairpin,
+		EndpointIsolated:      &ec.Isolated,
+		EndpointNeighSuppress: &ec.NeighSuppress,
+	} {
+		opt, ok := epOptions[label]
+		if !ok {
+			continue
+		}
+		value, ok := opt.(string)
+		if !ok {
+			return nil, &ErrInvalidEndpointConfig{}
+		}
+		b, err := parseTunableBool(value)
+		if err != nil {
+			return nil, parseErr(label, value, err.Error())
+		}
+		*tunable = b
+	}
+
 	return ec, nil
 }
 
@@ -5,9 +5,11 @@ import (
 	"fmt"
 	"net"
 
+	"github.com/docker/docker/pkg/stringid"
 	"github.com/docker/libnetwork/datastore"
 	"github.com/docker/libnetwork/discoverapi"
 	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/portallocator"
 	"github.com/docker/libnetwork/types"
 	"github.com/sirupsen/logrus"
 )
@@ -18,6 +20,8 @@ const (
 	// prefix with different root
 	bridgePrefix         = "bridge"
 	bridgeEndpointPrefix = "bridge-endpoint"
+	// host port allocations of the endpoints, by endpoint
+	bridgePortAllocationsPrefix = "bridge-port-allocations"
 )
 
 func (d *driver) initStore(option map[string]interface{}) error {
@@ -37,10 +41,19 @@ func (d *driver) initStore(option map[string]interface{}) error {
 			return err
 		}
 
+		// Reserve the host ports of the previous run first, so that they
+		// are not handed out before their endpoints are restored
+		allocs, err := d.reservePortAllocations()
+		if err != nil {
+			return err
+		}
+
 		err = d.populateEndpoints()
 		if err != nil {
 			return err
 		}
+
+		d.gcPortAllocations(allocs)
 	}
 
 	return nil
@@ -57,6 +70,9 @@ func (d *driver) populateNetworks() error {
 		return nil
 	}
 
+	d.configNetwork.Lock()
+	defer d.configNetwork.Unlock()
+
 	for _, kvo := range kvol {
 		ncfg := kvo.(*networkConfiguration)
 		if err = d.createNetwork(ncfg); err != nil {
@@ -91,12 +107,103 @@ func (d *driver) populateEndpoints() error {
 		}
 		n.endpoints[ep.id] = ep
 		n.restorePortAllocations(ep)
+		if n.config.VlanFiltering {
+			n.restoreEndpointVlans(d.nlh, ep)
+		}
+		n.restoreEndpointTunables(d.nlh, ep)
 		logrus.Debugf("Endpoint (%s) restored to network (%s)", ep.id[0:7], ep.nid[0:7])
 	}
 
 	return nil
 }
 
+// reservePortAllocations reserves the host ports recorded in the store for
+// the binding they were allocated to. The records of the networks which were
+// not restored are deleted. It returns the records of the reservations.
+func (d *driver) reservePortAllocations() ([]*portAllocations, error) {
+	kvol, err := d.store.List(datastore.Key(bridgePortAllocationsPrefix), &portAllocations{})
+	if err != nil && err != datastore.ErrKeyNotFound {
+		return nil, fmt.Errorf("failed to get bridge port allocations from store: %v", err)
+	}
+
+	var allocs []*portAllocations
+	for _, kvo := range kvol {
+		pa := kvo.(*portAllocations)
+		// The bindings of a routed network are not allocated
+		if n, ok := d.networks[pa.nid]; !ok || n.config.routed() {
+			logrus.Debugf("Deleting stale port allocations of bridge endpoint (%s) from store", stringid.TruncateID(pa.id))
+			if err := d.storeDelete(pa); err != nil {
+				logrus.Debugf("Failed to delete stale port allocations of bridge endpoint (%s) from store", stringid.TruncateID(pa.id))
+			}
+			continue
+		}
+		for _, b := range pa.bindings {
+			owner := portallocator.Owner{NetworkID: pa.nid, EndpointID: pa.id, ContainerPort: int(b.Port)}
+			if err := portallocator.Get().Reserve(b.HostIP, b.Proto.String(), int(b.HostPort), owner); err != nil {
+				logrus.Warnf("Failed to reserve host port %s/%d of endpoint %s: %v", b.Proto.String(), b.HostPort, stringid.TruncateID(pa.id), err)
+			}
+		}
+		allocs = append(allocs, pa)
+	}
+
+	return allocs, nil
+}
+
+// gcPortAllocations releases the reservations of the endpoints which were
+// not restored, and deletes their records. The reservations of the restored
+// endpoints are kept until the endpoints map their ports again, or are
+// deleted.
+func (d *driver) gcPortAllocations(allocs []*portAllocations) {
+	for _, pa := range allocs {
+		if n, ok := d.networks[pa.nid]; ok {
+			if _, ok := n.endpoints[pa.id]; ok {
+				continue
+			}
+		}
+		portallocator.Get().ReleaseReservations(portallocator.Owner{NetworkID: pa.nid, EndpointID: pa.id})
+		logrus.Debugf("Deleting stale port allocations of bridge endpoint (%s) from store", stringid.TruncateID(pa.id))
+		if err := d.storeDelete(pa); err != nil {
+			logrus.Debugf("Failed to delete stale port allocations of bridge endpoint (%s) from store", stringid.TruncateID(pa.id))
+		}
+	}
+}
+
+// storePortAllocations records the host ports allocated to the endpoint, or
+// deletes the record when it has none.
+func (d *driver) storePortAllocations(n *bridgeNetwork, ep *bridgeEndpoint) error {
+	if d.store == nil || n.config.routed() {
+		return nil
+	}
+
+	if len(ep.portMapping) == 0 {
+		return d.deletePortAllocations(ep)
+	}
+
+	pa := &portAllocations{id: ep.id, nid: ep.nid}
+	if err := d.store.GetObject(datastore.Key(pa.Key()...), pa); err != nil && err != datastore.ErrKeyNotFound {
+		return err
+	}
+	pa.bindings = ep.portMapping
+	return d.storeUpdate(pa)
+}
+
+// deletePortAllocations deletes the record of the host ports allocated to the
+// endpoint, if any.
+func (d *driver) deletePortAllocations(ep *bridgeEndpoint) error {
+	if d.store == nil {
+		return nil
+	}
+
+	pa := &portAllocations{id: ep.id, nid: ep.nid}
+	if err := d.store.GetObject(datastore.Key(pa.Key()...), pa); err != nil {
+		if err == datastore.ErrKeyNotFound {
+			return nil
+		}
+		return err
+	}
+	return d.storeDelete(pa)
+}
+
 func (d *driver) storeUpdate(kvObject datastore.KVObject) error {
 	if d.store == nil {
 		logrus.Warnf("bridge store not initialized. kv object %s is not added to the store", datastore.Key(kvObject.Key()...))
@@ -145,6 +252,24 @@ func (ncfg *networkConfiguration) MarshalJSON() ([]byte, error) {
 	nMap["DefaultGatewayIPv6"] = ncfg.DefaultGatewayIPv6.String()
 	nMap["ContainerIfacePrefix"] = ncfg.ContainerIfacePrefix
 	nMap["BridgeIfaceCreator"] = ncfg.BridgeIfaceCreator
+	nMap["GatewayMode"] = ncfg.GatewayMode
+	if ncfg.EgressSNATIPv4 != nil {
+		nMap["EgressSNATIPv4"] = ncfg.EgressSNATIPv4.String()
+	}
+	if ncfg.EgressSNATIPv6 != nil {
+		nMap["EgressSNATIPv6"] = ncfg.EgressSNATIPv6.String()
+	}
+	nMap["VlanFiltering"] = ncfg.VlanFiltering
+	nMap["Uplink"] = ncfg.Uplink
+	nMap["UplinkVlans"] = ncfg.UplinkVlans
+	nMap["STP"] = ncfg.STP
+	nMap["MulticastSnooping"] = ncfg.MulticastSnooping
+	nMap["MulticastQuerier"] = ncfg.MulticastQuerier
+	nMap["AgeingTime"] = ncfg.AgeingTime
+	nMap["UplinkMoveIP"] = ncfg.UplinkMoveIP
+	if ncfg.UplinkIPConfig != nil {
+		nMap["UplinkIPConfig"] = ncfg.UplinkIPConfig
+	}
 
 	if ncfg.AddressIPv4 != nil {
 		nMap["AddressIPv4"] = ncfg.AddressIPv4.String()
@@ -201,6 +326,44 @@ func (ncfg *networkConfiguration) UnmarshalJSON(b []byte) error {
 		ncfg.BridgeIfaceCreator = ifaceCreator(v.(float64))
 	}
 
+	if v, ok := nMap["GatewayMode"]; ok {
+		ncfg.GatewayMode = v.(string)
+	}
+	if v, ok := nMap["EgressSNATIPv4"]; ok {
+		ncfg.EgressSNATIPv4 = net.ParseIP(v.(string))
+	}
+	if v, ok := nMap["EgressSNATIPv6"]; ok {
+		ncfg.EgressSNATIPv6 = net.ParseIP(v.(string))
+	}
+
+	if v, ok := nMap["VlanFiltering"]; ok {
+		ncfg.VlanFiltering = v.(bool)
+	}
+	if v, ok := nMap["Uplink"]; ok {
+		ncfg.Uplink = v.(string)
+	}
+	ncfg.STP = tunableBool(nMap["STP"])
+	ncfg.MulticastSnooping = tunableBool(nMap["MulticastSnooping"])
+	ncfg.MulticastQuerier = tunableBool(nMap["MulticastQuerier"])
+	if v, ok := nMap["AgeingTime"].(float64); ok {
+		at := uint32(v)
+		ncfg.AgeingTime = &at
+	}
+	if v, ok := nMap["UplinkVlans"]; ok && v != nil {
+		for _, vid := range v.([]interface{}) {
+			ncfg.UplinkVlans = append(ncfg.UplinkVlans, uint16(vid.(float64)))
+		}
+	}
+	if v, ok := nMap["UplinkMoveIP"]; ok {
+		ncfg.UplinkMoveIP = v.(bool)
+	}
+	if v, ok := nMap["UplinkIPConfig"]; ok {
+		d, _ := json.Marshal(v)
+		if err := json.Unmarshal(d, &ncfg.UplinkIPConfig); err != nil {
+			return types.InternalErrorf("failed to decode bridge network uplink IP configuration after json unmarshal: %v", err)
+		}
+	}
+
 	return nil
 }
 
@@ -260,6 +423,7 @@ func (ep *bridgeEndpoint) MarshalJSON() ([]byte, error) {
 	epMap["id"] = ep.id
 	epMap["nid"] = ep.nid
 	epMap["SrcName"] = ep.srcName
+	epMap["HostIfName"] = ep.hostIfName
 	epMap["MacAddress"] = ep.macAddress.String()
 	epMap["Addr"] = ep.addr.String()
 	if ep.addrv6 != nil {
@@ -301,6 +465,9 @@ func (ep *bridgeEndpoint) UnmarshalJSON(b []byte) error {
 	ep.id = epMap["id"].(string)
 	ep.nid = epMap["nid"].(string)
 	ep.srcName = epMap["SrcName"].(string)
+	if v, ok := epMap["HostIfName"]; ok {
+		ep.hostIfName = v.(string)
+	}
 	d, _ := json.Marshal(epMap["Config"])
 	if err := json.Unmarshal(d, &ep.config); err != nil {
 		logrus.Warnf("Failed to decode endpoint config %v", err)
@@ -378,11 +545,109 @@ func (n *bridgeNetwork) restorePortAllocations(ep *bridgeEndpoint) {
 		ep.extConnConfig.PortBindings == nil {
 		return
 	}
+	// The bindings of a routed network are the port openings themselves
+	if n.config.routed() {
+		if err := n.programOpenings(ep.portMapping, true); err != nil {
+			logrus.Warnf("Failed to restore the port openings of endpoint %s: %v", ep.id[0:7], err)
+		}
+		return
+	}
 	tmp := ep.extConnConfig.PortBindings
 	ep.extConnConfig.PortBindings = ep.portMapping
 	_, err := n.allocatePorts(ep, n.config.DefaultBindingIP, n.driver.config.EnableUserlandProxy)
 	if err != nil {
+		// The reservations are kept for the endpoint to map its ports again
 		logrus.Warnf("Failed to reserve existing port mapping for endpoint %s:%v", ep.id[0:7], err)
+	} else {
+		// Release the reservations of the ports the endpoint no longer maps
+		n.portMapper.Allocator.ReleaseReservations(portallocator.Owner{NetworkID: n.id, EndpointID: ep.id})
 	}
 	ep.extConnConfig.PortBindings = tmp
 }
+
+// portAllocations records the host ports allocated to the bindings of an
+// endpoint, to reserve them for it on restart.
+type portAllocations struct {
+	id       string
+	nid      string
+	bindings []types.PortBinding
+	dbIndex  uint64
+	dbExists bool
+}
+
+func (pa *portAllocations) MarshalJSON() ([]byte, error) {
+	paMap := make(map[string]interface{})
+	paMap["id"] = pa.id
+	paMap["nid"] = pa.nid
+	paMap["Bindings"] = pa.bindings
+	return json.Marshal(paMap)
+}
+
+func (pa *portAllocations) UnmarshalJSON(b []byte) error {
+	var paMap map[string]interface{}
+	if err := json.Unmarshal(b, &paMap); err != nil {
+		return fmt.Errorf("Failed to unmarshal to bridge port allocations: %v", err)
+	}
+
+	pa.id = paMap["id"].(string)
+	pa.nid = paMap["nid"].(string)
+	d, _ := json.Marshal(paMap["Bindings"])
+	if err := json.Unmarshal(d, &pa.bindings); err != nil {
+		logrus.Warnf("Failed to decode port allocations %v", err)
+	}
+
+	return nil
+}
+
+func (pa *portAllocations) Key() []string {
+	return []string{bridgePortAllocationsPrefix, pa.id}
+}
+
+func (pa *portAllocations) KeyPrefix() []string {
+	return []string{bridgePortAllocationsPrefix}
+}
+
+func (pa *portAllocations) Value() []byte {
+	b, err := json.Marshal(pa)
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+func (pa *portAllocations) SetValue(value []byte) error {
+	return json.Unmarshal(value, pa)
+}
+
+func (pa *portAllocations) Index() uint64 {
+	return pa.dbIndex
+}
+
+func (pa *portAllocations) SetIndex(index uint64) {
+	pa.dbIndex = index
+	pa.dbExists = true
+}
+
+func (pa *portAllocations) Exists() bool {
+	return pa.dbExists
+}
+
+func (pa *portAllocations) Skip() bool {
+	return false
+}
+
+func (pa *portAllocations) New() datastore.KVObject {
+	return &portAllocations{}
+}
+
+func (pa *portAllocations) CopyTo(o datastore.KVObject) error {
+	dstPa := o.(*portAllocations)
+	*dstPa = *pa
+	dstPa.bindings = make([]types.PortBinding, len(pa.bindings))
+	copy(dstPa.bindings, pa.bindings)
+	return nil
+}
+
+func (pa *portAllocations) DataScope() string {
+	return datastore.LocalScope
+}
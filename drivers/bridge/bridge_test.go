@@ -5,16 +5,19 @@ import (
 	"encoding/json"
 	"fmt"
 	"net"
+	"reflect"
 	"regexp"
 	"strconv"
 	"testing"
 
+	"github.com/docker/libnetwork/discoverapi"
 	"github.com/docker/libnetwork/driverapi"
 	"github.com/docker/libnetwork/ipamutils"
 	"github.com/docker/libnetwork/iptables"
 	"github.com/docker/libnetwork/netlabel"
 	"github.com/docker/libnetwork/netutils"
 	"github.com/docker/libnetwork/options"
+	"github.com/docker/libnetwork/portallocator"
 	"github.com/docker/libnetwork/testutils"
 	"github.com/docker/libnetwork/types"
 	"github.com/vishvananda/netlink"
@@ -35,7 +38,8 @@ func TestEndpointMarshalling(t *testing.T) {
 		addrv6:     ip2,
 		macAddress: mac,
 		srcName:    "veth123456",
-		config:     &endpointConfiguration{MacAddress: mac},
+		hostIfName: "veth654321",
+		config:     &endpointConfiguration{MacAddress: mac, AccessVlan: 10, TrunkVlans: []uint16{20, 30}},
 		containerConfig: &containerConfiguration{
 			ParentEndpoints: []string{"one", "due", "three"},
 			ChildEndpoints:  []string{"four", "five", "six"},
@@ -88,7 +92,7 @@ func TestEndpointMarshalling(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if e.id != ee.id || e.nid != ee.nid || e.srcName != ee.srcName || !bytes.Equal(e.macAddress, ee.macAddress) ||
+	if e.id != ee.id || e.nid != ee.nid || e.srcName != ee.srcName || e.hostIfName != ee.hostIfName || !bytes.Equal(e.macAddress, ee.macAddress) ||
 		!types.CompareIPNet(e.addr, ee.addr) || !types.CompareIPNet(e.addrv6, ee.addrv6) ||
 		!compareEpConfig(e.config, ee.config) ||
 		!compareContainerConfig(e.containerConfig, ee.containerConfig) ||
@@ -105,7 +109,8 @@ func compareEpConfig(a, b *endpointConfiguration) bool {
 	if a == nil || b == nil {
 		return false
 	}
-	return bytes.Equal(a.MacAddress, b.MacAddress)
+	return bytes.Equal(a.MacAddress, b.MacAddress) && a.AccessVlan == b.AccessVlan &&
+		reflect.DeepEqual(a.TrunkVlans, b.TrunkVlans)
 }
 
 func compareContainerConfig(a, b *containerConfiguration) bool {
@@ -1114,3 +1119,35 @@ func TestCreateParallel(t *testing.T) {
 		t.Fatalf("Success should be 1 instead: %d", success)
 	}
 }
+
+func TestReloadPortAllocatorConfig(t *testing.T) {
+	d := newDriver()
+	d.config = &configuration{}
+	defer portallocator.Get().SetConfig(networkType, portallocator.Config{})
+
+	hostIP := net.ParseIP("127.0.0.5")
+	reload := func(ranges map[string]string) error {
+		return d.DiscoverNew(discoverapi.DriverConfigUpdate, map[string]interface{}{
+			netlabel.GenericData: options.Generic{"DynamicPortRanges": ranges},
+		})
+	}
+
+	if err := reload(map[string]string{hostIP.String(): "40000-40001"}); err != nil {
+		t.Fatal(err)
+	}
+	port, err := portallocator.Get().RequestPort(hostIP, "tcp", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer portallocator.Get().ReleasePort(hostIP, "tcp", port)
+	if port != 40000 && port != 40001 {
+		t.Fatalf("Expected a port of the reloaded range, got %d", port)
+	}
+	if d.config.DynamicPortRanges[hostIP.String()] != "40000-40001" {
+		t.Fatalf("Unexpected driver port ranges %v", d.config.DynamicPortRanges)
+	}
+
+	if err := reload(map[string]string{hostIP.String(): "40001-40000"}); err == nil {
+		t.Fatal("Expected the reload of an invalid port range to fail")
+	}
+}
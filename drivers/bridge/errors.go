@@ -115,6 +115,26 @@ func (eim ErrInvalidMtu) Error() string {
 // BadRequest denotes the type of this error
 func (eim ErrInvalidMtu) BadRequest() {}
 
+// ErrInvalidUserlandProxyMode is returned when the userland proxy mode is not supported.
+type ErrInvalidUserlandProxyMode string
+
+func (eupm ErrInvalidUserlandProxyMode) Error() string {
+	return fmt.Sprintf("invalid userland proxy mode: %s", string(eupm))
+}
+
+// BadRequest denotes the type of this error
+func (eupm ErrInvalidUserlandProxyMode) BadRequest() {}
+
+// ErrInvalidVlan is returned when a VLAN ID or a VLAN list is not valid.
+type ErrInvalidVlan string
+
+func (eiv ErrInvalidVlan) Error() string {
+	return fmt.Sprintf("invalid VLAN: %s", string(eiv))
+}
+
+// BadRequest denotes the type of this error
+func (eiv ErrInvalidVlan) BadRequest() {}
+
 // ErrInvalidPort is returned when the container or host port specified in the port binding is not valid.
 type ErrInvalidPort string
 
@@ -15,4 +15,55 @@ const (
 
 	// DefaultBridge label
 	DefaultBridge = "com.docker.network.bridge.default_bridge"
+
+	// GatewayMode label is the gateway mode of the network, nat (default) or
+	// routed
+	GatewayMode = "com.docker.network.bridge.gateway_mode"
+
+	// EgressSNATIPv4 label is the host IPv4 address the egress traffic is
+	// source NATed to, instead of being masqueraded
+	EgressSNATIPv4 = "com.docker.network.bridge.egress_snat_ipv4"
+
+	// EgressSNATIPv6 label is the host IPv6 address the egress traffic is
+	// source NATed to
+	EgressSNATIPv6 = "com.docker.network.bridge.egress_snat_ipv6"
+
+	// VlanFiltering label enables the VLAN filtering on the bridge
+	VlanFiltering = "com.docker.network.bridge.vlan_filtering"
+
+	// Uplink label names a host interface to add to the bridge
+	Uplink = "com.docker.network.bridge.uplink"
+
+	// UplinkMoveIP label moves the IP configuration of the uplink to the bridge
+	UplinkMoveIP = "com.docker.network.bridge.uplink_move_ip"
+
+	// UplinkVlans label lists the VLANs the uplink carries tagged, as "10,20,100-110"
+	UplinkVlans = "com.docker.network.bridge.uplink_vlans"
+
+	// STP label enables, or disables, the spanning tree protocol on the bridge
+	STP = "com.docker.network.bridge.stp"
+
+	// MulticastSnooping label enables, or disables, the multicast snooping on the bridge
+	MulticastSnooping = "com.docker.network.bridge.multicast_snooping"
+
+	// MulticastQuerier label enables, or disables, the multicast querier of the bridge
+	MulticastQuerier = "com.docker.network.bridge.multicast_querier"
+
+	// AgeingTime label is the ageing time of the bridge forwarding entries, in seconds
+	AgeingTime = "com.docker.network.bridge.ageing_time"
+
+	// EndpointVlan label is the access VLAN of an endpoint, untagged on its port
+	EndpointVlan = "com.docker.network.bridge.endpoint.vlan"
+
+	// EndpointVlanTrunk label lists the VLANs an endpoint carries tagged
+	EndpointVlanTrunk = "com.docker.network.bridge.endpoint.vlan_trunk"
+
+	// EndpointHairpin label enables, or disables, the hairpin mode of the bridge port of an endpoint
+	EndpointHairpin = "com.docker.network.bridge.endpoint.hairpin"
+
+	// EndpointIsolated label isolates the bridge port of an endpoint from the other isolated ports
+	EndpointIsolated = "com.docker.network.bridge.endpoint.isolated"
+
+	// EndpointNeighSuppress label enables the ARP and ND suppression on the bridge port of an endpoint
+	EndpointNeighSuppress = "com.docker.network.bridge.endpoint.neigh_suppress"
 )
@@ -4,7 +4,7 @@ import (
 	"fmt"
 	"net"
 
-	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/firewall"
 	"github.com/docker/libnetwork/types"
 	"github.com/sirupsen/logrus"
 )
@@ -36,7 +36,7 @@ func (l *link) Enable() error {
 		return linkContainers("-A", l.parentIP, l.childIP, l.ports, l.bridge, false)
 	}
 
-	iptables.OnReloaded(func() { linkFunction() })
+	firewall.Get(firewall.IPv4).OnReloaded(func() { linkFunction() })
 	return linkFunction()
 }
 
@@ -52,15 +52,15 @@ func (l *link) Disable() {
 
 func linkContainers(action, parentIP, childIP string, ports []types.TransportPort, bridge string,
 	ignoreErrors bool) error {
-	var nfAction iptables.Action
+	var nfAction firewall.Action
 
 	switch action {
 	case "-A":
-		nfAction = iptables.Append
+		nfAction = firewall.Append
 	case "-I":
-		nfAction = iptables.Insert
+		nfAction = firewall.Insert
 	case "-D":
-		nfAction = iptables.Delete
+		nfAction = firewall.Delete
 	default:
 		return InvalidIPTablesCfgError(action)
 	}
@@ -74,7 +74,11 @@ func linkContainers(action, parentIP, childIP string, ports []types.TransportPor
 		return InvalidLinkIPAddrError(childIP)
 	}
 
-	chain := iptables.ChainInfo{Name: DockerChain}
+	family := firewall.IPv4
+	if ip1.To4() == nil {
+		family = firewall.IPv6
+	}
+	chain := firewall.Chain{Name: DockerChain, Table: firewall.Filter, Backend: firewall.Get(family)}
 	for _, port := range ports {
 		err := chain.Link(nfAction, ip1, ip2, int(port.Port), port.Proto.String(), bridge)
 		if !ignoreErrors && err != nil {
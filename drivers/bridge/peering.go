@@ -0,0 +1,172 @@
+package bridge
+
+import (
+	"fmt"
+
+	"github.com/docker/libnetwork/firewall"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+// networkPeering allows the traffic between the bridges of two networks
+// across their isolation.
+type networkPeering struct {
+	id       string
+	nid1     string
+	nid2     string
+	bridge1  string
+	bridge2  string
+	rules    []types.PeeringRule
+	families []firewall.Family
+}
+
+// peeringRules returns the rules exempting the traffic between the two
+// bridges from the isolation, in the firewall of the family. Without
+// peering rules all the traffic is exempted, else only the connections
+// matching them and their replies.
+func peeringRules(family firewall.Family, bridge1, bridge2 string, rules []types.PeeringRule) []iptRule {
+	var rs []iptRule
+	for _, dir := range [][2]string{{bridge1, bridge2}, {bridge2, bridge1}} {
+		in, out := dir[0], dir[1]
+		if len(rules) == 0 {
+			rs = append(rs, iptRule{family: family, table: firewall.Filter, chain: IsolationChain1, rule: firewall.Rule{InIface: in, OutIface: out, Jump: "RETURN"}})
+			continue
+		}
+		rs = append(rs, iptRule{family: family, table: firewall.Filter, chain: IsolationChain1, rule: firewall.Rule{InIface: in, OutIface: out, CtState: []string{"RELATED", "ESTABLISHED"}, Jump: "RETURN"}})
+		for _, r := range rules {
+			rule := firewall.Rule{InIface: in, OutIface: out, DstPort: int(r.Port), Jump: "RETURN"}
+			if r.Proto != 0 {
+				rule.Proto = r.Proto.String()
+				if r.Proto == types.ICMP && family == firewall.IPv6 {
+					rule.Proto = "icmpv6"
+				}
+			}
+			rs = append(rs, iptRule{family: family, table: firewall.Filter, chain: IsolationChain1, rule: rule})
+		}
+	}
+	return rs
+}
+
+// programPeering adds, or removes, the isolation exceptions of the peering
+// in the firewall of each of its families.
+func programPeering(p *networkPeering, enable bool) error {
+	for i, family := range p.families {
+		tx := firewall.Get(family).NewTransaction()
+		for _, rule := range peeringRules(family, p.bridge1, p.bridge2, p.rules) {
+			addChainRule(tx, rule, enable)
+		}
+		if err := tx.Commit(); err != nil {
+			if enable && i > 0 {
+				// Rollback the exceptions added in the previous families
+				if err := programPeering(&networkPeering{bridge1: p.bridge1, bridge2: p.bridge2, rules: p.rules, families: p.families[:i]}, false); err != nil {
+					logrus.Warnf("Failed to rollback the peering %s: %v", p.id, err)
+				}
+			}
+			return fmt.Errorf("unable to %s the %s peering between %s and %s: %v", operationName(enable), family, p.bridge1, p.bridge2, err)
+		}
+	}
+	return nil
+}
+
+// PeerNetworks allows the traffic between the two bridge networks.
+func (d *driver) PeerNetworks(peeringID, nid1, nid2 string, rules []types.PeeringRule) error {
+	if nid1 == nid2 {
+		return types.BadRequestErrorf("cannot peer network %s with itself", nid1)
+	}
+
+	// Serialize with the setup of the networks and the firewall verifier
+	d.configNetwork.Lock()
+	defer d.configNetwork.Unlock()
+
+	n1, err := d.getNetwork(nid1)
+	if err != nil {
+		return err
+	}
+	n2, err := d.getNetwork(nid2)
+	if err != nil {
+		return err
+	}
+
+	n1.Lock()
+	config1 := n1.config
+	n1.Unlock()
+	n2.Lock()
+	config2 := n2.config
+	n2.Unlock()
+
+	if config1.Internal || config2.Internal {
+		return types.ForbiddenErrorf("cannot peer an internal network")
+	}
+
+	d.Lock()
+	_, exists := d.peerings[peeringID]
+	config := d.config
+	d.Unlock()
+
+	if exists {
+		return types.ForbiddenErrorf("network peering %s already exists", peeringID)
+	}
+
+	p := &networkPeering{
+		id:      peeringID,
+		nid1:    nid1,
+		nid2:    nid2,
+		bridge1: config1.BridgeName,
+		bridge2: config2.BridgeName,
+		rules:   rules,
+	}
+	// Without isolation rules there is nothing to except
+	if config.EnableIPTables {
+		p.families = append(p.families, firewall.IPv4)
+		if config.EnableIP6Tables && config1.EnableIPv6 && config2.EnableIPv6 {
+			p.families = append(p.families, firewall.IPv6)
+		}
+	}
+
+	if err := programPeering(p, true); err != nil {
+		return err
+	}
+
+	d.Lock()
+	d.peerings[peeringID] = p
+	d.Unlock()
+	return nil
+}
+
+// UnpeerNetworks revokes the peering between two bridge networks.
+func (d *driver) UnpeerNetworks(peeringID string) error {
+	d.configNetwork.Lock()
+	defer d.configNetwork.Unlock()
+
+	d.Lock()
+	p, ok := d.peerings[peeringID]
+	if ok {
+		delete(d.peerings, peeringID)
+	}
+	d.Unlock()
+
+	if !ok {
+		return types.NotFoundErrorf("network peering not found: %s", peeringID)
+	}
+	return programPeering(p, false)
+}
+
+// unpeerNetwork revokes the peerings of a network being deleted. It is
+// called with configNetwork held.
+func (d *driver) unpeerNetwork(nid string) {
+	var peerings []*networkPeering
+	d.Lock()
+	for id, p := range d.peerings {
+		if p.nid1 == nid || p.nid2 == nid {
+			peerings = append(peerings, p)
+			delete(d.peerings, id)
+		}
+	}
+	d.Unlock()
+
+	for _, p := range peerings {
+		if err := programPeering(p, false); err != nil {
+			logrus.Warnf("Failed to revoke the peering %s of network %s: %v", p.id, nid, err)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package bridge
+
+import (
+	"net"
+	"testing"
+
+	"github.com/docker/libnetwork/firewall"
+	"github.com/docker/libnetwork/portmapper"
+	"github.com/docker/libnetwork/types"
+)
+
+func TestPeeringRules(t *testing.T) {
+	rules := peeringRules(firewall.IPv4, "br1", "br2", nil)
+	if len(rules) != 2 {
+		t.Fatalf("Expected 2 unrestricted peering rules, got %d", len(rules))
+	}
+	for i, dir := range [][2]string{{"br1", "br2"}, {"br2", "br1"}} {
+		r := rules[i]
+		if r.chain != IsolationChain1 || r.rule.InIface != dir[0] || r.rule.OutIface != dir[1] || r.rule.Jump != "RETURN" || r.rule.Proto != "" {
+			t.Fatalf("Unexpected peering rule in %s: %s", r.chain, r.rule)
+		}
+	}
+
+	rules = peeringRules(firewall.IPv6, "br1", "br2", []types.PeeringRule{{Proto: types.TCP, Port: 80}, {Proto: types.ICMP}})
+	if len(rules) != 6 {
+		t.Fatalf("Expected 6 restricted peering rules, got %d", len(rules))
+	}
+	var replies, web, icmp int
+	for _, r := range rules {
+		switch {
+		case len(r.rule.CtState) > 0:
+			replies++
+		case r.rule.Proto == "tcp" && r.rule.DstPort == 80:
+			web++
+		case r.rule.Proto == "icmpv6":
+			icmp++
+		default:
+			t.Fatalf("Unexpected peering rule: %s", r.rule)
+		}
+	}
+	if replies != 2 || web != 2 || icmp != 2 {
+		t.Fatalf("Unexpected peering rules: %d replies, %d tcp/80, %d icmpv6", replies, web, icmp)
+	}
+}
+
+func TestPeeringRuleSet(t *testing.T) {
+	fw4 := firewall.Get(firewall.IPv4)
+	d := &driver{
+		config:      &configuration{EnableIPTables: true},
+		natChain:    &firewall.Chain{Name: DockerChain, Table: firewall.Nat, Backend: fw4},
+		filterChain: &firewall.Chain{Name: DockerChain, Table: firewall.Filter, Backend: fw4},
+		networks:    map[string]*bridgeNetwork{},
+		peerings:    map[string]*networkPeering{},
+	}
+	for _, name := range []string{"br1", "br2"} {
+		d.networks[name] = &bridgeNetwork{
+			id:         name,
+			config:     &networkConfiguration{BridgeName: name},
+			portMapper: portmapper.New(""),
+			bridge: &bridgeInterface{
+				bridgeIPv4: &net.IPNet{IP: net.ParseIP(iptablesTestBridgeIP), Mask: net.CIDRMask(24, 32)},
+			},
+		}
+	}
+
+	rules := []types.PeeringRule{{Proto: types.TCP, Port: 443}}
+	d.peerings["p1"] = &networkPeering{id: "p1", nid1: "br1", nid2: "br2", bridge1: "br1", bridge2: "br2", rules: rules, families: []firewall.Family{firewall.IPv4}}
+
+	set := d.ruleSet()
+	for _, r := range peeringRules(firewall.IPv4, "br1", "br2", rules) {
+		found := false
+		for _, e := range set.Rules {
+			if e.Family == firewall.IPv4 && e.Chain == IsolationChain1 && e.Position == firewall.Top && e.Rule.String() == r.rule.String() {
+				found = true
+			}
+		}
+		if !found {
+			t.Fatalf("Missing peering expectation: %s", r.rule)
+		}
+	}
+
+	d.unpeerNetwork("br2")
+	if len(d.peerings) != 0 {
+		t.Fatal("Expected the peering to be revoked with its network")
+	}
+}
+
+func TestPeerNetworksValidation(t *testing.T) {
+	d := newDriver()
+	d.networks["n1"] = &bridgeNetwork{id: "n1", config: &networkConfiguration{BridgeName: "br1"}}
+	d.networks["n2"] = &bridgeNetwork{id: "n2", config: &networkConfiguration{BridgeName: "br2", Internal: true}}
+
+	if err := d.PeerNetworks("p1", "n1", "n1", nil); err == nil {
+		t.Fatal("Expected an error peering a network with itself")
+	}
+	if _, ok := d.PeerNetworks("p1", "n1", "n3", nil).(types.NotFoundError); !ok {
+		t.Fatal("Expected a not found error peering an unknown network")
+	}
+	if _, ok := d.PeerNetworks("p1", "n1", "n2", nil).(types.ForbiddenError); !ok {
+		t.Fatal("Expected a forbidden error peering an internal network")
+	}
+
+	// Without iptables there are no isolation rules to except
+	d.networks["n2"].config.Internal = false
+	if err := d.PeerNetworks("p1", "n1", "n2", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := d.PeerNetworks("p1", "n1", "n2", nil).(types.ForbiddenError); !ok {
+		t.Fatal("Expected a forbidden error adding an existing peering")
+	}
+	if err := d.UnpeerNetworks("p1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := d.UnpeerNetworks("p1").(types.NotFoundError); !ok {
+		t.Fatal("Expected a not found error revoking an unknown peering")
+	}
+}
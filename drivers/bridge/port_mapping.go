@@ -6,13 +6,15 @@ import (
 	"fmt"
 	"net"
 
+	"github.com/docker/libnetwork/portallocator"
 	"github.com/docker/libnetwork/types"
 	"github.com/ishidawataru/sctp"
 	"github.com/sirupsen/logrus"
 )
 
 var (
-	defaultBindingIP = net.IPv4(0, 0, 0, 0)
+	defaultBindingIP   = net.IPv4(0, 0, 0, 0)
+	defaultBindingIPv6 = net.IPv6unspecified
 )
 
 func (n *bridgeNetwork) allocatePorts(ep *bridgeEndpoint, reqDefBindIP net.IP, ulPxyEnabled bool) ([]types.PortBinding, error) {
@@ -20,36 +22,119 @@ func (n *bridgeNetwork) allocatePorts(ep *bridgeEndpoint, reqDefBindIP net.IP, u
 		return nil, nil
 	}
 
+	if n.config.routed() {
+		return n.openPorts(ep)
+	}
+
 	defHostIP := defaultBindingIP
 	if reqDefBindIP != nil {
 		defHostIP = reqDefBindIP
 	}
 
-	return n.allocatePortsInternal(ep.extConnConfig.PortBindings, ep.addr.IP, defHostIP, ulPxyEnabled)
+	var containerIPv6 net.IP
+	if ep.addrv6 != nil {
+		containerIPv6 = ep.addrv6.IP
+	}
+
+	// The bindings without host IP are also published on IPv6 when the
+	// ip6tables rules of the network are programmed
+	dualStack := n.driver.config.EnableIP6Tables && n.config.EnableIPv6 && containerIPv6 != nil && defHostIP.Equal(defaultBindingIP)
+
+	owner := portallocator.Owner{NetworkID: n.id, EndpointID: ep.id}
+	return n.allocatePortsInternal(ep.extConnConfig.PortBindings, ep.addr.IP, containerIPv6, defHostIP, ulPxyEnabled, dualStack, owner)
 }
 
-func (n *bridgeNetwork) allocatePortsInternal(bindings []types.PortBinding, containerIP, defHostIP net.IP, ulPxyEnabled bool) ([]types.PortBinding, error) {
+// allocatePortsInternal maps the bindings to the container address of the
+// family of their host address. With dualStack, the bindings without host
+// address are published on the same host port on both families.
+func (n *bridgeNetwork) allocatePortsInternal(bindings []types.PortBinding, containerIP, containerIPv6, defHostIP net.IP, ulPxyEnabled, dualStack bool, owner portallocator.Owner) ([]types.PortBinding, error) {
 	bs := make([]types.PortBinding, 0, len(bindings))
 	for _, c := range bindings {
-		b := c.GetCopy()
-		if err := n.allocatePort(&b, containerIP, defHostIP, ulPxyEnabled); err != nil {
-			// On allocation failure, release previously allocated ports. On cleanup error, just log a warning message
-			if cuErr := n.releasePortsInternal(bs); cuErr != nil {
-				logrus.Warnf("Upon allocation failure for %v, failed to clear previously allocated port bindings: %v", b, cuErr)
+		var (
+			b   = c.GetCopy()
+			err error
+		)
+		// The ports reserved for the endpoint are matched to their binding
+		// by container port
+		owner.ContainerPort = int(c.Port)
+		if len(c.HostIP) == 0 && dualStack {
+			b6 := c.GetCopy()
+			if err = n.allocateDualStackPort(&b, &b6, containerIP, containerIPv6, ulPxyEnabled, owner); err == nil {
+				bs = append(bs, b, b6)
+				continue
+			}
+		} else {
+			hostIP := c.HostIP
+			if len(hostIP) == 0 {
+				hostIP = defHostIP
+			}
+			cIP := containerIP
+			if hostIP.To4() == nil && containerIPv6 != nil {
+				cIP = containerIPv6
 			}
-			return nil, err
+			// The unspecified addresses of both families are mapped
+			// separately when publishing on both of them
+			singleFamily := dualStack && hostIP.IsUnspecified()
+			if err = n.allocatePort(&b, cIP, defHostIP, ulPxyEnabled, singleFamily, owner); err == nil {
+				bs = append(bs, b)
+				continue
+			}
+		}
+		// On allocation failure, release previously allocated ports. On cleanup error, just log a warning message
+		if cuErr := n.releasePortsInternal(bs); cuErr != nil {
+			logrus.Warnf("Upon allocation failure for %v, failed to clear previously allocated port bindings: %v", b, cuErr)
 		}
-		bs = append(bs, b)
+		return nil, err
 	}
 	return bs, nil
 }
 
-func (n *bridgeNetwork) allocatePort(bnd *types.PortBinding, containerIP, defHostIP net.IP, ulPxyEnabled bool) error {
+// allocateDualStackPort publishes a binding without host address on the same
+// host port on the unspecified address of both families. The port is first
+// reserved on both, then mapped on each of them.
+func (n *bridgeNetwork) allocateDualStackPort(bnd, bnd6 *types.PortBinding, containerIP, containerIPv6 net.IP, ulPxyEnabled bool, owner portallocator.Owner) error {
+	hostPortEnd := bnd.HostPortEnd
+	if hostPortEnd == 0 {
+		hostPortEnd = bnd.HostPort
+	}
+
+	allocator := n.portMapper.Allocator
+	proto := bnd.Proto.String()
+	port, err := allocator.ReservePortInRange([]net.IP{defaultBindingIP, defaultBindingIPv6}, proto, int(bnd.HostPort), int(hostPortEnd), owner)
+	if err != nil {
+		return err
+	}
+
+	bnd.HostPort, bnd.HostPortEnd = uint16(port), uint16(port)
+	*bnd6 = bnd.GetCopy()
+	bnd6.HostIP = defaultBindingIPv6
+
+	if err := n.allocatePort(bnd, containerIP, defaultBindingIP, ulPxyEnabled, true, owner); err != nil {
+		allocator.CancelReservation(defaultBindingIP, proto, port, owner)
+		allocator.CancelReservation(defaultBindingIPv6, proto, port, owner)
+		return err
+	}
+	if err := n.allocatePort(bnd6, containerIPv6, defaultBindingIPv6, ulPxyEnabled, true, owner); err != nil {
+		allocator.CancelReservation(defaultBindingIPv6, proto, port, owner)
+		if cuErr := n.releasePort(*bnd); cuErr != nil {
+			logrus.Warnf("Upon allocation failure for %v, failed to clear the IPv4 port binding: %v", bnd6, cuErr)
+		}
+		return err
+	}
+	return nil
+}
+
+func (n *bridgeNetwork) allocatePort(bnd *types.PortBinding, containerIP, defHostIP net.IP, ulPxyEnabled, singleFamily bool, owner portallocator.Owner) error {
 	var (
 		host net.Addr
 		err  error
 	)
 
+	mapRange := n.portMapper.MapRange
+	if singleFamily {
+		mapRange = n.portMapper.MapRangeSingleFamily
+	}
+
 	// Store the container interface address in the operational binding
 	bnd.IP = containerIP
 
@@ -71,7 +156,7 @@ func (n *bridgeNetwork) allocatePort(bnd *types.PortBinding, containerIP, defHos
 
 	// Try up to maxAllocatePortAttempts times to get a port that's not already allocated.
 	for i := 0; i < maxAllocatePortAttempts; i++ {
-		if host, err = n.portMapper.MapRange(container, bnd.HostIP, int(bnd.HostPort), int(bnd.HostPortEnd), ulPxyEnabled); err == nil {
+		if host, err = mapRange(container, bnd.HostIP, int(bnd.HostPort), int(bnd.HostPortEnd), ulPxyEnabled, int(bnd.ProxyProtocol), owner); err == nil {
 			break
 		}
 		// There is no point in immediately retrying to map an explicitly chosen port.
@@ -103,6 +188,11 @@ func (n *bridgeNetwork) allocatePort(bnd *types.PortBinding, containerIP, defHos
 }
 
 func (n *bridgeNetwork) releasePorts(ep *bridgeEndpoint) error {
+	if n.config.routed() {
+		return n.programOpenings(ep.portMapping, false)
+	}
+	// The ports still reserved for the endpoint are not mapped again
+	n.portMapper.Allocator.ReleaseReservations(portallocator.Owner{NetworkID: n.id, EndpointID: ep.id})
 	return n.releasePortsInternal(ep.portMapping)
 }
 
@@ -1,11 +1,14 @@
 package bridge
 
 import (
+	"net"
 	"os"
 	"testing"
 
 	"github.com/docker/docker/pkg/reexec"
 	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/portallocator"
+	"github.com/docker/libnetwork/portmapper"
 	"github.com/docker/libnetwork/testutils"
 	"github.com/docker/libnetwork/types"
 )
@@ -95,3 +98,27 @@ func TestPortMappingConfig(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestPortMappingProxyStats(t *testing.T) {
+	n := &bridgeNetwork{portMapper: portmapper.New("")}
+	if err := n.portMapper.SetProxyMode(portmapper.ProxyModeInProcess); err != nil {
+		t.Fatal(err)
+	}
+
+	hostIP := net.ParseIP("127.0.0.1")
+	container := &net.TCPAddr{IP: hostIP, Port: 80}
+	host, err := n.portMapper.MapRange(container, hostIP, 0, 0, true, 0, portallocator.Owner{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.portMapper.Unmap(host)
+
+	bindings := []types.PortBinding{
+		{Proto: types.TCP, IP: container.IP, Port: 80, HostIP: hostIP, HostPort: uint16(host.(*net.TCPAddr).Port)},
+		{Proto: types.UDP, IP: container.IP, Port: 80, HostIP: hostIP, HostPort: uint16(host.(*net.TCPAddr).Port)},
+	}
+	stats := n.proxyStats(bindings)
+	if len(stats) != 2 || stats[0] == nil || stats[1] != nil {
+		t.Fatalf("Unexpected proxy stats %v", stats)
+	}
+}
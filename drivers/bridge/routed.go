@@ -0,0 +1,136 @@
+package bridge
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/docker/libnetwork/firewall"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+// Gateway modes of a bridge network. In the routed mode the subnet of the
+// network is routed to the host by the upstream router: its traffic is
+// forwarded without NAT, and the port bindings open the container ports in
+// the firewall instead of mapping host ports to them.
+const (
+	gatewayModeNAT    = "nat"
+	gatewayModeRouted = "routed"
+)
+
+// routed tells whether the network is in the routed gateway mode.
+func (c *networkConfiguration) routed() bool {
+	return c.GatewayMode == gatewayModeRouted
+}
+
+// routedNetworkRules returns the rules forwarding the traffic of the subnet
+// of a routed network: the outgoing traffic and the incoming ICMP. The
+// replies are accepted by the rules of the filter chain, and the incoming
+// connections to the published ports by the port openings.
+func routedNetworkRules(family firewall.Family, bridgeIface string, addr net.Addr) []iptRule {
+	icmp := "icmp"
+	if family == firewall.IPv6 {
+		icmp = "icmpv6"
+	}
+	return []iptRule{
+		{family: family, table: firewall.Filter, chain: "FORWARD", rule: firewall.Rule{InIface: bridgeIface, OutIface: bridgeIface, NotOutIface: true, Src: addr.String(), Jump: "ACCEPT"}},
+		{family: family, table: firewall.Filter, chain: "FORWARD", rule: firewall.Rule{InIface: bridgeIface, NotInIface: true, OutIface: bridgeIface, Dst: addr.String(), Proto: icmp, Jump: "ACCEPT"}},
+	}
+}
+
+func setupRoutedNetworkRules(family firewall.Family, bridgeIface string, addr net.Addr, icc, insert bool) error {
+	tx := firewall.Get(family).NewTransaction()
+	for _, rule := range routedNetworkRules(family, bridgeIface, addr) {
+		addChainRule(tx, rule, insert)
+	}
+	// Set Inter Container Communication.
+	setIcc(tx, bridgeIface, icc, insert)
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("Unable to %s routed network %s rules: %v", operationName(insert), bridgeIface, err)
+	}
+	return nil
+}
+
+// setupRoutedTables programs the rules of a routed network in the firewall
+// of the family of its subnet.
+func (n *bridgeNetwork) setupRoutedTables(family firewall.Family, config *networkConfiguration, addr *net.IPNet, hairpinMode bool) error {
+	if err := setupRoutedNetworkRules(family, config.BridgeName, addr, config.EnableICC, true); err != nil {
+		return fmt.Errorf("Failed to setup %s routed network rules: %v", family, err)
+	}
+	n.registerIptCleanFunc(func() error {
+		return setupRoutedNetworkRules(family, config.BridgeName, addr, config.EnableICC, false)
+	})
+
+	var filterChain *firewall.Chain
+	if family == firewall.IPv6 {
+		_, filterChain, _ = n.getDriverIP6Chains()
+	} else {
+		_, filterChain, _, _, _ = n.getDriverChains()
+	}
+	if filterChain == nil {
+		return fmt.Errorf("Failed to setup %s routed network rules, cannot acquire chain info", family)
+	}
+
+	if err := filterChain.Program(config.BridgeName, hairpinMode, true); err != nil {
+		return fmt.Errorf("Failed to program %s FILTER chain: %v", family, err)
+	}
+	n.registerIptCleanFunc(func() error {
+		return filterChain.Program(config.BridgeName, hairpinMode, false)
+	})
+	return nil
+}
+
+// openingRule returns the rule accepting the incoming connections to the
+// container port of a binding of a routed network.
+func openingRule(bridgeIface string, bnd types.PortBinding) iptRule {
+	family := firewall.IPv4
+	if bnd.IP.To4() == nil {
+		family = firewall.IPv6
+	}
+	return iptRule{family: family, table: firewall.Filter, chain: DockerChain, rule: firewall.Rule{
+		InIface:    bridgeIface,
+		NotInIface: true,
+		OutIface:   bridgeIface,
+		Proto:      bnd.Proto.String(),
+		Dst:        bnd.IP.String(),
+		DstPort:    int(bnd.Port),
+		Jump:       "ACCEPT",
+	}}
+}
+
+// openPorts opens the container ports of the bindings of an endpoint of a
+// routed network, on each of its addresses. The host addresses and ports of
+// the bindings are ignored, no host port being mapped.
+func (n *bridgeNetwork) openPorts(ep *bridgeEndpoint) ([]types.PortBinding, error) {
+	containerIPs := []net.IP{ep.addr.IP}
+	if ep.addrv6 != nil && n.driver.config.EnableIP6Tables && n.config.EnableIPv6 {
+		containerIPs = append(containerIPs, ep.addrv6.IP)
+	}
+
+	bs := make([]types.PortBinding, 0, len(ep.extConnConfig.PortBindings)*len(containerIPs))
+	for _, c := range ep.extConnConfig.PortBindings {
+		for _, ip := range containerIPs {
+			bs = append(bs, types.PortBinding{Proto: c.Proto, IP: ip, Port: c.Port})
+		}
+	}
+
+	if err := n.programOpenings(bs, true); err != nil {
+		if errRb := n.programOpenings(bs, false); errRb != nil {
+			logrus.Warnf("Failed to close the ports of endpoint %s on failure: %v", ep.id[0:7], errRb)
+		}
+		return nil, err
+	}
+	return bs, nil
+}
+
+// programOpenings adds, or removes, the rules opening the container ports
+// of the bindings.
+func (n *bridgeNetwork) programOpenings(bindings []types.PortBinding, enable bool) error {
+	bridgeName := n.getNetworkBridgeName()
+	for _, b := range bindings {
+		if err := programChainRule(openingRule(bridgeName, b), "PORT OPENING", enable); err != nil {
+			return err
+		}
+	}
+	return nil
+}
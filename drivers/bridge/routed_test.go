@@ -0,0 +1,112 @@
+package bridge
+
+import (
+	"net"
+	"testing"
+
+	"github.com/docker/libnetwork/firewall"
+	"github.com/docker/libnetwork/portmapper"
+	"github.com/docker/libnetwork/types"
+)
+
+func TestRoutedValidate(t *testing.T) {
+	config := &networkConfiguration{BridgeName: "br0", EnableIPMasquerade: true, GatewayMode: "bgp"}
+	if err := config.Validate(); err == nil {
+		t.Fatal("Expected an error validating an unknown gateway mode")
+	}
+
+	config.GatewayMode = gatewayModeRouted
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if !config.routed() {
+		t.Fatal("Expected the network to be routed")
+	}
+
+	config.EgressSNATIPv4 = net.ParseIP("10.0.0.5")
+	if err := config.Validate(); err == nil {
+		t.Fatal("Expected an error validating a SNAT address in the routed gateway mode")
+	}
+}
+
+func TestOpeningRule(t *testing.T) {
+	bnd := types.PortBinding{Proto: types.TCP, IP: net.ParseIP("192.168.42.2"), Port: 80}
+	r := openingRule("br0", bnd)
+	if r.family != firewall.IPv4 || r.table != firewall.Filter || r.chain != DockerChain {
+		t.Fatalf("Unexpected opening rule %s/%s/%s", r.family, r.table, r.chain)
+	}
+	if r.rule.Dst != "192.168.42.2" || r.rule.DstPort != 80 || r.rule.Proto != "tcp" || r.rule.Jump != "ACCEPT" {
+		t.Fatalf("Unexpected opening rule: %s", r.rule)
+	}
+
+	bnd.IP = net.ParseIP("fd00:42::2")
+	if r := openingRule("br0", bnd); r.family != firewall.IPv6 || r.rule.Dst != "fd00:42::2" {
+		t.Fatalf("Unexpected IPv6 opening rule %s: %s", r.family, r.rule)
+	}
+}
+
+func TestRoutedRuleSet(t *testing.T) {
+	fw4, fw6 := firewall.Get(firewall.IPv4), firewall.Get(firewall.IPv6)
+	d := &driver{
+		config: &configuration{
+			EnableIPTables:  true,
+			EnableIP6Tables: true,
+		},
+		natChain:      &firewall.Chain{Name: DockerChain, Table: firewall.Nat, Backend: fw4},
+		filterChain:   &firewall.Chain{Name: DockerChain, Table: firewall.Filter, Backend: fw4},
+		natChainV6:    &firewall.Chain{Name: DockerChain, Table: firewall.Nat, Backend: fw6},
+		filterChainV6: &firewall.Chain{Name: DockerChain, Table: firewall.Filter, Backend: fw6},
+		networks:      map[string]*bridgeNetwork{},
+	}
+
+	addr := &net.IPNet{IP: net.ParseIP("192.168.42.0"), Mask: net.CIDRMask(24, 32)}
+	addrv6 := &net.IPNet{IP: net.ParseIP("fd00:42::"), Mask: net.CIDRMask(64, 128)}
+	bindings := []types.PortBinding{
+		{Proto: types.TCP, IP: net.ParseIP("192.168.42.2"), Port: 80},
+		{Proto: types.TCP, IP: net.ParseIP("fd00:42::2"), Port: 80},
+	}
+	d.networks["br-routed"] = &bridgeNetwork{
+		config:     &networkConfiguration{BridgeName: "br-routed", EnableIPv6: true, EnableIPMasquerade: true, GatewayMode: gatewayModeRouted},
+		portMapper: portmapper.New(""),
+		bridge: &bridgeInterface{
+			bridgeIPv4: &net.IPNet{IP: net.ParseIP(iptablesTestBridgeIP), Mask: addr.Mask},
+			bridgeIPv6: &net.IPNet{IP: net.ParseIP(iptablesTestBridgeIPv6), Mask: addrv6.Mask},
+		},
+		endpoints: map[string]*bridgeEndpoint{
+			"ep1": {id: "ep1", portMapping: bindings},
+		},
+	}
+
+	set := d.ruleSet()
+	has := func(family firewall.Family, r iptRule) bool {
+		for _, e := range set.Rules {
+			if e.Family == family && e.Table == r.table && e.Chain == r.chain && e.Rule.String() == r.rule.String() {
+				return true
+			}
+		}
+		return false
+	}
+
+	for family, subnet := range map[firewall.Family]*net.IPNet{firewall.IPv4: addr, firewall.IPv6: addrv6} {
+		for _, r := range routedNetworkRules(family, "br-routed", subnet) {
+			if !has(family, r) {
+				t.Fatalf("Missing %s routed network rule: %s", family, r.rule)
+			}
+		}
+		if has(family, egressNatRule(family, "br-routed", subnet, nil)) {
+			t.Fatalf("Unexpected %s masquerading rule for a routed network", family)
+		}
+		for _, r := range incRules("br-routed") {
+			if !has(family, r) {
+				t.Fatalf("Missing %s isolation rule: %s", family, r.rule)
+			}
+		}
+	}
+
+	for _, b := range bindings {
+		r := openingRule("br-routed", b)
+		if !has(r.family, r) {
+			t.Fatalf("Missing %s port opening: %s", r.family, r.rule)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package bridge
+
+import (
+	"net"
+
+	"github.com/docker/libnetwork/firewall"
+)
+
+// ruleSet returns the firewall chains and rules the driver expects for its
+// networks, their endpoints and their port mappings. It is called with
+// configNetwork held.
+func (d *driver) ruleSet() firewall.RuleSet {
+	var set firewall.RuleSet
+
+	d.Lock()
+	config := d.config
+	natChain, filterChain := d.natChain, d.filterChain
+	natChainV6, filterChainV6 := d.natChainV6, d.filterChainV6
+	networks := make([]*bridgeNetwork, 0, len(d.networks))
+	for _, n := range d.networks {
+		networks = append(networks, n)
+	}
+	peerings := make([]*networkPeering, 0, len(d.peerings))
+	for _, p := range d.peerings {
+		peerings = append(peerings, p)
+	}
+	d.Unlock()
+
+	if !config.EnableIPTables || natChain == nil {
+		return set
+	}
+
+	set.AddChain(firewall.IPv4, firewall.Nat, DockerChain)
+	set.AddChain(firewall.IPv4, firewall.Filter, DockerChain)
+	set.AddChain(firewall.IPv4, firewall.Filter, IsolationChain1)
+	set.AddChain(firewall.IPv4, firewall.Filter, IsolationChain2)
+	set.AddBottom(firewall.IPv4, firewall.Filter, IsolationChain1, firewall.Rule{Jump: "RETURN"})
+	set.AddBottom(firewall.IPv4, firewall.Filter, IsolationChain2, firewall.Rule{Jump: "RETURN"})
+	if config.EnableIP6Tables && natChainV6 != nil {
+		set.AddChain(firewall.IPv6, firewall.Nat, DockerChain)
+		set.AddChain(firewall.IPv6, firewall.Filter, DockerChain)
+		set.AddChain(firewall.IPv6, firewall.Filter, IsolationChain1)
+		set.AddChain(firewall.IPv6, firewall.Filter, IsolationChain2)
+		set.AddBottom(firewall.IPv6, firewall.Filter, IsolationChain1, firewall.Rule{Jump: "RETURN"})
+		set.AddBottom(firewall.IPv6, firewall.Filter, IsolationChain2, firewall.Rule{Jump: "RETURN"})
+	}
+
+	// The peering exceptions precede the isolation rules of their networks
+	for _, p := range peerings {
+		for _, family := range p.families {
+			for _, r := range peeringRules(family, p.bridge1, p.bridge2, p.rules) {
+				set.AddTop(family, r.table, r.chain, 0, r.rule)
+			}
+		}
+	}
+
+	hairpinMode := !config.EnableUserlandProxy
+	for _, n := range networks {
+		n.Lock()
+		nwConfig := n.config
+		bridge := n.bridge
+		n.Unlock()
+
+		// The verifications hold configNetwork, so the networks are
+		// fully set up here, but for the ones without bridge address.
+		if bridge == nil || bridge.bridgeIPv4 == nil {
+			continue
+		}
+
+		addr := &net.IPNet{
+			IP:   bridge.bridgeIPv4.IP.Mask(bridge.bridgeIPv4.Mask),
+			Mask: bridge.bridgeIPv4.Mask,
+		}
+		addIsolationRules(&set, firewall.IPv4, nwConfig, addr)
+
+		enableIPv6 := config.EnableIP6Tables && nwConfig.EnableIPv6 && natChainV6 != nil && bridge.bridgeIPv6 != nil
+		if enableIPv6 {
+			addrv6 := &net.IPNet{
+				IP:   bridge.bridgeIPv6.IP.Mask(bridge.bridgeIPv6.Mask),
+				Mask: bridge.bridgeIPv6.Mask,
+			}
+			addIsolationRules(&set, firewall.IPv6, nwConfig, addrv6)
+		}
+
+		if nwConfig.Internal {
+			continue
+		}
+
+		if nwConfig.routed() {
+			addRoutedRules(&set, firewall.IPv4, nwConfig.BridgeName, addr, filterChain, hairpinMode)
+			if enableIPv6 {
+				addrv6 := &net.IPNet{
+					IP:   bridge.bridgeIPv6.IP.Mask(bridge.bridgeIPv6.Mask),
+					Mask: bridge.bridgeIPv6.Mask,
+				}
+				addRoutedRules(&set, firewall.IPv6, nwConfig.BridgeName, addrv6, filterChainV6, hairpinMode)
+			}
+			n.Lock()
+			for _, ep := range n.endpoints {
+				for _, b := range ep.portMapping {
+					r := openingRule(nwConfig.BridgeName, b)
+					set.Add(r.family, r.chainRule())
+				}
+			}
+			n.Unlock()
+			continue
+		}
+
+		for _, r := range bridgeRules(firewall.IPv4, nwConfig.BridgeName, addr, nwConfig.EnableIPMasquerade, nwConfig.EgressSNATIPv4, hairpinMode) {
+			set.Add(firewall.IPv4, r.chainRule())
+		}
+		set.Add(firewall.IPv4, natChain.ProgramRules(nwConfig.BridgeName, hairpinMode)...)
+		set.Add(firewall.IPv4, filterChain.ProgramRules(nwConfig.BridgeName, hairpinMode)...)
+
+		if enableIPv6 {
+			addrv6 := &net.IPNet{
+				IP:   bridge.bridgeIPv6.IP.Mask(bridge.bridgeIPv6.Mask),
+				Mask: bridge.bridgeIPv6.Mask,
+			}
+			for _, r := range bridgeRules(firewall.IPv6, nwConfig.BridgeName, addrv6, nwConfig.EnableIPMasquerade, nwConfig.EgressSNATIPv6, hairpinMode) {
+				set.Add(firewall.IPv6, r.chainRule())
+			}
+			set.Add(firewall.IPv6, natChainV6.ProgramRules(nwConfig.BridgeName, hairpinMode)...)
+			set.Add(firewall.IPv6, filterChainV6.ProgramRules(nwConfig.BridgeName, hairpinMode)...)
+		}
+
+		ports := n.portMapper.RuleSet()
+		set.Rules = append(set.Rules, ports.Rules...)
+	}
+
+	return set
+}
+
+// addIsolationRules adds the rules isolating a network, in the firewall of
+// the family of its subnet, to the rule set: the jump to the isolation
+// chains, the ICC rule and either the internal network rules or the inter
+// network communication ones.
+func addIsolationRules(set *firewall.RuleSet, family firewall.Family, nwConfig *networkConfiguration, addr *net.IPNet) {
+	set.AddTop(family, firewall.Filter, "FORWARD", firewall.RankIsolation, firewall.Rule{Jump: IsolationChain1})
+
+	icc := iccRule(nwConfig.BridgeName, nwConfig.EnableICC).chainRule()
+	if nwConfig.EnableICC {
+		set.Add(family, icc)
+	} else {
+		set.AddTail(family, icc)
+	}
+
+	rules := incRules(nwConfig.BridgeName)
+	if nwConfig.Internal {
+		rules = internalNetworkRules(nwConfig.BridgeName, addr)
+	}
+	for _, r := range rules {
+		set.Add(family, r.chainRule())
+	}
+}
+
+// addRoutedRules adds the rules forwarding the traffic of a routed network,
+// in the firewall of the family of its subnet, to the rule set.
+func addRoutedRules(set *firewall.RuleSet, family firewall.Family, bridgeIface string, addr *net.IPNet, filterChain *firewall.Chain, hairpinMode bool) {
+	for _, r := range routedNetworkRules(family, bridgeIface, addr) {
+		set.Add(family, r.chainRule())
+	}
+	set.Add(family, filterChain.ProgramRules(bridgeIface, hairpinMode)...)
+}
@@ -37,7 +37,16 @@ func setupDevice(config *networkConfiguration, i *bridgeInterface) error {
 
 	if err = i.nlh.LinkAdd(i.Link); err != nil {
 		logrus.Debugf("Failed to create bridge %s via netlink. Trying ioctl", config.BridgeName)
-		return ioctlCreateBridge(config.BridgeName, setMac)
+		if err = ioctlCreateBridge(config.BridgeName, setMac); err != nil {
+			return err
+		}
+		// The tunables are set by index, unknown to the ioctl created link
+		if config.hasBridgeTunables() {
+			if i.Link, err = i.nlh.LinkByName(config.BridgeName); err != nil {
+				return fmt.Errorf("failed to retrieve bridge %s: %v", config.BridgeName, err)
+			}
+		}
+		return setupBridgeTunables(config, i)
 	}
 
 	if setMac {
@@ -47,7 +56,7 @@ func setupDevice(config *networkConfiguration, i *bridgeInterface) error {
 		}
 		logrus.Debugf("Setting bridge mac address to %s", hwAddr)
 	}
-	return err
+	return setupBridgeTunables(config, i)
 }
 
 // SetupDeviceUp ups the given bridge interface.
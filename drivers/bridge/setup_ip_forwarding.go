@@ -4,7 +4,7 @@ import (
 	"fmt"
 	"io/ioutil"
 
-	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/firewall"
 	"github.com/sirupsen/logrus"
 )
 
@@ -39,15 +39,16 @@ func setupIPForwarding(enableIPTables bool) error {
 		if !enableIPTables {
 			return nil
 		}
-		if err := iptables.SetDefaultPolicy(iptables.Filter, "FORWARD", iptables.Drop); err != nil {
+		fw := firewall.Get(firewall.IPv4)
+		if err := fw.SetDefaultPolicy(firewall.Filter, "FORWARD", firewall.Drop); err != nil {
 			if err := configureIPForwarding(false); err != nil {
 				logrus.Errorf("Disabling IP forwarding failed, %v", err)
 			}
 			return err
 		}
-		iptables.OnReloaded(func() {
+		fw.OnReloaded(func() {
 			logrus.Debug("Setting the default DROP policy on firewall reload")
-			if err := iptables.SetDefaultPolicy(iptables.Filter, "FORWARD", iptables.Drop); err != nil {
+			if err := fw.SetDefaultPolicy(firewall.Filter, "FORWARD", firewall.Drop); err != nil {
 				logrus.Warnf("Settig the default DROP policy on firewall reload failed, %v", err)
 			}
 		})
@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"net"
 
+	"github.com/docker/libnetwork/firewall"
 	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/types"
 	"github.com/sirupsen/logrus"
 	"github.com/vishvananda/netlink"
 )
@@ -26,73 +28,112 @@ const (
 	IsolationChain2 = "DOCKER-ISOLATION-STAGE-2"
 )
 
-func setupIPChains(config *configuration) (*iptables.ChainInfo, *iptables.ChainInfo, *iptables.ChainInfo, *iptables.ChainInfo, error) {
+func setupIPChains(config *configuration) (*firewall.Chain, *firewall.Chain, *firewall.Chain, *firewall.Chain, error) {
 	// Sanity check.
 	if config.EnableIPTables == false {
 		return nil, nil, nil, nil, errors.New("cannot create new chains, EnableIPTable is disabled")
 	}
 
 	hairpinMode := !config.EnableUserlandProxy
+	fw := firewall.Get(firewall.IPv4)
 
-	natChain, err := iptables.NewChain(DockerChain, iptables.Nat, hairpinMode)
+	natChain, err := firewall.NewChain(fw, DockerChain, firewall.Nat, hairpinMode)
 	if err != nil {
 		return nil, nil, nil, nil, fmt.Errorf("failed to create NAT chain %s: %v", DockerChain, err)
 	}
 	defer func() {
 		if err != nil {
-			if err := iptables.RemoveExistingChain(DockerChain, iptables.Nat); err != nil {
+			if err := fw.RemoveChain(firewall.Nat, DockerChain); err != nil {
 				logrus.Warnf("failed on removing iptables NAT chain %s on cleanup: %v", DockerChain, err)
 			}
 		}
 	}()
 
-	filterChain, err := iptables.NewChain(DockerChain, iptables.Filter, false)
+	filterChain, err := firewall.NewChain(fw, DockerChain, firewall.Filter, false)
 	if err != nil {
 		return nil, nil, nil, nil, fmt.Errorf("failed to create FILTER chain %s: %v", DockerChain, err)
 	}
 	defer func() {
 		if err != nil {
-			if err := iptables.RemoveExistingChain(DockerChain, iptables.Filter); err != nil {
+			if err := fw.RemoveChain(firewall.Filter, DockerChain); err != nil {
 				logrus.Warnf("failed on removing iptables FILTER chain %s on cleanup: %v", DockerChain, err)
 			}
 		}
 	}()
 
-	isolationChain1, err := iptables.NewChain(IsolationChain1, iptables.Filter, false)
+	isolationChain1, err := firewall.NewChain(fw, IsolationChain1, firewall.Filter, false)
 	if err != nil {
 		return nil, nil, nil, nil, fmt.Errorf("failed to create FILTER isolation chain: %v", err)
 	}
 	defer func() {
 		if err != nil {
-			if err := iptables.RemoveExistingChain(IsolationChain1, iptables.Filter); err != nil {
+			if err := fw.RemoveChain(firewall.Filter, IsolationChain1); err != nil {
 				logrus.Warnf("failed on removing iptables FILTER chain %s on cleanup: %v", IsolationChain1, err)
 			}
 		}
 	}()
 
-	isolationChain2, err := iptables.NewChain(IsolationChain2, iptables.Filter, false)
+	isolationChain2, err := firewall.NewChain(fw, IsolationChain2, firewall.Filter, false)
 	if err != nil {
 		return nil, nil, nil, nil, fmt.Errorf("failed to create FILTER isolation chain: %v", err)
 	}
 	defer func() {
 		if err != nil {
-			if err := iptables.RemoveExistingChain(IsolationChain2, iptables.Filter); err != nil {
+			if err := fw.RemoveChain(firewall.Filter, IsolationChain2); err != nil {
 				logrus.Warnf("failed on removing iptables FILTER chain %s on cleanup: %v", IsolationChain2, err)
 			}
 		}
 	}()
 
-	if err := iptables.AddReturnRule(IsolationChain1); err != nil {
+	if err := firewall.AddReturnRule(fw, IsolationChain1); err != nil {
 		return nil, nil, nil, nil, err
 	}
 
-	if err := iptables.AddReturnRule(IsolationChain2); err != nil {
+	if err := firewall.AddReturnRule(fw, IsolationChain2); err != nil {
 		return nil, nil, nil, nil, err
 	}
 
 	return natChain, filterChain, isolationChain1, isolationChain2, nil
 }
 
+// setupIP6Chains creates the ip6tables chains of the IPv6 port mappings and
+// of the isolation of the IPv6 networks.
+func setupIP6Chains(config *configuration) (*firewall.Chain, *firewall.Chain, error) {
+	// Sanity check.
+	if !config.EnableIPTables || !config.EnableIP6Tables {
+		return nil, nil, errors.New("cannot create new ip6tables chains, EnableIPTable or EnableIP6Table is disabled")
+	}
+
+	hairpinMode := !config.EnableUserlandProxy
+	fw6 := firewall.Get(firewall.IPv6)
+
+	natChain, err := firewall.NewChain(fw6, DockerChain, firewall.Nat, hairpinMode)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create ip6tables NAT chain %s: %v", DockerChain, err)
+	}
+
+	filterChain, err := firewall.NewChain(fw6, DockerChain, firewall.Filter, false)
+	if err != nil {
+		if err := fw6.RemoveChain(firewall.Nat, DockerChain); err != nil {
+			logrus.Warnf("failed on removing ip6tables NAT chain %s on cleanup: %v", DockerChain, err)
+		}
+		return nil, nil, fmt.Errorf("failed to create ip6tables FILTER chain %s: %v", DockerChain, err)
+	}
+
+	for _, chain := range []string{IsolationChain1, IsolationChain2} {
+		if err := fw6.NewChain(firewall.Filter, chain); err != nil {
+			removeIP6Chains()
+			return nil, nil, fmt.Errorf("failed to create ip6tables FILTER isolation chain %s: %v", chain, err)
+		}
+		if err := firewall.AddReturnRule(fw6, chain); err != nil {
+			removeIP6Chains()
+			return nil, nil, err
+		}
+	}
+
+	return natChain, filterChain, nil
+}
+
 func (n *bridgeNetwork) setupIPTables(config *networkConfiguration, i *bridgeInterface) error {
 	var err error
 
@@ -113,44 +154,53 @@ func (n *bridgeNetwork) setupIPTables(config *networkConfiguration, i *bridgeInt
 		IP:   i.bridgeIPv4.IP.Mask(i.bridgeIPv4.Mask),
 		Mask: i.bridgeIPv4.Mask,
 	}
+	if config.EgressSNATIPv4 != nil && !config.Internal {
+		if err = checkHostAddress(i.nlh, config.EgressSNATIPv4); err != nil {
+			return err
+		}
+	}
 	if config.Internal {
-		if err = setupInternalNetworkRules(config.BridgeName, maskedAddrv4, config.EnableICC, true); err != nil {
+		if err = setupInternalNetworkRules(firewall.IPv4, config.BridgeName, maskedAddrv4, config.EnableICC, true); err != nil {
 			return fmt.Errorf("Failed to Setup IP tables: %s", err.Error())
 		}
 		n.registerIptCleanFunc(func() error {
-			return setupInternalNetworkRules(config.BridgeName, maskedAddrv4, config.EnableICC, false)
+			return setupInternalNetworkRules(firewall.IPv4, config.BridgeName, maskedAddrv4, config.EnableICC, false)
 		})
+	} else if config.routed() {
+		if err = n.setupRoutedTables(firewall.IPv4, config, maskedAddrv4, hairpinMode); err != nil {
+			return err
+		}
 	} else {
-		if err = setupIPTablesInternal(config.BridgeName, maskedAddrv4, config.EnableICC, config.EnableIPMasquerade, hairpinMode, true); err != nil {
+		if err = setupIPTablesInternal(firewall.IPv4, config.BridgeName, maskedAddrv4, config.EnableICC, config.EnableIPMasquerade, config.EgressSNATIPv4, hairpinMode, true); err != nil {
 			return fmt.Errorf("Failed to Setup IP tables: %s", err.Error())
 		}
 		n.registerIptCleanFunc(func() error {
-			return setupIPTablesInternal(config.BridgeName, maskedAddrv4, config.EnableICC, config.EnableIPMasquerade, hairpinMode, false)
+			return setupIPTablesInternal(firewall.IPv4, config.BridgeName, maskedAddrv4, config.EnableICC, config.EnableIPMasquerade, config.EgressSNATIPv4, hairpinMode, false)
 		})
 		natChain, filterChain, _, _, err := n.getDriverChains()
 		if err != nil {
 			return fmt.Errorf("Failed to setup IP tables, cannot acquire chain info %s", err.Error())
 		}
 
-		err = iptables.ProgramChain(natChain, config.BridgeName, hairpinMode, true)
+		err = natChain.Program(config.BridgeName, hairpinMode, true)
 		if err != nil {
 			return fmt.Errorf("Failed to program NAT chain: %s", err.Error())
 		}
 
-		err = iptables.ProgramChain(filterChain, config.BridgeName, hairpinMode, true)
+		err = filterChain.Program(config.BridgeName, hairpinMode, true)
 		if err != nil {
 			return fmt.Errorf("Failed to program FILTER chain: %s", err.Error())
 		}
 
 		n.registerIptCleanFunc(func() error {
-			return iptables.ProgramChain(filterChain, config.BridgeName, hairpinMode, false)
+			return filterChain.Program(config.BridgeName, hairpinMode, false)
 		})
 
 		n.portMapper.SetIptablesChain(natChain, n.getNetworkBridgeName())
 	}
 
 	d.Lock()
-	err = iptables.EnsureJumpRule("FORWARD", IsolationChain1)
+	err = firewall.EnsureJumpRule(firewall.Get(firewall.IPv4), "FORWARD", IsolationChain1)
 	d.Unlock()
 	if err != nil {
 		return err
@@ -159,149 +209,286 @@ func (n *bridgeNetwork) setupIPTables(config *networkConfiguration, i *bridgeInt
 	return nil
 }
 
+// setupIP6Tables programs the ip6tables rules of an IPv6 enabled network:
+// its isolation, its inter container communication policy and, but in the
+// routed gateway mode, the publishing of its ports on the IPv6 host
+// addresses.
+func (n *bridgeNetwork) setupIP6Tables(config *networkConfiguration, i *bridgeInterface) error {
+	d := n.driver
+	d.Lock()
+	driverConfig := d.config
+	d.Unlock()
+
+	// Sanity check.
+	if !driverConfig.EnableIPTables || !driverConfig.EnableIP6Tables {
+		return errors.New("Cannot program ip6tables chains, EnableIPTable or EnableIP6Table is disabled")
+	}
+
+	maskedAddrv6 := &net.IPNet{
+		IP:   i.bridgeIPv6.IP.Mask(i.bridgeIPv6.Mask),
+		Mask: i.bridgeIPv6.Mask,
+	}
+	if config.Internal {
+		if err := setupInternalNetworkRules(firewall.IPv6, config.BridgeName, maskedAddrv6, config.EnableICC, true); err != nil {
+			return fmt.Errorf("Failed to Setup IP6 tables: %s", err.Error())
+		}
+		n.registerIptCleanFunc(func() error {
+			return setupInternalNetworkRules(firewall.IPv6, config.BridgeName, maskedAddrv6, config.EnableICC, false)
+		})
+	} else if config.routed() {
+		if err := n.setupRoutedTables(firewall.IPv6, config, maskedAddrv6, !driverConfig.EnableUserlandProxy); err != nil {
+			return err
+		}
+	} else if err := n.setupIP6PortTables(config, driverConfig, i, maskedAddrv6); err != nil {
+		return err
+	}
+
+	d.Lock()
+	err := firewall.EnsureJumpRule(firewall.Get(firewall.IPv6), "FORWARD", IsolationChain1)
+	d.Unlock()
+	return err
+}
+
+// setupIP6PortTables programs the ip6tables rules of the inter container
+// communication policy, of the egress SNAT and of the port mappings of a
+// network which is not internal.
+func (n *bridgeNetwork) setupIP6PortTables(config *networkConfiguration, driverConfig *configuration, i *bridgeInterface, maskedAddrv6 *net.IPNet) error {
+	hairpinMode := !driverConfig.EnableUserlandProxy
+
+	if config.EnableIPMasquerade && config.EgressSNATIPv6 != nil {
+		if err := checkHostAddress(i.nlh, config.EgressSNATIPv6); err != nil {
+			return err
+		}
+	}
+
+	if err := setupIPTablesInternal(firewall.IPv6, config.BridgeName, maskedAddrv6, config.EnableICC, config.EnableIPMasquerade, config.EgressSNATIPv6, hairpinMode, true); err != nil {
+		return fmt.Errorf("Failed to Setup IP6 tables: %s", err.Error())
+	}
+	n.registerIptCleanFunc(func() error {
+		return setupIPTablesInternal(firewall.IPv6, config.BridgeName, maskedAddrv6, config.EnableICC, config.EnableIPMasquerade, config.EgressSNATIPv6, hairpinMode, false)
+	})
+
+	natChain, filterChain, err := n.getDriverIP6Chains()
+	if err != nil {
+		return fmt.Errorf("Failed to setup IP6 tables, cannot acquire chain info %s", err.Error())
+	}
+
+	if err := natChain.Program(config.BridgeName, hairpinMode, true); err != nil {
+		return fmt.Errorf("Failed to program IPv6 NAT chain: %s", err.Error())
+	}
+
+	if err := filterChain.Program(config.BridgeName, hairpinMode, true); err != nil {
+		return fmt.Errorf("Failed to program IPv6 FILTER chain: %s", err.Error())
+	}
+	n.registerIptCleanFunc(func() error {
+		return filterChain.Program(config.BridgeName, hairpinMode, false)
+	})
+
+	n.portMapper.SetIP6tablesChain(natChain, n.getNetworkBridgeName())
+
+	return nil
+}
+
 type iptRule struct {
-	table   iptables.Table
-	chain   string
-	preArgs []string
-	args    []string
+	// family is the IP version of the rule, IPv4 for the zero value
+	family firewall.Family
+	table  firewall.Table
+	chain  string
+	rule   firewall.Rule
+}
+
+// backend returns the firewall backend of the family of the rule.
+func (r iptRule) backend() firewall.Backend {
+	if r.family == firewall.IPv6 {
+		return firewall.Get(firewall.IPv6)
+	}
+	return firewall.Get(firewall.IPv4)
+}
+
+// chainRule returns the rule in its chain.
+func (r iptRule) chainRule() firewall.ChainRule {
+	return firewall.ChainRule{Table: r.table, Chain: r.chain, Rule: r.rule}
 }
 
-func setupIPTablesInternal(bridgeIface string, addr net.Addr, icc, ipmasq, hairpin, enable bool) error {
+// egressNatRule returns the rule masquerading the egress traffic of the
+// subnet of the bridge, or source NATing it to snatIP if not nil.
+func egressNatRule(family firewall.Family, bridgeIface string, addr net.Addr, snatIP net.IP) iptRule {
+	rule := firewall.Rule{Src: addr.String(), OutIface: bridgeIface, NotOutIface: true, Jump: "MASQUERADE"}
+	if snatIP != nil {
+		rule.Jump = "SNAT"
+		rule.ToSource = snatIP.String()
+	}
+	return iptRule{family: family, table: firewall.Nat, chain: "POSTROUTING", rule: rule}
+}
 
+// checkHostAddress verifies that the egress SNAT address is an address of
+// the host.
+func checkHostAddress(nlh *netlink.Handle, ip net.IP) error {
+	addrs, err := nlh.AddrList(nil, netlink.FAMILY_ALL)
+	if err != nil {
+		return fmt.Errorf("failed to list the host addresses: %v", err)
+	}
+	for _, a := range addrs {
+		if a.IP.Equal(ip) {
+			return nil
+		}
+	}
+	return types.ForbiddenErrorf("egress SNAT address %s is not an address of the host", ip)
+}
+
+// bridgeRules returns the rules of a bridge network in the firewall of
+// family, but its ICC rule. The IPv6 subnet is not masqueraded: only its
+// egress SNAT rule, if snatIP is set, and the rule skipping the DNAT of its
+// own traffic are returned for it.
+func bridgeRules(family firewall.Family, bridgeIface string, addr net.Addr, ipmasq bool, snatIP net.IP, hairpin bool) []iptRule {
 	var (
-		address   = addr.String()
-		natRule   = iptRule{table: iptables.Nat, chain: "POSTROUTING", preArgs: []string{"-t", "nat"}, args: []string{"-s", address, "!", "-o", bridgeIface, "-j", "MASQUERADE"}}
-		hpNatRule = iptRule{table: iptables.Nat, chain: "POSTROUTING", preArgs: []string{"-t", "nat"}, args: []string{"-m", "addrtype", "--src-type", "LOCAL", "-o", bridgeIface, "-j", "MASQUERADE"}}
-		skipDNAT  = iptRule{table: iptables.Nat, chain: DockerChain, preArgs: []string{"-t", "nat"}, args: []string{"-i", bridgeIface, "-j", "RETURN"}}
-		outRule   = iptRule{table: iptables.Filter, chain: "FORWARD", args: []string{"-i", bridgeIface, "!", "-o", bridgeIface, "-j", "ACCEPT"}}
+		natRule   = egressNatRule(family, bridgeIface, addr, snatIP)
+		hpNatRule = iptRule{family: family, table: firewall.Nat, chain: "POSTROUTING", rule: firewall.Rule{SrcType: "LOCAL", OutIface: bridgeIface, Jump: "MASQUERADE"}}
+		skipDNAT  = iptRule{family: family, table: firewall.Nat, chain: DockerChain, rule: firewall.Rule{InIface: bridgeIface, Jump: "RETURN"}}
+		outRule   = iptRule{family: family, table: firewall.Filter, chain: "FORWARD", rule: firewall.Rule{InIface: bridgeIface, OutIface: bridgeIface, NotOutIface: true, Jump: "ACCEPT"}}
+		rules     []iptRule
 	)
 
+	if family == firewall.IPv6 {
+		if ipmasq && snatIP != nil {
+			rules = append(rules, natRule)
+		}
+		if !hairpin {
+			rules = append(rules, skipDNAT)
+		}
+		return rules
+	}
+
 	// Set NAT.
 	if ipmasq {
-		if err := programChainRule(natRule, "NAT", enable); err != nil {
-			return err
-		}
+		rules = append(rules, natRule)
 	}
 
 	if ipmasq && !hairpin {
-		if err := programChainRule(skipDNAT, "SKIP DNAT", enable); err != nil {
-			return err
-		}
+		rules = append(rules, skipDNAT)
 	}
 
 	// In hairpin mode, masquerade traffic from localhost
 	if hairpin {
-		if err := programChainRule(hpNatRule, "MASQ LOCAL HOST", enable); err != nil {
-			return err
-		}
+		rules = append(rules, hpNatRule)
 	}
 
-	// Set Inter Container Communication.
-	if err := setIcc(bridgeIface, icc, enable); err != nil {
-		return err
+	// Set Accept on all non-intercontainer outgoing packets.
+	return append(rules, outRule)
+}
+
+func setupIPTablesInternal(family firewall.Family, bridgeIface string, addr net.Addr, icc, ipmasq bool, snatIP net.IP, hairpin, enable bool) error {
+	tx := firewall.Get(family).NewTransaction()
+
+	for _, rule := range bridgeRules(family, bridgeIface, addr, ipmasq, snatIP, hairpin) {
+		addChainRule(tx, rule, enable)
 	}
 
-	// Set Accept on all non-intercontainer outgoing packets.
-	return programChainRule(outRule, "ACCEPT NON_ICC OUTGOING", enable)
+	// Set Inter Container Communication.
+	setIcc(tx, bridgeIface, icc, enable)
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("Unable to %s bridge %s rules: %v", operationName(enable), bridgeIface, err)
+	}
+	return nil
 }
 
-func programChainRule(rule iptRule, ruleDescr string, insert bool) error {
-	var (
-		prefix    []string
-		operation string
-		condition bool
-		doesExist = iptables.Exists(rule.table, rule.chain, rule.args...)
-	)
+// addChainRule adds the insertion of the rule to the transaction if it is
+// not present, or its deletion if it is.
+func addChainRule(tx firewall.Transaction, rule iptRule, insert bool) {
+	action := firewall.Insert
+	if !insert {
+		action = firewall.Delete
+	}
+	tx.ProgramRule(rule.table, rule.chain, action, rule.rule)
+}
 
-	if insert {
-		condition = !doesExist
-		prefix = []string{"-I", rule.chain}
-		operation = "enable"
-	} else {
-		condition = doesExist
-		prefix = []string{"-D", rule.chain}
-		operation = "disable"
+func operationName(enable bool) string {
+	if enable {
+		return "enable"
 	}
-	if rule.preArgs != nil {
-		prefix = append(rule.preArgs, prefix...)
+	return "disable"
+}
+
+func programChainRule(rule iptRule, ruleDescr string, insert bool) error {
+	action := firewall.Insert
+	if !insert {
+		action = firewall.Delete
 	}
 
-	if condition {
-		if err := iptables.RawCombinedOutput(append(prefix, rule.args...)...); err != nil {
-			return fmt.Errorf("Unable to %s %s rule: %s", operation, ruleDescr, err.Error())
-		}
+	if err := rule.backend().ProgramRule(rule.table, rule.chain, action, rule.rule); err != nil {
+		return fmt.Errorf("Unable to %s %s rule: %s", operationName(insert), ruleDescr, err.Error())
 	}
 
 	return nil
 }
 
-func setIcc(bridgeIface string, iccEnable, insert bool) error {
+// iccRule returns the rule allowing, or denying, the inter container
+// communication on the bridge.
+func iccRule(bridgeIface string, iccEnable bool) iptRule {
+	jump := "ACCEPT"
+	if !iccEnable {
+		jump = "DROP"
+	}
+	return iptRule{table: firewall.Filter, chain: "FORWARD", rule: firewall.Rule{InIface: bridgeIface, OutIface: bridgeIface, Jump: jump}}
+}
+
+func setIcc(tx firewall.Transaction, bridgeIface string, iccEnable, insert bool) {
 	var (
-		table      = iptables.Filter
+		table      = firewall.Filter
 		chain      = "FORWARD"
-		args       = []string{"-i", bridgeIface, "-o", bridgeIface, "-j"}
-		acceptArgs = append(args, "ACCEPT")
-		dropArgs   = append(args, "DROP")
+		acceptRule = iccRule(bridgeIface, true).rule
+		dropRule   = iccRule(bridgeIface, false).rule
 	)
 
 	if insert {
 		if !iccEnable {
-			iptables.Raw(append([]string{"-D", chain}, acceptArgs...)...)
-
-			if !iptables.Exists(table, chain, dropArgs...) {
-				if err := iptables.RawCombinedOutput(append([]string{"-A", chain}, dropArgs...)...); err != nil {
-					return fmt.Errorf("Unable to prevent intercontainer communication: %s", err.Error())
-				}
-			}
+			tx.ProgramRule(table, chain, firewall.Delete, acceptRule)
+			tx.ProgramRule(table, chain, firewall.Append, dropRule)
 		} else {
-			iptables.Raw(append([]string{"-D", chain}, dropArgs...)...)
-
-			if !iptables.Exists(table, chain, acceptArgs...) {
-				if err := iptables.RawCombinedOutput(append([]string{"-I", chain}, acceptArgs...)...); err != nil {
-					return fmt.Errorf("Unable to allow intercontainer communication: %s", err.Error())
-				}
-			}
+			tx.ProgramRule(table, chain, firewall.Delete, dropRule)
+			tx.ProgramRule(table, chain, firewall.Insert, acceptRule)
 		}
 	} else {
 		// Remove any ICC rule.
 		if !iccEnable {
-			if iptables.Exists(table, chain, dropArgs...) {
-				iptables.Raw(append([]string{"-D", chain}, dropArgs...)...)
-			}
+			tx.ProgramRule(table, chain, firewall.Delete, dropRule)
 		} else {
-			if iptables.Exists(table, chain, acceptArgs...) {
-				iptables.Raw(append([]string{"-D", chain}, acceptArgs...)...)
-			}
+			tx.ProgramRule(table, chain, firewall.Delete, acceptRule)
 		}
 	}
+}
 
-	return nil
+// incRules returns the rules isolating the bridge network from the other
+// ones, in the first and second isolation chains.
+func incRules(iface string) []iptRule {
+	return []iptRule{
+		{table: firewall.Filter, chain: IsolationChain1, rule: firewall.Rule{InIface: iface, OutIface: iface, NotOutIface: true, Jump: IsolationChain2}},
+		{table: firewall.Filter, chain: IsolationChain2, rule: firewall.Rule{OutIface: iface, Jump: "DROP"}},
+	}
 }
 
 // Control Inter Network Communication. Install[Remove] only if it is [not] present.
-func setINC(iface string, enable bool) error {
+func setINC(family firewall.Family, iface string, enable bool) error {
 	var (
-		action    = iptables.Insert
+		fw        = firewall.Get(family)
+		action    = firewall.Insert
 		actionMsg = "add"
-		chains    = []string{IsolationChain1, IsolationChain2}
-		rules     = [][]string{
-			{"-i", iface, "!", "-o", iface, "-j", IsolationChain2},
-			{"-o", iface, "-j", "DROP"},
-		}
+		rules     = incRules(iface)
 	)
 
 	if !enable {
-		action = iptables.Delete
+		action = firewall.Delete
 		actionMsg = "remove"
 	}
 
-	for i, chain := range chains {
-		if err := iptables.ProgramRule(iptables.Filter, chain, action, rules[i]); err != nil {
+	for i, rule := range rules {
+		if err := fw.ProgramRule(rule.table, rule.chain, action, rule.rule); err != nil {
 			msg := fmt.Sprintf("unable to %s inter-network communication rule: %v", actionMsg, err)
 			if enable {
 				if i == 1 {
 					// Rollback the rule installed on first chain
-					if err2 := iptables.ProgramRule(iptables.Filter, chains[0], iptables.Delete, rules[0]); err2 != nil {
+					if err2 := fw.ProgramRule(rules[0].table, rules[0].chain, firewall.Delete, rules[0].rule); err2 != nil {
 						logrus.Warn("Failed to rollback iptables rule after failure (%v): %v", err, err2)
 					}
 				}
@@ -318,36 +505,59 @@ func setINC(iface string, enable bool) error {
 const oldIsolationChain = "DOCKER-ISOLATION"
 
 func removeIPChains() {
+	fw := firewall.Get(firewall.IPv4)
+
 	// Remove obsolete rules from default chains
-	iptables.ProgramRule(iptables.Filter, "FORWARD", iptables.Delete, []string{"-j", oldIsolationChain})
+	fw.ProgramRule(firewall.Filter, "FORWARD", firewall.Delete, firewall.Rule{Jump: oldIsolationChain})
 
 	// Remove chains
-	for _, chainInfo := range []iptables.ChainInfo{
-		{Name: DockerChain, Table: iptables.Nat},
-		{Name: DockerChain, Table: iptables.Filter},
-		{Name: IsolationChain1, Table: iptables.Filter},
-		{Name: IsolationChain2, Table: iptables.Filter},
-		{Name: oldIsolationChain, Table: iptables.Filter},
+	for _, chainInfo := range []firewall.Chain{
+		{Name: DockerChain, Table: firewall.Nat},
+		{Name: DockerChain, Table: firewall.Filter},
+		{Name: IsolationChain1, Table: firewall.Filter},
+		{Name: IsolationChain2, Table: firewall.Filter},
+		{Name: oldIsolationChain, Table: firewall.Filter},
 	} {
-		if err := chainInfo.Remove(); err != nil {
+		if err := fw.RemoveChain(chainInfo.Table, chainInfo.Name); err != nil {
 			logrus.Warnf("Failed to remove existing iptables entries in table %s chain %s : %v", chainInfo.Table, chainInfo.Name, err)
 		}
 	}
 }
 
-func setupInternalNetworkRules(bridgeIface string, addr net.Addr, icc, insert bool) error {
-	var (
-		inDropRule  = iptRule{table: iptables.Filter, chain: IsolationChain1, args: []string{"-i", bridgeIface, "!", "-d", addr.String(), "-j", "DROP"}}
-		outDropRule = iptRule{table: iptables.Filter, chain: IsolationChain1, args: []string{"-o", bridgeIface, "!", "-s", addr.String(), "-j", "DROP"}}
-	)
-	if err := programChainRule(inDropRule, "DROP INCOMING", insert); err != nil {
-		return err
+func removeIP6Chains() {
+	fw6 := firewall.Get(firewall.IPv6)
+	for _, chainInfo := range []firewall.Chain{
+		{Name: DockerChain, Table: firewall.Nat},
+		{Name: DockerChain, Table: firewall.Filter},
+		{Name: IsolationChain1, Table: firewall.Filter},
+		{Name: IsolationChain2, Table: firewall.Filter},
+	} {
+		if err := fw6.RemoveChain(chainInfo.Table, chainInfo.Name); err != nil {
+			logrus.Warnf("Failed to remove existing ip6tables entries in table %s chain %s : %v", chainInfo.Table, chainInfo.Name, err)
+		}
 	}
-	if err := programChainRule(outDropRule, "DROP OUTGOING", insert); err != nil {
-		return err
+}
+
+// internalNetworkRules returns the rules preventing the traffic of an
+// internal network from leaving its subnet.
+func internalNetworkRules(bridgeIface string, addr net.Addr) []iptRule {
+	return []iptRule{
+		{table: firewall.Filter, chain: IsolationChain1, rule: firewall.Rule{InIface: bridgeIface, Dst: addr.String(), NotDst: true, Jump: "DROP"}},
+		{table: firewall.Filter, chain: IsolationChain1, rule: firewall.Rule{OutIface: bridgeIface, Src: addr.String(), NotSrc: true, Jump: "DROP"}},
+	}
+}
+
+func setupInternalNetworkRules(family firewall.Family, bridgeIface string, addr net.Addr, icc, insert bool) error {
+	tx := firewall.Get(family).NewTransaction()
+	for _, rule := range internalNetworkRules(bridgeIface, addr) {
+		addChainRule(tx, rule, insert)
 	}
 	// Set Inter Container Communication.
-	return setIcc(bridgeIface, icc, insert)
+	setIcc(tx, bridgeIface, icc, insert)
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("Unable to %s internal network %s rules: %v", operationName(insert), bridgeIface, err)
+	}
+	return nil
 }
 
 func clearEndpointConnections(nlh *netlink.Handle, ep *bridgeEndpoint) {
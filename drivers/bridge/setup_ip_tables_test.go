@@ -4,14 +4,15 @@ import (
 	"net"
 	"testing"
 
-	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/firewall"
 	"github.com/docker/libnetwork/portmapper"
 	"github.com/docker/libnetwork/testutils"
 	"github.com/vishvananda/netlink"
 )
 
 const (
-	iptablesTestBridgeIP = "192.168.42.1"
+	iptablesTestBridgeIP   = "192.168.42.1"
+	iptablesTestBridgeIPv6 = "fd00:42::1"
 )
 
 func TestProgramIPTable(t *testing.T) {
@@ -30,12 +31,12 @@ func TestProgramIPTable(t *testing.T) {
 		rule  iptRule
 		descr string
 	}{
-		{iptRule{table: iptables.Filter, chain: "FORWARD", args: []string{"-d", "127.1.2.3", "-i", "lo", "-o", "lo", "-j", "DROP"}}, "Test Loopback"},
-		{iptRule{table: iptables.Nat, chain: "POSTROUTING", preArgs: []string{"-t", "nat"}, args: []string{"-s", iptablesTestBridgeIP, "!", "-o", DefaultBridgeName, "-j", "MASQUERADE"}}, "NAT Test"},
-		{iptRule{table: iptables.Filter, chain: "FORWARD", args: []string{"-o", DefaultBridgeName, "-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "ACCEPT"}}, "Test ACCEPT INCOMING"},
-		{iptRule{table: iptables.Filter, chain: "FORWARD", args: []string{"-i", DefaultBridgeName, "!", "-o", DefaultBridgeName, "-j", "ACCEPT"}}, "Test ACCEPT NON_ICC OUTGOING"},
-		{iptRule{table: iptables.Filter, chain: "FORWARD", args: []string{"-i", DefaultBridgeName, "-o", DefaultBridgeName, "-j", "ACCEPT"}}, "Test enable ICC"},
-		{iptRule{table: iptables.Filter, chain: "FORWARD", args: []string{"-i", DefaultBridgeName, "-o", DefaultBridgeName, "-j", "DROP"}}, "Test disable ICC"},
+		{iptRule{table: firewall.Filter, chain: "FORWARD", rule: firewall.Rule{InIface: "lo", OutIface: "lo", Dst: "127.1.2.3", Jump: "DROP"}}, "Test Loopback"},
+		{iptRule{table: firewall.Nat, chain: "POSTROUTING", rule: firewall.Rule{Src: iptablesTestBridgeIP, OutIface: DefaultBridgeName, NotOutIface: true, Jump: "MASQUERADE"}}, "NAT Test"},
+		{iptRule{table: firewall.Filter, chain: "FORWARD", rule: firewall.Rule{OutIface: DefaultBridgeName, CtState: []string{"RELATED", "ESTABLISHED"}, Jump: "ACCEPT"}}, "Test ACCEPT INCOMING"},
+		{iptRule{table: firewall.Filter, chain: "FORWARD", rule: firewall.Rule{InIface: DefaultBridgeName, OutIface: DefaultBridgeName, NotOutIface: true, Jump: "ACCEPT"}}, "Test ACCEPT NON_ICC OUTGOING"},
+		{iptRule{table: firewall.Filter, chain: "FORWARD", rule: firewall.Rule{InIface: DefaultBridgeName, OutIface: DefaultBridgeName, Jump: "ACCEPT"}}, "Test enable ICC"},
+		{iptRule{table: firewall.Filter, chain: "FORWARD", rule: firewall.Rule{InIface: DefaultBridgeName, OutIface: DefaultBridgeName, Jump: "DROP"}}, "Test disable ICC"},
 	}
 
 	// Assert the chain rules' insertion and removal.
@@ -77,6 +78,149 @@ func TestSetupIPChains(t *testing.T) {
 	assertBridgeConfig(config, br, d, t)
 }
 
+func TestSetupIsolationRulesBothFamilies(t *testing.T) {
+	defer testutils.SetupTestOSContext(t)()
+
+	nh, err := netlink.NewHandle()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := &driver{
+		config: &configuration{
+			EnableIPTables:  true,
+			EnableIP6Tables: true,
+		},
+	}
+	assertChainConfig(d, t)
+	if d.natChainV6, d.filterChainV6, err = setupIP6Chains(d.config); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, internal := range []bool{false, true} {
+		config := getBasicTestConfig()
+		config.EnableIPv6 = true
+		config.AddressIPv6 = &net.IPNet{IP: net.ParseIP(iptablesTestBridgeIPv6), Mask: net.CIDRMask(64, 128)}
+		config.Internal = internal
+		br := &bridgeInterface{nlh: nh}
+		createTestBridge(config, br, t)
+		if err := setupBridgeIPv6(config, br); err != nil {
+			t.Fatal(err)
+		}
+
+		nw := &bridgeNetwork{portMapper: portmapper.New(""), config: config, driver: d}
+		if err := nw.setupIPTables(config, br); err != nil {
+			t.Fatal(err)
+		}
+		if err := nw.setupIP6Tables(config, br); err != nil {
+			t.Fatal(err)
+		}
+		if err := nw.isolateNetwork(nil, true); err != nil {
+			t.Fatal(err)
+		}
+
+		addrs := map[firewall.Family]*net.IPNet{
+			firewall.IPv4: {IP: br.bridgeIPv4.IP.Mask(br.bridgeIPv4.Mask), Mask: br.bridgeIPv4.Mask},
+			firewall.IPv6: {IP: br.bridgeIPv6.IP.Mask(br.bridgeIPv6.Mask), Mask: br.bridgeIPv6.Mask},
+		}
+		for family, addr := range addrs {
+			fw := firewall.Get(family)
+			rules := append([]iptRule{iccRule(config.BridgeName, config.EnableICC)}, incRules(config.BridgeName)...)
+			if internal {
+				rules = append([]iptRule{iccRule(config.BridgeName, config.EnableICC)}, internalNetworkRules(config.BridgeName, addr)...)
+			}
+			rules = append(rules, iptRule{table: firewall.Filter, chain: "FORWARD", rule: firewall.Rule{Jump: IsolationChain1}})
+			for _, r := range rules {
+				if !fw.Exists(r.table, r.chain, r.rule) {
+					t.Fatalf("Missing %s rule in %s/%s (internal: %t): %s", family, r.table, r.chain, internal, r.rule)
+				}
+			}
+		}
+
+		if err := nw.isolateNetwork(nil, false); err != nil {
+			t.Fatal(err)
+		}
+		for _, clean := range nw.iptCleanFuncs {
+			if err := clean(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for family := range addrs {
+			r := iccRule(config.BridgeName, config.EnableICC)
+			if firewall.Get(family).Exists(r.table, r.chain, r.rule) {
+				t.Fatalf("The %s ICC rule was not removed (internal: %t)", family, internal)
+			}
+		}
+	}
+}
+
+func TestRuleSetBothFamilies(t *testing.T) {
+	fw4, fw6 := firewall.Get(firewall.IPv4), firewall.Get(firewall.IPv6)
+	d := &driver{
+		config: &configuration{
+			EnableIPTables:  true,
+			EnableIP6Tables: true,
+		},
+		natChain:      &firewall.Chain{Name: DockerChain, Table: firewall.Nat, Backend: fw4},
+		filterChain:   &firewall.Chain{Name: DockerChain, Table: firewall.Filter, Backend: fw4},
+		natChainV6:    &firewall.Chain{Name: DockerChain, Table: firewall.Nat, Backend: fw6},
+		filterChainV6: &firewall.Chain{Name: DockerChain, Table: firewall.Filter, Backend: fw6},
+		networks:      map[string]*bridgeNetwork{},
+	}
+
+	addr := &net.IPNet{IP: net.ParseIP("192.168.42.0"), Mask: net.CIDRMask(24, 32)}
+	addrv6 := &net.IPNet{IP: net.ParseIP("fd00:42::"), Mask: net.CIDRMask(64, 128)}
+	for _, nwConfig := range []*networkConfiguration{
+		{BridgeName: "br-ext", EnableIPv6: true},
+		{BridgeName: "br-int", EnableIPv6: true, Internal: true, EnableICC: true},
+	} {
+		d.networks[nwConfig.BridgeName] = &bridgeNetwork{
+			config:     nwConfig,
+			portMapper: portmapper.New(""),
+			bridge: &bridgeInterface{
+				bridgeIPv4: &net.IPNet{IP: net.ParseIP("192.168.42.1"), Mask: addr.Mask},
+				bridgeIPv6: &net.IPNet{IP: net.ParseIP("fd00:42::1"), Mask: addrv6.Mask},
+			},
+		}
+	}
+
+	set := d.ruleSet()
+	expects := func(family firewall.Family, r iptRule, position firewall.Position) {
+		for _, e := range set.Rules {
+			if e.Family == family && e.Table == r.table && e.Chain == r.chain && e.Rule.String() == r.rule.String() && e.Position == position {
+				return
+			}
+		}
+		t.Fatalf("Missing %s expectation in %s/%s: %s", family, r.table, r.chain, r.rule)
+	}
+
+	for family, subnet := range map[firewall.Family]*net.IPNet{firewall.IPv4: addr, firewall.IPv6: addrv6} {
+		for _, chain := range []string{IsolationChain1, IsolationChain2} {
+			found := false
+			for _, c := range set.Chains {
+				if c == (firewall.ChainRef{Family: family, Table: firewall.Filter, Name: chain}) {
+					found = true
+				}
+			}
+			if !found {
+				t.Fatalf("Missing %s chain %s", family, chain)
+			}
+			expects(family, iptRule{table: firewall.Filter, chain: chain, rule: firewall.Rule{Jump: "RETURN"}}, firewall.Bottom)
+		}
+		expects(family, iptRule{table: firewall.Filter, chain: "FORWARD", rule: firewall.Rule{Jump: IsolationChain1}}, firewall.Top)
+
+		expects(family, iccRule("br-ext", false), firewall.Tail)
+		for _, r := range incRules("br-ext") {
+			expects(family, r, firewall.Anywhere)
+		}
+
+		expects(family, iccRule("br-int", true), firewall.Anywhere)
+		for _, r := range internalNetworkRules("br-int", subnet) {
+			expects(family, r, firewall.Anywhere)
+		}
+	}
+}
+
 func getBasicTestConfig() *networkConfiguration {
 	config := &networkConfiguration{
 		BridgeName:  DefaultBridgeName,
@@ -99,7 +243,7 @@ func assertIPTableChainProgramming(rule iptRule, descr string, t *testing.T) {
 	if err := programChainRule(rule, descr, true); err != nil {
 		t.Fatalf("Failed to program iptable rule %s: %s", descr, err.Error())
 	}
-	if iptables.Exists(rule.table, rule.chain, rule.args...) == false {
+	if rule.backend().Exists(rule.table, rule.chain, rule.rule) == false {
 		t.Fatalf("Failed to effectively program iptable rule: %s", descr)
 	}
 
@@ -107,7 +251,7 @@ func assertIPTableChainProgramming(rule iptRule, descr string, t *testing.T) {
 	if err := programChainRule(rule, descr, false); err != nil {
 		t.Fatalf("Failed to remove iptable rule %s: %s", descr, err.Error())
 	}
-	if iptables.Exists(rule.table, rule.chain, rule.args...) == true {
+	if rule.backend().Exists(rule.table, rule.chain, rule.rule) == true {
 		t.Fatalf("Failed to effectively remove iptable rule: %s", descr)
 	}
 }
@@ -134,3 +278,75 @@ func assertBridgeConfig(config *networkConfiguration, br *bridgeInterface, d *dr
 		t.Fatalf("%v", err)
 	}
 }
+
+func TestEgressSNATRules(t *testing.T) {
+	addr := &net.IPNet{IP: net.ParseIP("192.168.42.0"), Mask: net.CIDRMask(24, 32)}
+
+	masq := egressNatRule(firewall.IPv4, "br0", addr, nil)
+	if masq.rule.Jump != "MASQUERADE" || masq.rule.ToSource != "" {
+		t.Fatalf("Unexpected egress rule without SNAT address: %s", masq.rule)
+	}
+
+	snatIP := net.ParseIP("10.0.0.5")
+	found := false
+	for _, r := range bridgeRules(firewall.IPv4, "br0", addr, true, snatIP, false) {
+		if r.chain != "POSTROUTING" {
+			continue
+		}
+		if r.rule.Jump == "MASQUERADE" && r.rule.Src != "" {
+			t.Fatalf("Unexpected masquerading rule with a SNAT address: %s", r.rule)
+		}
+		if r.rule.Jump == "SNAT" && r.rule.ToSource == "10.0.0.5" && r.rule.Src == addr.String() {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatal("Missing egress SNAT rule")
+	}
+
+	addrv6 := &net.IPNet{IP: net.ParseIP("fd00:42::"), Mask: net.CIDRMask(64, 128)}
+	if rules := bridgeRules(firewall.IPv6, "br0", addrv6, true, nil, true); len(rules) != 0 {
+		t.Fatalf("Unexpected IPv6 bridge rules without SNAT address: %v", rules)
+	}
+	rules := bridgeRules(firewall.IPv6, "br0", addrv6, true, net.ParseIP("fd00::5"), false)
+	if len(rules) != 2 || rules[0].rule.Jump != "SNAT" || rules[1].rule.Jump != "RETURN" {
+		t.Fatalf("Unexpected IPv6 bridge rules: %v", rules)
+	}
+	for _, r := range rules {
+		if r.family != firewall.IPv6 {
+			t.Fatalf("Unexpected family of IPv6 bridge rule %v", r.rule)
+		}
+	}
+
+	config := &networkConfiguration{BridgeName: "br0", EgressSNATIPv4: snatIP}
+	if err := config.Validate(); err == nil {
+		t.Fatal("Expected an error validating a SNAT address without IP masquerading")
+	}
+	config.EnableIPMasquerade = true
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	config.EgressSNATIPv6 = snatIP
+	if err := config.Validate(); err == nil {
+		t.Fatal("Expected an error validating an IPv4 address as IPv6 SNAT address")
+	}
+}
+
+func TestCheckHostAddress(t *testing.T) {
+	defer testutils.SetupTestOSContext(t)()
+
+	nh, err := netlink.NewHandle()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nh.Delete()
+
+	createTestBridge(getBasicTestConfig(), &bridgeInterface{nlh: nh}, t)
+
+	if err := checkHostAddress(nh, net.ParseIP(iptablesTestBridgeIP)); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkHostAddress(nh, net.ParseIP("10.99.99.99")); err == nil {
+		t.Fatal("Expected an error checking an address missing on the host")
+	}
+}
@@ -0,0 +1,161 @@
+package bridge
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// Bridge port attributes missing in the netlink package
+	iflaBrportNeighSuppress = 32
+	iflaBrportIsolated      = 33
+
+	// userHZ is the unit of the bridge ageing time, in clock ticks
+	userHZ = 100
+	// maxAgeingTime is the highest ageing time, in seconds, the kernel
+	// represents
+	maxAgeingTime = math.MaxUint32 / userHZ
+)
+
+// hasBridgeTunables tells whether any of the bridge tunables is set.
+func (c *networkConfiguration) hasBridgeTunables() bool {
+	return c.STP != nil || c.MulticastSnooping != nil || c.MulticastQuerier != nil || c.AgeingTime != nil
+}
+
+// hasPortTunables tells whether any of the bridge port tunables is set.
+func (ec *endpointConfiguration) hasPortTunables() bool {
+	return ec != nil && (ec.Hairpin != nil || ec.Isolated != nil || ec.NeighSuppress != nil)
+}
+
+// setupBridgeTunables applies the tunables of the network to its bridge.
+func setupBridgeTunables(config *networkConfiguration, i *bridgeInterface) error {
+	if !config.hasBridgeTunables() {
+		return nil
+	}
+	if err := setBridgeTunables(i.Link, config); err != nil {
+		return fmt.Errorf("failed to set the tunables of bridge %s: %v", config.BridgeName, err)
+	}
+	return nil
+}
+
+// setBridgeTunables sets the set tunables of the bridge in a single request,
+// the others keeping their current values.
+func setBridgeTunables(link netlink.Link, config *networkConfiguration) error {
+	req := nl.NewNetlinkRequest(unix.RTM_NEWLINK, unix.NLM_F_ACK)
+
+	msg := nl.NewIfInfomsg(unix.AF_UNSPEC)
+	msg.Index = int32(link.Attrs().Index)
+	req.AddData(msg)
+
+	linkInfo := nl.NewRtAttr(unix.IFLA_LINKINFO, nil)
+	nl.NewRtAttrChild(linkInfo, nl.IFLA_INFO_KIND, nl.NonZeroTerminated("bridge"))
+	data := nl.NewRtAttrChild(linkInfo, nl.IFLA_INFO_DATA, nil)
+	if config.STP != nil {
+		nl.NewRtAttrChild(data, nl.IFLA_BR_STP_STATE, nl.Uint32Attr(uint32(boolToUint8(*config.STP))))
+	}
+	if config.AgeingTime != nil {
+		nl.NewRtAttrChild(data, nl.IFLA_BR_AGEING_TIME, nl.Uint32Attr(*config.AgeingTime*userHZ))
+	}
+	if config.MulticastSnooping != nil {
+		nl.NewRtAttrChild(data, nl.IFLA_BR_MCAST_SNOOPING, nl.Uint8Attr(boolToUint8(*config.MulticastSnooping)))
+	}
+	if config.MulticastQuerier != nil {
+		nl.NewRtAttrChild(data, nl.IFLA_BR_MCAST_QUERIER, nl.Uint8Attr(boolToUint8(*config.MulticastQuerier)))
+	}
+	req.AddData(linkInfo)
+
+	_, err := req.Execute(unix.NETLINK_ROUTE, 0)
+	return err
+}
+
+// setPortTunables sets the set tunables of the bridge port of an endpoint,
+// in a single request.
+func setPortTunables(link netlink.Link, ec *endpointConfiguration) error {
+	if !ec.hasPortTunables() {
+		return nil
+	}
+
+	req := nl.NewNetlinkRequest(unix.RTM_SETLINK, unix.NLM_F_ACK)
+
+	msg := nl.NewIfInfomsg(unix.AF_BRIDGE)
+	msg.Index = int32(link.Attrs().Index)
+	req.AddData(msg)
+
+	protinfo := nl.NewRtAttr(unix.IFLA_PROTINFO|unix.NLA_F_NESTED, nil)
+	if ec.Hairpin != nil {
+		nl.NewRtAttrChild(protinfo, nl.IFLA_BRPORT_MODE, nl.Uint8Attr(boolToUint8(*ec.Hairpin)))
+	}
+	if ec.Isolated != nil {
+		nl.NewRtAttrChild(protinfo, iflaBrportIsolated, nl.Uint8Attr(boolToUint8(*ec.Isolated)))
+	}
+	if ec.NeighSuppress != nil {
+		nl.NewRtAttrChild(protinfo, iflaBrportNeighSuppress, nl.Uint8Attr(boolToUint8(*ec.NeighSuppress)))
+	}
+	req.AddData(protinfo)
+
+	if _, err := req.Execute(unix.NETLINK_ROUTE, 0); err != nil {
+		return fmt.Errorf("failed to set the tunables of port %s: %v", link.Attrs().Name, err)
+	}
+	return nil
+}
+
+// restoreEndpointTunables sets the tunables of a restored endpoint again on
+// its bridge port.
+func (n *bridgeNetwork) restoreEndpointTunables(nlh *netlink.Handle, ep *bridgeEndpoint) {
+	if !ep.config.hasPortTunables() || ep.hostIfName == "" {
+		return
+	}
+	link, err := nlh.LinkByName(ep.hostIfName)
+	if err != nil {
+		logrus.Warnf("Failed to find the host interface %s of endpoint %s to restore its tunables: %v", ep.hostIfName, ep.id[0:7], err)
+		return
+	}
+	if err := setPortTunables(link, ep.config); err != nil {
+		logrus.Warnf("Failed to restore the tunables of endpoint %s: %v", ep.id[0:7], err)
+	}
+}
+
+// parseTunableBool parses the value of a boolean tunable.
+func parseTunableBool(value string) (*bool, error) {
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// parseAgeingTime parses an ageing time in seconds.
+func parseAgeingTime(value string) (*uint32, error) {
+	t, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	if t > maxAgeingTime {
+		return nil, fmt.Errorf("ageing time exceeds %d seconds", maxAgeingTime)
+	}
+	at := uint32(t)
+	return &at, nil
+}
+
+// tunableBool returns the value of a boolean tunable unmarshalled from the
+// store, nil when unset.
+func tunableBool(v interface{}) *bool {
+	b, ok := v.(bool)
+	if !ok {
+		return nil
+	}
+	return &b
+}
+
+func boolToUint8(b bool) uint8 {
+	if b {
+		return 1
+	}
+	return 0
+}
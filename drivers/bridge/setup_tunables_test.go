@@ -0,0 +1,135 @@
+package bridge
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/docker/libnetwork/testutils"
+	"github.com/vishvananda/netlink"
+)
+
+func TestSetupBridgeTunables(t *testing.T) {
+	defer testutils.SetupTestOSContext(t)()
+
+	nh, err := netlink.NewHandle()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nh.Delete()
+
+	enable, disable, ageing := true, false, uint32(60)
+	config := getBasicTestConfig()
+	config.STP = &enable
+	config.MulticastSnooping = &disable
+	config.MulticastQuerier = &enable
+	config.AgeingTime = &ageing
+	br := &bridgeInterface{nlh: nh}
+	if err := setupDevice(config, br); err != nil {
+		t.Fatal(err)
+	}
+
+	link, err := nh.LinkByName(config.BridgeName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bridge, ok := link.(*netlink.Bridge)
+	if !ok {
+		t.Fatalf("Unexpected link type %s", link.Type())
+	}
+	if bridge.MulticastSnooping == nil || *bridge.MulticastSnooping {
+		t.Fatal("Expected the multicast snooping to be disabled")
+	}
+
+	// Reconciling an existing bridge applies the tunables again
+	config.MulticastSnooping = &enable
+	if err := setupBridgeTunables(config, &bridgeInterface{nlh: nh, Link: link}); err != nil {
+		t.Fatal(err)
+	}
+	if link, err = nh.LinkByName(config.BridgeName); err != nil {
+		t.Fatal(err)
+	}
+	if snooping := link.(*netlink.Bridge).MulticastSnooping; snooping == nil || !*snooping {
+		t.Fatal("Expected the multicast snooping to be enabled")
+	}
+}
+
+func TestSetPortTunables(t *testing.T) {
+	defer testutils.SetupTestOSContext(t)()
+
+	nh, err := netlink.NewHandle()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nh.Delete()
+
+	config := getBasicTestConfig()
+	createTestBridge(config, &bridgeInterface{nlh: nh}, t)
+
+	veth := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "veth0"}, PeerName: "veth1"}
+	if err := nh.LinkAdd(veth); err != nil {
+		t.Fatal(err)
+	}
+	if err := addToBridge(nh, "veth0", config.BridgeName); err != nil {
+		t.Fatal(err)
+	}
+
+	enable := true
+	if err := setPortTunables(veth, &endpointConfiguration{Hairpin: &enable, Isolated: &enable}); err != nil {
+		t.Fatal(err)
+	}
+	pi, err := nh.LinkGetProtinfo(veth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pi.Hairpin {
+		t.Fatal("Expected the hairpin mode to be enabled on the port")
+	}
+}
+
+func TestTunablesOptions(t *testing.T) {
+	config := &networkConfiguration{}
+	labels := map[string]string{
+		STP:               "true",
+		MulticastSnooping: "false",
+		AgeingTime:        "120",
+	}
+	if err := config.fromLabels(labels); err != nil {
+		t.Fatal(err)
+	}
+	if config.STP == nil || !*config.STP || config.MulticastSnooping == nil || *config.MulticastSnooping ||
+		config.MulticastQuerier != nil || config.AgeingTime == nil || *config.AgeingTime != 120 {
+		t.Fatalf("Unexpected tunables %+v", config)
+	}
+
+	if err := (&networkConfiguration{}).fromLabels(map[string]string{AgeingTime: "50000000"}); err == nil {
+		t.Fatal("Expected an error parsing an ageing time exceeding the kernel range")
+	}
+
+	b, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := &networkConfiguration{}
+	if err := json.Unmarshal(b, restored); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(restored.STP, config.STP) || !reflect.DeepEqual(restored.MulticastSnooping, config.MulticastSnooping) ||
+		restored.MulticastQuerier != nil || !reflect.DeepEqual(restored.AgeingTime, config.AgeingTime) {
+		t.Fatalf("Unexpected restored tunables %+v", restored)
+	}
+
+	ec, err := parseEndpointOptions(map[string]interface{}{
+		EndpointHairpin:  "false",
+		EndpointIsolated: "true",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ec.Hairpin == nil || *ec.Hairpin || ec.Isolated == nil || !*ec.Isolated || ec.NeighSuppress != nil {
+		t.Fatalf("Unexpected endpoint tunables %+v", ec)
+	}
+	if _, err := parseEndpointOptions(map[string]interface{}{EndpointNeighSuppress: "maybe"}); err == nil {
+		t.Fatal("Expected an error parsing an invalid endpoint tunable")
+	}
+}
@@ -0,0 +1,216 @@
+package bridge
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// uplinkIPConfig is the host IP configuration moved from the uplink to the
+// bridge, recorded to restore it on the uplink on network delete.
+type uplinkIPConfig struct {
+	Addrs  []string
+	Routes []uplinkRoute
+}
+
+// uplinkRoute is a route through the uplink. An empty destination is the
+// default route.
+type uplinkRoute struct {
+	Dst      string
+	Gw       string
+	Src      string
+	Priority int
+	Table    int
+}
+
+// hasAddr tells whether the address was moved from the uplink.
+func (c *uplinkIPConfig) hasAddr(addr *net.IPNet) bool {
+	if c == nil {
+		return false
+	}
+	for _, a := range c.Addrs {
+		if a == addr.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// setupUplink adds the uplink interface to the bridge, and moves the uplink
+// IP configuration to the bridge if requested. It returns whether
+// it attached the uplink, an uplink already attached on restore being
+// only re-programmed. On failure, the changes made are rolled back.
+func setupUplink(config *networkConfiguration, i *bridgeInterface) (attached bool, err error) {
+	link, err := i.nlh.LinkByName(config.Uplink)
+	if err != nil {
+		return false, fmt.Errorf("could not find uplink %s: %v", config.Uplink, err)
+	}
+
+	if master := link.Attrs().MasterIndex; master != 0 {
+		if master != i.Link.Attrs().Index {
+			return false, types.ForbiddenErrorf("uplink %s is already attached to another bridge", config.Uplink)
+		}
+		// Restoring a network whose uplink is still attached
+		if config.UplinkMoveIP && config.UplinkIPConfig != nil {
+			if err := moveIPConfig(i.nlh, link, i.Link, config.UplinkIPConfig); err != nil {
+				return false, fmt.Errorf("failed to restore the IP configuration of uplink %s on bridge %s: %v", config.Uplink, config.BridgeName, err)
+			}
+		}
+		return false, nil
+	}
+
+	var ipConfig *uplinkIPConfig
+	if config.UplinkMoveIP {
+		if ipConfig, err = getUplinkIPConfig(i.nlh, link); err != nil {
+			return false, err
+		}
+	}
+
+	if err := addToBridge(i.nlh, config.Uplink, config.BridgeName); err != nil {
+		return false, fmt.Errorf("adding uplink %s to bridge %s failed: %v", config.Uplink, config.BridgeName, err)
+	}
+	defer func() {
+		if err != nil {
+			if err := i.nlh.LinkSetNoMaster(link); err != nil {
+				logrus.Warnf("Failed to remove uplink %s from bridge %s on rollback: %v", config.Uplink, config.BridgeName, err)
+			}
+		}
+	}()
+
+	if err := i.nlh.LinkSetUp(link); err != nil {
+		return false, fmt.Errorf("could not set link up for uplink %s: %v", config.Uplink, err)
+	}
+
+	if ipConfig != nil {
+		if err := moveIPConfig(i.nlh, link, i.Link, ipConfig); err != nil {
+			if errRb := moveIPConfig(i.nlh, i.Link, link, ipConfig); errRb != nil {
+				logrus.Warnf("Failed to restore the IP configuration of uplink %s on rollback: %v", config.Uplink, errRb)
+			}
+			return false, fmt.Errorf("failed to move the IP configuration of uplink %s to bridge %s: %v", config.Uplink, config.BridgeName, err)
+		}
+		config.UplinkIPConfig = ipConfig
+	}
+
+	return true, nil
+}
+
+// removeUplink releases the uplink interface from the bridge and restores
+// its IP configuration.
+func removeUplink(nlh *netlink.Handle, config *networkConfiguration) error {
+	link, err := nlh.LinkByName(config.Uplink)
+	if err != nil {
+		return fmt.Errorf("could not find uplink %s: %v", config.Uplink, err)
+	}
+	if err := nlh.LinkSetNoMaster(link); err != nil {
+		return fmt.Errorf("could not remove uplink %s from bridge %s: %v", config.Uplink, config.BridgeName, err)
+	}
+
+	if !config.UplinkMoveIP || config.UplinkIPConfig == nil {
+		return nil
+	}
+	bridge, err := nlh.LinkByName(config.BridgeName)
+	if err != nil {
+		return fmt.Errorf("could not find bridge %s: %v", config.BridgeName, err)
+	}
+	if err := moveIPConfig(nlh, bridge, link, config.UplinkIPConfig); err != nil {
+		return fmt.Errorf("failed to restore the IP configuration of uplink %s: %v", config.Uplink, err)
+	}
+	return nil
+}
+
+// getUplinkIPConfig returns the addresses, but the IPv6 link local ones,
+// and the routes, but the ones the kernel adds with the addresses, of the
+// uplink.
+func getUplinkIPConfig(nlh *netlink.Handle, link netlink.Link) (*uplinkIPConfig, error) {
+	name := link.Attrs().Name
+	addrs, err := nlh.AddrList(link, netlink.FAMILY_ALL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the addresses of uplink %s: %v", name, err)
+	}
+	routes, err := nlh.RouteList(link, netlink.FAMILY_ALL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the routes of uplink %s: %v", name, err)
+	}
+
+	c := &uplinkIPConfig{}
+	for _, a := range addrs {
+		if a.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		c.Addrs = append(c.Addrs, a.IPNet.String())
+	}
+	for _, r := range routes {
+		if r.Protocol == unix.RTPROT_KERNEL || (r.Dst != nil && r.Dst.IP.IsLinkLocalUnicast()) {
+			continue
+		}
+		ur := uplinkRoute{Priority: r.Priority, Table: r.Table}
+		if r.Dst != nil {
+			ur.Dst = r.Dst.String()
+		}
+		if r.Gw != nil {
+			ur.Gw = r.Gw.String()
+		}
+		if r.Src != nil {
+			ur.Src = r.Src.String()
+		}
+		c.Routes = append(c.Routes, ur)
+	}
+	return c, nil
+}
+
+// moveIPConfig moves the addresses and routes from an interface to the
+// other. All the changes are attempted, the first error is returned.
+func moveIPConfig(nlh *netlink.Handle, from, to netlink.Link, c *uplinkIPConfig) error {
+	var firstErr error
+	setErr := func(err error) {
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	for _, a := range c.Addrs {
+		ipNet, err := types.ParseCIDR(a)
+		if err != nil {
+			setErr(fmt.Errorf("invalid address %s: %v", a, err))
+			continue
+		}
+		addr := &netlink.Addr{IPNet: ipNet}
+		if err := nlh.AddrDel(from, addr); err != nil && err != unix.EADDRNOTAVAIL {
+			setErr(fmt.Errorf("failed to remove address %s from %s: %v", a, from.Attrs().Name, err))
+		}
+		if err := nlh.AddrReplace(to, addr); err != nil {
+			setErr(fmt.Errorf("failed to add address %s to %s: %v", a, to.Attrs().Name, err))
+		}
+	}
+
+	// The routes were removed with the addresses of their source interface.
+	// Their gateways are only reachable once the interface is up, the
+	// bridge being brought up by the last setup step.
+	if len(c.Routes) > 0 {
+		if err := nlh.LinkSetUp(to); err != nil {
+			setErr(fmt.Errorf("could not set link up for %s: %v", to.Attrs().Name, err))
+		}
+	}
+	for _, ur := range c.Routes {
+		r := &netlink.Route{LinkIndex: to.Attrs().Index, Priority: ur.Priority, Table: ur.Table}
+		if ur.Dst != "" {
+			dst, err := types.ParseCIDR(ur.Dst)
+			if err != nil {
+				setErr(fmt.Errorf("invalid route destination %s: %v", ur.Dst, err))
+				continue
+			}
+			r.Dst = dst
+		}
+		r.Gw = net.ParseIP(ur.Gw)
+		r.Src = net.ParseIP(ur.Src)
+		if err := nlh.RouteReplace(r); err != nil {
+			setErr(fmt.Errorf("failed to add route %s via %s on %s: %v", ur.Dst, ur.Gw, to.Attrs().Name, err))
+		}
+	}
+
+	return firstErr
+}
@@ -0,0 +1,173 @@
+package bridge
+
+import (
+	"encoding/json"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/docker/libnetwork/testutils"
+	"github.com/docker/libnetwork/types"
+	"github.com/vishvananda/netlink"
+)
+
+func TestSetupUplinkMoveIP(t *testing.T) {
+	defer testutils.SetupTestOSContext(t)()
+
+	nh, err := netlink.NewHandle()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nh.Delete()
+
+	uplink := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "uplink0"}, PeerName: "uplink1"}
+	if err := nh.LinkAdd(uplink); err != nil {
+		t.Fatal(err)
+	}
+	if err := nh.LinkSetUp(uplink); err != nil {
+		t.Fatal(err)
+	}
+	addr, _ := types.ParseCIDR("10.42.0.2/24")
+	if err := nh.AddrAdd(uplink, &netlink.Addr{IPNet: addr}); err != nil {
+		t.Fatal(err)
+	}
+	dst, _ := types.ParseCIDR("10.43.0.0/16")
+	if err := nh.RouteAdd(&netlink.Route{LinkIndex: uplink.Attrs().Index, Dst: dst, Gw: net.ParseIP("10.42.0.1")}); err != nil {
+		t.Fatal(err)
+	}
+
+	config := getBasicTestConfig()
+	config.Uplink = "uplink0"
+	config.UplinkMoveIP = true
+	br := &bridgeInterface{nlh: nh}
+	createTestBridge(config, br, t)
+
+	attached, err := setupUplink(config, br)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !attached {
+		t.Fatal("Expected the uplink to be attached")
+	}
+	if config.UplinkIPConfig == nil || !config.UplinkIPConfig.hasAddr(addr) || len(config.UplinkIPConfig.Routes) != 1 {
+		t.Fatalf("Unexpected recorded uplink IP configuration %+v", config.UplinkIPConfig)
+	}
+
+	link, err := nh.LinkByName("uplink0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link.Attrs().MasterIndex != br.Link.Attrs().Index {
+		t.Fatal("The uplink is not attached to the bridge")
+	}
+	assertAddress(t, nh, br.Link, addr, true)
+	assertAddress(t, nh, link, addr, false)
+	assertRoute(t, nh, br.Link, dst)
+
+	// Setting up the restored network keeps the uplink attached
+	if attached, err = setupUplink(config, br); err != nil || attached {
+		t.Fatalf("Unexpected restore of the uplink, attached: %t, error: %v", attached, err)
+	}
+
+	if err := removeUplink(nh, config); err != nil {
+		t.Fatal(err)
+	}
+	if link, err = nh.LinkByName("uplink0"); err != nil {
+		t.Fatal(err)
+	}
+	if link.Attrs().MasterIndex != 0 {
+		t.Fatal("The uplink is still attached to the bridge")
+	}
+	assertAddress(t, nh, link, addr, true)
+	assertAddress(t, nh, br.Link, addr, false)
+	assertRoute(t, nh, link, dst)
+}
+
+func TestSetupUplinkAttachedElsewhere(t *testing.T) {
+	defer testutils.SetupTestOSContext(t)()
+
+	nh, err := netlink.NewHandle()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nh.Delete()
+
+	other := &netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: "other0"}}
+	uplink := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "uplink0"}, PeerName: "uplink1"}
+	for _, l := range []netlink.Link{other, uplink} {
+		if err := nh.LinkAdd(l); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := nh.LinkSetMaster(uplink, other); err != nil {
+		t.Fatal(err)
+	}
+
+	config := getBasicTestConfig()
+	config.Uplink = "uplink0"
+	br := &bridgeInterface{nlh: nh}
+	createTestBridge(config, br, t)
+
+	if _, err := setupUplink(config, br); err == nil {
+		t.Fatal("Expected a failure attaching an uplink of another bridge")
+	}
+}
+
+func TestUplinkConfigMarshalling(t *testing.T) {
+	config := getBasicTestConfig()
+	config.Uplink = "eth1"
+	config.UplinkMoveIP = true
+	config.UplinkIPConfig = &uplinkIPConfig{
+		Addrs:  []string{"10.42.0.2/24", "2001:db8::2/64"},
+		Routes: []uplinkRoute{{Gw: "10.42.0.1", Priority: 100, Table: 254}},
+	}
+
+	b, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := &networkConfiguration{}
+	if err := json.Unmarshal(b, restored); err != nil {
+		t.Fatal(err)
+	}
+	if !restored.UplinkMoveIP || !reflect.DeepEqual(restored.UplinkIPConfig, config.UplinkIPConfig) {
+		t.Fatalf("Unexpected restored uplink configuration %+v", restored.UplinkIPConfig)
+	}
+
+	config.Uplink = ""
+	if err := config.Validate(); err == nil {
+		t.Fatal("Expected an error validating an uplink IP move without uplink")
+	}
+	if err := (&networkConfiguration{BridgeName: "br0", Uplink: "eth1"}).Conflicts(&networkConfiguration{BridgeName: "br1", Uplink: "eth1"}); err == nil {
+		t.Fatal("Expected a conflict between networks with the same uplink")
+	}
+}
+
+func assertAddress(t *testing.T, nh *netlink.Handle, link netlink.Link, addr *net.IPNet, expected bool) {
+	addrs, err := nh.AddrList(link, netlink.FAMILY_V4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, a := range addrs {
+		if a.IPNet.String() == addr.String() {
+			found = true
+		}
+	}
+	if found != expected {
+		t.Fatalf("Expected address %s on %s: %t, got %v", addr, link.Attrs().Name, expected, addrs)
+	}
+}
+
+func assertRoute(t *testing.T, nh *netlink.Handle, link netlink.Link, dst *net.IPNet) {
+	routes, err := nh.RouteList(link, netlink.FAMILY_V4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range routes {
+		if r.Dst != nil && r.Dst.String() == dst.String() {
+			return
+		}
+	}
+	t.Fatalf("Missing route to %s on %s: %v", dst, link.Attrs().Name, routes)
+}
@@ -35,16 +35,18 @@ func setupVerifyAndReconcile(config *networkConfiguration, i *bridgeInterface) e
 		return (*IPv6AddrNoMatchError)(bridgeIPv6)
 	}
 
-	// Release any residual IPv6 address that might be there because of older daemon instances
+	// Release any residual IPv6 address that might be there because of older daemon instances,
+	// but the ones moved from the uplink
 	for _, addrv6 := range addrsv6 {
-		if addrv6.IP.IsGlobalUnicast() && !types.CompareIPNet(addrv6.IPNet, i.bridgeIPv6) {
+		if addrv6.IP.IsGlobalUnicast() && !types.CompareIPNet(addrv6.IPNet, i.bridgeIPv6) && !config.UplinkIPConfig.hasAddr(addrv6.IPNet) {
 			if err := i.nlh.AddrDel(i.Link, &addrv6); err != nil {
 				logrus.Warnf("Failed to remove residual IPv6 address %s from bridge: %v", addrv6.IPNet, err)
 			}
 		}
 	}
 
-	return nil
+	// Reconcile the tunables of the existing bridge with the configuration
+	return setupBridgeTunables(config, i)
 }
 
 func findIPv6Address(addr netlink.Addr, addresses []netlink.Addr) bool {
@@ -0,0 +1,141 @@
+package bridge
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+)
+
+const (
+	// defaultVlan is the VLAN the bridge ports are members of, untagged,
+	// when VLAN filtering is enabled on the bridge
+	defaultVlan = 1
+	minVlan     = 1
+	maxVlan     = 4094
+)
+
+// setupVlanFiltering enables the VLAN filtering on the bridge, so that its
+// ports only forward the frames of their VLANs.
+func setupVlanFiltering(config *networkConfiguration, i *bridgeInterface) error {
+	path := filepath.Join("/sys/class/net", config.BridgeName, "bridge/vlan_filtering")
+	if err := ioutil.WriteFile(path, []byte{'1', '\n'}, 0644); err != nil {
+		return fmt.Errorf("unable to enable VLAN filtering on bridge %s: %v", config.BridgeName, err)
+	}
+	return nil
+}
+
+// setupUplinkVlans makes the uplink interface, a port of the bridge, a
+// trunk port carrying the uplink VLANs.
+func setupUplinkVlans(config *networkConfiguration, i *bridgeInterface) error {
+	link, err := i.nlh.LinkByName(config.Uplink)
+	if err != nil {
+		return fmt.Errorf("could not find uplink %s: %v", config.Uplink, err)
+	}
+	if link.Attrs().MasterIndex != i.Link.Attrs().Index {
+		return types.ForbiddenErrorf("uplink %s is not a port of bridge %s", config.Uplink, config.BridgeName)
+	}
+	return setPortVlans(i.nlh, link, 0, config.UplinkVlans)
+}
+
+// setPortVlans makes the bridge port an access port of the VLAN access, if
+// not zero, and a tagged member of the trunk VLANs. The port of an access
+// VLAN leaves the default VLAN.
+func setPortVlans(nlh *netlink.Handle, link netlink.Link, access uint16, trunk []uint16) error {
+	name := link.Attrs().Name
+	if access != 0 {
+		if access != defaultVlan {
+			// The port may have already left the default VLAN on restore
+			if err := nlh.BridgeVlanDel(link, defaultVlan, true, true, false, true); err != nil {
+				logrus.Debugf("Failed to remove port %s from the default VLAN: %v", name, err)
+			}
+		}
+		if err := nlh.BridgeVlanAdd(link, access, true, true, false, true); err != nil {
+			return fmt.Errorf("failed to set access VLAN %d on port %s: %v", access, name, err)
+		}
+	}
+	for _, vid := range trunk {
+		if err := nlh.BridgeVlanAdd(link, vid, false, false, false, true); err != nil {
+			return fmt.Errorf("failed to add trunk VLAN %d on port %s: %v", vid, name, err)
+		}
+	}
+	return nil
+}
+
+// checkEndpointVlans verifies that the VLANs of an endpoint can be set on
+// the bridge of the network. The bridge itself, holding the gateway
+// address, sends its frames on the default VLAN: an endpoint of a network
+// which is not internal must stay an access port of that VLAN to reach its
+// gateway.
+func checkEndpointVlans(config *networkConfiguration, epConfig *endpointConfiguration) error {
+	if epConfig == nil || (epConfig.AccessVlan == 0 && len(epConfig.TrunkVlans) == 0) {
+		return nil
+	}
+	if !config.VlanFiltering {
+		return types.ForbiddenErrorf("endpoint VLANs require VLAN filtering on network %s", config.ID)
+	}
+	if epConfig.AccessVlan != 0 && epConfig.AccessVlan != defaultVlan && !config.Internal {
+		return types.ForbiddenErrorf("the gateway of network %s is not reachable from access VLAN %d, only internal networks allow an access VLAN other than %d", config.ID, epConfig.AccessVlan, defaultVlan)
+	}
+	return nil
+}
+
+// parseVlan parses a VLAN ID.
+func parseVlan(value string) (uint16, error) {
+	vid, err := strconv.ParseUint(strings.TrimSpace(value), 10, 16)
+	if err != nil || vid < minVlan || vid > maxVlan {
+		return 0, ErrInvalidVlan(value)
+	}
+	return uint16(vid), nil
+}
+
+// parseVlanList parses a comma separated list of VLAN IDs and VLAN ID
+// ranges, as "10,20,100-110".
+func parseVlanList(value string) ([]uint16, error) {
+	var (
+		vids []uint16
+		seen = map[uint16]bool{}
+	)
+	for _, item := range strings.Split(value, ",") {
+		first, last := item, item
+		if i := strings.Index(item, "-"); i >= 0 {
+			first, last = item[:i], item[i+1:]
+		}
+		start, err := parseVlan(first)
+		if err != nil {
+			return nil, ErrInvalidVlan(item)
+		}
+		end, err := parseVlan(last)
+		if err != nil || end < start {
+			return nil, ErrInvalidVlan(item)
+		}
+		for vid := int(start); vid <= int(end); vid++ {
+			if !seen[uint16(vid)] {
+				seen[uint16(vid)] = true
+				vids = append(vids, uint16(vid))
+			}
+		}
+	}
+	return vids, nil
+}
+
+// restoreEndpointVlans programs the VLANs of a restored endpoint again on
+// its host side interface.
+func (n *bridgeNetwork) restoreEndpointVlans(nlh *netlink.Handle, ep *bridgeEndpoint) {
+	if ep.config == nil || (ep.config.AccessVlan == 0 && len(ep.config.TrunkVlans) == 0) || ep.hostIfName == "" {
+		return
+	}
+	link, err := nlh.LinkByName(ep.hostIfName)
+	if err != nil {
+		logrus.Warnf("Failed to find the host interface %s of endpoint %s to restore its VLANs: %v", ep.hostIfName, ep.id[0:7], err)
+		return
+	}
+	if err := setPortVlans(nlh, link, ep.config.AccessVlan, ep.config.TrunkVlans); err != nil {
+		logrus.Warnf("Failed to restore the VLANs of endpoint %s: %v", ep.id[0:7], err)
+	}
+}
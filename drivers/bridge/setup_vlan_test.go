@@ -0,0 +1,97 @@
+package bridge
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestParseVlanList(t *testing.T) {
+	vids, err := parseVlanList("10,20,100-103,20")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []uint16{10, 20, 100, 101, 102, 103}
+	if !reflect.DeepEqual(vids, expected) {
+		t.Fatalf("Unexpected VLANs %v, expected %v", vids, expected)
+	}
+
+	for _, value := range []string{"", "0", "4095", "10,abc", "20-10", "10-", "-10"} {
+		if _, err := parseVlanList(value); err == nil {
+			t.Fatalf("Expected an error parsing VLAN list %q", value)
+		}
+	}
+}
+
+func TestVlanOptions(t *testing.T) {
+	config := &networkConfiguration{}
+	if err := config.fromLabels(map[string]string{
+		VlanFiltering: "true",
+		Uplink:        "eth1",
+		UplinkVlans:   "10-12",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if !config.VlanFiltering || config.Uplink != "eth1" || !reflect.DeepEqual(config.UplinkVlans, []uint16{10, 11, 12}) {
+		t.Fatalf("Unexpected VLAN configuration %+v", config)
+	}
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := &networkConfiguration{}
+	if err := json.Unmarshal(b, restored); err != nil {
+		t.Fatal(err)
+	}
+	if restored.VlanFiltering != config.VlanFiltering || restored.Uplink != config.Uplink || !reflect.DeepEqual(restored.UplinkVlans, config.UplinkVlans) {
+		t.Fatalf("Unexpected restored VLAN configuration %+v", restored)
+	}
+
+	config.VlanFiltering = false
+	if err := config.Validate(); err == nil {
+		t.Fatal("Expected an error validating uplink VLANs without VLAN filtering")
+	}
+
+	ec, err := parseEndpointOptions(map[string]interface{}{
+		EndpointVlan:      "10",
+		EndpointVlanTrunk: "20,30",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ec.AccessVlan != 10 || !reflect.DeepEqual(ec.TrunkVlans, []uint16{20, 30}) {
+		t.Fatalf("Unexpected endpoint VLAN configuration %+v", ec)
+	}
+	if _, err := parseEndpointOptions(map[string]interface{}{EndpointVlan: "5000"}); err == nil {
+		t.Fatal("Expected an error parsing an invalid access VLAN")
+	}
+}
+
+func TestCheckEndpointVlans(t *testing.T) {
+	config := &networkConfiguration{ID: "net1", VlanFiltering: true}
+	for _, c := range []struct {
+		internal bool
+		ep       *endpointConfiguration
+		valid    bool
+	}{
+		{false, nil, true},
+		{false, &endpointConfiguration{AccessVlan: defaultVlan, TrunkVlans: []uint16{20}}, true},
+		{false, &endpointConfiguration{TrunkVlans: []uint16{20}}, true},
+		{false, &endpointConfiguration{AccessVlan: 10}, false},
+		{true, &endpointConfiguration{AccessVlan: 10}, true},
+	} {
+		config.Internal = c.internal
+		if err := checkEndpointVlans(config, c.ep); (err == nil) != c.valid {
+			t.Fatalf("Unexpected result %v checking the endpoint VLANs %+v (internal %v)", err, c.ep, c.internal)
+		}
+	}
+
+	config.VlanFiltering = false
+	if err := checkEndpointVlans(config, &endpointConfiguration{TrunkVlans: []uint16{20}}); err == nil {
+		t.Fatal("Expected an error for endpoint VLANs without VLAN filtering")
+	}
+}
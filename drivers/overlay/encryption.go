@@ -10,9 +10,8 @@ import (
 	"sync"
 	"syscall"
 
-	"strconv"
-
-	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/discoverapi"
+	"github.com/docker/libnetwork/firewall"
 	"github.com/docker/libnetwork/ns"
 	"github.com/docker/libnetwork/types"
 	"github.com/sirupsen/logrus"
@@ -22,6 +21,10 @@ import (
 const (
 	r            = 0xD0C4E3
 	pktExpansion = 26 // SPI(4) + SeqN(4) + IV(8) + PadLength(1) + NextHeader(1) + ICV(8)
+	// netReqidBase is the base of the request IDs, and marks, of the
+	// security associations of the networks encrypted with their own keys,
+	// one per VNI
+	netReqidBase = 0x80000000
 )
 
 const (
@@ -30,8 +33,6 @@ const (
 	bidir
 )
 
-var spMark = netlink.XfrmMark{Value: uint32(r), Mask: 0xffffffff}
-
 type key struct {
 	value []byte
 	tag   uint32
@@ -55,9 +56,53 @@ func (s *spi) String() string {
 
 type encrMap struct {
 	nodes map[string][]*spi
+	// ports are the encapsulation ports selected by the security policies
+	// towards each node
+	ports map[string][]uint16
+	// vni is the VNI of the security associations of a network encrypted
+	// with its own keys, zero for the cluster keys shared by the networks
+	vni uint32
+	sync.Mutex
+}
+
+// netEncr is the key ring of a network encrypted with its own keys, and the
+// security associations of its VNIs.
+type netEncr struct {
+	keys []*key
+	maps map[uint32]*encrMap
 	sync.Mutex
 }
 
+// secMap returns the security associations of the VNI. To be called while
+// holding the lock.
+func (ne *netEncr) secMap(vni uint32) *encrMap {
+	em, ok := ne.maps[vni]
+	if !ok {
+		em = &encrMap{nodes: map[string][]*spi{}, ports: map[string][]uint16{}, vni: vni}
+		ne.maps[vni] = em
+	}
+	return em
+}
+
+// saReqid returns the request ID, and mark, of the security associations of
+// the VNI, the one of the cluster keys for the zero VNI.
+func saReqid(vni uint32) uint32 {
+	if vni == 0 {
+		return r
+	}
+	return netReqidBase | vni
+}
+
+// ipsecMarks returns the mark of the outgoing traffic of the VNI, and the
+// request ID of the security associations accepted for its incoming traffic,
+// any with the cluster keys.
+func ipsecMarks(vni uint32, networkKeys bool) (uint32, uint32) {
+	if !networkKeys {
+		return r, 0
+	}
+	return saReqid(vni), saReqid(vni)
+}
+
 func (e *encrMap) String() string {
 	e.Lock()
 	defer e.Unlock()
@@ -85,7 +130,12 @@ func (d *driver) checkEncryption(nid string, rIP net.IP, vxlanID uint32, isLocal
 		return nil
 	}
 
-	if len(d.keys) == 0 {
+	var ne *netEncr
+	if n.networkKeys {
+		if ne = d.netEncryption(nid); ne == nil {
+			return types.ForbiddenErrorf("encryption key of network %s is not present", nid[0:7])
+		}
+	} else if len(d.keys) == 0 {
 		return types.ForbiddenErrorf("encryption key is not present")
 	}
 
@@ -111,9 +161,17 @@ func (d *driver) checkEncryption(nid string, rIP net.IP, vxlanID uint32, isLocal
 
 	logrus.Debugf("List of nodes: %s", nodes)
 
+	if n.wireguard() {
+		return d.checkWireGuard(n, rIP, vxlanID, nodes, add)
+	}
+
+	if ne != nil {
+		return checkNetworkEncryption(ne, lIP, aIP, rIP, vxlanID, n.encapPort(), nodes, add)
+	}
+
 	if add {
 		for _, rIP := range nodes {
-			if err := setupEncryption(lIP, aIP, rIP, vxlanID, d.secMap, d.keys); err != nil {
+			if err := setupEncryption(lIP, aIP, rIP, vxlanID, n.encapPort(), d.secMap, d.keys); err != nil {
 				logrus.Warnf("Failed to program network encryption between %s and %s: %v", lIP, rIP, err)
 			}
 		}
@@ -128,29 +186,40 @@ func (d *driver) checkEncryption(nid string, rIP net.IP, vxlanID uint32, isLocal
 	return nil
 }
 
-func setupEncryption(localIP, advIP, remoteIP net.IP, vni uint32, em *encrMap, keys []*key) error {
+func setupEncryption(localIP, advIP, remoteIP net.IP, vni uint32, port uint16, em *encrMap, keys []*key) error {
 	logrus.Debugf("Programming encryption for vxlan %d between %s and %s", vni, localIP, remoteIP)
 	rIPs := remoteIP.String()
 
 	indices := make([]*spi, 0, len(keys))
+	mark, inReqid := ipsecMarks(vni, em.vni != 0)
 
-	err := programMangle(vni, true)
+	// The networks sharing the security associations may encapsulate their
+	// traffic on different ports, each selected by its own policy
+	em.Lock()
+	ports := em.ports[rIPs]
+	if !hasPort(ports, port) {
+		ports = append(ports, port)
+		em.ports[rIPs] = ports
+	}
+	em.Unlock()
+
+	err := programMangle(vni, port, ipFamily(remoteIP), mark, true)
 	if err != nil {
 		logrus.Warn(err)
 	}
 
-	err = programInput(vni, true)
+	err = programInput(vni, port, ipFamily(remoteIP), inReqid, true)
 	if err != nil {
 		logrus.Warn(err)
 	}
 
 	for i, k := range keys {
-		spis := &spi{buildSPI(advIP, remoteIP, k.tag), buildSPI(remoteIP, advIP, k.tag)}
+		spis := &spi{buildSPI(advIP, remoteIP, em.vni, k.tag), buildSPI(remoteIP, advIP, em.vni, k.tag)}
 		dir := reverse
 		if i == 0 {
 			dir = bidir
 		}
-		fSA, rSA, err := programSA(localIP, remoteIP, spis, k, dir, true)
+		fSA, rSA, err := programSA(localIP, remoteIP, spis, k, dir, saReqid(em.vni), true)
 		if err != nil {
 			logrus.Warn(err)
 		}
@@ -158,9 +227,10 @@ func setupEncryption(localIP, advIP, remoteIP net.IP, vni uint32, em *encrMap, k
 		if i != 0 {
 			continue
 		}
-		err = programSP(fSA, rSA, true)
-		if err != nil {
-			logrus.Warn(err)
+		for _, dport := range ports {
+			if err := programSP(fSA, rSA, dport, true); err != nil {
+				logrus.Warn(err)
+			}
 		}
 	}
 
@@ -174,6 +244,8 @@ func setupEncryption(localIP, advIP, remoteIP net.IP, vni uint32, em *encrMap, k
 func removeEncryption(localIP, remoteIP net.IP, em *encrMap) error {
 	em.Lock()
 	indices, ok := em.nodes[remoteIP.String()]
+	ports := em.ports[remoteIP.String()]
+	delete(em.ports, remoteIP.String())
 	em.Unlock()
 	if !ok {
 		return nil
@@ -183,78 +255,84 @@ func removeEncryption(localIP, remoteIP net.IP, em *encrMap) error {
 		if i == 0 {
 			dir = bidir
 		}
-		fSA, rSA, err := programSA(localIP, remoteIP, idxs, nil, dir, false)
+		fSA, rSA, err := programSA(localIP, remoteIP, idxs, nil, dir, saReqid(em.vni), false)
 		if err != nil {
 			logrus.Warn(err)
 		}
 		if i != 0 {
 			continue
 		}
-		err = programSP(fSA, rSA, false)
-		if err != nil {
-			logrus.Warn(err)
+		for _, port := range ports {
+			if err := programSP(fSA, rSA, port, false); err != nil {
+				logrus.Warn(err)
+			}
 		}
 	}
 	return nil
 }
 
-func programMangle(vni uint32, add bool) (err error) {
+// hasPort tells whether the port is in the list.
+func hasPort(ports []uint16, port uint16) bool {
+	for _, p := range ports {
+		if p == port {
+			return true
+		}
+	}
+	return false
+}
+
+func programMangle(vni uint32, port uint16, family firewall.Family, mark uint32, add bool) (err error) {
 	var (
-		p      = strconv.FormatUint(uint64(vxlanPort), 10)
-		c      = fmt.Sprintf("0>>22&0x3C@12&0xFFFFFF00=%d", int(vni)<<8)
-		m      = strconv.FormatUint(uint64(r), 10)
 		chain  = "OUTPUT"
-		rule   = []string{"-p", "udp", "--dport", p, "-m", "u32", "--u32", c, "-j", "MARK", "--set-mark", m}
-		a      = "-A"
+		rule   = firewall.Rule{Proto: "udp", DstPort: int(port), VNI: vni, Family: family, Jump: "MARK", SetMark: mark}
+		a      = firewall.Append
 		action = "install"
+		fw     = firewall.Get(family)
 	)
 
-	if add == iptables.Exists(iptables.Mangle, chain, rule...) {
+	if add == fw.Exists(firewall.Mangle, chain, rule) {
 		return
 	}
 
 	if !add {
-		a = "-D"
+		a = firewall.Delete
 		action = "remove"
 	}
 
-	if err = iptables.RawCombinedOutput(append([]string{"-t", string(iptables.Mangle), a, chain}, rule...)...); err != nil {
+	if err = fw.Apply(firewall.Mangle, chain, a, rule); err != nil {
 		logrus.Warnf("could not %s mangle rule: %v", action, err)
 	}
 
 	return
 }
 
-func programInput(vni uint32, add bool) (err error) {
+func programInput(vni uint32, port uint16, family firewall.Family, reqid uint32, add bool) (err error) {
 	var (
-		port       = strconv.FormatUint(uint64(vxlanPort), 10)
-		vniMatch   = fmt.Sprintf("0>>22&0x3C@12&0xFFFFFF00=%d", int(vni)<<8)
-		plainVxlan = []string{"-p", "udp", "--dport", port, "-m", "u32", "--u32", vniMatch, "-j"}
-		ipsecVxlan = append([]string{"-m", "policy", "--dir", "in", "--pol", "ipsec"}, plainVxlan...)
-		block      = append(plainVxlan, "DROP")
-		accept     = append(ipsecVxlan, "ACCEPT")
-		chain      = "INPUT"
-		action     = iptables.Append
-		msg        = "add"
+		block  = firewall.Rule{Proto: "udp", DstPort: int(port), VNI: vni, Family: family, Jump: "DROP"}
+		accept = firewall.Rule{IPsecIn: true, IPsecReqID: reqid, Proto: "udp", DstPort: int(port), VNI: vni, Family: family, Jump: "ACCEPT"}
+		chain  = "INPUT"
+		action = firewall.Append
+		msg    = "add"
+		fw     = firewall.Get(family)
 	)
 
 	if !add {
-		action = iptables.Delete
+		action = firewall.Delete
 		msg = "remove"
 	}
 
-	if err := iptables.ProgramRule(iptables.Filter, chain, action, accept); err != nil {
+	if err := fw.ProgramRule(firewall.Filter, chain, action, accept); err != nil {
 		logrus.Errorf("could not %s input rule: %v. Please do it manually.", msg, err)
 	}
 
-	if err := iptables.ProgramRule(iptables.Filter, chain, action, block); err != nil {
+	if err := fw.ProgramRule(firewall.Filter, chain, action, block); err != nil {
 		logrus.Errorf("could not %s input rule: %v. Please do it manually.", msg, err)
 	}
 
 	return
 }
 
-func programSA(localIP, remoteIP net.IP, spi *spi, k *key, dir int, add bool) (fSA *netlink.XfrmState, rSA *netlink.XfrmState, err error) {
+func programSA(localIP, remoteIP net.IP, spi *spi, k *key, dir int, reqid uint32, add bool) (fSA *netlink.XfrmState, rSA *netlink.XfrmState, err error) {
 	var (
 		action      = "Removing"
 		xfrmProgram = ns.NlHandle().XfrmStateDel
@@ -272,7 +350,7 @@ func programSA(localIP, remoteIP net.IP, spi *spi, k *key, dir int, add bool) (f
 			Proto: netlink.XFRM_PROTO_ESP,
 			Spi:   spi.reverse,
 			Mode:  netlink.XFRM_MODE_TRANSPORT,
-			Reqid: r,
+			Reqid: int(reqid),
 		}
 		if add {
 			rSA.Aead = buildAeadAlgo(k, spi.reverse)
@@ -298,7 +376,7 @@ func programSA(localIP, remoteIP net.IP, spi *spi, k *key, dir int, add bool) (f
 			Proto: netlink.XFRM_PROTO_ESP,
 			Spi:   spi.forward,
 			Mode:  netlink.XFRM_MODE_TRANSPORT,
-			Reqid: r,
+			Reqid: int(reqid),
 		}
 		if add {
 			fSA.Aead = buildAeadAlgo(k, spi.forward)
@@ -320,7 +398,7 @@ func programSA(localIP, remoteIP net.IP, spi *spi, k *key, dir int, add bool) (f
 	return
 }
 
-func programSP(fSA *netlink.XfrmState, rSA *netlink.XfrmState, add bool) error {
+func programSP(fSA *netlink.XfrmState, rSA *netlink.XfrmState, port uint16, add bool) error {
 	action := "Removing"
 	xfrmProgram := ns.NlHandle().XfrmPolicyDel
 	if add {
@@ -333,13 +411,14 @@ func programSP(fSA *netlink.XfrmState, rSA *netlink.XfrmState, add bool) error {
 	d := types.GetMinimalIP(fSA.Dst)
 	fullMask := net.CIDRMask(8*len(s), 8*len(s))
 
+	// The security associations are marked with their request ID
 	fPol := &netlink.XfrmPolicy{
 		Src:     &net.IPNet{IP: s, Mask: fullMask},
 		Dst:     &net.IPNet{IP: d, Mask: fullMask},
 		Dir:     netlink.XFRM_DIR_OUT,
 		Proto:   17,
-		DstPort: 4789,
-		Mark:    &spMark,
+		DstPort: int(port),
+		Mark:    &netlink.XfrmMark{Value: uint32(fSA.Reqid), Mask: 0xffffffff},
 		Tmpls: []netlink.XfrmPolicyTmpl{
 			{
 				Src:   fSA.Src,
@@ -347,7 +426,7 @@ func programSP(fSA *netlink.XfrmState, rSA *netlink.XfrmState, add bool) error {
 				Proto: netlink.XFRM_PROTO_ESP,
 				Mode:  netlink.XFRM_MODE_TRANSPORT,
 				Spi:   fSA.Spi,
-				Reqid: r,
+				Reqid: fSA.Reqid,
 			},
 		},
 	}
@@ -395,13 +474,21 @@ func spExists(sp *netlink.XfrmPolicy) (bool, error) {
 	}
 }
 
-func buildSPI(src, dst net.IP, st uint32) int {
+// buildSPI returns the SPI of the security association between the nodes
+// with the key of the tag, of the VNI of a network encrypted with its own
+// keys, or of the cluster keys for the zero VNI.
+func buildSPI(src, dst net.IP, vni, st uint32) int {
 	b := make([]byte, 4)
 	binary.BigEndian.PutUint32(b, st)
 	h := fnv.New32a()
 	h.Write(src)
 	h.Write(b)
 	h.Write(dst)
+	if vni != 0 {
+		v := make([]byte, 4)
+		binary.BigEndian.PutUint32(v, vni)
+		h.Write(v)
+	}
 	return int(binary.BigEndian.Uint32(h.Sum(nil)))
 }
 
@@ -415,29 +502,36 @@ func buildAeadAlgo(k *key, s int) *netlink.XfrmStateAlgo {
 	}
 }
 
-func (d *driver) secMapWalk(f func(string, []*spi) ([]*spi, bool)) error {
-	d.secMap.Lock()
-	for node, indices := range d.secMap.nodes {
+func (e *encrMap) walk(f func(string, []*spi) ([]*spi, bool)) error {
+	e.Lock()
+	for node, indices := range e.nodes {
 		idxs, stop := f(node, indices)
 		if idxs != nil {
-			d.secMap.nodes[node] = idxs
+			e.nodes[node] = idxs
 		}
 		if stop {
 			break
 		}
 	}
-	d.secMap.Unlock()
+	e.Unlock()
 	return nil
 }
 
 func (d *driver) setKeys(keys []*key) error {
 	// Remove any stale policy, state
-	clearEncryptionStates()
+	clearEncryptionStates(false)
 	// Accept the encryption keys and clear any stale encryption map
 	d.Lock()
 	d.keys = keys
-	d.secMap = &encrMap{nodes: map[string][]*spi{}}
+	d.secMap = &encrMap{nodes: map[string][]*spi{}, ports: map[string][]uint16{}}
 	d.Unlock()
+	// Remove any stale wireguard interface, and derive the keys of the new one
+	d.wg.removeDevice()
+	if len(keys) > 0 {
+		if err := d.wg.setKeys(keys[0], false); err != nil {
+			return err
+		}
+	}
 	logrus.Debugf("Initial encryption keys: %v", keys)
 	return nil
 }
@@ -450,22 +544,48 @@ func (d *driver) updateKeys(newKey, primary, pruneKey *key) error {
 	logrus.Debugf("Current: %v", d.keys)
 
 	var (
-		newIdx = -1
-		priIdx = -1
-		delIdx = -1
-		lIP    = net.ParseIP(d.bindAddress)
-		aIP    = net.ParseIP(d.advertiseAddress)
+		lIP = net.ParseIP(d.bindAddress)
+		aIP = net.ParseIP(d.advertiseAddress)
 	)
 
 	d.Lock()
 	defer d.Unlock()
 
+	keys, priIdx, err := updateKeyRing(lIP, aIP, d.keys, []*encrMap{d.secMap}, newKey, primary, pruneKey)
+	if err != nil {
+		return err
+	}
+	d.keys = keys
+
+	// rotate the wireguard keys along with the primary key
+	if priIdx > 0 {
+		if err := d.wg.setKeys(d.keys[0], true); err != nil {
+			logrus.Warnf("Failed to rotate the wireguard keys: %v", err)
+		}
+	}
+
+	logrus.Debugf("Updated: %v", d.keys)
+
+	return nil
+}
+
+// updateKeyRing adds the new key and/or changes the primary key and/or
+// prunes the key of the key ring, updating the security associations of the
+// maps. It returns the updated key ring, the primary key first, and the
+// former index of the primary key.
+func updateKeyRing(lIP, aIP net.IP, keys []*key, maps []*encrMap, newKey, primary, pruneKey *key) ([]*key, int, error) {
+	var (
+		newIdx = -1
+		priIdx = -1
+		delIdx = -1
+	)
+
 	// add new
 	if newKey != nil {
-		d.keys = append(d.keys, newKey)
-		newIdx += len(d.keys)
+		keys = append(keys, newKey)
+		newIdx += len(keys)
 	}
-	for i, k := range d.keys {
+	for i, k := range keys {
 		if primary != nil && k.tag == primary.tag {
 			priIdx = i
 		}
@@ -477,36 +597,191 @@ func (d *driver) updateKeys(newKey, primary, pruneKey *key) error {
 	if (newKey != nil && newIdx == -1) ||
 		(primary != nil && priIdx == -1) ||
 		(pruneKey != nil && delIdx == -1) {
-		return types.BadRequestErrorf("cannot find proper key indices while processing key update:"+
+		return nil, -1, types.BadRequestErrorf("cannot find proper key indices while processing key update:"+
 			"(newIdx,priIdx,delIdx):(%d, %d, %d)", newIdx, priIdx, delIdx)
 	}
 
 	if priIdx != -1 && priIdx == delIdx {
-		return types.BadRequestErrorf("attempting to both make a key (index %d) primary and delete it", priIdx)
+		return nil, -1, types.BadRequestErrorf("attempting to both make a key (index %d) primary and delete it", priIdx)
 	}
 
-	d.secMapWalk(func(rIPs string, spis []*spi) ([]*spi, bool) {
-		rIP := net.ParseIP(rIPs)
-		return updateNodeKey(lIP, aIP, rIP, spis, d.keys, newIdx, priIdx, delIdx), false
-	})
+	for _, em := range maps {
+		vni := em.vni
+		em.walk(func(rIPs string, spis []*spi) ([]*spi, bool) {
+			rIP := net.ParseIP(rIPs)
+			return updateNodeKey(lIP, aIP, rIP, vni, em.ports[rIPs], spis, keys, newIdx, priIdx, delIdx), false
+		})
+	}
 
 	// swap primary
 	if priIdx != -1 {
-		d.keys[0], d.keys[priIdx] = d.keys[priIdx], d.keys[0]
+		keys[0], keys[priIdx] = keys[priIdx], keys[0]
 	}
 	// prune
 	if delIdx != -1 {
 		if delIdx == 0 {
 			delIdx = priIdx
 		}
-		d.keys = append(d.keys[:delIdx], d.keys[delIdx+1:]...)
+		keys = append(keys[:delIdx], keys[delIdx+1:]...)
 	}
 
-	logrus.Debugf("Updated: %v", d.keys)
+	return keys, priIdx, nil
+}
+
+// netEncryption returns the key ring of the network encrypted with its own
+// keys, nil if its keys are not set.
+func (d *driver) netEncryption(nid string) *netEncr {
+	d.Lock()
+	defer d.Unlock()
+	return d.netEncr[nid]
+}
+
+// checkNetworkEncryption programs, or removes, the security associations of
+// the network encrypted with its own keys with the remote nodes.
+func checkNetworkEncryption(ne *netEncr, lIP, aIP, rIP net.IP, vxlanID uint32, port uint16, nodes map[string]net.IP, add bool) error {
+	ne.Lock()
+	defer ne.Unlock()
+
+	if add {
+		em := ne.secMap(vxlanID)
+		for _, rIP := range nodes {
+			if err := setupEncryption(lIP, aIP, rIP, vxlanID, port, em, ne.keys); err != nil {
+				logrus.Warnf("Failed to program network encryption between %s and %s: %v", lIP, rIP, err)
+			}
+		}
+	} else {
+		if len(nodes) == 0 {
+			for _, em := range ne.maps {
+				if err := removeEncryption(lIP, rIP, em); err != nil {
+					logrus.Warnf("Failed to remove network encryption between %s and %s: %v", lIP, rIP, err)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// SetNetworkKeys sets the key ring of the network encrypted with its own
+// keys, the primary key first. The keys missing from the current key ring
+// are added, and the ones missing from the new key ring pruned, rotating the
+// keys of the network independently of the other networks. An empty key
+// ring removes the keys of the network.
+func (d *driver) SetNetworkKeys(nid string, config discoverapi.DriverEncryptionConfig) error {
+	keys := make([]*key, 0, len(config.Keys))
+	for i := 0; i < len(config.Keys); i++ {
+		keys = append(keys, &key{
+			value: config.Keys[i],
+			tag:   uint32(config.Tags[i]),
+		})
+	}
+
+	var (
+		lIP = net.ParseIP(d.bindAddress)
+		aIP = net.ParseIP(d.advertiseAddress)
+	)
+
+	d.Lock()
+	ne, ok := d.netEncr[nid]
+	switch {
+	case len(keys) == 0:
+		// The traffic of the endpoints would leave in clear
+		if n := d.networks[nid]; n != nil && len(n.endpoints) > 0 {
+			d.Unlock()
+			return types.ForbiddenErrorf("cannot remove the encryption keys of network %s with endpoints", nid[0:7])
+		}
+		delete(d.netEncr, nid)
+	case !ok:
+		d.netEncr[nid] = &netEncr{keys: keys, maps: map[uint32]*encrMap{}}
+	}
+	d.Unlock()
+
+	if len(keys) == 0 {
+		if ok {
+			ne.clear(lIP)
+		}
+		return nil
+	}
+	if !ok {
+		logrus.Debugf("Initial encryption keys of network %s: %v", nid[0:7], keys)
+		return nil
+	}
+
+	ne.Lock()
+	defer ne.Unlock()
+
+	maps := make([]*encrMap, 0, len(ne.maps))
+	for _, em := range ne.maps {
+		maps = append(maps, em)
+	}
+	update := func(newKey, primary, pruneKey *key) error {
+		keys, _, err := updateKeyRing(lIP, aIP, ne.keys, maps, newKey, primary, pruneKey)
+		if err != nil {
+			return err
+		}
+		ne.keys = keys
+		return nil
+	}
+
+	// Add the new keys, switch the primary key, then prune the old keys
+	for _, k := range keys {
+		if keyIndex(ne.keys, k) == -1 {
+			if err := update(k, nil, nil); err != nil {
+				return err
+			}
+		}
+	}
+	if ne.keys[0].tag != keys[0].tag {
+		if err := update(nil, keys[0], nil); err != nil {
+			return err
+		}
+	}
+	for _, k := range append([]*key{}, ne.keys...) {
+		if keyIndex(keys, k) == -1 {
+			if err := update(nil, nil, k); err != nil {
+				return err
+			}
+		}
+	}
+
+	logrus.Debugf("Updated encryption keys of network %s: %v", nid[0:7], ne.keys)
 
 	return nil
 }
 
+// clear removes the security associations of the network.
+func (ne *netEncr) clear(lIP net.IP) {
+	ne.Lock()
+	defer ne.Unlock()
+
+	for _, em := range ne.maps {
+		em.Lock()
+		nodes := make([]string, 0, len(em.nodes))
+		for node := range em.nodes {
+			nodes = append(nodes, node)
+		}
+		em.Unlock()
+
+		for _, node := range nodes {
+			if err := removeEncryption(lIP, net.ParseIP(node), em); err != nil {
+				logrus.Warnf("Failed to remove network encryption between %s and %s: %v", lIP, node, err)
+			}
+		}
+	}
+	ne.maps = map[uint32]*encrMap{}
+}
+
+// keyIndex returns the index of the key of the tag in the key ring, -1 if
+// missing.
+func keyIndex(keys []*key, k *key) int {
+	for i, kk := range keys {
+		if kk.tag == k.tag {
+			return i
+		}
+	}
+	return -1
+}
+
 /********************************************************
  * Steady state: rSA0, rSA1, rSA2, fSA1, fSP1
  * Rotation --> -rSA0, +rSA3, +fSA2, +fSP2/-fSP1, -fSA1
@@ -514,7 +789,7 @@ func (d *driver) updateKeys(newKey, primary, pruneKey *key) error {
  *********************************************************/
 
 // Spis and keys are sorted in such away the one in position 0 is the primary
-func updateNodeKey(lIP, aIP, rIP net.IP, idxs []*spi, curKeys []*key, newIdx, priIdx, delIdx int) []*spi {
+func updateNodeKey(lIP, aIP, rIP net.IP, vni uint32, ports []uint16, idxs []*spi, curKeys []*key, newIdx, priIdx, delIdx int) []*spi {
 	logrus.Debugf("Updating keys for node: %s (%d,%d,%d)", rIP, newIdx, priIdx, delIdx)
 
 	spis := idxs
@@ -523,55 +798,57 @@ func updateNodeKey(lIP, aIP, rIP net.IP, idxs []*spi, curKeys []*key, newIdx, pr
 	// add new
 	if newIdx != -1 {
 		spis = append(spis, &spi{
-			forward: buildSPI(aIP, rIP, curKeys[newIdx].tag),
-			reverse: buildSPI(rIP, aIP, curKeys[newIdx].tag),
+			forward: buildSPI(aIP, rIP, vni, curKeys[newIdx].tag),
+			reverse: buildSPI(rIP, aIP, vni, curKeys[newIdx].tag),
 		})
 	}
 
 	if delIdx != -1 {
 		// -rSA0
-		programSA(lIP, rIP, spis[delIdx], nil, reverse, false)
+		programSA(lIP, rIP, spis[delIdx], nil, reverse, saReqid(vni), false)
 	}
 
 	if newIdx > -1 {
 		// +rSA2
-		programSA(lIP, rIP, spis[newIdx], curKeys[newIdx], reverse, true)
+		programSA(lIP, rIP, spis[newIdx], curKeys[newIdx], reverse, saReqid(vni), true)
 	}
 
 	if priIdx > 0 {
 		// +fSA2
-		fSA2, _, _ := programSA(lIP, rIP, spis[priIdx], curKeys[priIdx], forward, true)
+		fSA2, _, _ := programSA(lIP, rIP, spis[priIdx], curKeys[priIdx], forward, saReqid(vni), true)
 
 		// +fSP2, -fSP1
 		s := types.GetMinimalIP(fSA2.Src)
 		d := types.GetMinimalIP(fSA2.Dst)
 		fullMask := net.CIDRMask(8*len(s), 8*len(s))
 
-		fSP1 := &netlink.XfrmPolicy{
-			Src:     &net.IPNet{IP: s, Mask: fullMask},
-			Dst:     &net.IPNet{IP: d, Mask: fullMask},
-			Dir:     netlink.XFRM_DIR_OUT,
-			Proto:   17,
-			DstPort: 4789,
-			Mark:    &spMark,
-			Tmpls: []netlink.XfrmPolicyTmpl{
-				{
-					Src:   fSA2.Src,
-					Dst:   fSA2.Dst,
-					Proto: netlink.XFRM_PROTO_ESP,
-					Mode:  netlink.XFRM_MODE_TRANSPORT,
-					Spi:   fSA2.Spi,
-					Reqid: r,
+		for _, port := range ports {
+			fSP1 := &netlink.XfrmPolicy{
+				Src:     &net.IPNet{IP: s, Mask: fullMask},
+				Dst:     &net.IPNet{IP: d, Mask: fullMask},
+				Dir:     netlink.XFRM_DIR_OUT,
+				Proto:   17,
+				DstPort: int(port),
+				Mark:    &netlink.XfrmMark{Value: saReqid(vni), Mask: 0xffffffff},
+				Tmpls: []netlink.XfrmPolicyTmpl{
+					{
+						Src:   fSA2.Src,
+						Dst:   fSA2.Dst,
+						Proto: netlink.XFRM_PROTO_ESP,
+						Mode:  netlink.XFRM_MODE_TRANSPORT,
+						Spi:   fSA2.Spi,
+						Reqid: int(saReqid(vni)),
+					},
 				},
-			},
-		}
-		logrus.Debugf("Updating fSP{%s}", fSP1)
-		if err := ns.NlHandle().XfrmPolicyUpdate(fSP1); err != nil {
-			logrus.Warnf("Failed to update fSP{%s}: %v", fSP1, err)
+			}
+			logrus.Debugf("Updating fSP{%s}", fSP1)
+			if err := ns.NlHandle().XfrmPolicyUpdate(fSP1); err != nil {
+				logrus.Warnf("Failed to update fSP{%s}: %v", fSP1, err)
+			}
 		}
 
 		// -fSA1
-		programSA(lIP, rIP, spis[0], nil, forward, false)
+		programSA(lIP, rIP, spis[0], nil, forward, saReqid(vni), false)
 	}
 
 	// swap
@@ -598,8 +875,22 @@ func (n *network) maxMTU() int {
 	if n.mtu != 0 {
 		mtu = n.mtu
 	}
-	mtu -= vxlanEncap
-	if n.secure {
+	if n.wireguard() {
+		// The encapsulated traffic is routed through the WireGuard interface
+		mtu -= wgOverhead
+		if n.ipv6Underlay() {
+			mtu -= ipv6Overhead
+		}
+	}
+	if n.geneve() {
+		mtu -= geneveEncap
+	} else {
+		mtu -= vxlanEncap
+	}
+	if n.ipv6Underlay() {
+		mtu -= ipv6Overhead
+	}
+	if n.secure && !n.wireguard() {
 		// In case of encryption account for the
 		// esp packet espansion and padding
 		mtu -= pktExpansion
@@ -608,7 +899,16 @@ func (n *network) maxMTU() int {
 	return mtu
 }
 
-func clearEncryptionStates() {
+// clearEncryptionStates removes the stale security policies and associations
+// of the cluster keys, or of the networks encrypted with their own keys.
+func clearEncryptionStates(networkKeys bool) {
+	match := func(reqid uint32) bool {
+		if networkKeys {
+			return reqid&netReqidBase != 0
+		}
+		return reqid == r
+	}
+
 	nlh := ns.NlHandle()
 	spList, err := nlh.XfrmPolicyList(netlink.FAMILY_ALL)
 	if err != nil {
@@ -619,7 +919,7 @@ func clearEncryptionStates() {
 		logrus.Warnf("Failed to retrieve SA list for cleanup: %v", err)
 	}
 	for _, sp := range spList {
-		if sp.Mark != nil && sp.Mark.Value == spMark.Value {
+		if sp.Mark != nil && match(sp.Mark.Value) {
 			if err := nlh.XfrmPolicyDel(&sp); err != nil {
 				logrus.Warnf("Failed to delete stale SP %s: %v", sp, err)
 				continue
@@ -628,7 +928,7 @@ func clearEncryptionStates() {
 		}
 	}
 	for _, sa := range saList {
-		if sa.Reqid == r {
+		if match(uint32(sa.Reqid)) {
 			if err := nlh.XfrmStateDel(&sa); err != nil {
 				logrus.Warnf("Failed to delete stale SA %s: %v", sa, err)
 				continue
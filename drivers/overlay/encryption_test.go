@@ -0,0 +1,104 @@
+package overlay
+
+import (
+	"net"
+	"testing"
+
+	"github.com/docker/libnetwork/discoverapi"
+	_ "github.com/docker/libnetwork/testutils"
+	"github.com/docker/libnetwork/types"
+)
+
+func TestBuildSPI(t *testing.T) {
+	a := net.ParseIP("192.168.1.1")
+	b := net.ParseIP("192.168.1.2")
+
+	// The SPIs of the cluster keys must not change across upgrades
+	if spi := buildSPI(a, b, 0, 1); spi != 0xdf51ac4b {
+		t.Fatalf("Unexpected cluster key SPI %#x", spi)
+	}
+	if buildSPI(a, b, 256, 1) == buildSPI(a, b, 0, 1) ||
+		buildSPI(a, b, 256, 1) == buildSPI(a, b, 257, 1) {
+		t.Fatal("Expected distinct SPIs for distinct VNIs")
+	}
+	if buildSPI(a, b, 256, 1) == buildSPI(b, a, 256, 1) {
+		t.Fatal("Expected distinct SPIs for each direction")
+	}
+}
+
+func TestIPsecMarks(t *testing.T) {
+	if mark, reqid := ipsecMarks(256, false); mark != r || reqid != 0 {
+		t.Fatalf("Unexpected cluster key mark %#x and request ID %#x", mark, reqid)
+	}
+	if mark, reqid := ipsecMarks(256, true); mark != netReqidBase|256 || reqid != mark {
+		t.Fatalf("Unexpected network key mark %#x and request ID %#x", mark, reqid)
+	}
+	if saReqid(0) != r {
+		t.Fatalf("Unexpected cluster key request ID %#x", saReqid(0))
+	}
+}
+
+func TestNetworkKeysValue(t *testing.T) {
+	n := &network{id: "network1", secure: true, networkKeys: true}
+	restored := &network{}
+	if err := restored.SetValue(n.Value()); err != nil {
+		t.Fatal(err)
+	}
+	if !restored.networkKeys {
+		t.Fatal("Expected the network keys option to be restored")
+	}
+}
+
+func TestSetNetworkKeys(t *testing.T) {
+	nid := "network1"
+	d := &driver{
+		networks: networkTable{nid: {id: nid, secure: true, networkKeys: true, endpoints: endpointTable{}}},
+		netEncr:  map[string]*netEncr{},
+	}
+	ring := func(tags ...uint64) discoverapi.DriverEncryptionConfig {
+		config := discoverapi.DriverEncryptionConfig{}
+		for _, tag := range tags {
+			config.Keys = append(config.Keys, []byte{byte(tag)})
+			config.Tags = append(config.Tags, tag)
+		}
+		return config
+	}
+	check := func(tags ...uint32) {
+		ne := d.netEncryption(nid)
+		if ne == nil || len(ne.keys) != len(tags) || ne.keys[0].tag != tags[0] {
+			t.Fatalf("Unexpected key ring %v, expected %v", ne, tags)
+		}
+		for _, tag := range tags {
+			if keyIndex(ne.keys, &key{tag: tag}) == -1 {
+				t.Fatalf("Missing key %d in key ring %v", tag, ne.keys)
+			}
+		}
+	}
+
+	if err := d.SetNetworkKeys(nid, ring(2, 1, 3)); err != nil {
+		t.Fatal(err)
+	}
+	check(2, 1, 3)
+
+	// Rotation: new key 4, primary key 3 and pruned key 1
+	if err := d.SetNetworkKeys(nid, ring(3, 2, 4)); err != nil {
+		t.Fatal(err)
+	}
+	check(3, 2, 4)
+
+	d.networks[nid].endpoints["ep1"] = &endpoint{id: "ep1"}
+	if err := d.SetNetworkKeys(nid, ring()); err == nil {
+		t.Fatal("Expected the removal of the keys of a network with endpoints to fail")
+	} else if _, ok := err.(types.ForbiddenError); !ok {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	check(3, 2, 4)
+
+	delete(d.networks[nid].endpoints, "ep1")
+	if err := d.SetNetworkKeys(nid, ring()); err != nil {
+		t.Fatal(err)
+	}
+	if d.netEncryption(nid) != nil {
+		t.Fatal("Expected the keys of the network to be removed")
+	}
+}
@@ -4,13 +4,16 @@ import (
 	"fmt"
 	"sync"
 
-	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/firewall"
 	"github.com/sirupsen/logrus"
 )
 
 const globalChain = "DOCKER-OVERLAY"
 
-var filterOnce sync.Once
+var filterOnce = map[firewall.Family]*sync.Once{
+	firewall.IPv4: {},
+	firewall.IPv6: {},
+}
 
 var filterChan = make(chan struct{}, 1)
 
@@ -19,124 +22,110 @@ func filterWait() func() {
 	return func() { <-filterChan }
 }
 
-func chainExists(cname string) bool {
-	if _, err := iptables.Raw("-L", cname); err != nil {
-		return false
-	}
-
-	return true
-}
+func setupGlobalChain(fw firewall.Backend) {
 
-func setupGlobalChain() {
 	// Because of an ungraceful shutdown, chain could already be present
-	if !chainExists(globalChain) {
-		if err := iptables.RawCombinedOutput("-N", globalChain); err != nil {
-			logrus.Errorf("could not create global overlay chain: %v", err)
-			return
-		}
+	if err := fw.NewChain(firewall.Filter, globalChain); err != nil {
+		logrus.Errorf("could not create global overlay chain: %v", err)
+		return
 	}
 
-	if !iptables.Exists(iptables.Filter, globalChain, "-j", "RETURN") {
-		if err := iptables.RawCombinedOutput("-A", globalChain, "-j", "RETURN"); err != nil {
-			logrus.Errorf("could not install default return chain in the overlay global chain: %v", err)
-		}
+	if err := fw.ProgramRule(firewall.Filter, globalChain, firewall.Append, firewall.Rule{Jump: "RETURN"}); err != nil {
+		logrus.Errorf("could not install default return chain in the overlay global chain: %v", err)
 	}
 }
 
-func setNetworkChain(cname string, remove bool) error {
+func setNetworkChain(fw firewall.Backend, cname string, remove bool) error {
 	// Initialize the onetime global overlay chain
-	filterOnce.Do(setupGlobalChain)
+	filterOnce[fw.Family()].Do(func() { setupGlobalChain(fw) })
 
-	exists := chainExists(cname)
-
-	opt := "-N"
-	// In case of remove, make sure to flush the rules in the chain
-	if remove && exists {
-		if err := iptables.RawCombinedOutput("-F", cname); err != nil {
-			return fmt.Errorf("failed to flush overlay network chain %s rules: %v", cname, err)
+	// In case of remove, the rules in the chain are flushed
+	if remove {
+		if err := fw.RemoveChain(firewall.Filter, cname); err != nil {
+			return fmt.Errorf("failed to remove overlay network chain %s: %v", cname, err)
 		}
-		opt = "-X"
+		return nil
 	}
 
-	if (!remove && !exists) || (remove && exists) {
-		if err := iptables.RawCombinedOutput(opt, cname); err != nil {
-			return fmt.Errorf("failed network chain operation %q for chain %s: %v", opt, cname, err)
-		}
+	if err := fw.NewChain(firewall.Filter, cname); err != nil {
+		return fmt.Errorf("failed to create overlay network chain %s: %v", cname, err)
 	}
 
-	if !remove {
-		if !iptables.Exists(iptables.Filter, cname, "-j", "DROP") {
-			if err := iptables.RawCombinedOutput("-A", cname, "-j", "DROP"); err != nil {
-				return fmt.Errorf("failed adding default drop rule to overlay network chain %s: %v", cname, err)
-			}
-		}
+	if err := fw.ProgramRule(firewall.Filter, cname, firewall.Append, firewall.Rule{Jump: "DROP"}); err != nil {
+		return fmt.Errorf("failed adding default drop rule to overlay network chain %s: %v", cname, err)
 	}
 
 	return nil
 }
 
-func addNetworkChain(cname string) error {
+func addNetworkChain(cname string, families []firewall.Family) error {
 	defer filterWait()()
 
-	return setNetworkChain(cname, false)
+	for _, family := range families {
+		if err := setNetworkChain(firewall.Get(family), cname, false); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func removeNetworkChain(cname string) error {
+func removeNetworkChain(cname string, families []firewall.Family) error {
 	defer filterWait()()
 
-	return setNetworkChain(cname, true)
+	for _, family := range families {
+		if err := setNetworkChain(firewall.Get(family), cname, true); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func setFilters(cname, brName string, remove bool) error {
-	opt := "-I"
+func setFilters(fw firewall.Backend, cname, brName string, remove bool) error {
+	action := firewall.Insert
 	if remove {
-		opt = "-D"
+		action = firewall.Delete
 	}
 
+	tx := fw.NewTransaction()
+
 	// Every time we set filters for a new subnet make sure to move the global overlay hook to the top of the both the OUTPUT and forward chains
 	if !remove {
 		for _, chain := range []string{"OUTPUT", "FORWARD"} {
-			exists := iptables.Exists(iptables.Filter, chain, "-j", globalChain)
-			if exists {
-				if err := iptables.RawCombinedOutput("-D", chain, "-j", globalChain); err != nil {
-					return fmt.Errorf("failed to delete overlay hook in chain %s while moving the hook: %v", chain, err)
-				}
-			}
-
-			if err := iptables.RawCombinedOutput("-I", chain, "-j", globalChain); err != nil {
-				return fmt.Errorf("failed to insert overlay hook in chain %s: %v", chain, err)
-			}
+			tx.ProgramRule(firewall.Filter, chain, firewall.Delete, firewall.Rule{Jump: globalChain})
+			tx.Add(firewall.Filter, chain, firewall.Insert, firewall.Rule{Jump: globalChain})
 		}
 	}
 
 	// Insert/Delete the rule to jump to per-bridge chain
-	exists := iptables.Exists(iptables.Filter, globalChain, "-o", brName, "-j", cname)
-	if (!remove && !exists) || (remove && exists) {
-		if err := iptables.RawCombinedOutput(opt, globalChain, "-o", brName, "-j", cname); err != nil {
-			return fmt.Errorf("failed to add per-bridge filter rule for bridge %s, network chain %s: %v", brName, cname, err)
-		}
-	}
+	tx.ProgramRule(firewall.Filter, globalChain, action, firewall.Rule{OutIface: brName, Jump: cname})
 
-	exists = iptables.Exists(iptables.Filter, cname, "-i", brName, "-j", "ACCEPT")
-	if (!remove && exists) || (remove && !exists) {
-		return nil
-	}
+	tx.ProgramRule(firewall.Filter, cname, action, firewall.Rule{InIface: brName, Jump: "ACCEPT"})
 
-	if err := iptables.RawCombinedOutput(opt, cname, "-i", brName, "-j", "ACCEPT"); err != nil {
-		return fmt.Errorf("failed to add overlay filter rile for network chain %s, bridge %s: %v", cname, brName, err)
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to program overlay filter rules for network chain %s, bridge %s: %v", cname, brName, err)
 	}
 
 	return nil
 }
 
-func addFilters(cname, brName string) error {
+func addFilters(cname, brName string, families []firewall.Family) error {
 	defer filterWait()()
 
-	return setFilters(cname, brName, false)
+	for _, family := range families {
+		if err := setFilters(firewall.Get(family), cname, brName, false); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func removeFilters(cname, brName string) error {
+func removeFilters(cname, brName string, families []firewall.Family) error {
 	defer filterWait()()
 
-	return setFilters(cname, brName, true)
+	for _, family := range families {
+		if err := setFilters(firewall.Get(family), cname, brName, true); err != nil {
+			return err
+		}
+	}
+	return nil
 }
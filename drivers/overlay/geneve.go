@@ -0,0 +1,210 @@
+package overlay
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+
+	"github.com/docker/libnetwork/netutils"
+	"github.com/docker/libnetwork/ns"
+	"github.com/docker/libnetwork/osl"
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+)
+
+const (
+	// Geneve link and bridge port attributes missing in the netlink package
+	iflaGeneveID            = 1
+	iflaGeneveRemote        = 2
+	iflaGenevePort          = 5
+	iflaGeneveRemote6       = 7
+	iflaBrportNeighSuppress = 32
+
+	tunnelPrefix = "gnv"
+	tunnelLen    = 7
+)
+
+// createGeneve creates a geneve interface tunneling the VNI to the remote
+// vtep, a geneve interface reaching a single remote. An externally controlled
+// interface could reach all the remotes, but the kernel allows a single one
+// per destination port in the namespace of its socket, the host namespace the
+// interfaces of all the networks are created in.
+func createGeneve(name string, vni uint32, remote net.IP, port uint16, mtu int) error {
+	defer osl.InitOSContext()()
+
+	req := nl.NewNetlinkRequest(syscall.RTM_NEWLINK, syscall.NLM_F_CREATE|syscall.NLM_F_EXCL|syscall.NLM_F_ACK)
+	req.AddData(nl.NewIfInfomsg(syscall.AF_UNSPEC))
+	req.AddData(nl.NewRtAttr(syscall.IFLA_IFNAME, nl.ZeroTerminated(name)))
+	if mtu > 0 {
+		req.AddData(nl.NewRtAttr(syscall.IFLA_MTU, nl.Uint32Attr(uint32(mtu))))
+	}
+
+	linkInfo := nl.NewRtAttr(syscall.IFLA_LINKINFO, nil)
+	nl.NewRtAttrChild(linkInfo, nl.IFLA_INFO_KIND, nl.NonZeroTerminated("geneve"))
+	data := nl.NewRtAttrChild(linkInfo, nl.IFLA_INFO_DATA, nil)
+	nl.NewRtAttrChild(data, iflaGeneveID, nl.Uint32Attr(vni))
+	if ip4 := remote.To4(); ip4 != nil {
+		nl.NewRtAttrChild(data, iflaGeneveRemote, []byte(ip4))
+	} else {
+		nl.NewRtAttrChild(data, iflaGeneveRemote6, []byte(remote.To16()))
+	}
+	nl.NewRtAttrChild(data, iflaGenevePort, nl.Uint16Attr(nl.Swap16(port)))
+	req.AddData(linkInfo)
+
+	if _, err := req.Execute(syscall.NETLINK_ROUTE, 0); err != nil {
+		return fmt.Errorf("error creating geneve interface: %v", err)
+	}
+
+	return nil
+}
+
+// addTunnel returns the geneve tunnel of the subnet to the remote vtep,
+// adding it to the subnet bridge if missing.
+func (n *network) addTunnel(s *subnet, vtep net.IP) (string, error) {
+	n.Lock()
+	tunnel, ok := s.tunnels[vtep.String()]
+	n.Unlock()
+	if ok {
+		return tunnel, nil
+	}
+
+	tunnel, err := netutils.GenerateIfaceName(ns.NlHandle(), tunnelPrefix, tunnelLen)
+	if err != nil {
+		return "", fmt.Errorf("error generating geneve tunnel name: %v", err)
+	}
+
+	if err := createGeneve(tunnel, n.vxlanID(s), vtep, n.encapPort(), n.maxMTU()); err != nil {
+		return "", err
+	}
+
+	sbox := n.sandbox()
+	if err := sbox.AddInterface(tunnel, "geneve",
+		sbox.InterfaceOptions().Master(s.brName)); err != nil {
+		deleteInterface(tunnel)
+		return "", fmt.Errorf("geneve interface creation failed for subnet %q: %v", s.subnetIP.String(), err)
+	}
+
+	// Let the bridge answer the neighbor requests for the peers behind the
+	// tunnel, as the vxlan proxy does
+	if err := setNeighSuppress(sbox, tunnel); err != nil {
+		deleteTunnel(sbox, tunnel)
+		return "", fmt.Errorf("failed to enable the neighbor suppression on geneve tunnel %s: %v", tunnel, err)
+	}
+
+	n.Lock()
+	if s.tunnels != nil {
+		s.tunnels[vtep.String()] = tunnel
+	}
+	n.Unlock()
+
+	return tunnel, nil
+}
+
+// removeTunnel removes the geneve tunnel of the subnet to the remote vtep.
+func (n *network) removeTunnel(s *subnet, vtep net.IP) {
+	n.Lock()
+	tunnel, ok := s.tunnels[vtep.String()]
+	delete(s.tunnels, vtep.String())
+	n.Unlock()
+
+	if sbox := n.sandbox(); ok && sbox != nil {
+		deleteTunnel(sbox, tunnel)
+	}
+}
+
+// deleteBridgeTunnels deletes the geneve tunnels enslaved to the bridge in
+// the sandbox.
+func (n *network) deleteBridgeTunnels(brName string) error {
+	var (
+		sbox    = n.sandbox()
+		dstName = sandboxIfaceName(sbox, brName)
+		err     error
+	)
+
+	if ierr := sbox.InvokeFunc(func() {
+		var (
+			br    netlink.Link
+			links []netlink.Link
+		)
+		if br, err = netlink.LinkByName(dstName); err != nil {
+			return
+		}
+		if links, err = netlink.LinkList(); err != nil {
+			return
+		}
+		for _, l := range links {
+			if l.Type() == encapGeneve && l.Attrs().MasterIndex == br.Attrs().Index {
+				if err = netlink.LinkDel(l); err != nil {
+					return
+				}
+			}
+		}
+	}); ierr != nil {
+		return ierr
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to delete the geneve tunnels of bridge %s: %v", brName, err)
+	}
+
+	return nil
+}
+
+// deleteTunnel moves the geneve tunnel out of the sandbox and deletes it.
+func deleteTunnel(sbox osl.Sandbox, tunnel string) {
+	for _, i := range sbox.Info().Interfaces() {
+		if i.SrcName() == tunnel {
+			if err := i.Remove(); err != nil {
+				logrus.Debugf("Remove interface %s failed: %v", tunnel, err)
+			}
+			break
+		}
+	}
+
+	if err := deleteInterface(tunnel); err != nil {
+		logrus.Warnf("Failed to delete geneve tunnel %s: %v", tunnel, err)
+	}
+}
+
+// setNeighSuppress enables the neighbor suppression on the bridge port of
+// the interface in the sandbox.
+func setNeighSuppress(sbox osl.Sandbox, srcName string) error {
+	var (
+		dstName = sandboxIfaceName(sbox, srcName)
+		err     error
+	)
+
+	if ierr := sbox.InvokeFunc(func() {
+		var link netlink.Link
+		if link, err = netlink.LinkByName(dstName); err != nil {
+			return
+		}
+
+		req := nl.NewNetlinkRequest(syscall.RTM_SETLINK, syscall.NLM_F_ACK)
+
+		msg := nl.NewIfInfomsg(syscall.AF_BRIDGE)
+		msg.Index = int32(link.Attrs().Index)
+		req.AddData(msg)
+
+		protinfo := nl.NewRtAttr(syscall.IFLA_PROTINFO|nl.NLA_F_NESTED, nil)
+		nl.NewRtAttrChild(protinfo, iflaBrportNeighSuppress, nl.Uint8Attr(1))
+		req.AddData(protinfo)
+
+		_, err = req.Execute(syscall.NETLINK_ROUTE, 0)
+	}); ierr != nil {
+		return ierr
+	}
+
+	return err
+}
+
+// sandboxIfaceName returns the name in the sandbox of the interface.
+func sandboxIfaceName(sbox osl.Sandbox, srcName string) string {
+	for _, i := range sbox.Info().Interfaces() {
+		if i.SrcName() == srcName {
+			return i.DstName()
+		}
+	}
+	return srcName
+}
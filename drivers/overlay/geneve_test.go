@@ -0,0 +1,59 @@
+package overlay
+
+import (
+	"net"
+	"testing"
+
+	_ "github.com/docker/libnetwork/testutils"
+)
+
+func TestGeneveNetworkValue(t *testing.T) {
+	n := &network{id: "net1", encap: encapGeneve, mtu: 1450}
+	if !n.geneve() || n.encapPort() != genevePort {
+		t.Fatalf("Unexpected encapsulation %q on port %d", n.encap, n.encapPort())
+	}
+	if mtu := n.maxMTU(); mtu != 1450-geneveEncap {
+		t.Fatalf("Unexpected geneve MTU %d", mtu)
+	}
+
+	n.port = 6090
+	restored := &network{}
+	if err := restored.SetValue(n.Value()); err != nil {
+		t.Fatal(err)
+	}
+	if !restored.geneve() || restored.encapPort() != 6090 {
+		t.Fatalf("Unexpected restored encapsulation %q on port %d", restored.encap, restored.encapPort())
+	}
+
+	// Networks stored before the encapsulation option remain VXLAN
+	legacy := &network{}
+	if err := legacy.SetValue([]byte(`{"secure":false,"mtu":0,"subnets":[]}`)); err != nil {
+		t.Fatal(err)
+	}
+	if legacy.geneve() || legacy.encapPort() != vxlanPort {
+		t.Fatalf("Unexpected legacy encapsulation %q on port %d", legacy.encap, legacy.encapPort())
+	}
+}
+
+func TestVtepInUse(t *testing.T) {
+	d := &driver{peerDb: peerNetworkMap{mp: map[string]*peerMap{}}}
+	_, subnet1, _ := net.ParseCIDR("10.0.0.0/24")
+	_, subnet2, _ := net.ParseCIDR("10.0.1.0/24")
+	s := &subnet{subnetIP: subnet1}
+	vtep := net.ParseIP("192.168.1.2")
+	mac, _ := net.ParseMAC("02:42:0a:00:01:02")
+
+	d.peerDbAdd("net1", "ep1", net.ParseIP("10.0.1.2"), subnet2.Mask, mac, vtep, false)
+	d.peerDbAdd("net1", "ep2", net.ParseIP("10.0.0.3"), subnet1.Mask, mac, vtep, true)
+	if d.vtepInUse("net1", s, vtep) {
+		t.Fatal("Expected the vtep to be unused by the remote peers of the subnet")
+	}
+
+	d.peerDbAdd("net1", "ep3", net.ParseIP("10.0.0.2"), subnet1.Mask, mac, vtep, false)
+	if !d.vtepInUse("net1", s, vtep) {
+		t.Fatal("Expected the vtep to be in use by a remote peer of the subnet")
+	}
+	if d.vtepInUse("net1", s, net.ParseIP("192.168.1.3")) {
+		t.Fatal("Expected another vtep to be unused")
+	}
+}
@@ -28,16 +28,26 @@ func (d *driver) Join(nid, eid string, sboxKey string, jinfo driverapi.JoinInfo,
 		return fmt.Errorf("could not find endpoint with id %s", eid)
 	}
 
-	if n.secure && len(d.keys) == 0 {
+	if n.networkKeys {
+		if d.netEncryption(nid) == nil {
+			return fmt.Errorf("cannot join secure network: network encryption keys not present")
+		}
+	} else if n.secure && len(d.keys) == 0 {
 		return fmt.Errorf("cannot join secure network: encryption keys not present")
 	}
 
 	nlh := ns.NlHandle()
 
-	if n.secure && !nlh.SupportsNetlinkFamily(syscall.NETLINK_XFRM) {
+	if n.secure && !n.wireguard() && !nlh.SupportsNetlinkFamily(syscall.NETLINK_XFRM) {
 		return fmt.Errorf("cannot join secure network: required modules to install IPSEC rules are missing on host")
 	}
 
+	if n.wireguard() {
+		if err := d.wg.setupDevice(n.wgMTU(), d.ipv6Underlay()); err != nil {
+			return fmt.Errorf("cannot join secure network: %v", err)
+		}
+	}
+
 	s := n.getSubnetforIP(ep.addr)
 	if s == nil {
 		return fmt.Errorf("could not find subnet for endpoint %s", eid)
@@ -126,11 +136,16 @@ func (d *driver) Join(nid, eid string, sboxKey string, jinfo driverapi.JoinInfo,
 		logrus.Warn(err)
 	}
 
-	buf, err := proto.Marshal(&PeerRecord{
+	peer := &PeerRecord{
 		EndpointIP:       ep.addr.String(),
 		EndpointMAC:      ep.mac.String(),
 		TunnelEndpointIP: d.advertiseAddress,
-	})
+	}
+	if n.wireguard() {
+		peer.TunnelPublicKey = d.wg.publicKey().String()
+	}
+
+	buf, err := proto.Marshal(peer)
 	if err != nil {
 		return err
 	}
@@ -204,6 +219,12 @@ func (d *driver) EventNotify(etype driverapi.EventType, nid, tableName, key stri
 		return
 	}
 
+	if peer.TunnelPublicKey != "" {
+		if err := d.wg.setPeerKey(vtep, peer.TunnelPublicKey); err != nil {
+			logrus.Errorf("Failed to set the wireguard key of VTEP %s: %v", vtep, err)
+		}
+	}
+
 	d.peerAdd(nid, eid, addr.IP, addr.Mask, mac, vtep, false, false, false)
 }
 
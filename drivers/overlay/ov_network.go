@@ -17,6 +17,7 @@ import (
 	"github.com/docker/docker/pkg/reexec"
 	"github.com/docker/libnetwork/datastore"
 	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/firewall"
 	"github.com/docker/libnetwork/netlabel"
 	"github.com/docker/libnetwork/netutils"
 	"github.com/docker/libnetwork/ns"
@@ -46,6 +47,8 @@ type subnet struct {
 	initErr   error
 	subnetIP  *net.IPNet
 	gwIP      *net.IPNet
+	// tunnels maps the remote vteps to the name of their geneve tunnel
+	tunnels map[string]string
 }
 
 type subnetJSON struct {
@@ -55,20 +58,26 @@ type subnetJSON struct {
 }
 
 type network struct {
-	id        string
-	dbIndex   uint64
-	dbExists  bool
-	sbox      osl.Sandbox
-	nlSocket  *nl.NetlinkSocket
-	endpoints endpointTable
-	driver    *driver
-	joinCnt   int
-	once      *sync.Once
-	initEpoch int
-	initErr   error
-	subnets   []*subnet
-	secure    bool
-	mtu       int
+	id         string
+	dbIndex    uint64
+	dbExists   bool
+	sbox       osl.Sandbox
+	nlSocket   *nl.NetlinkSocket
+	endpoints  endpointTable
+	driver     *driver
+	joinCnt    int
+	once       *sync.Once
+	initEpoch  int
+	initErr    error
+	subnets    []*subnet
+	secure     bool
+	mtu        int
+	encap      string
+	port       uint16
+	encryption string
+	// networkKeys tells whether the network is encrypted with its own keys
+	// rather than the cluster ones
+	networkKeys bool
 	sync.Mutex
 }
 
@@ -181,6 +190,34 @@ func (d *driver) CreateNetwork(id string, option map[string]interface{}, nInfo d
 				return fmt.Errorf("invalid MTU value: %v", n.mtu)
 			}
 		}
+		if val, ok := optMap[encapOption]; ok {
+			if val != encapVxlan && val != encapGeneve {
+				return types.BadRequestErrorf("invalid encapsulation %q, expected %s or %s", val, encapVxlan, encapGeneve)
+			}
+			n.encap = val
+		}
+		if val, ok := optMap[encapPortOption]; ok {
+			port, err := strconv.ParseUint(val, 10, 16)
+			if err != nil || port == 0 {
+				return types.BadRequestErrorf("invalid encapsulation port %q", val)
+			}
+			n.port = uint16(port)
+		}
+		if val, ok := optMap[encryptionOption]; ok {
+			if val != encryptionIPsec && val != encryptionWireGuard {
+				return types.BadRequestErrorf("invalid encryption %q, expected %s or %s", val, encryptionIPsec, encryptionWireGuard)
+			}
+			if !n.secure {
+				return types.BadRequestErrorf("encryption %q requires an encrypted network", val)
+			}
+			n.encryption = val
+		}
+		if _, ok := optMap[networkKeysOption]; ok {
+			if !n.secure || n.wireguard() {
+				return types.BadRequestErrorf("network keys require a network encrypted with IPsec")
+			}
+			n.networkKeys = true
+		}
 	}
 
 	// If we are getting vnis from libnetwork, either we get for
@@ -214,10 +251,17 @@ func (d *driver) CreateNetwork(id string, option map[string]interface{}, nInfo d
 	}
 
 	// Make sure no rule is on the way from any stale secure network
-	if !n.secure {
-		for _, vni := range vnis {
-			programMangle(vni, false)
-			programInput(vni, false)
+	for _, vni := range vnis {
+		for _, networkKeys := range []bool{false, true} {
+			if n.secure && !n.wireguard() && n.networkKeys == networkKeys {
+				continue
+			}
+			mark, reqid := ipsecMarks(vni, networkKeys)
+			programMangle(vni, n.encapPort(), d.underlayFamily(), mark, false)
+			programInput(vni, n.encapPort(), d.underlayFamily(), reqid, false)
+		}
+		if !n.wireguard() {
+			programWireGuardRules(vni, n.encapPort(), d.underlayFamily(), false)
 		}
 	}
 
@@ -286,10 +330,22 @@ func (d *driver) DeleteNetwork(nid string) error {
 		return err
 	}
 
-	if n.secure {
+	if n.wireguard() {
+		for _, vni := range vnis {
+			if err := programWireGuardRules(vni, n.encapPort(), d.underlayFamily(), false); err != nil {
+				logrus.Warn(err)
+			}
+		}
+		d.wg.removeNetwork(nid)
+	} else if n.secure {
 		for _, vni := range vnis {
-			programMangle(vni, false)
-			programInput(vni, false)
+			mark, reqid := ipsecMarks(vni, n.networkKeys)
+			programMangle(vni, n.encapPort(), d.underlayFamily(), mark, false)
+			programInput(vni, n.encapPort(), d.underlayFamily(), reqid, false)
+		}
+		// Keep the keys of the network, it may be created again
+		if ne := d.netEncr[nid]; ne != nil {
+			ne.clear(net.ParseIP(d.bindAddress))
 		}
 	}
 
@@ -359,7 +415,7 @@ func (n *network) destroySandbox() {
 
 		for _, s := range n.subnets {
 			if hostMode {
-				if err := removeFilters(n.id[:12], s.brName); err != nil {
+				if err := removeFilters(n.id[:12], s.brName, n.filterFamilies()); err != nil {
 					logrus.Warnf("Could not remove overlay filters: %v", err)
 				}
 			}
@@ -370,10 +426,17 @@ func (n *network) destroySandbox() {
 					logrus.Warnf("could not cleanup sandbox properly: %v", err)
 				}
 			}
+
+			for _, tunnel := range s.tunnels {
+				if err := deleteInterface(tunnel); err != nil {
+					logrus.Warnf("could not cleanup sandbox properly: %v", err)
+				}
+			}
+			s.tunnels = nil
 		}
 
 		if hostMode {
-			if err := removeNetworkChain(n.id[:12]); err != nil {
+			if err := removeNetworkChain(n.id[:12], n.filterFamilies()); err != nil {
 				logrus.Warnf("could not remove network chain: %v", err)
 			}
 		}
@@ -441,7 +504,7 @@ func networkOnceInit() {
 		return
 	}
 
-	err := createVxlan("testvxlan", 1, 0)
+	err := createVxlan("testvxlan", 1, vxlanPort, false, 0)
 	if err != nil {
 		logrus.Errorf("Failed to create testvxlan interface: %v", err)
 		return
@@ -527,6 +590,12 @@ func (n *network) restoreSubnetSandbox(s *subnet, brName, vxlanName string) erro
 		return err
 	}
 
+	if n.geneve() {
+		// The tunnels of the previous life are not tracked, they are
+		// created again as the peers get programmed
+		return n.deleteBridgeTunnels(brName)
+	}
+
 	Ifaces = make(map[string][]osl.IfaceOption)
 	vxlanIfaceOption := make([]osl.IfaceOption, 1)
 	vxlanIfaceOption = append(vxlanIfaceOption, sbox.InterfaceOptions().Master(brName))
@@ -581,14 +650,18 @@ func (n *network) setupSubnetSandbox(s *subnet, brName, vxlanName string) error
 		return fmt.Errorf("bridge creation in sandbox failed for subnet %q: %v", s.subnetIP.String(), err)
 	}
 
-	err := createVxlan(vxlanName, n.vxlanID(s), n.maxMTU())
-	if err != nil {
-		return err
-	}
+	// Geneve tunnels reach a single remote, they are added to the bridge
+	// as the peers get programmed
+	if !n.geneve() {
+		err := createVxlan(vxlanName, n.vxlanID(s), n.encapPort(), n.ipv6Underlay(), n.maxMTU())
+		if err != nil {
+			return err
+		}
 
-	if err := sbox.AddInterface(vxlanName, "vxlan",
-		sbox.InterfaceOptions().Master(brName)); err != nil {
-		return fmt.Errorf("vxlan interface creation failed for subnet %q: %v", s.subnetIP.String(), err)
+		if err := sbox.AddInterface(vxlanName, "vxlan",
+			sbox.InterfaceOptions().Master(brName)); err != nil {
+			return fmt.Errorf("vxlan interface creation failed for subnet %q: %v", s.subnetIP.String(), err)
+		}
 	}
 
 	if !hostMode {
@@ -611,7 +684,7 @@ func (n *network) setupSubnetSandbox(s *subnet, brName, vxlanName string) error
 	}
 
 	if hostMode {
-		if err := addFilters(n.id[:12], brName); err != nil {
+		if err := addFilters(n.id[:12], brName, n.filterFamilies()); err != nil {
 			return err
 		}
 	}
@@ -622,6 +695,9 @@ func (n *network) setupSubnetSandbox(s *subnet, brName, vxlanName string) error
 func (n *network) initSubnetSandbox(s *subnet, restore bool) error {
 	brName := n.generateBridgeName(s)
 	vxlanName := n.generateVxlanName(s)
+	if n.geneve() {
+		vxlanName = ""
+	}
 
 	if restore {
 		if err := n.restoreSubnetSandbox(s, brName, vxlanName); err != nil {
@@ -636,6 +712,7 @@ func (n *network) initSubnetSandbox(s *subnet, restore bool) error {
 	n.Lock()
 	s.vxlanName = vxlanName
 	s.brName = brName
+	s.tunnels = map[string]string{}
 	n.Unlock()
 
 	return nil
@@ -685,7 +762,7 @@ func (n *network) initSandbox(restore bool) error {
 
 	if !restore {
 		if hostMode {
-			if err := addNetworkChain(n.id[:12]); err != nil {
+			if err := addNetworkChain(n.id[:12], n.filterFamilies()); err != nil {
 				return err
 			}
 		}
@@ -713,8 +790,9 @@ func (n *network) initSandbox(restore bool) error {
 	// this is needed to let the peerAdd configure the sandbox
 	n.setSandbox(sbox)
 
-	if !restore {
-		// Initialize the sandbox with all the peers previously received from networkdb
+	if !restore || n.geneve() {
+		// Initialize the sandbox with all the peers previously received from networkdb,
+		// on restore the geneve tunnels must be created again
 		n.driver.initSandboxPeerDB(n.id)
 	}
 
@@ -898,6 +976,58 @@ func (n *network) setVxlanID(s *subnet, vni uint32) {
 	n.Unlock()
 }
 
+// geneve tells whether the network encapsulates its traffic with Geneve.
+func (n *network) geneve() bool {
+	return n.encap == encapGeneve
+}
+
+// wireguard tells whether the network is encrypted with WireGuard rather
+// than IPsec.
+func (n *network) wireguard() bool {
+	return n.secure && n.encryption == encryptionWireGuard
+}
+
+// wgMTU returns the MTU the WireGuard interface needs to carry the
+// encapsulated traffic of the network.
+func (n *network) wgMTU() int {
+	mtu := 1500
+	if n.mtu != 0 {
+		mtu = n.mtu
+	}
+	mtu -= wgOverhead
+	if n.ipv6Underlay() {
+		mtu -= ipv6Overhead
+	}
+	return mtu
+}
+
+// ipv6Underlay tells whether the network encapsulates its traffic over
+// IPv6.
+func (n *network) ipv6Underlay() bool {
+	return n.driver != nil && n.driver.ipv6Underlay()
+}
+
+// filterFamilies returns the firewall families isolating the bridges of the
+// network in host mode, IPv6 as well on the IPv6 data paths.
+func (n *network) filterFamilies() []firewall.Family {
+	if n.ipv6Underlay() {
+		return []firewall.Family{firewall.IPv4, firewall.IPv6}
+	}
+	return []firewall.Family{firewall.IPv4}
+}
+
+// encapPort returns the UDP port of the encapsulation of the network.
+func (n *network) encapPort() uint16 {
+	switch {
+	case n.port != 0:
+		return n.port
+	case n.geneve():
+		return genevePort
+	default:
+		return vxlanPort
+	}
+}
+
 func (n *network) Key() []string {
 	return []string{"overlay", "network", n.id}
 }
@@ -923,6 +1053,10 @@ func (n *network) Value() []byte {
 	m["secure"] = n.secure
 	m["subnets"] = netJSON
 	m["mtu"] = n.mtu
+	m["encap"] = n.encap
+	m["port"] = n.port
+	m["encryption"] = n.encryption
+	m["network_keys"] = n.networkKeys
 	b, err := json.Marshal(m)
 	if err != nil {
 		return []byte{}
@@ -975,6 +1109,18 @@ func (n *network) SetValue(value []byte) error {
 		if val, ok := m["mtu"]; ok {
 			n.mtu = int(val.(float64))
 		}
+		if val, ok := m["encap"]; ok {
+			n.encap = val.(string)
+		}
+		if val, ok := m["port"]; ok {
+			n.port = uint16(val.(float64))
+		}
+		if val, ok := m["encryption"]; ok {
+			n.encryption = val.(string)
+		}
+		if val, ok := m["network_keys"]; ok {
+			n.networkKeys = val.(bool)
+		}
 		bytes, err := json.Marshal(m["subnets"])
 		if err != nil {
 			return err
@@ -2,6 +2,7 @@ package overlay
 
 import (
 	"fmt"
+	"net"
 	"strings"
 	"syscall"
 
@@ -54,18 +55,23 @@ func createVethPair() (string, string, error) {
 	return name1, name2, nil
 }
 
-func createVxlan(name string, vni uint32, mtu int) error {
+func createVxlan(name string, vni uint32, port uint16, ipv6 bool, mtu int) error {
 	defer osl.InitOSContext()()
 
 	vxlan := &netlink.Vxlan{
 		LinkAttrs: netlink.LinkAttrs{Name: name, MTU: mtu},
 		VxlanId:   int(vni),
 		Learning:  true,
-		Port:      vxlanPort,
+		Port:      int(port),
 		Proxy:     true,
 		L3miss:    true,
 		L2miss:    true,
 	}
+	if ipv6 {
+		// The unspecified IPv6 local address makes the vxlan interface
+		// reach its remotes over IPv6
+		vxlan.SrcAddr = net.IPv6unspecified
+	}
 
 	if err := ns.NlHandle().LinkAdd(vxlan); err != nil {
 		return fmt.Errorf("error creating vxlan interface: %v", err)
@@ -11,6 +11,7 @@ import (
 	"github.com/docker/libnetwork/datastore"
 	"github.com/docker/libnetwork/discoverapi"
 	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/firewall"
 	"github.com/docker/libnetwork/idm"
 	"github.com/docker/libnetwork/netlabel"
 	"github.com/docker/libnetwork/osl"
@@ -27,7 +28,32 @@ const (
 	vxlanIDEnd   = (1 << 24) - 1
 	vxlanPort    = 4789
 	vxlanEncap   = 50
+	genevePort   = 6081
+	geneveEncap  = 50
+	// ipv6Overhead is the extra length of the outer IPv6 header over the
+	// IPv4 one, with IPv6 data path addresses
+	ipv6Overhead = 20
 	secureOption = "encrypted"
+
+	// encapOption selects the encapsulation of the network, VXLAN unless
+	// set to geneve
+	encapOption = "encap"
+	// encapPortOption sets the UDP port of the encapsulation
+	encapPortOption = "encap_port"
+
+	encapVxlan  = "vxlan"
+	encapGeneve = "geneve"
+
+	// encryptionOption selects the encryption backend of the encrypted
+	// network, IPsec unless set to wireguard
+	encryptionOption = "encryption"
+
+	encryptionIPsec     = "ipsec"
+	encryptionWireGuard = "wireguard"
+
+	// networkKeysOption encrypts the network with its own keys, set through
+	// SetNetworkKeys, rather than with the cluster keys
+	networkKeysOption = "network_keys"
 )
 
 var initVxlanIdm = make(chan (bool), 1)
@@ -42,6 +68,8 @@ type driver struct {
 	config           map[string]interface{}
 	peerDb           peerNetworkMap
 	secMap           *encrMap
+	netEncr          map[string]*netEncr
+	wg               *wgState
 	serfInstance     *serf.Serf
 	networks         networkTable
 	store            datastore.DataStore
@@ -67,7 +95,9 @@ func Init(dc driverapi.DriverCallback, config map[string]interface{}) error {
 		peerDb: peerNetworkMap{
 			mp: map[string]*peerMap{},
 		},
-		secMap:   &encrMap{nodes: map[string][]*spi{}},
+		secMap:   &encrMap{nodes: map[string][]*spi{}, ports: map[string][]uint16{}},
+		netEncr:  map[string]*netEncr{},
+		wg:       &wgState{peers: map[string]*wgPeer{}},
 		config:   config,
 		peerOpCh: make(chan *peerOperation),
 	}
@@ -101,6 +131,10 @@ func Init(dc driverapi.DriverCallback, config map[string]interface{}) error {
 		}
 	}
 
+	// Remove the stale policies, states of the networks encrypted with their
+	// own keys, programmed again as their keys get set
+	clearEncryptionStates(true)
+
 	if err := d.restoreEndpoints(); err != nil {
 		logrus.Warnf("Failure during overlay endpoints restore: %v", err)
 	}
@@ -254,6 +288,28 @@ func validateSelf(node string) error {
 	return fmt.Errorf("Multi-Host overlay networking requires cluster-advertise(%s) to be configured with a local ip-address that is reachable within the cluster", advIP.String())
 }
 
+// ipv6Underlay tells whether the data path address of the node is IPv6.
+func (d *driver) ipv6Underlay() bool {
+	ip := net.ParseIP(d.advertiseAddress)
+	return ip != nil && ip.To4() == nil
+}
+
+// underlayFamily returns the firewall family of the data path of the node.
+func (d *driver) underlayFamily() firewall.Family {
+	if d.ipv6Underlay() {
+		return firewall.IPv6
+	}
+	return firewall.IPv4
+}
+
+// ipFamily returns the firewall family of the address.
+func ipFamily(ip net.IP) firewall.Family {
+	if ip.To4() == nil {
+		return firewall.IPv6
+	}
+	return firewall.IPv4
+}
+
 func (d *driver) nodeJoin(advertiseAddress, bindAddress string, self bool) {
 	if self && !d.isSerfAlive() {
 		d.Lock()
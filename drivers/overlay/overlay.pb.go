@@ -48,6 +48,9 @@ type PeerRecord struct {
 	// which this container is running and can be reached by
 	// building a tunnel to that host IP.
 	TunnelEndpointIP string `protobuf:"bytes,3,opt,name=tunnel_endpoint_ip,json=tunnelEndpointIp,proto3" json:"tunnel_endpoint_ip,omitempty"`
+	// Tunnel Public Key is the WireGuard public key of the host,
+	// set when the network encrypts its traffic with WireGuard.
+	TunnelPublicKey string `protobuf:"bytes,4,opt,name=tunnel_public_key,json=tunnelPublicKey,proto3" json:"tunnel_public_key,omitempty"`
 }
 
 func (m *PeerRecord) Reset()                    { *m = PeerRecord{} }
@@ -61,11 +64,12 @@ func (this *PeerRecord) GoString() string {
 	if this == nil {
 		return "nil"
 	}
-	s := make([]string, 0, 7)
+	s := make([]string, 0, 8)
 	s = append(s, "&overlay.PeerRecord{")
 	s = append(s, "EndpointIP: "+fmt.Sprintf("%#v", this.EndpointIP)+",\n")
 	s = append(s, "EndpointMAC: "+fmt.Sprintf("%#v", this.EndpointMAC)+",\n")
 	s = append(s, "TunnelEndpointIP: "+fmt.Sprintf("%#v", this.TunnelEndpointIP)+",\n")
+	s = append(s, "TunnelPublicKey: "+fmt.Sprintf("%#v", this.TunnelPublicKey)+",\n")
 	s = append(s, "}")
 	return strings.Join(s, "")
 }
@@ -127,6 +131,12 @@ func (m *PeerRecord) MarshalTo(data []byte) (int, error) {
 		i = encodeVarintOverlay(data, i, uint64(len(m.TunnelEndpointIP)))
 		i += copy(data[i:], m.TunnelEndpointIP)
 	}
+	if len(m.TunnelPublicKey) > 0 {
+		data[i] = 0x22
+		i++
+		i = encodeVarintOverlay(data, i, uint64(len(m.TunnelPublicKey)))
+		i += copy(data[i:], m.TunnelPublicKey)
+	}
 	return i, nil
 }
 
@@ -172,6 +182,10 @@ func (m *PeerRecord) Size() (n int) {
 	if l > 0 {
 		n += 1 + l + sovOverlay(uint64(l))
 	}
+	l = len(m.TunnelPublicKey)
+	if l > 0 {
+		n += 1 + l + sovOverlay(uint64(l))
+	}
 	return n
 }
 
@@ -196,6 +210,7 @@ func (this *PeerRecord) String() string {
 		`EndpointIP:` + fmt.Sprintf("%v", this.EndpointIP) + `,`,
 		`EndpointMAC:` + fmt.Sprintf("%v", this.EndpointMAC) + `,`,
 		`TunnelEndpointIP:` + fmt.Sprintf("%v", this.TunnelEndpointIP) + `,`,
+		`TunnelPublicKey:` + fmt.Sprintf("%v", this.TunnelPublicKey) + `,`,
 		`}`,
 	}, "")
 	return s
@@ -324,6 +339,35 @@ func (m *PeerRecord) Unmarshal(data []byte) error {
 			}
 			m.TunnelEndpointIP = string(data[iNdEx:postIndex])
 			iNdEx = postIndex
+		case 4:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field TunnelPublicKey", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOverlay
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthOverlay
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.TunnelPublicKey = string(data[iNdEx:postIndex])
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipOverlay(data[iNdEx:])
@@ -451,18 +495,20 @@ var (
 )
 
 var fileDescriptorOverlay = []byte{
-	// 195 bytes of a gzipped FileDescriptorProto
+	// 229 bytes of a gzipped FileDescriptorProto
 	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x09, 0x6e, 0x88, 0x02, 0xff, 0xe2, 0xe2, 0xcd, 0x2f, 0x4b, 0x2d,
 	0xca, 0x49, 0xac, 0xd4, 0x2b, 0x28, 0xca, 0x2f, 0xc9, 0x17, 0x62, 0x87, 0x72, 0xa5, 0x44, 0xd2,
-	0xf3, 0xd3, 0xf3, 0xc1, 0x62, 0xfa, 0x20, 0x16, 0x44, 0x5a, 0x69, 0x2b, 0x23, 0x17, 0x57, 0x40,
+	0xf3, 0xd3, 0xf3, 0xc1, 0x62, 0xfa, 0x20, 0x16, 0x44, 0x5a, 0xe9, 0x21, 0x23, 0x17, 0x57, 0x40,
 	0x6a, 0x6a, 0x51, 0x50, 0x6a, 0x72, 0x7e, 0x51, 0x8a, 0x90, 0x3e, 0x17, 0x77, 0x6a, 0x5e, 0x4a,
 	0x41, 0x7e, 0x66, 0x5e, 0x49, 0x7c, 0x66, 0x81, 0x04, 0xa3, 0x02, 0xa3, 0x06, 0xa7, 0x13, 0xdf,
 	0xa3, 0x7b, 0xf2, 0x5c, 0xae, 0x50, 0x61, 0xcf, 0x80, 0x20, 0x2e, 0x98, 0x12, 0xcf, 0x02, 0x21,
-	0x23, 0x2e, 0x1e, 0xb8, 0x86, 0xdc, 0xc4, 0x64, 0x09, 0x26, 0xb0, 0x0e, 0x7e, 0xa0, 0x0e, 0x6e,
-	0x98, 0x0e, 0x5f, 0x47, 0xe7, 0x20, 0xb8, 0xa9, 0xbe, 0x89, 0xc9, 0x42, 0x4e, 0x5c, 0x42, 0x25,
-	0xa5, 0x79, 0x79, 0xa9, 0x39, 0xf1, 0xc8, 0x76, 0x31, 0x83, 0x75, 0x8a, 0x00, 0x75, 0x0a, 0x84,
-	0x80, 0x65, 0x91, 0x6c, 0x14, 0x28, 0x41, 0x15, 0x29, 0x70, 0x92, 0xb8, 0xf1, 0x50, 0x8e, 0xe1,
-	0xc3, 0x43, 0x39, 0xc6, 0x86, 0x47, 0x72, 0x8c, 0x27, 0x80, 0xf8, 0x02, 0x10, 0x3f, 0x00, 0xe2,
-	0x24, 0x36, 0xb0, 0xc7, 0x8c, 0x01, 0x01, 0x00, 0x00, 0xff, 0xff, 0xbf, 0xd7, 0x7d, 0x7d, 0x08,
-	0x01, 0x00, 0x00,
+	0x23, 0x2e, 0x1e, 0xb8, 0x86, 0xdc, 0xc4, 0x64, 0x09, 0x26, 0xb0, 0x0e, 0xfe, 0x47, 0xf7, 0xe4,
+	0xb9, 0x61, 0x3a, 0x7c, 0x1d, 0x9d, 0x83, 0xe0, 0xa6, 0xfa, 0x26, 0x26, 0x0b, 0x39, 0x71, 0x09,
+	0x95, 0x94, 0xe6, 0xe5, 0xa5, 0xe6, 0xc4, 0x23, 0xdb, 0xc5, 0x0c, 0xd6, 0x29, 0xf2, 0xe8, 0x9e,
+	0xbc, 0x40, 0x08, 0x58, 0x16, 0xc9, 0x46, 0x81, 0x12, 0x54, 0x91, 0x02, 0x21, 0x2d, 0x2e, 0x41,
+	0xa8, 0x19, 0x05, 0xa5, 0x49, 0x39, 0x99, 0xc9, 0xf1, 0xd9, 0xa9, 0x95, 0x12, 0x2c, 0x20, 0x23,
+	0x82, 0xf8, 0x21, 0x12, 0x01, 0x60, 0x71, 0xef, 0xd4, 0x4a, 0x27, 0x89, 0x1b, 0x0f, 0xe5, 0x18,
+	0x3e, 0x3c, 0x94, 0x63, 0x6c, 0x78, 0x24, 0xc7, 0x78, 0xe2, 0x91, 0x1c, 0xe3, 0x85, 0x47, 0x72,
+	0x8c, 0x0f, 0x1e, 0xc9, 0x31, 0x26, 0xb1, 0x81, 0x03, 0xc1, 0x18, 0x30, 0x00, 0xc8, 0xf8, 0xe1,
+	0x93, 0x34, 0x01, 0x00, 0x00,
 }
@@ -14,8 +14,10 @@ import (
 	"github.com/docker/libnetwork/datastore"
 	"github.com/docker/libnetwork/discoverapi"
 	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/firewall"
 	"github.com/docker/libnetwork/netlabel"
-	_ "github.com/docker/libnetwork/testutils"
+	"github.com/docker/libnetwork/testutils"
+	"github.com/vishvananda/netlink"
 	"github.com/vishvananda/netlink/nl"
 )
 
@@ -173,3 +175,40 @@ func TestNetlinkSocket(t *testing.T) {
 		}
 	}
 }
+
+func TestIPv6Underlay(t *testing.T) {
+	d := &driver{advertiseAddress: "fd00::1"}
+	if !d.ipv6Underlay() || d.underlayFamily() != firewall.IPv6 {
+		t.Fatalf("Expected an IPv6 data path for %s", d.advertiseAddress)
+	}
+	n := &network{id: "net1", driver: d, mtu: 1500}
+	if mtu := n.maxMTU(); mtu != 1500-vxlanEncap-ipv6Overhead {
+		t.Fatalf("Unexpected MTU %d over IPv6", mtu)
+	}
+	if families := n.filterFamilies(); len(families) != 2 {
+		t.Fatalf("Unexpected filter families %v", families)
+	}
+
+	defer testutils.SetupTestOSContext(t)()
+
+	if err := createVxlan("testvxlan6", 1, vxlanPort, true, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer deleteInterface("testvxlan6")
+
+	link, err := netlink.LinkByName("testvxlan6")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mac, _ := net.ParseMAC("02:42:0a:00:00:02")
+	if err := netlink.NeighSet(&netlink.Neigh{
+		LinkIndex:    link.Attrs().Index,
+		Family:       syscall.AF_BRIDGE,
+		Flags:        netlink.NTF_SELF,
+		State:        netlink.NUD_PERMANENT,
+		IP:           net.ParseIP("fd00::2"),
+		HardwareAddr: mac,
+	}); err != nil {
+		t.Fatalf("Failed to add an IPv6 VTEP to the vxlan interface: %v", err)
+	}
+}
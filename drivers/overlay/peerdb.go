@@ -139,6 +139,16 @@ func (d *driver) peerDbNetworkWalk(nid string, f func(*peerKey, *peerEntry) bool
 	return nil
 }
 
+// vtepInUse tells whether any remote peer of the subnet is behind the vtep.
+func (d *driver) vtepInUse(nid string, s *subnet, vtep net.IP) bool {
+	var inUse bool
+	d.peerDbNetworkWalk(nid, func(pKey *peerKey, pEntry *peerEntry) bool {
+		inUse = !pEntry.isLocal && pEntry.vtep.Equal(vtep) && s.subnetIP.Contains(pKey.peerIP)
+		return inUse
+	})
+	return inUse
+}
+
 func (d *driver) peerDbSearch(nid string, peerIP net.IP) (*peerKey, *peerEntry, error) {
 	var pKeyMatched *peerKey
 	var pEntryMatched *peerEntry
@@ -392,8 +402,21 @@ func (d *driver) peerAddOp(nid, eid string, peerIP net.IP, peerIPMask net.IPMask
 		logrus.Warn(err)
 	}
 
+	ipLink, fdbLink := s.vxlanName, s.vxlanName
+	fdbOptions := []osl.NeighOption{sbox.NeighborOptions().Family(syscall.AF_BRIDGE)}
+	if n.geneve() {
+		// Geneve tunnels reach a single remote, the bridge forwards the peer mac
+		// to the tunnel of its vtep and answers for the peer IP
+		tunnel, err := n.addTunnel(s, vtep)
+		if err != nil {
+			return fmt.Errorf("could not add the geneve tunnel for nid:%s eid:%s into the sandbox:%v", nid, eid, err)
+		}
+		ipLink, fdbLink = s.brName, tunnel
+		fdbOptions = append(fdbOptions, sbox.NeighborOptions().MasterFDB(true))
+	}
+
 	// Add neighbor entry for the peer IP
-	if err := sbox.AddNeighbor(peerIP, peerMac, l3Miss, sbox.NeighborOptions().LinkName(s.vxlanName)); err != nil {
+	if err := sbox.AddNeighbor(peerIP, peerMac, l3Miss, sbox.NeighborOptions().LinkName(ipLink)); err != nil {
 		if _, ok := err.(osl.NeighborSearchError); ok && dbEntries > 1 {
 			// We are in the transient case so only the first configuration is programmed into the kernel
 			// Upon deletion if the active configuration is deleted the next one from the database will be restored
@@ -404,8 +427,7 @@ func (d *driver) peerAddOp(nid, eid string, peerIP net.IP, peerIPMask net.IPMask
 	}
 
 	// Add fdb entry to the bridge for the peer mac
-	if err := sbox.AddNeighbor(vtep, peerMac, l2Miss, sbox.NeighborOptions().LinkName(s.vxlanName),
-		sbox.NeighborOptions().Family(syscall.AF_BRIDGE)); err != nil {
+	if err := sbox.AddNeighbor(vtep, peerMac, l2Miss, append(fdbOptions, sbox.NeighborOptions().LinkName(fdbLink))...); err != nil {
 		return fmt.Errorf("could not add fdb entry for nid:%s eid:%s into the sandbox:%v", nid, eid, err)
 	}
 
@@ -470,6 +492,11 @@ func (d *driver) peerDeleteOp(nid, eid string, peerIP net.IP, peerIPMask net.IPM
 		if err := sbox.DeleteNeighbor(peerIP, peerMac, true); err != nil {
 			return fmt.Errorf("could not delete neighbor entry for nid:%s eid:%s into the sandbox:%v", nid, eid, err)
 		}
+
+		// Remove the geneve tunnel to the vtep once no peer is behind it
+		if s := n.getSubnetforIP(&net.IPNet{IP: peerIP, Mask: peerIPMask}); n.geneve() && s != nil && !d.vtepInUse(nid, s, vtep) {
+			n.removeTunnel(s, vtep)
+		}
 	}
 
 	if dbEntries == 0 {
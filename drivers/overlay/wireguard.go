@@ -0,0 +1,593 @@
+package overlay
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"syscall"
+
+	"github.com/docker/libnetwork/firewall"
+	"github.com/docker/libnetwork/ns"
+	"github.com/docker/libnetwork/osl"
+	"github.com/gogo/protobuf/proto"
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/crypto/curve25519"
+)
+
+const (
+	wgIfaceName = "ov-wg0"
+	wgPort      = 51820
+	// wgMark marks the encapsulated traffic of the networks encrypted with
+	// WireGuard, routed to the remote vteps through the WireGuard interface
+	// by the rule looking up wgTable
+	wgMark  = 0xD0C4E4
+	wgTable = 0xD0C4
+	// wgOverhead is the outer IP(20) + UDP(8) + WireGuard header(16) +
+	// authentication tag(16)
+	wgOverhead = 60
+
+	// WireGuard generic netlink interface
+	wgGenlName               = "wireguard"
+	wgGenlVersion            = 1
+	wgCmdSetDevice           = 1
+	wgDeviceAIfname          = 2
+	wgDeviceAPrivateKey      = 3
+	wgDeviceAListenPort      = 6
+	wgDeviceAPeers           = 8
+	wgPeerAPublicKey         = 1
+	wgPeerAPresharedKey      = 2
+	wgPeerAFlags             = 3
+	wgPeerAEndpoint          = 4
+	wgPeerAAllowedIPs        = 9
+	wgPeerFRemoveMe          = 1
+	wgPeerFReplaceAllowedIPs = 2
+	wgAllowedIPAFamily       = 1
+	wgAllowedIPAIPAddr       = 2
+	wgAllowedIPACidrMask     = 3
+)
+
+type wgKey [32]byte
+
+func (k wgKey) String() string {
+	return base64.StdEncoding.EncodeToString(k[:])
+}
+
+// publicKey returns the public key of the private key.
+func (k wgKey) publicKey() wgKey {
+	var pub wgKey
+	curve25519.ScalarBaseMult((*[32]byte)(&pub), (*[32]byte)(&k))
+	return pub
+}
+
+// newWgPrivateKey generates a curve25519 private key.
+func newWgPrivateKey() (wgKey, error) {
+	var k wgKey
+	if _, err := rand.Read(k[:]); err != nil {
+		return k, err
+	}
+	k[0] &= 248
+	k[31] &= 127
+	k[31] |= 64
+	return k, nil
+}
+
+func parseWgKey(s string) (wgKey, error) {
+	var k wgKey
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return k, err
+	}
+	if len(b) != len(k) {
+		return k, fmt.Errorf("invalid key length %d", len(b))
+	}
+	copy(k[:], b)
+	return k, nil
+}
+
+// wgPeer is a remote node in the WireGuard interface.
+type wgPeer struct {
+	vtep net.IP
+	// publicKey is the last key the node gossiped
+	publicKey *wgKey
+	// programmed is the key of the node in the WireGuard interface
+	programmed *wgKey
+	// networks are the encrypted networks with local endpoints the node
+	// participates to
+	networks map[string]bool
+}
+
+// wgState is the WireGuard interface of the node, shared by the overlay
+// networks encrypted with WireGuard.
+type wgState struct {
+	privateKey   *wgKey
+	presharedKey wgKey
+	ifIndex      int
+	mtu          int
+	family       int
+	peers        map[string]*wgPeer
+	sync.Mutex
+}
+
+// setKeys derives the preshared key of the nodes from the primary encryption
+// key, and generates a new key pair for the node on rotation.
+func (w *wgState) setKeys(primary *key, rotate bool) error {
+	w.Lock()
+	defer w.Unlock()
+
+	if rotate || w.privateKey == nil {
+		k, err := newWgPrivateKey()
+		if err != nil {
+			return fmt.Errorf("failed to generate the wireguard private key: %v", err)
+		}
+		w.privateKey = &k
+	}
+	w.presharedKey = sha256.Sum256(primary.value)
+
+	if w.ifIndex == 0 {
+		return nil
+	}
+
+	var peers []wgPeerConfig
+	for _, p := range w.peers {
+		if p.programmed != nil {
+			peers = append(peers, wgPeerConfig{publicKey: *p.programmed, endpoint: p.vtep})
+		}
+	}
+	return wgSetDevice(w.privateKey, w.presharedKey, peers)
+}
+
+// publicKey returns the public key of the node.
+func (w *wgState) publicKey() wgKey {
+	w.Lock()
+	defer w.Unlock()
+
+	if w.privateKey == nil {
+		k, err := newWgPrivateKey()
+		if err != nil {
+			logrus.Warnf("Failed to generate the wireguard private key: %v", err)
+			return wgKey{}
+		}
+		w.privateKey = &k
+	}
+	return w.privateKey.publicKey()
+}
+
+// setupDevice creates the WireGuard interface if missing, raising its MTU to
+// carry the encapsulated traffic of the network, over IPv6 with IPv6 data
+// path addresses.
+func (w *wgState) setupDevice(mtu int, ipv6 bool) error {
+	w.Lock()
+	defer w.Unlock()
+
+	family := netlink.FAMILY_V4
+	if ipv6 {
+		family = netlink.FAMILY_V6
+	}
+
+	if w.ifIndex != 0 && mtu <= w.mtu {
+		return nil
+	}
+	if w.privateKey == nil {
+		return fmt.Errorf("wireguard key not present")
+	}
+
+	nlh := ns.NlHandle()
+	link, err := nlh.LinkByName(wgIfaceName)
+	if err != nil {
+		if err := nlh.LinkAdd(&netlink.GenericLink{
+			LinkAttrs: netlink.LinkAttrs{Name: wgIfaceName, MTU: mtu},
+			LinkType:  "wireguard",
+		}); err != nil {
+			return fmt.Errorf("error creating wireguard interface: %v", err)
+		}
+		if link, err = nlh.LinkByName(wgIfaceName); err != nil {
+			return fmt.Errorf("failed to find wireguard interface: %v", err)
+		}
+	}
+	if mtu > link.Attrs().MTU {
+		if err := nlh.LinkSetMTU(link, mtu); err != nil {
+			return fmt.Errorf("failed to set the MTU of the wireguard interface: %v", err)
+		}
+	}
+
+	// The decrypted traffic comes from vteps routed through the underlay
+	if family == netlink.FAMILY_V4 {
+		if err := writeSystemProperty("net.ipv4.conf."+wgIfaceName+".rp_filter", "2"); err != nil {
+			return fmt.Errorf("failed to loosen the reverse path filter of the wireguard interface: %v", err)
+		}
+	}
+	if err := wgSetDevice(w.privateKey, w.presharedKey, nil); err != nil {
+		return err
+	}
+	if err := nlh.LinkSetUp(link); err != nil {
+		return fmt.Errorf("failed to bring up the wireguard interface: %v", err)
+	}
+	if err := programWireGuardRule(family, true); err != nil {
+		return err
+	}
+	// Never leak the marked traffic in clear when the peer is not programmed
+	if err := nlh.RouteReplace(&netlink.Route{
+		Dst:   wgPrefix(family, nil),
+		Table: wgTable,
+		Type:  syscall.RTN_UNREACHABLE,
+	}); err != nil {
+		return fmt.Errorf("failed to set the default route of the wireguard table: %v", err)
+	}
+
+	w.ifIndex = link.Attrs().Index
+	w.mtu = mtu
+	w.family = family
+
+	// Program the peers known before the interface
+	for _, p := range w.peers {
+		if err := w.syncPeer(p); err != nil {
+			logrus.Warn(err)
+		}
+	}
+	return nil
+}
+
+// removeDevice deletes the WireGuard interface and its routing rule, stale
+// from a previous life.
+func (w *wgState) removeDevice() {
+	w.Lock()
+	defer w.Unlock()
+
+	w.ifIndex = 0
+	w.mtu = 0
+	for _, p := range w.peers {
+		p.programmed = nil
+	}
+
+	for _, family := range []int{netlink.FAMILY_V4, netlink.FAMILY_V6} {
+		if err := programWireGuardRule(family, false); err != nil {
+			logrus.Debug(err)
+		}
+	}
+	if link, err := ns.NlHandle().LinkByName(wgIfaceName); err == nil {
+		if err := ns.NlHandle().LinkDel(link); err != nil {
+			logrus.Warnf("Failed to delete the wireguard interface: %v", err)
+		}
+	}
+}
+
+// setPeerKey records the public key gossiped by the remote node.
+func (w *wgState) setPeerKey(vtep net.IP, publicKey string) error {
+	k, err := parseWgKey(publicKey)
+	if err != nil {
+		return fmt.Errorf("invalid wireguard public key of %s: %v", vtep, err)
+	}
+
+	w.Lock()
+	defer w.Unlock()
+
+	p := w.peer(vtep)
+	p.publicKey = &k
+	return w.syncPeer(p)
+}
+
+// addPeer routes the encapsulated traffic of the network to the remote node
+// through the WireGuard interface.
+func (w *wgState) addPeer(nid string, vtep net.IP) error {
+	w.Lock()
+	defer w.Unlock()
+
+	p := w.peer(vtep)
+	p.networks[nid] = true
+	return w.syncPeer(p)
+}
+
+// removePeer stops routing the encapsulated traffic of the network to the
+// remote node through the WireGuard interface.
+func (w *wgState) removePeer(nid string, vtep net.IP) error {
+	w.Lock()
+	defer w.Unlock()
+
+	p, ok := w.peers[vtep.String()]
+	if !ok {
+		return nil
+	}
+	delete(p.networks, nid)
+	return w.syncPeer(p)
+}
+
+// removeNetwork stops routing the encapsulated traffic of the deleted network
+// through the WireGuard interface.
+func (w *wgState) removeNetwork(nid string) {
+	w.Lock()
+	defer w.Unlock()
+
+	for _, p := range w.peers {
+		if !p.networks[nid] {
+			continue
+		}
+		delete(p.networks, nid)
+		if err := w.syncPeer(p); err != nil {
+			logrus.Warn(err)
+		}
+	}
+}
+
+// peer returns the peer of the remote node, added if missing. To be called
+// while holding the lock.
+func (w *wgState) peer(vtep net.IP) *wgPeer {
+	p, ok := w.peers[vtep.String()]
+	if !ok {
+		p = &wgPeer{vtep: vtep, networks: map[string]bool{}}
+		w.peers[vtep.String()] = p
+	}
+	return p
+}
+
+// syncPeer programs the peer in the WireGuard interface when it participates
+// to encrypted networks and its public key is known, else removes it. To be
+// called while holding the lock.
+func (w *wgState) syncPeer(p *wgPeer) error {
+	if w.ifIndex == 0 {
+		return nil
+	}
+
+	var (
+		active = len(p.networks) > 0 && p.publicKey != nil
+		peers  []wgPeerConfig
+		route  = &netlink.Route{
+			Dst:       wgPrefix(w.family, p.vtep),
+			LinkIndex: w.ifIndex,
+			Table:     wgTable,
+		}
+	)
+
+	if p.programmed != nil && (!active || *p.programmed != *p.publicKey) {
+		peers = append(peers, wgPeerConfig{publicKey: *p.programmed, remove: true})
+	}
+	if active && (p.programmed == nil || *p.programmed != *p.publicKey) {
+		peers = append(peers, wgPeerConfig{publicKey: *p.publicKey, endpoint: p.vtep})
+	}
+	if len(peers) == 0 {
+		return nil
+	}
+
+	if err := wgSetDevice(nil, w.presharedKey, peers); err != nil {
+		return err
+	}
+
+	if !active {
+		p.programmed = nil
+		if err := ns.NlHandle().RouteDel(route); err != nil {
+			logrus.Warnf("Failed to delete the wireguard route to %s: %v", p.vtep, err)
+		}
+		return nil
+	}
+
+	p.programmed = p.publicKey
+	if err := ns.NlHandle().RouteReplace(route); err != nil {
+		return fmt.Errorf("failed to route the traffic to %s through the wireguard interface: %v", p.vtep, err)
+	}
+	return nil
+}
+
+type wgPeerConfig struct {
+	publicKey wgKey
+	endpoint  net.IP
+	remove    bool
+}
+
+// wgSetDevice configures the WireGuard interface and its peers.
+func wgSetDevice(privateKey *wgKey, presharedKey wgKey, peers []wgPeerConfig) error {
+	defer osl.InitOSContext()()
+
+	family, err := netlink.GenlFamilyGet(wgGenlName)
+	if err != nil {
+		return fmt.Errorf("failed to get the wireguard netlink family: %v", err)
+	}
+
+	req := nl.NewNetlinkRequest(int(family.ID), syscall.NLM_F_ACK)
+	req.AddData(&nl.Genlmsg{Command: wgCmdSetDevice, Version: wgGenlVersion})
+	req.AddData(nl.NewRtAttr(wgDeviceAIfname, nl.ZeroTerminated(wgIfaceName)))
+	if privateKey != nil {
+		req.AddData(nl.NewRtAttr(wgDeviceAPrivateKey, privateKey[:]))
+		req.AddData(nl.NewRtAttr(wgDeviceAListenPort, nl.Uint16Attr(wgPort)))
+	}
+
+	if len(peers) > 0 {
+		peersAttr := nl.NewRtAttr(wgDeviceAPeers|nl.NLA_F_NESTED, nil)
+		for _, p := range peers {
+			peer := nl.NewRtAttrChild(peersAttr, nl.NLA_F_NESTED, nil)
+			nl.NewRtAttrChild(peer, wgPeerAPublicKey, p.publicKey[:])
+			if p.remove {
+				nl.NewRtAttrChild(peer, wgPeerAFlags, nl.Uint32Attr(wgPeerFRemoveMe))
+				continue
+			}
+
+			var (
+				endpoint []byte
+				family   = syscall.AF_INET
+				ip       = p.endpoint.To4()
+			)
+			if ip != nil {
+				// struct sockaddr_in
+				endpoint = make([]byte, syscall.SizeofSockaddrInet4)
+				copy(endpoint[4:], ip)
+			} else {
+				// struct sockaddr_in6, the address follows the flow info
+				family = syscall.AF_INET6
+				ip = p.endpoint.To16()
+				endpoint = make([]byte, syscall.SizeofSockaddrInet6)
+				copy(endpoint[8:], ip)
+			}
+			nl.NativeEndian().PutUint16(endpoint[0:], uint16(family))
+			binary.BigEndian.PutUint16(endpoint[2:], wgPort)
+
+			nl.NewRtAttrChild(peer, wgPeerAFlags, nl.Uint32Attr(wgPeerFReplaceAllowedIPs))
+			nl.NewRtAttrChild(peer, wgPeerAPresharedKey, presharedKey[:])
+			nl.NewRtAttrChild(peer, wgPeerAEndpoint, endpoint)
+			allowedIPs := nl.NewRtAttrChild(peer, wgPeerAAllowedIPs|nl.NLA_F_NESTED, nil)
+			allowedIP := nl.NewRtAttrChild(allowedIPs, nl.NLA_F_NESTED, nil)
+			nl.NewRtAttrChild(allowedIP, wgAllowedIPAFamily, nl.Uint16Attr(uint16(family)))
+			nl.NewRtAttrChild(allowedIP, wgAllowedIPAIPAddr, []byte(ip))
+			nl.NewRtAttrChild(allowedIP, wgAllowedIPACidrMask, nl.Uint8Attr(uint8(8*len(ip))))
+		}
+		req.AddData(peersAttr)
+	}
+
+	if _, err := req.Execute(syscall.NETLINK_GENERIC, 0); err != nil {
+		return fmt.Errorf("failed to configure the wireguard interface: %v", err)
+	}
+	return nil
+}
+
+// wgPrefix returns the host prefix of the address in the family, or the
+// default prefix without address.
+func wgPrefix(family int, ip net.IP) *net.IPNet {
+	bits := 32
+	if family == netlink.FAMILY_V6 {
+		bits = 128
+	}
+	if ip == nil {
+		if bits == 32 {
+			return &net.IPNet{IP: net.IPv4zero, Mask: net.CIDRMask(0, bits)}
+		}
+		return &net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(0, bits)}
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+}
+
+// programWireGuardRule adds, or removes, the rule of the family routing the
+// marked encapsulated traffic with the WireGuard table.
+func programWireGuardRule(family int, add bool) error {
+	nlh := ns.NlHandle()
+
+	rules, err := nlh.RuleList(family)
+	if err != nil {
+		return fmt.Errorf("failed to list the routing rules: %v", err)
+	}
+	var exists bool
+	for _, r := range rules {
+		if r.Mark == wgMark && r.Table == wgTable {
+			exists = true
+			break
+		}
+	}
+	if add == exists {
+		return nil
+	}
+
+	if add {
+		rule := netlink.NewRule()
+		rule.Family = family
+		rule.Mark = wgMark
+		rule.Table = wgTable
+		if err := nlh.RuleAdd(rule); err != nil {
+			return fmt.Errorf("failed to add the wireguard routing rule: %v", err)
+		}
+		return nil
+	}
+	if err := wgRuleDel(family); err != nil {
+		return fmt.Errorf("failed to remove the wireguard routing rule: %v", err)
+	}
+	return nil
+}
+
+// wgRuleDel deletes the WireGuard routing rule. The netlink package sets the
+// create flags on the rule deletions, refused by the recent kernels.
+func wgRuleDel(family int) error {
+	defer osl.InitOSContext()()
+
+	req := nl.NewNetlinkRequest(syscall.RTM_DELRULE, syscall.NLM_F_ACK)
+	msg := nl.NewRtMsg()
+	msg.Family = uint8(family)
+	msg.Table = syscall.RT_TABLE_UNSPEC
+	req.AddData(msg)
+	req.AddData(nl.NewRtAttr(nl.FRA_FWMARK, nl.Uint32Attr(wgMark)))
+	req.AddData(nl.NewRtAttr(nl.FRA_TABLE, nl.Uint32Attr(wgTable)))
+
+	_, err := req.Execute(syscall.NETLINK_ROUTE, 0)
+	return err
+}
+
+// programWireGuardRules installs, or removes, the rules marking the outgoing
+// encapsulated traffic of the VNI for the WireGuard interface, and accepting
+// the incoming one only from it.
+func programWireGuardRules(vni uint32, port uint16, family firewall.Family, add bool) error {
+	var (
+		mark   = firewall.Rule{Proto: "udp", DstPort: int(port), VNI: vni, Family: family, Jump: "MARK", SetMark: wgMark}
+		accept = firewall.Rule{InIface: wgIfaceName, Proto: "udp", DstPort: int(port), VNI: vni, Family: family, Jump: "ACCEPT"}
+		block  = firewall.Rule{Proto: "udp", DstPort: int(port), VNI: vni, Family: family, Jump: "DROP"}
+		action = firewall.Append
+		msg    = "add"
+		tx     = firewall.Get(family).NewTransaction()
+	)
+
+	if !add {
+		action = firewall.Delete
+		msg = "remove"
+	}
+
+	tx.ProgramRule(firewall.Mangle, "OUTPUT", action, mark)
+	tx.ProgramRule(firewall.Filter, "INPUT", action, accept)
+	tx.ProgramRule(firewall.Filter, "INPUT", action, block)
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not %s wireguard rules for vni %d: %v", msg, vni, err)
+	}
+	return nil
+}
+
+// checkWireGuard routes the encapsulated traffic of the network to the remote
+// nodes through the WireGuard interface, or stops routing it to the node.
+func (d *driver) checkWireGuard(n *network, rIP net.IP, vxlanID uint32, nodes map[string]net.IP, add bool) error {
+	if add {
+		if err := programWireGuardRules(vxlanID, n.encapPort(), d.underlayFamily(), true); err != nil {
+			logrus.Warn(err)
+		}
+		for _, rIP := range nodes {
+			if err := d.wg.addPeer(n.id, rIP); err != nil {
+				logrus.Warnf("Failed to program wireguard peer %s: %v", rIP, err)
+			}
+		}
+	} else {
+		if len(nodes) == 0 {
+			if err := d.wg.removePeer(n.id, rIP); err != nil {
+				logrus.Warnf("Failed to remove wireguard peer %s: %v", rIP, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// UpdateTableEntry refreshes the WireGuard public key of the node in the peer
+// records of the local endpoints, after a key rotation.
+func (d *driver) UpdateTableEntry(nid, eid, tableName, key string, value []byte) ([]byte, bool) {
+	if tableName != ovPeerTable {
+		return nil, false
+	}
+	n := d.network(nid)
+	if n == nil || !n.wireguard() {
+		return nil, false
+	}
+
+	var peer PeerRecord
+	if err := proto.Unmarshal(value, &peer); err != nil {
+		logrus.Errorf("UpdateTableEntry: failed to unmarshal peer record for key %s: %v", key, err)
+		return nil, false
+	}
+
+	publicKey := d.wg.publicKey().String()
+	if peer.TunnelPublicKey == publicKey {
+		return nil, false
+	}
+	peer.TunnelPublicKey = publicKey
+
+	buf, err := proto.Marshal(&peer)
+	if err != nil {
+		logrus.Errorf("UpdateTableEntry: failed to marshal peer record for key %s: %v", key, err)
+		return nil, false
+	}
+	return buf, true
+}
@@ -0,0 +1,128 @@
+package overlay
+
+import (
+	"testing"
+
+	"github.com/docker/libnetwork/testutils"
+	"github.com/gogo/protobuf/proto"
+	"github.com/vishvananda/netlink"
+)
+
+func TestWireGuardKeys(t *testing.T) {
+	k1, err := newWgPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	k2, err := newWgPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k1 == k2 || k1.publicKey() == k2.publicKey() {
+		t.Fatal("Expected distinct key pairs")
+	}
+
+	pub, err := parseWgKey(k1.publicKey().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pub != k1.publicKey() {
+		t.Fatalf("Unexpected parsed key %s", pub)
+	}
+	if _, err := parseWgKey("c2hvcnQ="); err == nil {
+		t.Fatal("Expected an error parsing a short key")
+	}
+
+	w := &wgState{peers: map[string]*wgPeer{}}
+	primary := &key{value: []byte("primary"), tag: 1}
+	if err := w.setKeys(primary, false); err != nil {
+		t.Fatal(err)
+	}
+	pub1 := w.publicKey()
+	if err := w.setKeys(primary, false); err != nil {
+		t.Fatal(err)
+	}
+	if w.publicKey() != pub1 {
+		t.Fatal("Expected the key pair to be kept without rotation")
+	}
+	if err := w.setKeys(&key{value: []byte("rotated"), tag: 2}, true); err != nil {
+		t.Fatal(err)
+	}
+	if w.publicKey() == pub1 {
+		t.Fatal("Expected a new key pair on rotation")
+	}
+}
+
+func TestWireGuardNetworkValue(t *testing.T) {
+	n := &network{id: "net1", secure: true, encryption: encryptionWireGuard, mtu: 1450}
+	if !n.wireguard() {
+		t.Fatal("Expected a wireguard network")
+	}
+	if mtu := n.maxMTU(); mtu != 1450-wgOverhead-vxlanEncap {
+		t.Fatalf("Unexpected wireguard MTU %d", mtu)
+	}
+	if mtu := n.wgMTU(); mtu != 1450-wgOverhead {
+		t.Fatalf("Unexpected wireguard interface MTU %d", mtu)
+	}
+
+	restored := &network{}
+	if err := restored.SetValue(n.Value()); err != nil {
+		t.Fatal(err)
+	}
+	if !restored.wireguard() {
+		t.Fatalf("Unexpected restored encryption %q", restored.encryption)
+	}
+
+	if (&network{secure: true}).wireguard() {
+		t.Fatal("Expected the encrypted networks to default to IPsec")
+	}
+}
+
+func TestWireGuardUpdateTableEntry(t *testing.T) {
+	d := &driver{
+		networks: networkTable{
+			"net1": {id: "net1", secure: true, encryption: encryptionWireGuard},
+			"net2": {id: "net2", secure: true},
+		},
+		wg: &wgState{peers: map[string]*wgPeer{}},
+	}
+	value, err := proto.Marshal(&PeerRecord{
+		EndpointIP:       "10.0.0.2/24",
+		EndpointMAC:      "02:42:0a:00:00:02",
+		TunnelEndpointIP: "192.168.1.1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := d.UpdateTableEntry("net2", "ep1", ovPeerTable, "ep1", value); ok {
+		t.Fatal("Expected no update for an IPsec network")
+	}
+
+	buf, ok := d.UpdateTableEntry("net1", "ep1", ovPeerTable, "ep1", value)
+	if !ok {
+		t.Fatal("Expected an update for a wireguard network")
+	}
+	var peer PeerRecord
+	if err := proto.Unmarshal(buf, &peer); err != nil {
+		t.Fatal(err)
+	}
+	if peer.TunnelPublicKey != d.wg.publicKey().String() || peer.EndpointIP != "10.0.0.2/24" {
+		t.Fatalf("Unexpected updated peer record %v", peer)
+	}
+
+	if _, ok := d.UpdateTableEntry("net1", "ep1", ovPeerTable, "ep1", buf); ok {
+		t.Fatal("Expected no update when the public key is current")
+	}
+}
+
+func TestWireGuardRule(t *testing.T) {
+	defer testutils.SetupTestOSContext(t)()
+
+	for _, family := range []int{netlink.FAMILY_V4, netlink.FAMILY_V6} {
+		for _, add := range []bool{true, true, false, false} {
+			if err := programWireGuardRule(family, add); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
@@ -69,6 +69,8 @@ type endpoint struct {
 	svcID             string
 	svcName           string
 	virtualIP         net.IP
+	virtualIPv6       net.IP
+	lbMode            string
 	svcAliases        []string
 	ingressPorts      []*PortConfig
 	dbIndex           uint64
@@ -99,6 +101,12 @@ func (ep *endpoint) MarshalJSON() ([]byte, error) {
 	epMap["svcName"] = ep.svcName
 	epMap["svcID"] = ep.svcID
 	epMap["virtualIP"] = ep.virtualIP.String()
+	if ep.virtualIPv6 != nil {
+		epMap["virtualIPv6"] = ep.virtualIPv6.String()
+	}
+	if ep.lbMode != "" {
+		epMap["lbMode"] = ep.lbMode
+	}
 	epMap["ingressPorts"] = ep.ingressPorts
 	epMap["svcAliases"] = ep.svcAliases
 	epMap["loadBalancer"] = ep.loadBalancer
@@ -202,6 +210,14 @@ func (ep *endpoint) UnmarshalJSON(b []byte) (err error) {
 		ep.virtualIP = net.ParseIP(vip.(string))
 	}
 
+	if vip, ok := epMap["virtualIPv6"]; ok {
+		ep.virtualIPv6 = net.ParseIP(vip.(string))
+	}
+
+	if m, ok := epMap["lbMode"]; ok {
+		ep.lbMode = m.(string)
+	}
+
 	if v, ok := epMap["loadBalancer"]; ok {
 		ep.loadBalancer = v.(bool)
 	}
@@ -243,6 +259,8 @@ func (ep *endpoint) CopyTo(o datastore.KVObject) error {
 	dstEp.svcName = ep.svcName
 	dstEp.svcID = ep.svcID
 	dstEp.virtualIP = ep.virtualIP
+	dstEp.virtualIPv6 = ep.virtualIPv6
+	dstEp.lbMode = ep.lbMode
 	dstEp.loadBalancer = ep.loadBalancer
 
 	dstEp.svcAliases = make([]string, len(ep.svcAliases))
@@ -575,8 +593,8 @@ func (ep *endpoint) sbJoin(sb *sandbox, options ...EndpointOption) (err error) {
 			logrus.Debugf("Programming external connectivity on endpoint %s (%s)", ep.Name(), ep.ID())
 			if err = d.ProgramExternalConnectivity(n.ID(), ep.ID(), sb.Labels()); err != nil {
 				return types.InternalErrorf(
-					"driver failed programming external connectivity on endpoint %s (%s): %v",
-					ep.Name(), ep.ID(), err)
+					"driver failed programming external connectivity on endpoint %s (%s): %v%s",
+					ep.Name(), ep.ID(), err, n.getController().describePortOwner(err))
 			}
 		}
 
@@ -1019,6 +1037,25 @@ func CreateOptionService(name, id string, vip net.IP, ingressPorts []*PortConfig
 	}
 }
 
+// CreateOptionServiceLBMode function returns an option setter for the mode forwarding the
+// virtual IP traffic to the service tasks, one of LBModeNAT, LBModeTunnel or LBModeDirectRoute.
+// An empty mode means LBModeNAT.
+func CreateOptionServiceLBMode(mode string) EndpointOption {
+	return func(ep *endpoint) {
+		ep.lbMode = mode
+	}
+}
+
+// CreateOptionServiceVIPv6 function returns an option setter for the IPv6
+// virtual IP of the service binding configuration. The address is expected
+// to be allocated from one of the network's IPv6 pools, as the IPv4 virtual
+// IP is allocated from its IPv4 pools.
+func CreateOptionServiceVIPv6(vip net.IP) EndpointOption {
+	return func(ep *endpoint) {
+		ep.virtualIPv6 = vip
+	}
+}
+
 // CreateOptionMyAlias function returns an option setter for setting endpoint's self alias
 func CreateOptionMyAlias(alias string) EndpointOption {
 	return func(ep *endpoint) {
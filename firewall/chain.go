@@ -0,0 +1,199 @@
+package firewall
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+// Chain defines a chain of a backend.
+type Chain struct {
+	Name        string
+	Table       Table
+	HairpinMode bool
+	Backend     Backend
+}
+
+// NewChain adds a new chain to the backend.
+func NewChain(b Backend, name string, table Table, hairpinMode bool) (*Chain, error) {
+	c := &Chain{
+		Name:        name,
+		Table:       table,
+		HairpinMode: hairpinMode,
+		Backend:     b,
+	}
+	if string(c.Table) == "" {
+		c.Table = Filter
+	}
+	if err := b.NewChain(c.Table, c.Name); err != nil {
+		return nil, fmt.Errorf("Could not create %s/%s chain: %v", c.Table, c.Name, err)
+	}
+	return c, nil
+}
+
+// loopbackNet returns the loopback network of the family of the backend.
+func (c *Chain) loopbackNet() string {
+	if c.Backend.Family() == IPv6 {
+		return "::1/128"
+	}
+	return "127.0.0.0/8"
+}
+
+// ProgramRules returns the rules linking the chain to the built-in chains.
+func (c *Chain) ProgramRules(bridgeName string, hairpinMode bool) []ChainRule {
+	switch c.Table {
+	case Nat:
+		output := Rule{DstType: "LOCAL", Jump: c.Name}
+		if !hairpinMode {
+			output.Dst, output.NotDst = c.loopbackNet(), true
+		}
+		return []ChainRule{
+			{Table: Nat, Chain: "PREROUTING", Rule: Rule{DstType: "LOCAL", Jump: c.Name}},
+			{Table: Nat, Chain: "OUTPUT", Rule: output},
+		}
+	case Filter:
+		return []ChainRule{
+			{Table: Filter, Chain: "FORWARD", Rule: Rule{OutIface: bridgeName, Jump: c.Name}},
+			{Table: Filter, Chain: "FORWARD", Rule: Rule{OutIface: bridgeName, CtState: []string{"RELATED", "ESTABLISHED"}, Jump: "ACCEPT"}},
+		}
+	}
+	return nil
+}
+
+// Program adds the rules linking the chain to the built-in chains, or
+// removes them. The rules are applied in a single transaction.
+func (c *Chain) Program(bridgeName string, hairpinMode, enable bool) error {
+	if c.Name == "" {
+		return errors.New("Could not program chain, missing chain name")
+	}
+	if c.Table == Filter && bridgeName == "" {
+		return fmt.Errorf("Could not program chain %s/%s, missing bridge name",
+			c.Table, c.Name)
+	}
+
+	action := Append
+	switch {
+	case !enable:
+		action = Delete
+	case c.Table == Filter:
+		action = Insert
+	}
+
+	tx := c.Backend.NewTransaction()
+	for _, r := range c.ProgramRules(bridgeName, hairpinMode) {
+		tx.ProgramRule(r.Table, r.Chain, action, r.Rule)
+	}
+	if err := tx.Commit(); err != nil {
+		if c.Table == Nat {
+			return fmt.Errorf("Failed to program %s in PREROUTING and OUTPUT chains: %s", c.Name, err)
+		}
+		return fmt.Errorf("Could not program linking and establish rules of %s/%s: %s", c.Table, c.Name, err)
+	}
+	return nil
+}
+
+// ForwardRules returns the nat and filter rules forwarding the port to the
+// destination.
+func (c *Chain) ForwardRules(ip net.IP, port int, proto, destAddr string, destPort int, bridgeName string) []ChainRule {
+	var daddr string
+	if !ip.IsUnspecified() {
+		daddr = ip.String()
+	}
+
+	dnat := Rule{
+		Proto:         proto,
+		Dst:           daddr,
+		DstPort:       port,
+		Jump:          "DNAT",
+		ToDestination: net.JoinHostPort(destAddr, strconv.Itoa(destPort)),
+	}
+	if !c.HairpinMode {
+		dnat.InIface, dnat.NotInIface = bridgeName, true
+	}
+
+	return []ChainRule{
+		{Table: Nat, Chain: c.Name, Rule: dnat},
+		{Table: Filter, Chain: c.Name, Rule: Rule{
+			InIface:    bridgeName,
+			NotInIface: true,
+			OutIface:   bridgeName,
+			Proto:      proto,
+			Dst:        destAddr,
+			DstPort:    destPort,
+			Jump:       "ACCEPT",
+		}},
+		{Table: Nat, Chain: "POSTROUTING", Rule: Rule{
+			Proto:   proto,
+			Src:     destAddr,
+			Dst:     destAddr,
+			DstPort: destPort,
+			Jump:    "MASQUERADE",
+		}},
+	}
+}
+
+// Forward adds forwarding rule to 'filter' table and corresponding nat rule to 'nat' table.
+func (c *Chain) Forward(action Action, ip net.IP, port int, proto, destAddr string, destPort int, bridgeName string) error {
+	tx := c.Backend.NewTransaction()
+	for _, r := range c.ForwardRules(ip, port, proto, destAddr, destPort, bridgeName) {
+		tx.ProgramRule(r.Table, r.Chain, action, r.Rule)
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if proto == "sctp" {
+		// Linux kernel v4.9 and below enables NETIF_F_SCTP_CRC for veth by
+		// the following commit.
+		// This introduces a problem when conbined with a physical NIC without
+		// NETIF_F_SCTP_CRC. As for a workaround, here we add an iptables entry
+		// to fill the checksum.
+		//
+		// https://github.com/torvalds/linux/commit/c80fafbbb59ef9924962f83aac85531039395b18
+		rule := Rule{Proto: proto, SrcPort: destPort, Jump: "CHECKSUM"}
+		if err := c.Backend.ProgramRule(Mangle, "POSTROUTING", action, rule); err != nil {
+			if _, ok := err.(types.NotImplementedError); !ok {
+				return err
+			}
+			logrus.Debugf("Skipping the SCTP checksum rule: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// Link adds reciprocal ACCEPT rule for two supplied IP addresses.
+// Traffic is allowed from ip1 to ip2 and vice-versa
+func (c *Chain) Link(action Action, ip1, ip2 net.IP, port int, proto string, bridgeName string) error {
+	tx := c.Backend.NewTransaction()
+	// forward
+	tx.ProgramRule(Filter, c.Name, action, Rule{
+		InIface:  bridgeName,
+		OutIface: bridgeName,
+		Proto:    proto,
+		Src:      ip1.String(),
+		Dst:      ip2.String(),
+		DstPort:  port,
+		Jump:     "ACCEPT",
+	})
+	// reverse
+	tx.ProgramRule(Filter, c.Name, action, Rule{
+		InIface:  bridgeName,
+		OutIface: bridgeName,
+		Proto:    proto,
+		Src:      ip2.String(),
+		Dst:      ip1.String(),
+		SrcPort:  port,
+		Jump:     "ACCEPT",
+	})
+	return tx.Commit()
+}
+
+// Remove removes the chain.
+func (c *Chain) Remove() error {
+	return c.Backend.RemoveChain(c.Table, c.Name)
+}
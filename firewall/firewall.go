@@ -0,0 +1,188 @@
+// Package firewall programs the host firewall rules of libnetwork through a
+// backend. The iptables backend invokes iptables and ip6tables, the nftables
+// backend manages its own libnetwork tables with nft.
+package firewall
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Table refers to Nat, Filter or Mangle.
+type Table string
+
+// Action signifies the rule action.
+type Action string
+
+// Policy is the default policy of a built-in chain.
+type Policy string
+
+// Family is the IP version of a backend.
+type Family string
+
+const (
+	// Nat table is used for nat translation rules.
+	Nat Table = "nat"
+	// Filter table is used for filter rules.
+	Filter Table = "filter"
+	// Mangle table is used for mangling the packet.
+	Mangle Table = "mangle"
+	// Append appends the rule at the end of the chain.
+	Append Action = "-A"
+	// Insert inserts the rule at the top of the chain.
+	Insert Action = "-I"
+	// Delete deletes the rule from the chain.
+	Delete Action = "-D"
+	// Drop is the DROP default policy.
+	Drop Policy = "DROP"
+	// Accept is the ACCEPT default policy.
+	Accept Policy = "ACCEPT"
+	// IPv4 is the family of the IPv4 rules.
+	IPv4 Family = "ipv4"
+	// IPv6 is the family of the IPv6 rules.
+	IPv6 Family = "ipv6"
+)
+
+const (
+	// IPTablesBackend is the name of the iptables backend.
+	IPTablesBackend = "iptables"
+	// NFTablesBackend is the name of the nftables backend.
+	NFTablesBackend = "nftables"
+
+	// BackendEnv is the environment variable passing the name of the
+	// backend to the reexec'ed processes programming the rules of the
+	// container namespaces.
+	BackendEnv = "LIBNETWORK_FIREWALL_BACKEND"
+)
+
+// Backend programs the firewall rules of one IP family. The built-in chains
+// are the iptables ones, PREROUTING, INPUT, FORWARD, OUTPUT and POSTROUTING.
+type Backend interface {
+	// Name returns the name of the backend.
+	Name() string
+	// Family returns the IP family of the rules of the backend.
+	Family() Family
+	// Native returns the backend programming the rules directly, without
+	// going through the firewall service of the host, for the rules of the
+	// container namespaces.
+	Native() Backend
+	// NewChain creates the chain if it does not exist.
+	NewChain(table Table, name string) error
+	// RemoveChain removes the chain and the rules jumping to it.
+	RemoveChain(table Table, name string) error
+	// FlushChain removes all the rules of the chain.
+	FlushChain(table Table, name string) error
+	// ExistChain tells whether the chain exists.
+	ExistChain(table Table, name string) bool
+	// Targets returns the targets of the rules of the chain, in order. The
+	// target of a rule which neither jumps to a chain nor ends with a
+	// verdict may be empty.
+	Targets(table Table, chain string) ([]string, error)
+	// Exists tells whether the rule is in the chain.
+	Exists(table Table, chain string, rule Rule) bool
+	// Apply inserts, appends or deletes the rule unconditionally.
+	Apply(table Table, chain string, action Action, rule Rule) error
+	// ProgramRule adds the rule only if it is not already present in the
+	// chain. Reciprocally, it removes the rule only if present.
+	ProgramRule(table Table, chain string, action Action, rule Rule) error
+	// NewTransaction returns an empty transaction.
+	NewTransaction() Transaction
+	// SetDefaultPolicy sets the default policy of the built-in chain.
+	SetDefaultPolicy(table Table, chain string, policy Policy) error
+	// OnReloaded registers a callback to reprogram the rules after the
+	// firewall service of the host flushed them.
+	OnReloaded(callback func())
+}
+
+// Transaction collects rule changes and applies them at once. On failure the
+// changes already applied are reverted.
+type Transaction interface {
+	// Add adds the action on the rule to the transaction.
+	Add(table Table, chain string, action Action, rule Rule)
+	// ProgramRule adds the insertion of the rule to the transaction only if
+	// it is not present once the rules already in the transaction are
+	// applied. Reciprocally, it adds its deletion only if present.
+	ProgramRule(table Table, chain string, action Action, rule Rule)
+	// Len returns the number of rule changes in the transaction.
+	Len() int
+	// Commit applies the transaction and empties it.
+	Commit() error
+}
+
+var (
+	mu          sync.Mutex
+	backendName = IPTablesBackend
+)
+
+// SetBackend selects the backend returned by Get.
+func SetBackend(name string) error {
+	switch name {
+	case "":
+		name = IPTablesBackend
+	case IPTablesBackend, NFTablesBackend:
+	default:
+		return fmt.Errorf("unknown firewall backend %q", name)
+	}
+	mu.Lock()
+	backendName = name
+	mu.Unlock()
+	return nil
+}
+
+// SetBackendFromEnv selects the backend passed by BackendEnv, if any.
+func SetBackendFromEnv() error {
+	if name := os.Getenv(BackendEnv); name != "" {
+		return SetBackend(name)
+	}
+	return nil
+}
+
+// ReexecEnv returns the environment of a reexec'ed process, passing it the
+// selected backend.
+func ReexecEnv() []string {
+	return append(os.Environ(), BackendEnv+"="+BackendName())
+}
+
+// BackendName returns the name of the selected backend.
+func BackendName() string {
+	mu.Lock()
+	defer mu.Unlock()
+	return backendName
+}
+
+// Get returns the selected backend for the family.
+func Get(family Family) Backend {
+	if BackendName() == NFTablesBackend {
+		return newNFTables(family)
+	}
+	return newIPTables(family)
+}
+
+// IPTables returns the iptables backend for the family, whichever backend is
+// selected, for the rules nftables cannot express.
+func IPTables(family Family) Backend {
+	return newIPTables(family)
+}
+
+// AddReturnRule adds a return rule at the end of the chain in the filter
+// table.
+func AddReturnRule(b Backend, chain string) error {
+	if err := b.ProgramRule(Filter, chain, Append, Rule{Jump: "RETURN"}); err != nil {
+		return fmt.Errorf("unable to add return rule in %s chain: %v", chain, err)
+	}
+	return nil
+}
+
+// EnsureJumpRule ensures the jump rule from a filter table chain to another
+// one is on top.
+func EnsureJumpRule(b Backend, fromChain, toChain string) error {
+	rule := Rule{Jump: toChain}
+	tx := b.NewTransaction()
+	tx.ProgramRule(Filter, fromChain, Delete, rule)
+	tx.Add(Filter, fromChain, Insert, rule)
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("unable to insert jump to %s rule in %s chain: %v", toChain, fromChain, err)
+	}
+	return nil
+}
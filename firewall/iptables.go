@@ -0,0 +1,138 @@
+package firewall
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+
+	"github.com/docker/libnetwork/iptables"
+)
+
+// ipTables is the backend programming the rules with iptables, or ip6tables,
+// through firewalld when it is running.
+type ipTables struct {
+	family  Family
+	iptable iptables.IPTable
+	native  bool
+}
+
+func newIPTables(family Family) *ipTables {
+	version := iptables.Iptables
+	if family == IPv6 {
+		version = iptables.IP6Tables
+	}
+	return &ipTables{family: family, iptable: iptables.GetIptable(version)}
+}
+
+func (b *ipTables) Name() string {
+	return IPTablesBackend
+}
+
+func (b *ipTables) Family() Family {
+	return b.family
+}
+
+func (b *ipTables) Native() Backend {
+	native := *b
+	native.native = true
+	return &native
+}
+
+func (b *ipTables) NewChain(table Table, name string) error {
+	_, err := b.iptable.NewChain(name, iptables.Table(table), false)
+	return err
+}
+
+func (b *ipTables) RemoveChain(table Table, name string) error {
+	return b.iptable.RemoveExistingChain(name, iptables.Table(table))
+}
+
+func (b *ipTables) FlushChain(table Table, name string) error {
+	return b.raw("-t", string(table), "-F", name)
+}
+
+func (b *ipTables) ExistChain(table Table, name string) bool {
+	return b.iptable.ExistChain(name, iptables.Table(table))
+}
+
+func (b *ipTables) Targets(table Table, chain string) ([]string, error) {
+	output, err := b.iptable.Raw("-t", string(table), "-S", chain)
+	if err != nil {
+		return nil, err
+	}
+	var targets []string
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || fields[0] != "-A" {
+			continue
+		}
+		var target string
+		for i := 0; i < len(fields)-1; i++ {
+			if fields[i] == "-j" || fields[i] == "-g" {
+				target = fields[i+1]
+				break
+			}
+		}
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
+
+func (b *ipTables) Exists(table Table, chain string, rule Rule) bool {
+	if b.native {
+		return b.iptable.ExistsNative(iptables.Table(table), chain, rule.iptablesArgs(b.family)...)
+	}
+	return b.iptable.Exists(iptables.Table(table), chain, rule.iptablesArgs(b.family)...)
+}
+
+func (b *ipTables) Apply(table Table, chain string, action Action, rule Rule) error {
+	return b.raw(append([]string{"-t", string(table), string(action), chain}, rule.iptablesArgs(b.family)...)...)
+}
+
+func (b *ipTables) ProgramRule(table Table, chain string, action Action, rule Rule) error {
+	if b.Exists(table, chain, rule) != (action == Delete) {
+		return nil
+	}
+	return b.Apply(table, chain, action, rule)
+}
+
+func (b *ipTables) NewTransaction() Transaction {
+	return &ipTablesTransaction{family: b.family, tx: b.iptable.NewTransaction()}
+}
+
+func (b *ipTables) SetDefaultPolicy(table Table, chain string, policy Policy) error {
+	return b.iptable.SetDefaultPolicy(iptables.Table(table), chain, iptables.Policy(policy))
+}
+
+func (b *ipTables) OnReloaded(callback func()) {
+	iptables.OnReloaded(callback)
+}
+
+func (b *ipTables) raw(args ...string) error {
+	if b.native {
+		return b.iptable.RawCombinedOutputNative(args...)
+	}
+	return b.iptable.RawCombinedOutput(args...)
+}
+
+type ipTablesTransaction struct {
+	family Family
+	tx     *iptables.Transaction
+}
+
+func (t *ipTablesTransaction) Add(table Table, chain string, action Action, rule Rule) {
+	t.tx.Add(iptables.Table(table), chain, iptables.Action(action), rule.iptablesArgs(t.family)...)
+}
+
+func (t *ipTablesTransaction) ProgramRule(table Table, chain string, action Action, rule Rule) {
+	t.tx.ProgramRule(iptables.Table(table), chain, iptables.Action(action), rule.iptablesArgs(t.family))
+}
+
+func (t *ipTablesTransaction) Len() int {
+	return t.tx.Len()
+}
+
+func (t *ipTablesTransaction) Commit() error {
+	return t.tx.Commit()
+}
@@ -0,0 +1,412 @@
+package firewall
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// nftTable is the name of the nftables tables of libnetwork, one per family.
+const nftTable = "libnetwork"
+
+// nftRulePrefix prefixes the comment identifying the rules of libnetwork.
+const nftRulePrefix = "lnet:"
+
+var (
+	nftPath     string
+	nftInitOnce sync.Once
+	// ErrNftNotFound is returned when the nft binary is not found.
+	ErrNftNotFound = errors.New("nft not found")
+
+	nftRuleRe  = regexp.MustCompile(`comment "(` + nftRulePrefix + `[0-9a-f]+)".*# handle ([0-9]+)`)
+	nftChainRe = regexp.MustCompile(`^\s*chain (\S+) \{`)
+)
+
+// nftBaseChain describes the base chain of a built-in chain.
+type nftBaseChain struct {
+	table    Table
+	name     string
+	typ      string
+	hook     string
+	priority int
+}
+
+// The base chains use the priorities of the iptables tables, to keep the
+// same ordering with the other rulesets of the host.
+var nftBaseChains = []nftBaseChain{
+	{Nat, "PREROUTING", "nat", "prerouting", -100},
+	{Nat, "OUTPUT", "nat", "output", -100},
+	{Nat, "POSTROUTING", "nat", "postrouting", 100},
+	{Filter, "INPUT", "filter", "input", 0},
+	{Filter, "FORWARD", "filter", "forward", 0},
+	{Filter, "OUTPUT", "filter", "output", 0},
+	{Mangle, "PREROUTING", "filter", "prerouting", -150},
+	{Mangle, "OUTPUT", "route", "output", -150},
+	{Mangle, "POSTROUTING", "filter", "postrouting", -150},
+}
+
+// nfTables is the backend programming the rules in the libnetwork table of
+// the family with nft. The chains of all the iptables tables live in that
+// table, their names prefixed by the name of their iptables table.
+//
+// The other tables of the host, as the ones of firewalld or of iptables-nft,
+// see the packets on the same hooks. An ACCEPT rule of the libnetwork table
+// only ends the evaluation of that table: a DROP verdict of another table
+// still drops the packet.
+type nfTables struct {
+	family Family
+}
+
+func newNFTables(family Family) *nfTables {
+	return &nfTables{family: family}
+}
+
+// nftChainName returns the name of the chain of the iptables table in the
+// libnetwork table.
+func nftChainName(table Table, chain string) string {
+	if string(table) == "" {
+		table = Filter
+	}
+	return string(table) + "-" + chain
+}
+
+// nftRuleID returns the comment identifying the rule in its chain.
+func nftRuleID(expr string) string {
+	h := fnv.New64a()
+	h.Write([]byte(expr))
+	return fmt.Sprintf("%s%016x", nftRulePrefix, h.Sum64())
+}
+
+func (b *nfTables) Name() string {
+	return NFTablesBackend
+}
+
+func (b *nfTables) Family() Family {
+	return b.family
+}
+
+// Native returns the backend itself, the nftables rules are always
+// programmed directly.
+func (b *nfTables) Native() Backend {
+	return b
+}
+
+func (b *nfTables) nftFamily() string {
+	if b.family == IPv6 {
+		return "ip6"
+	}
+	return "ip"
+}
+
+func (b *nfTables) chainRef(table Table, chain string) string {
+	return fmt.Sprintf("%s %s %s", b.nftFamily(), nftTable, nftChainName(table, chain))
+}
+
+// header returns the commands creating the table and its base chains. They
+// are part of every batch so the rules survive a flush of the ruleset.
+func (b *nfTables) header() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "add table %s %s\n", b.nftFamily(), nftTable)
+	for _, c := range nftBaseChains {
+		fmt.Fprintf(&buf, "add chain %s { type %s hook %s priority %d; }\n", b.chainRef(c.table, c.name), c.typ, c.hook, c.priority)
+	}
+	return buf.String()
+}
+
+func isBaseChain(table Table, chain string) bool {
+	for _, c := range nftBaseChains {
+		if c.table == table && c.name == chain {
+			return true
+		}
+	}
+	return false
+}
+
+func lookupNft() string {
+	nftInitOnce.Do(func() {
+		nftPath, _ = exec.LookPath("nft")
+	})
+	return nftPath
+}
+
+func (b *nfTables) run(args ...string) ([]byte, error) {
+	if lookupNft() == "" {
+		return nil, ErrNftNotFound
+	}
+	output, err := exec.Command(nftPath, args...).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("nft %s failed: %s (%v)", strings.Join(args, " "), strings.TrimSpace(string(output)), err)
+	}
+	return output, nil
+}
+
+// apply runs the commands in a single atomic nft batch.
+func (b *nfTables) apply(commands string) error {
+	if lookupNft() == "" {
+		return ErrNftNotFound
+	}
+	input := b.header() + commands
+	logrus.Debugf("%s -f -:\n%s", nftPath, input)
+
+	cmd := exec.Command(nftPath, "-f", "-")
+	cmd.Stdin = strings.NewReader(input)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("nft failed: %s (%v)", strings.TrimSpace(string(output)), err)
+	}
+	return nil
+}
+
+// handles returns the handles of the libnetwork rules of the chain, by rule
+// identifier.
+func (b *nfTables) handles(table Table, chain string) (map[string][]string, error) {
+	output, err := b.run("-a", "list", "chain", b.nftFamily(), nftTable, nftChainName(table, chain))
+	if err != nil {
+		return nil, err
+	}
+	handles := map[string][]string{}
+	for _, m := range nftRuleRe.FindAllStringSubmatch(string(output), -1) {
+		handles[m[1]] = append(handles[m[1]], m[2])
+	}
+	return handles, nil
+}
+
+// command returns the nft command of the action on the rule. The handles of
+// the chain are needed by the deletions.
+func (b *nfTables) command(table Table, chain string, action Action, rule Rule, handles map[string][]string) (string, error) {
+	expr, err := rule.nftExpr(b.family, table)
+	if err != nil {
+		return "", err
+	}
+	id := nftRuleID(expr)
+	switch action {
+	case Insert:
+		return fmt.Sprintf("insert rule %s %s comment %q\n", b.chainRef(table, chain), expr, id), nil
+	case Append:
+		return fmt.Sprintf("add rule %s %s comment %q\n", b.chainRef(table, chain), expr, id), nil
+	case Delete:
+		h := handles[id]
+		if len(h) == 0 {
+			return "", fmt.Errorf("rule %q not found in chain %s", expr, nftChainName(table, chain))
+		}
+		handles[id] = h[1:]
+		return fmt.Sprintf("delete rule %s handle %s\n", b.chainRef(table, chain), h[0]), nil
+	}
+	return "", fmt.Errorf("invalid action %q", action)
+}
+
+func (b *nfTables) NewChain(table Table, name string) error {
+	return b.apply(fmt.Sprintf("add chain %s\n", b.chainRef(table, name)))
+}
+
+func (b *nfTables) RemoveChain(table Table, name string) error {
+	output, err := b.run("-a", "list", "table", b.nftFamily(), nftTable)
+	if err != nil {
+		// The table was never set up
+		return nil
+	}
+
+	var (
+		commands bytes.Buffer
+		found    bool
+		current  string
+		target   = nftChainName(table, name)
+		jump     = "jump " + target + " "
+	)
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if m := nftChainRe.FindStringSubmatch(line); m != nil {
+			current = m[1]
+			found = found || current == target
+			continue
+		}
+		if m := nftRuleRe.FindStringSubmatch(line); m != nil && strings.Contains(line, jump) {
+			fmt.Fprintf(&commands, "delete rule %s %s %s handle %s\n", b.nftFamily(), nftTable, current, m[2])
+		}
+	}
+	if !found {
+		return nil
+	}
+	fmt.Fprintf(&commands, "flush chain %s\n", b.chainRef(table, name))
+	if !isBaseChain(table, name) {
+		fmt.Fprintf(&commands, "delete chain %s\n", b.chainRef(table, name))
+	}
+	return b.apply(commands.String())
+}
+
+func (b *nfTables) FlushChain(table Table, name string) error {
+	return b.apply(fmt.Sprintf("flush chain %s\n", b.chainRef(table, name)))
+}
+
+func (b *nfTables) ExistChain(table Table, name string) bool {
+	_, err := b.run("list", "chain", b.nftFamily(), nftTable, nftChainName(table, name))
+	return err == nil
+}
+
+func (b *nfTables) Targets(table Table, chain string) ([]string, error) {
+	output, err := b.run("list", "chain", b.nftFamily(), nftTable, nftChainName(table, chain))
+	if err != nil {
+		return nil, err
+	}
+	var targets []string
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || nftChainRe.MatchString(scanner.Text()) {
+			continue
+		}
+		switch fields[0] {
+		case "table", "type", "policy", "}":
+			continue
+		}
+		targets = append(targets, nftTarget(table, fields))
+	}
+	return targets, nil
+}
+
+// nftTarget returns the iptables target of the fields of an nft rule, the
+// chain it jumps to or its verdict.
+func nftTarget(table Table, fields []string) string {
+	for i, f := range fields {
+		switch f {
+		case "jump", "goto":
+			if i+1 < len(fields) {
+				return strings.TrimPrefix(fields[i+1], string(table)+"-")
+			}
+		case "accept", "drop", "return", "masquerade", "dnat", "snat", "redirect":
+			return strings.ToUpper(f)
+		case "comment":
+			return ""
+		}
+	}
+	return ""
+}
+
+func (b *nfTables) Exists(table Table, chain string, rule Rule) bool {
+	expr, err := rule.nftExpr(b.family, table)
+	if err != nil {
+		return false
+	}
+	handles, err := b.handles(table, chain)
+	if err != nil {
+		return false
+	}
+	return len(handles[nftRuleID(expr)]) > 0
+}
+
+func (b *nfTables) Apply(table Table, chain string, action Action, rule Rule) error {
+	tx := b.NewTransaction()
+	tx.Add(table, chain, action, rule)
+	return tx.Commit()
+}
+
+func (b *nfTables) ProgramRule(table Table, chain string, action Action, rule Rule) error {
+	tx := b.NewTransaction()
+	tx.ProgramRule(table, chain, action, rule)
+	return tx.Commit()
+}
+
+func (b *nfTables) NewTransaction() Transaction {
+	return &nfTablesTransaction{backend: b}
+}
+
+func (b *nfTables) SetDefaultPolicy(table Table, chain string, policy Policy) error {
+	for _, c := range nftBaseChains {
+		if c.table == table && c.name == chain {
+			return b.apply(fmt.Sprintf("add chain %s { type %s hook %s priority %d; policy %s; }\n",
+				b.chainRef(c.table, c.name), c.typ, c.hook, c.priority, strings.ToLower(string(policy))))
+		}
+	}
+	return fmt.Errorf("setting default policy to %v in %v chain failed: not a built-in chain", policy, chain)
+}
+
+// OnReloaded does not register the callback: the libnetwork tables are not
+// flushed by the firewall service of the host.
+func (b *nfTables) OnReloaded(callback func()) {}
+
+type nftRuleChange struct {
+	table  Table
+	chain  string
+	action Action
+	rule   Rule
+}
+
+// nfTablesTransaction applies its rule changes in a single nft batch, which
+// nft applies atomically.
+type nfTablesTransaction struct {
+	backend *nfTables
+	changes []nftRuleChange
+}
+
+func (t *nfTablesTransaction) Add(table Table, chain string, action Action, rule Rule) {
+	if string(table) == "" {
+		table = Filter
+	}
+	t.changes = append(t.changes, nftRuleChange{table: table, chain: chain, action: action, rule: rule})
+}
+
+func (t *nfTablesTransaction) ProgramRule(table Table, chain string, action Action, rule Rule) {
+	if string(table) == "" {
+		table = Filter
+	}
+	for i := len(t.changes) - 1; i >= 0; i-- {
+		c := t.changes[i]
+		if c.table != table || c.chain != chain || c.rule.String() != rule.String() {
+			continue
+		}
+		switch {
+		case (c.action == Delete) == (action == Delete):
+		case action == Delete:
+			// The rule is added by the transaction, just drop it
+			t.changes = append(t.changes[:i], t.changes[i+1:]...)
+		default:
+			t.Add(table, chain, action, rule)
+		}
+		return
+	}
+	if t.backend.Exists(table, chain, rule) != (action == Delete) {
+		return
+	}
+	t.Add(table, chain, action, rule)
+}
+
+func (t *nfTablesTransaction) Len() int {
+	return len(t.changes)
+}
+
+func (t *nfTablesTransaction) Commit() error {
+	changes := t.changes
+	t.changes = nil
+	if len(changes) == 0 {
+		return nil
+	}
+
+	var (
+		commands bytes.Buffer
+		handles  = map[string]map[string][]string{}
+	)
+	for _, c := range changes {
+		ref := nftChainName(c.table, c.chain)
+		if c.action == Delete && handles[ref] == nil {
+			h, err := t.backend.handles(c.table, c.chain)
+			if err != nil {
+				return err
+			}
+			handles[ref] = h
+		}
+		cmd, err := t.backend.command(c.table, c.chain, c.action, c.rule, handles[ref])
+		if err != nil {
+			return err
+		}
+		commands.WriteString(cmd)
+	}
+	return t.backend.apply(commands.String())
+}
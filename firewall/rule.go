@@ -0,0 +1,295 @@
+package firewall
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/docker/libnetwork/types"
+)
+
+// Rule describes a firewall rule independently of the backend. The zero
+// value of a field does not match on it.
+type Rule struct {
+	// IPsecIn matches the packets received through an IPsec policy.
+	IPsecIn bool
+	// IPsecReqID limits IPsecIn to the security associations of the
+	// request ID.
+	IPsecReqID uint32
+
+	InIface     string
+	NotInIface  bool
+	OutIface    string
+	NotOutIface bool
+
+	// Proto is the transport protocol: tcp, udp, sctp, icmp or icmpv6.
+	Proto string
+
+	// Src and Dst are an address or a CIDR.
+	Src    string
+	NotSrc bool
+	Dst    string
+	NotDst bool
+
+	// SrcType and DstType match the address type, LOCAL for the local
+	// addresses.
+	SrcType string
+	DstType string
+
+	SrcPort int
+	DstPort int
+
+	// ICMPEcho matches the echo requests, Proto must be icmp or icmpv6.
+	ICMPEcho bool
+
+	// VNI matches the VXLAN packets of the network identifier, Proto and
+	// DstPort must be those of the VXLAN transport.
+	VNI uint32
+	// Family is the IP family String renders the rule for, IPv4 unless set.
+	// The backends render the rule for their own family.
+	Family Family
+
+	// CtState matches the connection tracking states, as RELATED and
+	// ESTABLISHED.
+	CtState []string
+
+	// IPVS matches the packets of the IPVS connections, IPVSMethod
+	// restricting them to a forwarding method, as MASQ.
+	IPVS       bool
+	IPVSMethod string
+
+	// Mark and MarkMask match the firewall mark of the packet.
+	Mark     uint32
+	MarkMask uint32
+	// ConnMark matches the mark of the connection.
+	ConnMark uint32
+
+	// Jump is the target: ACCEPT, DROP, RETURN, MASQUERADE, DNAT, SNAT,
+	// REDIRECT, MARK, CONNMARK, CHECKSUM or the name of a chain.
+	Jump          string
+	ToDestination string
+	ToSource      string
+	ToPort        int
+	SetMark       uint32
+	RestoreMark   bool
+}
+
+func (r Rule) String() string {
+	family := r.Family
+	if family == "" {
+		family = IPv4
+	}
+	return strings.Join(r.iptablesArgs(family), " ")
+}
+
+func not(negate bool) []string {
+	if negate {
+		return []string{"!"}
+	}
+	return nil
+}
+
+// iptablesArgs returns the iptables, or ip6tables, arguments of the rule, in
+// the order of the rules programmed with iptables by the former versions.
+func (r Rule) iptablesArgs(family Family) []string {
+	var args []string
+	if r.IPsecIn {
+		args = append(args, "-m", "policy", "--dir", "in", "--pol", "ipsec")
+		if r.IPsecReqID != 0 {
+			args = append(args, "--reqid", strconv.FormatUint(uint64(r.IPsecReqID), 10))
+		}
+	}
+	if r.InIface != "" {
+		args = append(append(args, not(r.NotInIface)...), "-i", r.InIface)
+	}
+	if r.OutIface != "" {
+		args = append(append(args, not(r.NotOutIface)...), "-o", r.OutIface)
+	}
+	if r.Proto != "" {
+		args = append(args, "-p", r.Proto)
+	}
+	if r.Src != "" {
+		args = append(append(args, not(r.NotSrc)...), "-s", r.Src)
+	}
+	if r.Dst != "" {
+		args = append(append(args, not(r.NotDst)...), "-d", r.Dst)
+	}
+	if r.SrcType != "" || r.DstType != "" {
+		args = append(args, "-m", "addrtype")
+		if r.SrcType != "" {
+			args = append(args, "--src-type", r.SrcType)
+		}
+		if r.DstType != "" {
+			args = append(args, "--dst-type", r.DstType)
+		}
+	}
+	if r.SrcPort != 0 {
+		args = append(args, "--sport", strconv.Itoa(r.SrcPort))
+	}
+	if r.DstPort != 0 {
+		args = append(args, "--dport", strconv.Itoa(r.DstPort))
+	}
+	if r.ICMPEcho {
+		if r.Proto == "icmpv6" {
+			args = append(args, "--icmpv6-type", "echo-request")
+		} else {
+			args = append(args, "--icmp-type", "echo-request")
+		}
+	}
+	if r.VNI != 0 {
+		// The VNI follows the UDP header, after the IPv4 header of variable
+		// length, or the IPv6 header of 40 bytes. The IPv6 offset assumes
+		// no extension header, the encapsulated traffic carries none.
+		offset := "0>>22&0x3C@12"
+		if family == IPv6 {
+			offset = "52"
+		}
+		args = append(args, "-m", "u32", "--u32", fmt.Sprintf("%s&0xFFFFFF00=%d", offset, int(r.VNI)<<8))
+	}
+	if len(r.CtState) > 0 {
+		args = append(args, "-m", "conntrack", "--ctstate", strings.Join(r.CtState, ","))
+	}
+	if r.IPVS {
+		args = append(args, "-m", "ipvs", "--ipvs")
+		if r.IPVSMethod != "" {
+			args = append(args, "--vmethod", r.IPVSMethod)
+		}
+	}
+	if r.Mark != 0 {
+		mark := strconv.FormatUint(uint64(r.Mark), 10)
+		if r.MarkMask != 0 {
+			mark += "/" + strconv.FormatUint(uint64(r.MarkMask), 10)
+		}
+		args = append(args, "-m", "mark", "--mark", mark)
+	}
+	if r.ConnMark != 0 {
+		args = append(args, "-m", "connmark", "--mark", strconv.FormatUint(uint64(r.ConnMark), 10))
+	}
+
+	args = append(args, "-j", r.Jump)
+	switch r.Jump {
+	case "DNAT":
+		args = append(args, "--to-destination", r.ToDestination)
+	case "SNAT":
+		args = append(args, "--to-source", r.ToSource)
+	case "REDIRECT":
+		args = append(args, "--to-port", strconv.Itoa(r.ToPort))
+	case "MARK":
+		args = append(args, "--set-mark", strconv.FormatUint(uint64(r.SetMark), 10))
+	case "CONNMARK":
+		if r.RestoreMark {
+			args = append(args, "--restore-mark")
+		} else {
+			args = append(args, "--set-mark", strconv.FormatUint(uint64(r.SetMark), 10))
+		}
+	case "CHECKSUM":
+		args = append(args, "--checksum-fill")
+	}
+	return args
+}
+
+func nftNot(negate bool) string {
+	if negate {
+		return "!= "
+	}
+	return ""
+}
+
+// nftExpr returns the nft expression of the rule in the table of the family.
+func (r Rule) nftExpr(family Family, table Table) (string, error) {
+	var (
+		expr []string
+		ip   = "ip"
+	)
+	if family == IPv6 {
+		ip = "ip6"
+	}
+
+	if r.IPsecIn && r.IPsecReqID != 0 {
+		expr = append(expr, fmt.Sprintf("ipsec in reqid %d", r.IPsecReqID))
+	} else if r.IPsecIn {
+		expr = append(expr, "meta secpath exists")
+	}
+	if r.InIface != "" {
+		expr = append(expr, fmt.Sprintf("iifname %s%q", nftNot(r.NotInIface), r.InIface))
+	}
+	if r.OutIface != "" {
+		expr = append(expr, fmt.Sprintf("oifname %s%q", nftNot(r.NotOutIface), r.OutIface))
+	}
+	if r.Src != "" {
+		expr = append(expr, fmt.Sprintf("%s saddr %s%s", ip, nftNot(r.NotSrc), r.Src))
+	}
+	if r.Dst != "" {
+		expr = append(expr, fmt.Sprintf("%s daddr %s%s", ip, nftNot(r.NotDst), r.Dst))
+	}
+	if r.SrcType != "" {
+		expr = append(expr, "fib saddr type "+strings.ToLower(r.SrcType))
+	}
+	if r.DstType != "" {
+		expr = append(expr, "fib daddr type "+strings.ToLower(r.DstType))
+	}
+	switch {
+	case r.ICMPEcho:
+		expr = append(expr, r.Proto+" type echo-request")
+	case r.SrcPort != 0 || r.DstPort != 0:
+		if r.SrcPort != 0 {
+			expr = append(expr, fmt.Sprintf("%s sport %d", r.Proto, r.SrcPort))
+		}
+		if r.DstPort != 0 {
+			expr = append(expr, fmt.Sprintf("%s dport %d", r.Proto, r.DstPort))
+		}
+	case r.Proto != "":
+		expr = append(expr, "meta l4proto "+r.Proto)
+	}
+	if r.VNI != 0 {
+		// The VNI follows the 8 bytes of the UDP header and the 4 bytes
+		// of the VXLAN flags.
+		expr = append(expr, fmt.Sprintf("@th,96,24 %d", r.VNI))
+	}
+	if len(r.CtState) > 0 {
+		expr = append(expr, "ct state "+strings.ToLower(strings.Join(r.CtState, ",")))
+	}
+	if r.IPVS {
+		// nftables has neither an IPVS match nor the conntrack state of
+		// the IPVS forwarding method.
+		return "", types.NotImplementedErrorf("the IPVS match is not supported by nftables")
+	}
+	if r.Mark != 0 {
+		if r.MarkMask != 0 {
+			expr = append(expr, fmt.Sprintf("meta mark & 0x%x == 0x%x", r.MarkMask, r.Mark))
+		} else {
+			expr = append(expr, fmt.Sprintf("meta mark 0x%x", r.Mark))
+		}
+	}
+	if r.ConnMark != 0 {
+		expr = append(expr, fmt.Sprintf("ct mark 0x%x", r.ConnMark))
+	}
+
+	switch r.Jump {
+	case "ACCEPT", "DROP", "RETURN":
+		expr = append(expr, strings.ToLower(r.Jump))
+	case "MASQUERADE":
+		expr = append(expr, "masquerade")
+	case "DNAT":
+		expr = append(expr, "dnat to "+r.ToDestination)
+	case "SNAT":
+		expr = append(expr, "snat to "+r.ToSource)
+	case "REDIRECT":
+		expr = append(expr, fmt.Sprintf("redirect to :%d", r.ToPort))
+	case "MARK":
+		expr = append(expr, fmt.Sprintf("meta mark set 0x%x", r.SetMark))
+	case "CONNMARK":
+		if r.RestoreMark {
+			expr = append(expr, "meta mark set ct mark")
+		} else {
+			expr = append(expr, fmt.Sprintf("ct mark set 0x%x", r.SetMark))
+		}
+	case "CHECKSUM":
+		return "", types.NotImplementedErrorf("the CHECKSUM target is not supported by nftables")
+	case "":
+		return "", fmt.Errorf("missing target in rule %s", r)
+	default:
+		expr = append(expr, "jump "+nftChainName(table, r.Jump))
+	}
+	return strings.Join(expr, " "), nil
+}
@@ -0,0 +1,170 @@
+package firewall
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/docker/libnetwork/types"
+
+	_ "github.com/docker/libnetwork/testutils"
+)
+
+func TestRuleIPTablesArgs(t *testing.T) {
+	for _, tc := range []struct {
+		rule     Rule
+		expected string
+	}{
+		{
+			rule:     Rule{DstType: "LOCAL", Dst: "127.0.0.0/8", NotDst: true, Jump: "DOCKER"},
+			expected: "! -d 127.0.0.0/8 -m addrtype --dst-type LOCAL -j DOCKER",
+		},
+		{
+			rule:     Rule{Proto: "tcp", Dst: "10.0.0.1", DstPort: 80, InIface: "docker0", NotInIface: true, Jump: "DNAT", ToDestination: "172.17.0.2:8080"},
+			expected: "! -i docker0 -p tcp -d 10.0.0.1 --dport 80 -j DNAT --to-destination 172.17.0.2:8080",
+		},
+		{
+			rule:     Rule{OutIface: "docker0", CtState: []string{"RELATED", "ESTABLISHED"}, Jump: "ACCEPT"},
+			expected: "-o docker0 -m conntrack --ctstate RELATED,ESTABLISHED -j ACCEPT",
+		},
+		{
+			rule:     Rule{Proto: "udp", DstPort: 4789, VNI: 256, Jump: "MARK", SetMark: 3},
+			expected: "-p udp --dport 4789 -m u32 --u32 0>>22&0x3C@12&0xFFFFFF00=65536 -j MARK --set-mark 3",
+		},
+		{
+			rule:     Rule{Proto: "udp", DstPort: 6081, VNI: 256, Family: IPv6, Jump: "DROP"},
+			expected: "-p udp --dport 6081 -m u32 --u32 52&0xFFFFFF00=65536 -j DROP",
+		},
+		{
+			rule:     Rule{IPVS: true, IPVSMethod: "MASQ", Dst: "10.0.0.0/24", Jump: "SNAT", ToSource: "10.0.0.2"},
+			expected: "-d 10.0.0.0/24 -m ipvs --ipvs --vmethod MASQ -j SNAT --to-source 10.0.0.2",
+		},
+		{
+			rule:     Rule{ConnMark: 8, Jump: "CONNMARK", RestoreMark: true},
+			expected: "-m connmark --mark 8 -j CONNMARK --restore-mark",
+		},
+		{
+			rule:     Rule{IPsecIn: true, IPsecReqID: 42, Proto: "udp", DstPort: 4789, Jump: "ACCEPT"},
+			expected: "-m policy --dir in --pol ipsec --reqid 42 -p udp --dport 4789 -j ACCEPT",
+		},
+	} {
+		if args := tc.rule.String(); args != tc.expected {
+			t.Errorf("Unexpected iptables arguments %q, expected %q", args, tc.expected)
+		}
+	}
+
+	rule := Rule{Proto: "udp", DstPort: 4789, VNI: 256, Jump: "DROP"}
+	expected := "-p udp --dport 4789 -m u32 --u32 52&0xFFFFFF00=65536 -j DROP"
+	if args := strings.Join(rule.iptablesArgs(IPv6), " "); args != expected {
+		t.Errorf("Unexpected ip6tables arguments %q, expected %q", args, expected)
+	}
+}
+
+func TestRuleNftExpr(t *testing.T) {
+	for _, tc := range []struct {
+		family   Family
+		table    Table
+		rule     Rule
+		expected string
+	}{
+		{
+			family:   IPv4,
+			table:    Nat,
+			rule:     Rule{DstType: "LOCAL", Dst: "127.0.0.0/8", NotDst: true, Jump: "DOCKER"},
+			expected: "ip daddr != 127.0.0.0/8 fib daddr type local jump nat-DOCKER",
+		},
+		{
+			family:   IPv6,
+			table:    Nat,
+			rule:     Rule{Proto: "tcp", DstPort: 80, InIface: "docker0", NotInIface: true, Jump: "DNAT", ToDestination: "[fd00::2]:8080"},
+			expected: `iifname != "docker0" tcp dport 80 dnat to [fd00::2]:8080`,
+		},
+		{
+			family:   IPv4,
+			table:    Filter,
+			rule:     Rule{OutIface: "docker0", CtState: []string{"RELATED", "ESTABLISHED"}, Jump: "ACCEPT"},
+			expected: `oifname "docker0" ct state related,established accept`,
+		},
+		{
+			family:   IPv4,
+			table:    Mangle,
+			rule:     Rule{Proto: "udp", DstPort: 4789, VNI: 256, Jump: "MARK", SetMark: 3},
+			expected: "udp dport 4789 @th,96,24 256 meta mark set 0x3",
+		},
+		{
+			family:   IPv6,
+			table:    Nat,
+			rule:     Rule{Proto: "icmpv6", ICMPEcho: true, Dst: "fd00::1", Jump: "DNAT", ToDestination: "::1"},
+			expected: "ip6 daddr fd00::1 icmpv6 type echo-request dnat to ::1",
+		},
+		{
+			family:   IPv4,
+			table:    Filter,
+			rule:     Rule{IPsecIn: true, IPsecReqID: 42, Proto: "udp", DstPort: 4789, Jump: "ACCEPT"},
+			expected: "ipsec in reqid 42 udp dport 4789 accept",
+		},
+	} {
+		expr, err := tc.rule.nftExpr(tc.family, tc.table)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expr != tc.expected {
+			t.Errorf("Unexpected nft expression %q, expected %q", expr, tc.expected)
+		}
+	}
+
+	_, err := Rule{Proto: "sctp", SrcPort: 80, Jump: "CHECKSUM"}.nftExpr(IPv4, Mangle)
+	if _, ok := err.(types.NotImplementedError); !ok {
+		t.Fatalf("Expected a not implemented error, got %v", err)
+	}
+
+	_, err = Rule{IPVS: true, IPVSMethod: "MASQ", Dst: "10.0.0.0/24", Jump: "SNAT", ToSource: "10.0.0.2"}.nftExpr(IPv4, Nat)
+	if _, ok := err.(types.NotImplementedError); !ok {
+		t.Fatalf("Expected a not implemented error, got %v", err)
+	}
+}
+
+func TestNFTablesTransactionProgramRule(t *testing.T) {
+	tx := newNFTables(IPv4).NewTransaction().(*nfTablesTransaction)
+	rule := Rule{OutIface: "docker0", Jump: "DOCKER"}
+
+	tx.ProgramRule(Filter, "FORWARD", Insert, rule)
+	tx.ProgramRule(Filter, "FORWARD", Insert, rule)
+	if tx.Len() != 1 {
+		t.Fatalf("Expected a single pending insertion, got %d changes", tx.Len())
+	}
+
+	tx.ProgramRule(Filter, "FORWARD", Delete, rule)
+	if tx.Len() != 0 {
+		t.Fatalf("Expected the deletion to drop the pending insertion, got %d changes", tx.Len())
+	}
+}
+
+func TestNftCommand(t *testing.T) {
+	b := newNFTables(IPv4)
+	rule := Rule{Jump: "RETURN"}
+	expr, err := rule.nftExpr(IPv4, Filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := nftRuleID(expr)
+
+	cmd, err := b.command(Filter, "DOCKER", Append, rule, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := `add rule ip libnetwork filter-DOCKER return comment "` + id + `"` + "\n"; cmd != expected {
+		t.Fatalf("Unexpected command %q, expected %q", cmd, expected)
+	}
+
+	handles := map[string][]string{id: {"12", "14"}}
+	cmd, err = b.command(Filter, "DOCKER", Delete, rule, handles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(cmd, "handle 12\n") || len(handles[id]) != 1 {
+		t.Fatalf("Unexpected deletion command %q", cmd)
+	}
+	if _, err := b.command(Filter, "DOCKER", Delete, Rule{Jump: "DROP"}, handles); err == nil {
+		t.Fatal("Expected the deletion of a missing rule to fail")
+	}
+}
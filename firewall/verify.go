@@ -0,0 +1,405 @@
+package firewall
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Position is the expected position of a rule in its chain.
+type Position int
+
+const (
+	// Anywhere is the position of the rules whose order does not matter.
+	Anywhere Position = iota
+	// Top is the position of the rules expected first in their chain,
+	// ordered by rank.
+	Top
+	// Bottom is the position of the rule expected last in its chain.
+	Bottom
+	// Tail is the position of the rules expected after the other ones of
+	// their chain, as the default DROP rules. Their order is not verified,
+	// they are re-programmed at the end of the chain.
+	Tail
+)
+
+// Ranks of the jump rules kept at the top of the filter FORWARD chain, so
+// that the user rules are evaluated first.
+const (
+	RankUser = iota
+	RankIngress
+	RankIsolation
+)
+
+// ChainRule is a rule of a chain of a table.
+type ChainRule struct {
+	Table Table
+	Chain string
+	Rule  Rule
+}
+
+// Expectation is a rule expected in a chain of the backend of its family.
+type Expectation struct {
+	Family Family
+	ChainRule
+	Position Position
+	// Rank orders the Top rules of a chain, the lowest first.
+	Rank int
+}
+
+// ChainRef refers to a chain of the backend of its family.
+type ChainRef struct {
+	Family Family
+	Table  Table
+	Name   string
+}
+
+// RuleSet is the set of chains and rules a component expects in the host
+// firewall.
+type RuleSet struct {
+	Chains []ChainRef
+	Rules  []Expectation
+}
+
+// AddChain adds a chain to the rule set.
+func (s *RuleSet) AddChain(family Family, table Table, name string) {
+	s.Chains = append(s.Chains, ChainRef{Family: family, Table: table, Name: name})
+}
+
+// Add adds rules expected anywhere in their chain to the rule set.
+func (s *RuleSet) Add(family Family, rules ...ChainRule) {
+	for _, r := range rules {
+		s.Rules = append(s.Rules, Expectation{Family: family, ChainRule: r})
+	}
+}
+
+// AddTail adds rules expected at the end of their chain to the rule set.
+func (s *RuleSet) AddTail(family Family, rules ...ChainRule) {
+	for _, r := range rules {
+		s.Rules = append(s.Rules, Expectation{Family: family, ChainRule: r, Position: Tail})
+	}
+}
+
+// AddTop adds a rule expected at the top of its chain to the rule set.
+func (s *RuleSet) AddTop(family Family, table Table, chain string, rank int, rule Rule) {
+	s.Rules = append(s.Rules, Expectation{
+		Family:    family,
+		ChainRule: ChainRule{Table: table, Chain: chain, Rule: rule},
+		Position:  Top,
+		Rank:      rank,
+	})
+}
+
+// AddBottom adds a rule expected at the bottom of its chain to the rule set.
+func (s *RuleSet) AddBottom(family Family, table Table, chain string, rule Rule) {
+	s.Rules = append(s.Rules, Expectation{
+		Family:    family,
+		ChainRule: ChainRule{Table: table, Chain: chain, Rule: rule},
+		Position:  Bottom,
+	})
+}
+
+// RuleSetFunc returns the rule set a component currently expects.
+type RuleSetFunc func() RuleSet
+
+// registeredRuleSet is a rule set along with the lock serializing the
+// programming of its rules, if any.
+type registeredRuleSet struct {
+	fn   RuleSetFunc
+	lock sync.Locker
+}
+
+var (
+	ruleSetsMu sync.Mutex
+	ruleSets   = map[string]registeredRuleSet{}
+	reloadOnce sync.Once
+	// verifyMu serializes the verifications
+	verifyMu sync.Mutex
+)
+
+// RegisterRuleSet registers the rule set of a component under its name,
+// replacing any rule set registered under that name. The rule sets are
+// verified and repaired when the firewall service of the host reloads.
+func RegisterRuleSet(name string, fn RuleSetFunc) {
+	RegisterRuleSetWithLock(name, fn, nil)
+}
+
+// RegisterRuleSetWithLock registers the rule set of a component as
+// RegisterRuleSet. The verifications hold l, the lock of the component
+// programming the rules, from the reading of the expected rules to their
+// repair, so that they do not race with the component setting them up.
+func RegisterRuleSetWithLock(name string, fn RuleSetFunc, l sync.Locker) {
+	ruleSetsMu.Lock()
+	ruleSets[name] = registeredRuleSet{fn: fn, lock: l}
+	ruleSetsMu.Unlock()
+
+	reloadOnce.Do(func() {
+		for _, family := range []Family{IPv4, IPv6} {
+			family := family
+			Get(family).OnReloaded(func() {
+				logrus.Debugf("Repairing the %s firewall rules on firewall reload", family)
+				verify(Get, true, family)
+			})
+		}
+	})
+}
+
+// UnregisterRuleSet unregisters the rule set of a component.
+func UnregisterRuleSet(name string) {
+	ruleSetsMu.Lock()
+	delete(ruleSets, name)
+	ruleSetsMu.Unlock()
+}
+
+// DriftKind is the kind of a difference between the expected and the live
+// rules.
+type DriftKind string
+
+const (
+	// MissingChain is the drift of an expected chain not found.
+	MissingChain DriftKind = "missing chain"
+	// MissingRule is the drift of an expected rule not found.
+	MissingRule DriftKind = "missing rule"
+	// MisorderedRule is the drift of a rule not at its expected position.
+	MisorderedRule DriftKind = "misordered rule"
+)
+
+// Drift is a difference between the expected and the live rules.
+type Drift struct {
+	// Source is the name of the rule set expecting the chain or rule
+	Source string
+	Kind   DriftKind
+	Family Family
+	Table  Table
+	Chain  string
+	Rule   Rule
+}
+
+func (d Drift) String() string {
+	if d.Kind == MissingChain {
+		return fmt.Sprintf("%s: %s %s/%s (%s)", d.Source, d.Kind, d.Table, d.Chain, d.Family)
+	}
+	return fmt.Sprintf("%s: %s in %s/%s (%s): %s", d.Source, d.Kind, d.Table, d.Chain, d.Family, d.Rule)
+}
+
+// sourcedRule is an expected rule along with the name of its rule set.
+type sourcedRule struct {
+	source string
+	Expectation
+}
+
+// chainKey identifies a chain across the backends.
+type chainKey struct {
+	family Family
+	table  Table
+	chain  string
+}
+
+// Verify compares the registered rule sets against the live rules and
+// returns the differences. With repair, it re-creates the missing chains,
+// re-programs the missing rules and moves the misordered ones back to
+// their position.
+func Verify(repair bool) ([]Drift, error) {
+	return verify(Get, repair, "")
+}
+
+// verify verifies the chains and rules of family, or of both families if
+// empty.
+func verify(get func(Family) Backend, repair bool, family Family) ([]Drift, error) {
+	verifyMu.Lock()
+	defer verifyMu.Unlock()
+
+	ruleSetsMu.Lock()
+	names := make([]string, 0, len(ruleSets))
+	for name := range ruleSets {
+		names = append(names, name)
+	}
+	fns := make([]RuleSetFunc, 0, len(ruleSets))
+	var locks []sync.Locker
+	sort.Strings(names)
+	for _, name := range names {
+		fns = append(fns, ruleSets[name].fn)
+		if l := ruleSets[name].lock; l != nil {
+			locks = append(locks, l)
+		}
+	}
+	ruleSetsMu.Unlock()
+
+	for _, l := range locks {
+		l.Lock()
+		defer l.Unlock()
+	}
+
+	var (
+		drifts   []Drift
+		firstErr error
+		chains   []chainKey
+		rules    = map[chainKey][]sourcedRule{}
+		seen     = map[ChainRef]bool{}
+	)
+	setErr := func(err error) {
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	// Create the chains first, the rules jump to them
+	for i, fn := range fns {
+		set := fn()
+		for _, c := range set.Chains {
+			if family != "" && c.Family != family {
+				continue
+			}
+			fw := get(c.Family)
+			if seen[c] || fw.ExistChain(c.Table, c.Name) {
+				continue
+			}
+			seen[c] = true
+			drifts = append(drifts, Drift{Source: names[i], Kind: MissingChain, Family: c.Family, Table: c.Table, Chain: c.Name})
+			if repair {
+				if err := fw.NewChain(c.Table, c.Name); err != nil {
+					setErr(fmt.Errorf("failed to re-create chain %s/%s: %v", c.Table, c.Name, err))
+				}
+			}
+		}
+		for _, r := range set.Rules {
+			if r.Family == "" {
+				r.Family = IPv4
+			}
+			if family != "" && r.Family != family {
+				continue
+			}
+			key := chainKey{family: r.Family, table: r.Table, chain: r.Chain}
+			if _, ok := rules[key]; !ok {
+				chains = append(chains, key)
+			}
+			if !containsRule(rules[key], r.Rule) {
+				rules[key] = append(rules[key], sourcedRule{source: names[i], Expectation: r})
+			}
+		}
+	}
+
+	for _, key := range chains {
+		chainDrifts, err := verifyChain(get(key.family), key, rules[key], repair)
+		drifts = append(drifts, chainDrifts...)
+		if err != nil {
+			setErr(err)
+		}
+	}
+
+	return drifts, firstErr
+}
+
+// containsRule tells whether the rule is already expected, as the rules
+// shared by several networks.
+func containsRule(rules []sourcedRule, rule Rule) bool {
+	for _, r := range rules {
+		if r.Rule.String() == rule.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyChain verifies, and repairs, the expected rules of a chain.
+func verifyChain(fw Backend, key chainKey, expected []sourcedRule, repair bool) ([]Drift, error) {
+	var (
+		drifts  []Drift
+		missing []sourcedRule
+		top     []sourcedRule
+		bottom  *sourcedRule
+	)
+	newDrift := func(r sourcedRule, kind DriftKind) Drift {
+		return Drift{Source: r.source, Kind: kind, Family: key.family, Table: key.table, Chain: key.chain, Rule: r.Rule}
+	}
+
+	for i, r := range expected {
+		if !fw.Exists(key.table, key.chain, r.Rule) {
+			missing = append(missing, r)
+			drifts = append(drifts, newDrift(r, MissingRule))
+		}
+		switch r.Position {
+		case Top:
+			top = append(top, r)
+		case Bottom:
+			bottom = &expected[i]
+		}
+	}
+	sort.SliceStable(top, func(i, j int) bool { return top[i].Rank < top[j].Rank })
+
+	// The order is only verified once all the rules are present, the
+	// missing rules being already reported.
+	if len(missing) == 0 {
+		misordered, err := misorderedRules(fw, key, top, bottom)
+		if err != nil {
+			return drifts, err
+		}
+		for _, r := range misordered {
+			drifts = append(drifts, newDrift(r, MisorderedRule))
+		}
+	}
+
+	if !repair || len(drifts) == 0 {
+		return drifts, nil
+	}
+
+	tx := fw.NewTransaction()
+	for _, r := range missing {
+		action := Insert
+		if r.Position == Bottom || r.Position == Tail {
+			action = Append
+		}
+		tx.Add(key.table, key.chain, action, r.Rule)
+	}
+	if err := tx.Commit(); err != nil {
+		return drifts, fmt.Errorf("failed to re-program the rules of %s/%s: %v", key.table, key.chain, err)
+	}
+
+	// The inserted rules may have pushed the top ones down
+	misordered, err := misorderedRules(fw, key, top, bottom)
+	if err != nil || len(misordered) == 0 {
+		return drifts, err
+	}
+	if len(top) > 0 {
+		for _, r := range top {
+			tx.ProgramRule(key.table, key.chain, Delete, r.Rule)
+		}
+		for i := len(top) - 1; i >= 0; i-- {
+			tx.Add(key.table, key.chain, Insert, top[i].Rule)
+		}
+	}
+	if bottom != nil {
+		tx.ProgramRule(key.table, key.chain, Delete, bottom.Rule)
+		tx.Add(key.table, key.chain, Append, bottom.Rule)
+	}
+	if err := tx.Commit(); err != nil {
+		return drifts, fmt.Errorf("failed to re-order the rules of %s/%s: %v", key.table, key.chain, err)
+	}
+	return drifts, nil
+}
+
+// misorderedRules returns the top and bottom rules of the chain which are
+// not at their position. The positions are verified on the targets of the
+// rules, the top and bottom rules being jumps or verdicts.
+func misorderedRules(fw Backend, key chainKey, top []sourcedRule, bottom *sourcedRule) ([]sourcedRule, error) {
+	if len(top) == 0 && bottom == nil {
+		return nil, nil
+	}
+	targets, err := fw.Targets(key.table, key.chain)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the rules of %s/%s: %v", key.table, key.chain, err)
+	}
+
+	var misordered []sourcedRule
+	for i, r := range top {
+		if i >= len(targets) || targets[i] != r.Rule.Jump {
+			misordered = append(misordered, r)
+		}
+	}
+	if bottom != nil && (len(targets) == 0 || targets[len(targets)-1] != bottom.Rule.Jump) {
+		misordered = append(misordered, *bottom)
+	}
+	return misordered, nil
+}
@@ -0,0 +1,263 @@
+package firewall
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	_ "github.com/docker/libnetwork/testutils"
+)
+
+// memBackend is an in-memory backend keeping the rules of its chains.
+type memBackend struct {
+	chains map[string][]Rule
+}
+
+func newMemBackend() *memBackend {
+	b := &memBackend{chains: map[string][]Rule{}}
+	for _, c := range []string{"PREROUTING", "OUTPUT", "POSTROUTING"} {
+		b.chains[memKey(Nat, c)] = nil
+	}
+	for _, c := range []string{"INPUT", "FORWARD", "OUTPUT"} {
+		b.chains[memKey(Filter, c)] = nil
+	}
+	return b
+}
+
+func memKey(table Table, chain string) string {
+	return string(table) + "/" + chain
+}
+
+func (b *memBackend) Name() string      { return "memory" }
+func (b *memBackend) Family() Family    { return IPv4 }
+func (b *memBackend) Native() Backend   { return b }
+func (b *memBackend) OnReloaded(func()) {}
+
+func (b *memBackend) NewChain(table Table, name string) error {
+	if _, ok := b.chains[memKey(table, name)]; !ok {
+		b.chains[memKey(table, name)] = nil
+	}
+	return nil
+}
+
+func (b *memBackend) RemoveChain(table Table, name string) error {
+	delete(b.chains, memKey(table, name))
+	return nil
+}
+
+func (b *memBackend) FlushChain(table Table, name string) error {
+	b.chains[memKey(table, name)] = nil
+	return nil
+}
+
+func (b *memBackend) ExistChain(table Table, name string) bool {
+	_, ok := b.chains[memKey(table, name)]
+	return ok
+}
+
+func (b *memBackend) Targets(table Table, chain string) ([]string, error) {
+	var targets []string
+	for _, r := range b.chains[memKey(table, chain)] {
+		targets = append(targets, r.Jump)
+	}
+	return targets, nil
+}
+
+func (b *memBackend) index(table Table, chain string, rule Rule) int {
+	for i, r := range b.chains[memKey(table, chain)] {
+		if r.String() == rule.String() {
+			return i
+		}
+	}
+	return -1
+}
+
+func (b *memBackend) Exists(table Table, chain string, rule Rule) bool {
+	return b.index(table, chain, rule) >= 0
+}
+
+func (b *memBackend) Apply(table Table, chain string, action Action, rule Rule) error {
+	key := memKey(table, chain)
+	rules, ok := b.chains[key]
+	if !ok {
+		return fmt.Errorf("no chain %s", key)
+	}
+	switch action {
+	case Insert:
+		b.chains[key] = append([]Rule{rule}, rules...)
+	case Append:
+		b.chains[key] = append(rules, rule)
+	case Delete:
+		i := b.index(table, chain, rule)
+		if i < 0 {
+			return fmt.Errorf("no rule %s in %s", rule, key)
+		}
+		b.chains[key] = append(rules[:i], rules[i+1:]...)
+	}
+	return nil
+}
+
+func (b *memBackend) ProgramRule(table Table, chain string, action Action, rule Rule) error {
+	if b.Exists(table, chain, rule) != (action == Delete) {
+		return nil
+	}
+	return b.Apply(table, chain, action, rule)
+}
+
+func (b *memBackend) NewTransaction() Transaction {
+	return &memTransaction{backend: b}
+}
+
+func (b *memBackend) SetDefaultPolicy(table Table, chain string, policy Policy) error {
+	return nil
+}
+
+// memTransaction applies its changes immediately.
+type memTransaction struct {
+	backend *memBackend
+	changes int
+}
+
+func (t *memTransaction) Add(table Table, chain string, action Action, rule Rule) {
+	t.backend.Apply(table, chain, action, rule)
+	t.changes++
+}
+
+func (t *memTransaction) ProgramRule(table Table, chain string, action Action, rule Rule) {
+	t.backend.ProgramRule(table, chain, action, rule)
+	t.changes++
+}
+
+func (t *memTransaction) Len() int {
+	return t.changes
+}
+
+func (t *memTransaction) Commit() error {
+	t.changes = 0
+	return nil
+}
+
+func testRuleSet() RuleSet {
+	var set RuleSet
+	set.AddChain(IPv4, Filter, "DOCKER-USER")
+	set.AddChain(IPv4, Filter, "DOCKER")
+	set.AddBottom(IPv4, Filter, "DOCKER-USER", Rule{Jump: "RETURN"})
+	set.AddTop(IPv4, Filter, "FORWARD", RankUser, Rule{Jump: "DOCKER-USER"})
+	set.AddTop(IPv4, Filter, "FORWARD", RankIsolation, Rule{Jump: "DOCKER-ISOLATION-STAGE-1"})
+	set.Add(IPv4, ChainRule{Table: Filter, Chain: "FORWARD", Rule: Rule{OutIface: "docker0", Jump: "DOCKER"}})
+	set.AddTail(IPv4, ChainRule{Table: Filter, Chain: "FORWARD", Rule: Rule{InIface: "docker0", OutIface: "docker0", Jump: "DROP"}})
+	return set
+}
+
+func TestVerifyRepair(t *testing.T) {
+	RegisterRuleSet("test", testRuleSet)
+	defer UnregisterRuleSet("test")
+
+	b := newMemBackend()
+	get := func(Family) Backend { return b }
+
+	drifts, err := verify(get, false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Two missing chains and five missing rules
+	if len(drifts) != 7 {
+		t.Fatalf("Expected 7 drifts, got %d: %v", len(drifts), drifts)
+	}
+	if b.ExistChain(Filter, "DOCKER-USER") {
+		t.Fatal("The verification without repair must not create the chains")
+	}
+
+	if _, err := verify(get, true, ""); err != nil {
+		t.Fatal(err)
+	}
+	targets, _ := b.Targets(Filter, "FORWARD")
+	expected := []string{"DOCKER-USER", "DOCKER-ISOLATION-STAGE-1", "DOCKER", "DROP"}
+	if fmt.Sprint(targets) != fmt.Sprint(expected) {
+		t.Fatalf("Unexpected FORWARD chain after repair %v, expected %v", targets, expected)
+	}
+
+	drifts, err = verify(get, false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(drifts) != 0 {
+		t.Fatalf("Expected no drift after repair, got %v", drifts)
+	}
+}
+
+func TestVerifyMisordered(t *testing.T) {
+	RegisterRuleSet("test", testRuleSet)
+	defer UnregisterRuleSet("test")
+
+	b := newMemBackend()
+	get := func(Family) Backend { return b }
+	if _, err := verify(get, true, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another tool inserts its rules on top of the chains
+	b.Apply(Filter, "FORWARD", Insert, Rule{InIface: "eth1", Jump: "ACCEPT"})
+	b.Apply(Filter, "DOCKER-USER", Append, Rule{InIface: "eth1", Jump: "DROP"})
+
+	drifts, err := verify(get, false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(drifts) != 3 {
+		t.Fatalf("Expected 3 drifts, got %d: %v", len(drifts), drifts)
+	}
+	for _, d := range drifts {
+		if d.Kind != MisorderedRule {
+			t.Fatalf("Unexpected drift %s", d)
+		}
+	}
+
+	if _, err := verify(get, true, ""); err != nil {
+		t.Fatal(err)
+	}
+	targets, _ := b.Targets(Filter, "FORWARD")
+	expected := []string{"DOCKER-USER", "DOCKER-ISOLATION-STAGE-1", "ACCEPT", "DOCKER", "DROP"}
+	if fmt.Sprint(targets) != fmt.Sprint(expected) {
+		t.Fatalf("Unexpected FORWARD chain after repair %v, expected %v", targets, expected)
+	}
+	targets, _ = b.Targets(Filter, "DOCKER-USER")
+	if targets[len(targets)-1] != "RETURN" {
+		t.Fatalf("Expected the RETURN rule last in DOCKER-USER, got %v", targets)
+	}
+}
+
+type countingLock struct {
+	sync.Mutex
+	locked int
+}
+
+func (l *countingLock) Lock() {
+	l.Mutex.Lock()
+	l.locked++
+}
+
+func TestVerifyLockAndFamily(t *testing.T) {
+	l := &countingLock{}
+	RegisterRuleSetWithLock("test", testRuleSet, l)
+	defer UnregisterRuleSet("test")
+
+	b := newMemBackend()
+	get := func(Family) Backend { return b }
+
+	// The rules of the other family are not verified
+	drifts, err := verify(get, false, IPv6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(drifts) != 0 {
+		t.Fatalf("Expected no IPv6 drift, got %v", drifts)
+	}
+	if l.locked != 1 {
+		t.Fatalf("Expected the rule set lock to be held once, got %d", l.locked)
+	}
+
+	if drifts, _ = verify(get, false, IPv4); len(drifts) != 7 {
+		t.Fatalf("Expected 7 IPv4 drifts, got %d: %v", len(drifts), drifts)
+	}
+}
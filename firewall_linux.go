@@ -1,40 +1,106 @@
 package libnetwork
 
 import (
-	"github.com/docker/libnetwork/iptables"
+	"time"
+
+	"github.com/docker/libnetwork/firewall"
 	"github.com/sirupsen/logrus"
 )
 
 const userChain = "DOCKER-USER"
 
+// setFirewallBackend selects the firewall backend of the configuration.
+func (c *controller) setFirewallBackend() error {
+	return firewall.SetBackend(c.cfg.Daemon.FirewallBackend)
+}
+
 func (c *controller) arrangeUserFilterRule() {
 	c.Lock()
 	arrangeUserFilterRule()
 	c.Unlock()
-	iptables.OnReloaded(func() {
-		c.Lock()
-		arrangeUserFilterRule()
-		c.Unlock()
-	})
+	firewall.RegisterRuleSet(userChain, userRuleSet)
+}
+
+// userRuleSet returns the DOCKER-USER chain and the jump to it, which must
+// be the first rule of the FORWARD chain.
+func userRuleSet() firewall.RuleSet {
+	var set firewall.RuleSet
+	set.AddChain(firewall.IPv4, firewall.Filter, userChain)
+	set.AddBottom(firewall.IPv4, firewall.Filter, userChain, firewall.Rule{Jump: "RETURN"})
+	set.AddTop(firewall.IPv4, firewall.Filter, "FORWARD", firewall.RankUser, firewall.Rule{Jump: userChain})
+	return set
 }
 
 // This chain allow users to configure firewall policies in a way that persists
 // docker operations/restarts. Docker will not delete or modify any pre-existing
 // rules from the DOCKER-USER filter chain.
 func arrangeUserFilterRule() {
-	_, err := iptables.NewChain(userChain, iptables.Filter, false)
-	if err != nil {
+	fw := firewall.Get(firewall.IPv4)
+	if err := fw.NewChain(firewall.Filter, userChain); err != nil {
 		logrus.Warnf("Failed to create %s chain: %v", userChain, err)
 		return
 	}
 
-	if err = iptables.AddReturnRule(userChain); err != nil {
+	if err := firewall.AddReturnRule(fw, userChain); err != nil {
 		logrus.Warnf("Failed to add the RETURN rule for %s: %v", userChain, err)
 		return
 	}
 
-	err = iptables.EnsureJumpRule("FORWARD", userChain)
-	if err != nil {
+	if err := firewall.EnsureJumpRule(fw, "FORWARD", userChain); err != nil {
 		logrus.Warnf("Failed to ensure the jump rule for %s: %v", userChain, err)
 	}
 }
+
+// startFirewallVerifier periodically verifies the firewall rules expected
+// by the networks, reporting the drifts and re-programming the rules when
+// the repair is enabled.
+func (c *controller) startFirewallVerifier() {
+	interval := c.cfg.Daemon.FirewallVerifyInterval
+	if interval <= 0 {
+		return
+	}
+	repair := c.cfg.Daemon.FirewallRepair
+
+	stop := make(chan struct{})
+	c.Lock()
+	c.firewallVerifyStop = stop
+	c.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				verifyFirewall(repair)
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+func (c *controller) stopFirewallVerifier() {
+	c.Lock()
+	stop := c.firewallVerifyStop
+	c.firewallVerifyStop = nil
+	c.Unlock()
+	if stop != nil {
+		close(stop)
+	}
+}
+
+// verifyFirewall verifies the firewall rules and logs the drifts found.
+func verifyFirewall(repair bool) {
+	drifts, err := firewall.Verify(repair)
+	for _, d := range drifts {
+		if repair {
+			logrus.Warnf("Repaired firewall drift: %s", d)
+		} else {
+			logrus.Warnf("Firewall drift: %s", d)
+		}
+	}
+	if err != nil {
+		logrus.Errorf("Firewall verification failed: %v", err)
+	}
+}
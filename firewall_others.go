@@ -2,5 +2,15 @@
 
 package libnetwork
 
+func (c *controller) setFirewallBackend() error {
+	return nil
+}
+
 func (c *controller) arrangeUserFilterRule() {
 }
+
+func (c *controller) startFirewallVerifier() {
+}
+
+func (c *controller) stopFirewallVerifier() {
+}
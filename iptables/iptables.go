@@ -43,10 +43,16 @@ const (
 )
 
 var (
-	iptablesPath  string
-	supportsXlock = false
-	supportsCOpt  = false
-	xLockWaitMsg  = "Another app is currently holding the xtables lock"
+	iptablesPath         string
+	ip6tablesPath        string
+	iptablesRestorePath  string
+	ip6tablesRestorePath string
+	iptablesSavePath     string
+	ip6tablesSavePath    string
+	supportsXlock        = false
+	supportsCOpt         = false
+	supportsRestoreWait  = false
+	xLockWaitMsg         = "Another app is currently holding the xtables lock"
 	// used to lock iptables commands if xtables lock is not supported
 	bestEffortLock sync.Mutex
 	// ErrIptablesNotFound is returned when the rule is not found.
@@ -54,11 +60,18 @@ var (
 	initOnce            sync.Once
 )
 
+// IPTable defines the iptables instance of one IP version. Its methods
+// invoke iptables for Iptables and ip6tables for IP6Tables.
+type IPTable struct {
+	Version IPV
+}
+
 // ChainInfo defines the iptables chain.
 type ChainInfo struct {
 	Name        string
 	Table       Table
 	HairpinMode bool
+	IPTable     IPTable
 }
 
 // ChainError is returned to represent errors during ip table operation.
@@ -93,12 +106,28 @@ func detectIptables() {
 	}
 	iptablesPath = path
 	supportsXlock = exec.Command(iptablesPath, "--wait", "-L", "-n").Run() == nil
+	if path, err := exec.LookPath("ip6tables"); err == nil {
+		ip6tablesPath = path
+	}
+	if path, err := exec.LookPath("iptables-restore"); err == nil {
+		iptablesRestorePath = path
+	}
+	if path, err := exec.LookPath("ip6tables-restore"); err == nil {
+		ip6tablesRestorePath = path
+	}
+	if path, err := exec.LookPath("iptables-save"); err == nil {
+		iptablesSavePath = path
+	}
+	if path, err := exec.LookPath("ip6tables-save"); err == nil {
+		ip6tablesSavePath = path
+	}
 	mj, mn, mc, err := GetVersion()
 	if err != nil {
 		logrus.Warnf("Failed to read iptables version: %v", err)
 		return
 	}
 	supportsCOpt = supportsCOption(mj, mn, mc)
+	supportsRestoreWait = supportsRestoreWaitOption(mj, mn, mc)
 }
 
 func initDependencies() {
@@ -107,29 +136,71 @@ func initDependencies() {
 	detectIptables()
 }
 
-func initCheck() error {
+func (iptable IPTable) initCheck() error {
 	initOnce.Do(initDependencies)
 
-	if iptablesPath == "" {
+	if iptable.path() == "" {
 		return ErrIptablesNotFound
 	}
 	return nil
 }
 
+// GetIptable returns the IPTable for the passed IP version.
+func GetIptable(version IPV) IPTable {
+	return IPTable{Version: version}
+}
+
+func (iptable IPTable) path() string {
+	if iptable.Version == IP6Tables {
+		return ip6tablesPath
+	}
+	return iptablesPath
+}
+
+func (iptable IPTable) binary() string {
+	if iptable.Version == IP6Tables {
+		return "ip6tables"
+	}
+	return "iptables"
+}
+
+// passthroughVersion returns the IP version to pass to firewalld,
+// defaulting to Iptables for the zero IPTable.
+func (iptable IPTable) passthroughVersion() IPV {
+	if iptable.Version == IP6Tables {
+		return IP6Tables
+	}
+	return Iptables
+}
+
+// loopbackNet returns the loopback network of the IP version.
+func (iptable IPTable) loopbackNet() string {
+	if iptable.Version == IP6Tables {
+		return "::1/128"
+	}
+	return "127.0.0.0/8"
+}
+
 // NewChain adds a new chain to ip table.
 func NewChain(name string, table Table, hairpinMode bool) (*ChainInfo, error) {
+	return GetIptable(Iptables).NewChain(name, table, hairpinMode)
+}
+
+// NewChain adds a new chain to ip table.
+func (iptable IPTable) NewChain(name string, table Table, hairpinMode bool) (*ChainInfo, error) {
 	c := &ChainInfo{
 		Name:        name,
 		Table:       table,
 		HairpinMode: hairpinMode,
+		IPTable:     iptable,
 	}
 	if string(c.Table) == "" {
 		c.Table = Filter
 	}
 
 	// Add chain if it doesn't exist
-	if _, err := Raw("-t", string(c.Table), "-n", "-L", c.Name); err != nil {
-		if output, err := Raw("-t", string(c.Table), "-N", c.Name); err != nil {
+	if _, err := iptable.Raw("-t", string(c.Table), "-n", "-L", c.Name); err != nil {
+		if output, err := iptable.Raw("-t", string(c.Table), "-N", c.Name); err != nil {
 			return nil, err
 		} else if len(output) != 0 {
 			return nil, fmt.Errorf("Could not create %s/%s chain: %s", c.Table, c.Name, output)
@@ -138,86 +209,57 @@ func NewChain(name string, table Table, hairpinMode bool) (*ChainInfo, error) {
 	return c, nil
 }
 
-// ProgramChain is used to add rules to a chain
+// ProgramChain is used to add rules to a chain. The rules are applied
+// in a single transaction.
 func ProgramChain(c *ChainInfo, bridgeName string, hairpinMode, enable bool) error {
 	if c.Name == "" {
 		return errors.New("Could not program chain, missing chain name")
 	}
 
+	action := Append
+	if !enable {
+		action = Delete
+	}
+
+	tx := c.IPTable.NewTransaction()
 	switch c.Table {
 	case Nat:
 		preroute := []string{
 			"-m", "addrtype",
 			"--dst-type", "LOCAL",
 			"-j", c.Name}
-		if !Exists(Nat, "PREROUTING", preroute...) && enable {
-			if err := c.Prerouting(Append, preroute...); err != nil {
-				return fmt.Errorf("Failed to inject %s in PREROUTING chain: %s", c.Name, err)
-			}
-		} else if Exists(Nat, "PREROUTING", preroute...) && !enable {
-			if err := c.Prerouting(Delete, preroute...); err != nil {
-				return fmt.Errorf("Failed to remove %s in PREROUTING chain: %s", c.Name, err)
-			}
-		}
+		tx.ProgramRule(Nat, "PREROUTING", action, preroute)
 		output := []string{
 			"-m", "addrtype",
 			"--dst-type", "LOCAL",
 			"-j", c.Name}
 		if !hairpinMode {
-			output = append(output, "!", "--dst", "127.0.0.0/8")
+			output = append(output, "!", "--dst", c.IPTable.loopbackNet())
 		}
-		if !Exists(Nat, "OUTPUT", output...) && enable {
-			if err := c.Output(Append, output...); err != nil {
-				return fmt.Errorf("Failed to inject %s in OUTPUT chain: %s", c.Name, err)
-			}
-		} else if Exists(Nat, "OUTPUT", output...) && !enable {
-			if err := c.Output(Delete, output...); err != nil {
-				return fmt.Errorf("Failed to inject %s in OUTPUT chain: %s", c.Name, err)
-			}
+		tx.ProgramRule(Nat, "OUTPUT", action, output)
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("Failed to program %s in PREROUTING and OUTPUT chains: %s", c.Name, err)
 		}
 	case Filter:
 		if bridgeName == "" {
 			return fmt.Errorf("Could not program chain %s/%s, missing bridge name",
 				c.Table, c.Name)
 		}
+		if enable {
+			action = Insert
+		}
 		link := []string{
 			"-o", bridgeName,
 			"-j", c.Name}
-		if !Exists(Filter, "FORWARD", link...) && enable {
-			insert := append([]string{string(Insert), "FORWARD"}, link...)
-			if output, err := Raw(insert...); err != nil {
-				return err
-			} else if len(output) != 0 {
-				return fmt.Errorf("Could not create linking rule to %s/%s: %s", c.Table, c.Name, output)
-			}
-		} else if Exists(Filter, "FORWARD", link...) && !enable {
-			del := append([]string{string(Delete), "FORWARD"}, link...)
-			if output, err := Raw(del...); err != nil {
-				return err
-			} else if len(output) != 0 {
-				return fmt.Errorf("Could not delete linking rule from %s/%s: %s", c.Table, c.Name, output)
-			}
-
-		}
+		tx.ProgramRule(Filter, "FORWARD", action, link)
 		establish := []string{
 			"-o", bridgeName,
 			"-m", "conntrack",
 			"--ctstate", "RELATED,ESTABLISHED",
 			"-j", "ACCEPT"}
-		if !Exists(Filter, "FORWARD", establish...) && enable {
-			insert := append([]string{string(Insert), "FORWARD"}, establish...)
-			if output, err := Raw(insert...); err != nil {
-				return err
-			} else if len(output) != 0 {
-				return fmt.Errorf("Could not create establish rule to %s: %s", c.Table, output)
-			}
-		} else if Exists(Filter, "FORWARD", establish...) && !enable {
-			del := append([]string{string(Delete), "FORWARD"}, establish...)
-			if output, err := Raw(del...); err != nil {
-				return err
-			} else if len(output) != 0 {
-				return fmt.Errorf("Could not delete establish rule from %s: %s", c.Table, output)
-			}
+		tx.ProgramRule(Filter, "FORWARD", action, establish)
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("Could not program linking and establish rules of %s/%s: %s", c.Table, c.Name, err)
 		}
 	}
 	return nil
@@ -225,9 +267,15 @@ func ProgramChain(c *ChainInfo, bridgeName string, hairpinMode, enable bool) err
 
 // RemoveExistingChain removes existing chain from the table.
 func RemoveExistingChain(name string, table Table) error {
+	return GetIptable(Iptables).RemoveExistingChain(name, table)
+}
+
+// RemoveExistingChain removes existing chain from the table.
+func (iptable IPTable) RemoveExistingChain(name string, table Table) error {
 	c := &ChainInfo{
-		Name:  name,
-		Table: table,
+		Name:    name,
+		Table:   table,
+		IPTable: iptable,
 	}
 	if string(c.Table) == "" {
 		c.Table = Filter
@@ -254,7 +302,7 @@ func (c *ChainInfo) Forward(action Action, ip net.IP, port int, proto, destAddr
 	if !c.HairpinMode {
 		args = append(args, "!", "-i", bridgeName)
 	}
-	if err := ProgramRule(Nat, c.Name, action, args); err != nil {
+	if err := c.IPTable.ProgramRule(Nat, c.Name, action, args); err != nil {
 		return err
 	}
 
@@ -266,7 +314,7 @@ func (c *ChainInfo) Forward(action Action, ip net.IP, port int, proto, destAddr
 		"--dport", strconv.Itoa(destPort),
 		"-j", "ACCEPT",
 	}
-	if err := ProgramRule(Filter, c.Name, action, args); err != nil {
+	if err := c.IPTable.ProgramRule(Filter, c.Name, action, args); err != nil {
 		return err
 	}
 
@@ -278,7 +326,7 @@ func (c *ChainInfo) Forward(action Action, ip net.IP, port int, proto, destAddr
 		"-j", "MASQUERADE",
 	}
 
-	if err := ProgramRule(Nat, "POSTROUTING", action, args); err != nil {
+	if err := c.IPTable.ProgramRule(Nat, "POSTROUTING", action, args); err != nil {
 		return err
 	}
 
@@ -296,7 +344,7 @@ func (c *ChainInfo) Forward(action Action, ip net.IP, port int, proto, destAddr
 			"-j", "CHECKSUM",
 			"--checksum-fill",
 		}
-		if err := ProgramRule(Mangle, "POSTROUTING", action, args); err != nil {
+		if err := c.IPTable.ProgramRule(Mangle, "POSTROUTING", action, args); err != nil {
 			return err
 		}
 	}
@@ -316,23 +364,30 @@ func (c *ChainInfo) Link(action Action, ip1, ip2 net.IP, port int, proto string,
 		"--dport", strconv.Itoa(port),
 		"-j", "ACCEPT",
 	}
-	if err := ProgramRule(Filter, c.Name, action, args); err != nil {
+	if err := c.IPTable.ProgramRule(Filter, c.Name, action, args); err != nil {
 		return err
 	}
 	// reverse
 	args[7], args[9] = args[9], args[7]
 	args[10] = "--sport"
-	return ProgramRule(Filter, c.Name, action, args)
+	return c.IPTable.ProgramRule(Filter, c.Name, action, args)
 }
 
 // ProgramRule adds the rule specified by args only if the
 // rule is not already present in the chain. Reciprocally,
 // it removes the rule only if present.
 func ProgramRule(table Table, chain string, action Action, args []string) error {
-	if Exists(table, chain, args...) != (action == Delete) {
+	return GetIptable(Iptables).ProgramRule(table, chain, action, args)
+}
+
+// ProgramRule adds the rule specified by args only if the
+// rule is not already present in the chain. Reciprocally,
+// it removes the rule only if present.
+func (iptable IPTable) ProgramRule(table Table, chain string, action Action, args []string) error {
+	if iptable.Exists(table, chain, args...) != (action == Delete) {
 		return nil
 	}
-	return RawCombinedOutput(append([]string{"-t", string(table), string(action), chain}, args...)...)
+	return iptable.RawCombinedOutput(append([]string{"-t", string(table), string(action), chain}, args...)...)
 }
 
 // Prerouting adds linking rule to nat/PREROUTING chain.
@@ -341,7 +396,7 @@ func (c *ChainInfo) Prerouting(action Action, args ...string) error {
 	if len(args) > 0 {
 		a = append(a, args...)
 	}
-	if output, err := Raw(a...); err != nil {
+	if output, err := c.IPTable.Raw(a...); err != nil {
 		return err
 	} else if len(output) != 0 {
 		return ChainError{Chain: "PREROUTING", Output: output}
@@ -355,7 +410,7 @@ func (c *ChainInfo) Output(action Action, args ...string) error {
 	if len(args) > 0 {
 		a = append(a, args...)
 	}
-	if output, err := Raw(a...); err != nil {
+	if output, err := c.IPTable.Raw(a...); err != nil {
 		return err
 	} else if len(output) != 0 {
 		return ChainError{Chain: "OUTPUT", Output: output}
@@ -368,39 +423,50 @@ func (c *ChainInfo) Remove() error {
 	// Ignore errors - This could mean the chains were never set up
 	if c.Table == Nat {
 		c.Prerouting(Delete, "-m", "addrtype", "--dst-type", "LOCAL", "-j", c.Name)
-		c.Output(Delete, "-m", "addrtype", "--dst-type", "LOCAL", "!", "--dst", "127.0.0.0/8", "-j", c.Name)
+		c.Output(Delete, "-m", "addrtype", "--dst-type", "LOCAL", "!", "--dst", c.IPTable.loopbackNet(), "-j", c.Name)
 		c.Output(Delete, "-m", "addrtype", "--dst-type", "LOCAL", "-j", c.Name) // Created in versions <= 0.1.6
 
 		c.Prerouting(Delete)
 		c.Output(Delete)
 	}
-	Raw("-t", string(c.Table), "-F", c.Name)
-	Raw("-t", string(c.Table), "-X", c.Name)
+	c.IPTable.Raw("-t", string(c.Table), "-F", c.Name)
+	c.IPTable.Raw("-t", string(c.Table), "-X", c.Name)
 	return nil
 }
 
 // Exists checks if a rule exists
 func Exists(table Table, chain string, rule ...string) bool {
-	return exists(false, table, chain, rule...)
+	return GetIptable(Iptables).Exists(table, chain, rule...)
+}
+
+// Exists checks if a rule exists
+func (iptable IPTable) Exists(table Table, chain string, rule ...string) bool {
+	return iptable.exists(false, table, chain, rule...)
 }
 
 // ExistsNative behaves as Exists with the difference it
 // will always invoke `iptables` binary.
 func ExistsNative(table Table, chain string, rule ...string) bool {
-	return exists(true, table, chain, rule...)
+	return GetIptable(Iptables).ExistsNative(table, chain, rule...)
 }
 
-func exists(native bool, table Table, chain string, rule ...string) bool {
-	f := Raw
+// ExistsNative behaves as Exists with the difference it
+// will always invoke the `iptables` or `ip6tables` binary.
+func (iptable IPTable) ExistsNative(table Table, chain string, rule ...string) bool {
+	return iptable.exists(true, table, chain, rule...)
+}
+
+func (iptable IPTable) exists(native bool, table Table, chain string, rule ...string) bool {
+	f := iptable.Raw
 	if native {
-		f = raw
+		f = iptable.raw
 	}
 
 	if string(table) == "" {
 		table = Filter
 	}
 
-	if err := initCheck(); err != nil {
+	if err := iptable.initCheck(); err != nil {
 		// The exists() signature does not allow us to return an error, but at least
 		// we can skip the (likely invalid) exec invocation.
 		return false
@@ -414,12 +480,12 @@ func exists(native bool, table Table, chain string, rule ...string) bool {
 
 	// parse "iptables -S" for the rule (it checks rules in a specific chain
 	// in a specific table and it is very unreliable)
-	return existsRaw(table, chain, rule...)
+	return iptable.existsRaw(table, chain, rule...)
 }
 
-func existsRaw(table Table, chain string, rule ...string) bool {
+func (iptable IPTable) existsRaw(table Table, chain string, rule ...string) bool {
 	ruleString := fmt.Sprintf("%s %s\n", chain, strings.Join(rule, " "))
-	existingRules, _ := exec.Command(iptablesPath, "-t", string(table), "-S", chain).Output()
+	existingRules, _ := exec.Command(iptable.path(), "-t", string(table), "-S", chain).Output()
 
 	return strings.Contains(string(existingRules), ruleString)
 }
@@ -445,20 +511,26 @@ func filterOutput(start time.Time, output []byte, args ...string) []byte {
 
 // Raw calls 'iptables' system command, passing supplied arguments.
 func Raw(args ...string) ([]byte, error) {
+	return GetIptable(Iptables).Raw(args...)
+}
+
+// Raw calls 'iptables' or 'ip6tables' system command, passing supplied arguments.
+func (iptable IPTable) Raw(args ...string) ([]byte, error) {
 	if firewalldRunning {
 		startTime := time.Now()
-		output, err := Passthrough(Iptables, args...)
+		output, err := Passthrough(iptable.passthroughVersion(), args...)
 		if err == nil || !strings.Contains(err.Error(), "was not provided by any .service files") {
 			return filterOutput(startTime, output, args...), err
 		}
 	}
-	return raw(args...)
+	return iptable.raw(args...)
 }
 
-func raw(args ...string) ([]byte, error) {
-	if err := initCheck(); err != nil {
+func (iptable IPTable) raw(args ...string) ([]byte, error) {
+	if err := iptable.initCheck(); err != nil {
 		return nil, err
 	}
+	path := iptable.path()
 	if supportsXlock {
 		args = append([]string{"--wait"}, args...)
 	} else {
@@ -466,12 +538,12 @@ func raw(args ...string) ([]byte, error) {
 		defer bestEffortLock.Unlock()
 	}
 
-	logrus.Debugf("%s, %v", iptablesPath, args)
+	logrus.Debugf("%s, %v", path, args)
 
 	startTime := time.Now()
-	output, err := exec.Command(iptablesPath, args...).CombinedOutput()
+	output, err := exec.Command(path, args...).CombinedOutput()
 	if err != nil {
-		return nil, fmt.Errorf("iptables failed: iptables %v: %s (%s)", strings.Join(args, " "), output, err)
+		return nil, fmt.Errorf("%s failed: %s %v: %s (%s)", iptable.binary(), iptable.binary(), strings.Join(args, " "), output, err)
 	}
 
 	return filterOutput(startTime, output, args...), err
@@ -480,7 +552,13 @@ func raw(args ...string) ([]byte, error) {
 // RawCombinedOutput inernally calls the Raw function and returns a non nil
 // error if Raw returned a non nil error or a non empty output
 func RawCombinedOutput(args ...string) error {
-	if output, err := Raw(args...); err != nil || len(output) != 0 {
+	return GetIptable(Iptables).RawCombinedOutput(args...)
+}
+
+// RawCombinedOutput inernally calls the Raw function and returns a non nil
+// error if Raw returned a non nil error or a non empty output
+func (iptable IPTable) RawCombinedOutput(args ...string) error {
+	if output, err := iptable.Raw(args...); err != nil || len(output) != 0 {
 		return fmt.Errorf("%s (%v)", string(output), err)
 	}
 	return nil
@@ -489,7 +567,13 @@ func RawCombinedOutput(args ...string) error {
 // RawCombinedOutputNative behave as RawCombinedOutput with the difference it
 // will always invoke `iptables` binary
 func RawCombinedOutputNative(args ...string) error {
-	if output, err := raw(args...); err != nil || len(output) != 0 {
+	return GetIptable(Iptables).RawCombinedOutputNative(args...)
+}
+
+// RawCombinedOutputNative behave as RawCombinedOutput with the difference it
+// will always invoke the `iptables` or `ip6tables` binary
+func (iptable IPTable) RawCombinedOutputNative(args ...string) error {
+	if output, err := iptable.raw(args...); err != nil || len(output) != 0 {
 		return fmt.Errorf("%s (%v)", string(output), err)
 	}
 	return nil
@@ -497,7 +581,12 @@ func RawCombinedOutputNative(args ...string) error {
 
 // ExistChain checks if a chain exists
 func ExistChain(chain string, table Table) bool {
-	if _, err := Raw("-t", string(table), "-nL", chain); err == nil {
+	return GetIptable(Iptables).ExistChain(chain, table)
+}
+
+// ExistChain checks if a chain exists
+func (iptable IPTable) ExistChain(chain string, table Table) bool {
+	if _, err := iptable.Raw("-t", string(table), "-nL", chain); err == nil {
 		return true
 	}
 	return false
@@ -514,7 +603,12 @@ func GetVersion() (major, minor, micro int, err error) {
 
 // SetDefaultPolicy sets the passed default policy for the table/chain
 func SetDefaultPolicy(table Table, chain string, policy Policy) error {
-	if err := RawCombinedOutput("-t", string(table), "-P", chain, string(policy)); err != nil {
+	return GetIptable(Iptables).SetDefaultPolicy(table, chain, policy)
+}
+
+// SetDefaultPolicy sets the passed default policy for the table/chain
+func (iptable IPTable) SetDefaultPolicy(table Table, chain string, policy Policy) error {
+	if err := iptable.RawCombinedOutput("-t", string(table), "-P", chain, string(policy)); err != nil {
 		return fmt.Errorf("setting default policy to %v in %v chain failed: %v", policy, chain, err)
 	}
 	return nil
@@ -535,16 +629,21 @@ func supportsCOption(mj, mn, mc int) bool {
 
 // AddReturnRule adds a return rule for the chain in the filter table
 func AddReturnRule(chain string) error {
+	return GetIptable(Iptables).AddReturnRule(chain)
+}
+
+// AddReturnRule adds a return rule for the chain in the filter table
+func (iptable IPTable) AddReturnRule(chain string) error {
 	var (
 		table = Filter
 		args  = []string{"-j", "RETURN"}
 	)
 
-	if Exists(table, chain, args...) {
+	if iptable.Exists(table, chain, args...) {
 		return nil
 	}
 
-	err := RawCombinedOutput(append([]string{"-A", chain}, args...)...)
+	err := iptable.RawCombinedOutput(append([]string{"-A", chain}, args...)...)
 	if err != nil {
 		return fmt.Errorf("unable to add return rule in %s chain: %s", chain, err.Error())
 	}
@@ -554,19 +653,24 @@ func AddReturnRule(chain string) error {
 
 // EnsureJumpRule ensures the jump rule is on top
 func EnsureJumpRule(fromChain, toChain string) error {
+	return GetIptable(Iptables).EnsureJumpRule(fromChain, toChain)
+}
+
+// EnsureJumpRule ensures the jump rule is on top
+func (iptable IPTable) EnsureJumpRule(fromChain, toChain string) error {
 	var (
 		table = Filter
 		args  = []string{"-j", toChain}
 	)
 
-	if Exists(table, fromChain, args...) {
-		err := RawCombinedOutput(append([]string{"-D", fromChain}, args...)...)
+	if iptable.Exists(table, fromChain, args...) {
+		err := iptable.RawCombinedOutput(append([]string{"-D", fromChain}, args...)...)
 		if err != nil {
 			return fmt.Errorf("unable to remove jump to %s rule in %s chain: %s", toChain, fromChain, err.Error())
 		}
 	}
 
-	err := RawCombinedOutput(append([]string{"-I", fromChain}, args...)...)
+	err := iptable.RawCombinedOutput(append([]string{"-I", fromChain}, args...)...)
 	if err != nil {
 		return fmt.Errorf("unable to insert jump to %s rule in %s chain: %s", toChain, fromChain, err.Error())
 	}
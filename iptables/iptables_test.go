@@ -275,14 +275,14 @@ func TestExistsRaw(t *testing.T) {
 		if err != nil {
 			t.Fatalf("i=%d, err: %v", i, err)
 		}
-		if !existsRaw(Filter, testChain1, r.rule...) {
+		if !GetIptable(Iptables).existsRaw(Filter, testChain1, r.rule...) {
 			t.Fatalf("Failed to detect rule. i=%d", i)
 		}
 		// Truncate the rule
 		trg := r.rule[len(r.rule)-1]
 		trg = trg[:len(trg)-2]
 		r.rule[len(r.rule)-1] = trg
-		if existsRaw(Filter, testChain1, r.rule...) {
+		if GetIptable(Iptables).existsRaw(Filter, testChain1, r.rule...) {
 			t.Fatalf("Invalid detection. i=%d", i)
 		}
 	}
@@ -316,3 +316,56 @@ func TestSupportsCOption(t *testing.T) {
 		}
 	}
 }
+
+func TestIPv6Forward(t *testing.T) {
+	iptable := GetIptable(IP6Tables)
+
+	natChain6, err := iptable.NewChain(chainName, Nat, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer iptable.RemoveExistingChain(chainName, Nat)
+
+	filterChain6, err := iptable.NewChain(chainName, Filter, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer iptable.RemoveExistingChain(chainName, Filter)
+
+	ip := net.ParseIP("2001:db8::1")
+	port := 1234
+	dstAddr := "fd00::2"
+	dstPort := 4321
+	proto := "tcp"
+
+	err = natChain6.Forward(Insert, ip, port, proto, dstAddr, dstPort, "lo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dnatRule := []string{
+		"-d", ip.String(),
+		"-p", proto,
+		"--dport", strconv.Itoa(port),
+		"-j", "DNAT",
+		"--to-destination", "[" + dstAddr + "]:" + strconv.Itoa(dstPort),
+		"!", "-i", "lo",
+	}
+
+	if !iptable.Exists(natChain6.Table, natChain6.Name, dnatRule...) {
+		t.Fatal("DNAT rule does not exist")
+	}
+
+	if Exists(natChain6.Table, natChain6.Name, dnatRule...) {
+		t.Fatal("IPv6 DNAT rule unexpectedly found in iptables")
+	}
+
+	err = natChain6.Forward(Delete, ip, port, proto, dstAddr, dstPort, "lo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if iptable.Exists(filterChain6.Table, filterChain6.Name, "-d", dstAddr, "-j", "ACCEPT") {
+		t.Fatal("filter rule was not removed")
+	}
+}
@@ -0,0 +1,411 @@
+package iptables
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Transaction collects rule insertions and deletions and applies them at
+// once with a single 'iptables-restore --noflush' invocation, instead of one
+// iptables invocation, and xtables lock acquisition, per rule. If the restore
+// fails, the changes already committed are rolled back.
+//
+// The rules of a table are applied in the order they were added. When
+// firewalld is running, or iptables-restore is not available or does not
+// support the xtables lock, the rules are applied one by one with Raw.
+type Transaction struct {
+	iptable IPTable
+	rules   []txRule
+	// saved holds the rules of the tables read with iptables-save by
+	// ProgramRule, nil for a table which could not be read.
+	saved map[Table]map[string]bool
+}
+
+type txRule struct {
+	table  Table
+	chain  string
+	action Action
+	args   []string
+}
+
+// NewTransaction returns an empty iptables transaction.
+func NewTransaction() *Transaction {
+	return GetIptable(Iptables).NewTransaction()
+}
+
+// NewTransaction returns an empty iptables or ip6tables transaction.
+func (iptable IPTable) NewTransaction() *Transaction {
+	return &Transaction{iptable: iptable}
+}
+
+// Add adds the action on the rule specified by args to the transaction.
+func (t *Transaction) Add(table Table, chain string, action Action, args ...string) {
+	if string(table) == "" {
+		table = Filter
+	}
+	t.rules = append(t.rules, txRule{table: table, chain: chain, action: action, args: args})
+}
+
+// ProgramRule adds the insertion of the rule specified by args to the
+// transaction only if the rule is not already present in the chain.
+// Reciprocally, it adds its deletion only if present. The rules already in
+// the transaction are taken into account. The presence of the rules is
+// checked against a single iptables-save snapshot of their table, taken by
+// the first ProgramRule on the table, rather than with an 'iptables -C'
+// invocation per rule.
+func (t *Transaction) ProgramRule(table Table, chain string, action Action, args []string) {
+	if string(table) == "" {
+		table = Filter
+	}
+	if t.exists(table, chain, args) != (action == Delete) {
+		return
+	}
+	t.Add(table, chain, action, args...)
+}
+
+// exists tells whether the rule is present once the transaction is applied.
+func (t *Transaction) exists(table Table, chain string, args []string) bool {
+	for i := len(t.rules) - 1; i >= 0; i-- {
+		if r := t.rules[i]; r.matches(table, chain, args) {
+			return r.action != Delete
+		}
+	}
+	if saved := t.snapshot(table); saved != nil {
+		return saved[ruleKey(chain, args)]
+	}
+	return t.iptable.Exists(table, chain, args...)
+}
+
+// snapshot returns the rules of table, read once per transaction with
+// iptables-save, or nil if they cannot be read.
+func (t *Transaction) snapshot(table Table) map[string]bool {
+	if saved, ok := t.saved[table]; ok {
+		return saved
+	}
+	if t.saved == nil {
+		t.saved = make(map[Table]map[string]bool)
+	}
+	output, err := t.iptable.save(table)
+	if err != nil {
+		logrus.Debugf("Checking the %s rules one by one: %v", t.iptable.binary(), err)
+		t.saved[table] = nil
+		return nil
+	}
+	t.saved[table] = parseSave(output)
+	return t.saved[table]
+}
+
+// Len returns the number of rule changes in the transaction.
+func (t *Transaction) Len() int {
+	return len(t.rules)
+}
+
+// Commit applies the transaction. On failure the rules of the transaction
+// which were applied are reverted. The transaction is emptied either way.
+func (t *Transaction) Commit() error {
+	rules := t.rules
+	t.rules, t.saved = nil, nil
+	if len(rules) == 0 {
+		return nil
+	}
+	if err := t.iptable.initCheck(); err != nil {
+		return err
+	}
+
+	if firewalldRunning || !t.iptable.supportsRestore() {
+		return t.iptable.applyRaw(rules)
+	}
+
+	if err := t.iptable.restore(restoreInput(rules)); err != nil {
+		t.iptable.rollback(rules)
+		return err
+	}
+	return nil
+}
+
+func (r txRule) matches(table Table, chain string, args []string) bool {
+	if r.table != table || r.chain != chain || len(r.args) != len(args) {
+		return false
+	}
+	for i := range args {
+		if r.args[i] != args[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// inverse returns the rule reverting r. The rules which were deleted are
+// restored at the top of their chain.
+func (r txRule) inverse() txRule {
+	inv := r
+	if r.action == Delete {
+		inv.action = Insert
+	} else {
+		inv.action = Delete
+	}
+	return inv
+}
+
+func (r txRule) rawArgs() []string {
+	return append([]string{"-t", string(r.table), string(r.action), r.chain}, r.args...)
+}
+
+// restoreInput returns the iptables-restore input applying rules, grouped by
+// table in the order the tables first appear.
+func restoreInput(rules []txRule) string {
+	var (
+		tables  []Table
+		byTable = map[Table][]txRule{}
+	)
+	for _, r := range rules {
+		if _, ok := byTable[r.table]; !ok {
+			tables = append(tables, r.table)
+		}
+		byTable[r.table] = append(byTable[r.table], r)
+	}
+
+	var b bytes.Buffer
+	for _, table := range tables {
+		fmt.Fprintf(&b, "*%s\n", table)
+		for _, r := range byTable[table] {
+			b.WriteString(string(r.action) + " " + r.chain)
+			for _, arg := range r.args {
+				b.WriteString(" " + quoteRestoreArg(arg))
+			}
+			b.WriteString("\n")
+		}
+		b.WriteString("COMMIT\n")
+	}
+	return b.String()
+}
+
+func quoteRestoreArg(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\"'") {
+		return arg
+	}
+	return `"` + strings.Replace(arg, `"`, `\"`, -1) + `"`
+}
+
+// rollback reverts the rules of a failed restore. iptables-restore commits
+// each table atomically, so the tables are reverted separately: the ones
+// committed before the failure are restored, the others fail to and are
+// left untouched.
+func (iptable IPTable) rollback(rules []txRule) {
+	var tables []Table
+	seen := map[Table]bool{}
+	for _, r := range rules {
+		if !seen[r.table] {
+			seen[r.table] = true
+			tables = append(tables, r.table)
+		}
+	}
+	for _, table := range tables {
+		var inverse []txRule
+		for i := len(rules) - 1; i >= 0; i-- {
+			if rules[i].table == table {
+				inverse = append(inverse, rules[i].inverse())
+			}
+		}
+		if err := iptable.restore(restoreInput(inverse)); err != nil {
+			logrus.Debugf("Rollback of %s table %s not applied: %v", iptable.binary(), table, err)
+		}
+	}
+}
+
+// applyRaw applies the rules one by one, reverting the ones applied if one
+// of them fails.
+func (iptable IPTable) applyRaw(rules []txRule) error {
+	for i, r := range rules {
+		if err := iptable.RawCombinedOutput(r.rawArgs()...); err != nil {
+			for j := i - 1; j >= 0; j-- {
+				if err := iptable.RawCombinedOutput(rules[j].inverse().rawArgs()...); err != nil {
+					logrus.Warnf("Failed to rollback %s rule %v: %v", iptable.binary(), rules[j].rawArgs(), err)
+				}
+			}
+			return fmt.Errorf("%s rule %v failed: %v", iptable.binary(), r.rawArgs(), err)
+		}
+	}
+	return nil
+}
+
+func (iptable IPTable) restorePath() string {
+	if iptable.Version == IP6Tables {
+		return ip6tablesRestorePath
+	}
+	return iptablesRestorePath
+}
+
+// supportsRestore tells whether iptables-restore can be used. Without the
+// xtables lock, it could race with the other iptables invocations.
+func (iptable IPTable) supportsRestore() bool {
+	return iptable.restorePath() != "" && supportsRestoreWait
+}
+
+func (iptable IPTable) savePath() string {
+	if iptable.Version == IP6Tables {
+		return ip6tablesSavePath
+	}
+	return iptablesSavePath
+}
+
+// save returns the iptables-save output of table.
+func (iptable IPTable) save(table Table) (string, error) {
+	if err := iptable.initCheck(); err != nil {
+		return "", err
+	}
+	if iptable.savePath() == "" {
+		return "", fmt.Errorf("%s-save not found", iptable.binary())
+	}
+	output, err := exec.Command(iptable.savePath(), "-t", string(table)).Output()
+	if err != nil {
+		return "", fmt.Errorf("%s-save failed: %v", iptable.binary(), err)
+	}
+	return string(output), nil
+}
+
+// parseSave returns the rules of an iptables-save output, keyed by ruleKey.
+func parseSave(output string) map[string]bool {
+	rules := make(map[string]bool)
+	for _, line := range strings.Split(output, "\n") {
+		if !strings.HasPrefix(line, "-A ") {
+			continue
+		}
+		fields := splitSaveLine(line)
+		if len(fields) < 2 {
+			continue
+		}
+		rules[ruleKey(fields[1], fields[2:])] = true
+	}
+	return rules
+}
+
+// splitSaveLine splits a line of iptables-save output in its arguments,
+// unquoting the double quoted ones.
+func splitSaveLine(line string) []string {
+	var (
+		fields []string
+		field  strings.Builder
+		quoted bool
+		inArg  bool
+	)
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == '\\' && quoted && i+1 < len(line) && line[i+1] == '"':
+			field.WriteByte('"')
+			i++
+		case c == '"':
+			quoted = !quoted
+			inArg = true
+		case (c == ' ' || c == '\t') && !quoted:
+			if inArg {
+				fields = append(fields, field.String())
+				field.Reset()
+				inArg = false
+			}
+		default:
+			field.WriteByte(c)
+			inArg = true
+		}
+	}
+	if inArg {
+		fields = append(fields, field.String())
+	}
+	return fields
+}
+
+// longOptions maps the long forms of the options iptables-save prints in
+// their short form.
+var longOptions = map[string]string{
+	"--source":        "-s",
+	"--destination":   "-d",
+	"--protocol":      "-p",
+	"--in-interface":  "-i",
+	"--out-interface": "-o",
+	"--jump":          "-j",
+	"--match":         "-m",
+}
+
+// ruleKey returns the key of the rule specified by args in chain, in a form
+// common to the rules as written by libnetwork and as printed by
+// iptables-save: the options are compared regardless of their order, the
+// host addresses with their prefix length, and without the protocol match
+// iptables-save adds for -p.
+func ruleKey(chain string, args []string) string {
+	var (
+		opts  []string
+		proto string
+	)
+	for i := 0; i < len(args); i++ {
+		opt := args[i]
+		neg := opt == "!"
+		if neg && i+1 < len(args) {
+			i++
+			opt = args[i]
+		}
+		if long, ok := longOptions[opt]; ok {
+			opt = long
+		}
+		var values []string
+		for i+1 < len(args) && args[i+1] != "!" && !strings.HasPrefix(args[i+1], "-") {
+			i++
+			values = append(values, args[i])
+		}
+		if (opt == "-s" || opt == "-d") && len(values) == 1 && !strings.Contains(values[0], "/") {
+			if ip := net.ParseIP(values[0]); ip != nil {
+				if ip.To4() != nil {
+					values[0] += "/32"
+				} else {
+					values[0] += "/128"
+				}
+			}
+		}
+		if opt == "-p" && len(values) == 1 {
+			proto = values[0]
+		}
+		group := strings.Join(append([]string{opt}, values...), " ")
+		if neg {
+			group = "! " + group
+		}
+		opts = append(opts, group)
+	}
+
+	key := []string{chain}
+	for _, o := range opts {
+		if proto != "" && o == "-m "+proto {
+			continue
+		}
+		key = append(key, o)
+	}
+	sort.Strings(key[1:])
+	return strings.Join(key, " ")
+}
+
+func (iptable IPTable) restore(input string) error {
+	args := []string{"--noflush", "--wait"}
+	logrus.Debugf("%s, %v:\n%s", iptable.restorePath(), args, input)
+
+	cmd := exec.Command(iptable.restorePath(), args...)
+	cmd.Stdin = strings.NewReader(input)
+	startTime := time.Now()
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s-restore failed: %s (%v)", iptable.binary(), strings.TrimSpace(string(output)), err)
+	}
+	filterOutput(startTime, output, args...)
+	return nil
+}
+
+// iptables-restore --wait option was added in v1.6.2
+// http://ftp.netfilter.org/pub/iptables/changes-iptables-1.6.2.txt
+func supportsRestoreWaitOption(mj, mn, mc int) bool {
+	return mj > 1 || (mj == 1 && (mn > 6 || (mn == 6 && mc >= 2)))
+}
@@ -0,0 +1,119 @@
+package iptables
+
+import (
+	"testing"
+
+	"github.com/docker/libnetwork/testutils"
+)
+
+func TestTransactionRestoreInput(t *testing.T) {
+	tx := NewTransaction()
+	tx.Add(Nat, "PREROUTING", Append, "-m", "addrtype", "--dst-type", "LOCAL", "-j", chainName)
+	tx.Add(Filter, "FORWARD", Insert, "-o", "lo", "-j", chainName)
+	tx.Add(Nat, "OUTPUT", Delete, "-m", "comment", "--comment", "a comment", "-j", chainName)
+
+	expected := "*nat\n" +
+		"-A PREROUTING -m addrtype --dst-type LOCAL -j " + chainName + "\n" +
+		"-D OUTPUT -m comment --comment \"a comment\" -j " + chainName + "\n" +
+		"COMMIT\n" +
+		"*filter\n" +
+		"-I FORWARD -o lo -j " + chainName + "\n" +
+		"COMMIT\n"
+	if input := restoreInput(tx.rules); input != expected {
+		t.Fatalf("Unexpected restore input:\n%s\nexpected:\n%s", input, expected)
+	}
+
+	var inverse []txRule
+	for i := len(tx.rules) - 1; i >= 0; i-- {
+		inverse = append(inverse, tx.rules[i].inverse())
+	}
+	expected = "*nat\n" +
+		"-I OUTPUT -m comment --comment \"a comment\" -j " + chainName + "\n" +
+		"-D PREROUTING -m addrtype --dst-type LOCAL -j " + chainName + "\n" +
+		"COMMIT\n" +
+		"*filter\n" +
+		"-D FORWARD -o lo -j " + chainName + "\n" +
+		"COMMIT\n"
+	if input := restoreInput(inverse); input != expected {
+		t.Fatalf("Unexpected rollback input:\n%s\nexpected:\n%s", input, expected)
+	}
+}
+
+func TestTransactionSnapshot(t *testing.T) {
+	output := "# Generated by iptables-save\n" +
+		"*nat\n" +
+		":PREROUTING ACCEPT [0:0]\n" +
+		"-A PREROUTING -m addrtype --dst-type LOCAL -j DOCKER\n" +
+		"-A POSTROUTING -s 172.17.0.0/16 ! -o docker0 -j MASQUERADE\n" +
+		"-A DOCKER -d 10.0.0.1/32 ! -i docker0 -p tcp -m tcp --dport 80 -j DNAT --to-destination 172.17.0.2:80\n" +
+		"-A DOCKER -m comment --comment \"a \\\"quoted\\\" comment\" -j RETURN\n" +
+		"COMMIT\n"
+
+	tx := NewTransaction()
+	tx.saved = map[Table]map[string]bool{Nat: parseSave(output)}
+	for _, r := range []struct {
+		chain  string
+		args   []string
+		exists bool
+	}{
+		{"PREROUTING", []string{"-m", "addrtype", "--dst-type", "LOCAL", "-j", "DOCKER"}, true},
+		{"POSTROUTING", []string{"-s", "172.17.0.0/16", "!", "-o", "docker0", "-j", "MASQUERADE"}, true},
+		{"POSTROUTING", []string{"-s", "172.17.0.0/16", "-o", "docker0", "-j", "MASQUERADE"}, false},
+		{"DOCKER", []string{"-p", "tcp", "-d", "10.0.0.1", "--dport", "80", "!", "-i", "docker0", "-j", "DNAT", "--to-destination", "172.17.0.2:80"}, true},
+		{"DOCKER", []string{"-p", "tcp", "-d", "10.0.0.1", "--dport", "81", "!", "-i", "docker0", "-j", "DNAT", "--to-destination", "172.17.0.2:80"}, false},
+		{"DOCKER", []string{"-m", "comment", "--comment", `a "quoted" comment`, "-j", "RETURN"}, true},
+		{"OUTPUT", []string{"-m", "addrtype", "--dst-type", "LOCAL", "-j", "DOCKER"}, false},
+	} {
+		if exists := tx.exists(Nat, r.chain, r.args); exists != r.exists {
+			t.Fatalf("Unexpected presence %v of rule %s %v", exists, r.chain, r.args)
+		}
+	}
+}
+
+func TestTransactionProgramRule(t *testing.T) {
+	if !testutils.IsRunningInContainer() {
+		t.Skip("Skipping test when not running inside a Container")
+	}
+	defer testutils.SetupTestOSContext(t)()
+
+	rule := []string{"-i", "docker-txtest", "-j", chainName}
+	if _, err := NewChain(chainName, Filter, false); err != nil {
+		t.Fatal(err)
+	}
+	defer GetIptable(Iptables).RemoveExistingChain(chainName, Filter)
+
+	tx := NewTransaction()
+	tx.ProgramRule(Filter, chainName, Delete, rule)
+	if tx.Len() != 0 {
+		t.Fatalf("Expected no deletion of a missing rule, got %d changes", tx.Len())
+	}
+
+	tx.ProgramRule(Filter, chainName, Insert, rule)
+	tx.ProgramRule(Filter, chainName, Append, rule)
+	if tx.Len() != 1 {
+		t.Fatalf("Expected the rule to be added once, got %d changes", tx.Len())
+	}
+
+	tx.ProgramRule(Filter, chainName, Delete, rule)
+	if tx.Len() != 2 || tx.rules[1].action != Delete {
+		t.Fatalf("Expected the rule added by the transaction to be deleted, got %v", tx.rules)
+	}
+}
+
+func TestSupportsRestoreWaitOption(t *testing.T) {
+	input := []struct {
+		mj, mn, mc int
+		expected   bool
+	}{
+		{1, 4, 21, false},
+		{1, 6, 1, false},
+		{1, 6, 2, true},
+		{1, 8, 0, true},
+		{2, 0, 0, true},
+	}
+	for _, i := range input {
+		if supportsRestoreWaitOption(i.mj, i.mn, i.mc) != i.expected {
+			t.Fatalf("Unexpected --wait support for iptables-restore v%d.%d.%d", i.mj, i.mn, i.mc)
+		}
+	}
+}
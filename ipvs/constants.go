@@ -88,6 +88,15 @@ const (
 	ipvsDestAttrAddressFamily
 )
 
+// Attributes used to describe a connection synchronization daemon.
+// Used inside nested attribute ipvsCmdAttrDaemon.
+const (
+	ipvsDaemonAttrUnspec int = iota
+	ipvsDaemonAttrState
+	ipvsDaemonAttrMcastIfn
+	ipvsDaemonAttrSyncID
+)
+
 // IPVS Svc Statistics constancs
 
 const (
@@ -126,6 +135,17 @@ const (
 	ConnectionFlagDirectRoute = 0x0003
 )
 
+// Connection synchronization daemon states
+const (
+	// SyncStateMaster is used for a daemon which sends connection
+	// state updates to the backup daemons.
+	SyncStateMaster = 0x0001
+
+	// SyncStateBackup is used for a daemon which receives
+	// connection state updates from a master daemon.
+	SyncStateBackup = 0x0002
+)
+
 const (
 	// RoundRobin distributes jobs equally amongst the available
 	// real servers.
@@ -62,6 +62,21 @@ type Destination struct {
 	LowerThreshold  uint32
 }
 
+// Config defines the IPVS connection timeouts of a namespace. A zero
+// value leaves the corresponding kernel setting unchanged.
+type Config struct {
+	TimeoutTCP    time.Duration
+	TimeoutTCPFin time.Duration
+	TimeoutUDP    time.Duration
+}
+
+// SyncDaemon defines an IPVS connection synchronization daemon.
+type SyncDaemon struct {
+	State    uint32 // SyncStateMaster or SyncStateBackup
+	McastIfn string // Interface used to send or receive sync messages.
+	SyncID   uint32
+}
+
 // Handle provides a namespace specific ipvs handle to program ipvs
 // rules.
 type Handle struct {
@@ -182,3 +197,31 @@ func (i *Handle) GetService(s *Service) (*Service, error) {
 
 	return res[0], nil
 }
+
+// GetConfig returns the current timeout configuration
+func (i *Handle) GetConfig() (*Config, error) {
+	return i.doGetConfigCmd()
+}
+
+// SetConfig set the current timeout configuration. 0: no change
+func (i *Handle) SetConfig(c *Config) error {
+	return i.doSetConfigCmd(c)
+}
+
+// StartSyncDaemon starts an IPVS connection synchronization daemon
+// in the passed handle.
+func (i *Handle) StartSyncDaemon(d *SyncDaemon) error {
+	return i.doDaemonCmd(d, ipvsCmdNewDaemon)
+}
+
+// StopSyncDaemon stops the IPVS connection synchronization daemon
+// running in the passed state in the passed handle.
+func (i *Handle) StopSyncDaemon(d *SyncDaemon) error {
+	return i.doDaemonCmd(d, ipvsCmdDelDaemon)
+}
+
+// GetSyncDaemons returns an array of connection synchronization
+// daemons running in the passed handle.
+func (i *Handle) GetSyncDaemons() ([]*SyncDaemon, error) {
+	return i.doGetSyncDaemonsCmd()
+}
@@ -6,6 +6,7 @@ import (
 	"net"
 	"syscall"
 	"testing"
+	"time"
 
 	"github.com/docker/libnetwork/testutils"
 	"github.com/stretchr/testify/assert"
@@ -342,3 +343,108 @@ func TestDestination(t *testing.T) {
 		}
 	}
 }
+
+func TestIPv6Destination(t *testing.T) {
+	defer testutils.SetupTestOSContext(t)()
+
+	i, err := New("")
+	require.NoError(t, err)
+
+	s := Service{
+		AddressFamily: nl.FAMILY_V6,
+		FWMark:        1234,
+		SchedName:     RoundRobin,
+	}
+
+	err = i.NewService(&s)
+	require.NoError(t, err)
+	checkService(t, i, &s, true)
+
+	s.SchedName = ""
+	d := Destination{
+		AddressFamily: nl.FAMILY_V6,
+		Address:       net.ParseIP("fd00::2"),
+		Weight:        1,
+	}
+
+	err = i.NewDestination(&s, &d)
+	require.NoError(t, err)
+	checkDestination(t, i, &s, &d, true)
+
+	dstArray, err := i.GetDestinations(&s)
+	require.NoError(t, err)
+	for _, dst := range dstArray {
+		assert.Equal(t, uint16(nl.FAMILY_V6), dst.AddressFamily)
+	}
+
+	err = i.DelDestination(&s, &d)
+	require.NoError(t, err)
+	checkDestination(t, i, &s, &d, false)
+
+	err = i.DelService(&s)
+	require.NoError(t, err)
+	checkService(t, i, &s, false)
+}
+
+func TestTimeouts(t *testing.T) {
+	if testutils.RunningOnCircleCI() {
+		t.Skip("Skipping as not supported on CIRCLE CI kernel")
+	}
+	defer testutils.SetupTestOSContext(t)()
+
+	i, err := New("")
+	require.NoError(t, err)
+	defer i.Close()
+
+	_, err = i.GetConfig()
+	require.NoError(t, err)
+
+	cfg := Config{66 * time.Second, 66 * time.Second, 66 * time.Second}
+	err = i.SetConfig(&cfg)
+	require.NoError(t, err)
+
+	c2, err := i.GetConfig()
+	require.NoError(t, err)
+	assert.Equal(t, cfg, *c2)
+
+	// A zero timeout leaves the current value untouched
+	err = i.SetConfig(&Config{TimeoutTCP: 77 * time.Second})
+	require.NoError(t, err)
+
+	c3, err := i.GetConfig()
+	require.NoError(t, err)
+	assert.Equal(t, Config{77 * time.Second, 66 * time.Second, 66 * time.Second}, *c3)
+}
+
+func TestSyncDaemon(t *testing.T) {
+	if testutils.RunningOnCircleCI() {
+		t.Skip("Skipping as not supported on CIRCLE CI kernel")
+	}
+	defer testutils.SetupTestOSContext(t)()
+
+	createDummyInterface(t)
+	i, err := New("")
+	require.NoError(t, err)
+	defer i.Close()
+
+	d := SyncDaemon{
+		State:    SyncStateBackup,
+		McastIfn: "dummy",
+		SyncID:   42,
+	}
+
+	err = i.StartSyncDaemon(&d)
+	require.NoError(t, err)
+
+	daemons, err := i.GetSyncDaemons()
+	require.NoError(t, err)
+	require.Len(t, daemons, 1)
+	assert.Equal(t, d, *daemons[0])
+
+	err = i.StopSyncDaemon(&d)
+	require.NoError(t, err)
+
+	daemons, err = i.GetSyncDaemons()
+	require.NoError(t, err)
+	assert.Len(t, daemons, 0)
+}
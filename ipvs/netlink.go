@@ -12,6 +12,7 @@ import (
 	"sync"
 	"sync/atomic"
 	"syscall"
+	"time"
 	"unsafe"
 
 	"github.com/sirupsen/logrus"
@@ -104,6 +105,9 @@ func fillDestinaton(d *Destination) nl.NetlinkRequestData {
 	cmdAttr := nl.NewRtAttr(ipvsCmdAttrDest, nil)
 
 	nl.NewRtAttrChild(cmdAttr, ipvsDestAttrAddress, rawIPData(d.Address))
+	if d.AddressFamily != 0 {
+		nl.NewRtAttrChild(cmdAttr, ipvsDestAttrAddressFamily, nl.Uint16Attr(d.AddressFamily))
+	}
 	// Port needs to be in network byte order.
 	portBuf := new(bytes.Buffer)
 	binary.Write(portBuf, binary.BigEndian, d.Port)
@@ -415,6 +419,7 @@ func (i *Handle) doCmdWithoutAttr(cmd uint8) ([][]byte, error) {
 func assembleDestination(attrs []syscall.NetlinkRouteAttr) (*Destination, error) {
 
 	var d Destination
+	var addressBytes []byte
 
 	for _, attr := range attrs {
 
@@ -422,11 +427,7 @@ func assembleDestination(attrs []syscall.NetlinkRouteAttr) (*Destination, error)
 
 		switch attrType {
 		case ipvsDestAttrAddress:
-			ip, err := parseIP(attr.Value, syscall.AF_INET)
-			if err != nil {
-				return nil, err
-			}
-			d.Address = ip
+			addressBytes = attr.Value
 		case ipvsDestAttrPort:
 			d.Port = binary.BigEndian.Uint16(attr.Value)
 		case ipvsDestAttrForwardingMethod:
@@ -441,6 +442,21 @@ func assembleDestination(attrs []syscall.NetlinkRouteAttr) (*Destination, error)
 			d.AddressFamily = native.Uint16(attr.Value)
 		}
 	}
+
+	// The address can only be parsed once the family is known, older
+	// kernels do not report it so assume IPv4.
+	if addressBytes != nil {
+		family := d.AddressFamily
+		if family == 0 {
+			family = syscall.AF_INET
+		}
+		ip, err := parseIP(addressBytes, family)
+		if err != nil {
+			return nil, err
+		}
+		d.Address = ip
+	}
+
 	return &d, nil
 }
 
@@ -493,6 +509,148 @@ func (i *Handle) doGetDestinationsCmd(s *Service, d *Destination) ([]*Destinatio
 	return res, nil
 }
 
+// parseConfig given a ipvs netlink response this function will respond with a valid config entry, an error otherwise
+func (i *Handle) parseConfig(msg []byte) (*Config, error) {
+	var c Config
+
+	//Remove General header for this message
+	hdr := deserializeGenlMsg(msg)
+	attrs, err := nl.ParseRouteAttr(msg[hdr.Len():])
+	if err != nil {
+		return nil, err
+	}
+
+	for _, attr := range attrs {
+		attrType := int(attr.Attr.Type)
+		switch attrType {
+		case ipvsCmdAttrTimeoutTCP:
+			c.TimeoutTCP = time.Duration(native.Uint32(attr.Value)) * time.Second
+		case ipvsCmdAttrTimeoutTCPFin:
+			c.TimeoutTCPFin = time.Duration(native.Uint32(attr.Value)) * time.Second
+		case ipvsCmdAttrTimeoutUDP:
+			c.TimeoutUDP = time.Duration(native.Uint32(attr.Value)) * time.Second
+		}
+	}
+
+	return &c, nil
+}
+
+// doGetConfigCmd a wrapper function to be used by GetConfig
+func (i *Handle) doGetConfigCmd() (*Config, error) {
+	msg, err := i.doCmdWithoutAttr(ipvsCmdGetConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(msg) == 0 {
+		return nil, fmt.Errorf("error no valid netlink message found while getting the ipvs config")
+	}
+
+	return i.parseConfig(msg[0])
+}
+
+// doSetConfigCmd a wrapper function to be used by SetConfig
+func (i *Handle) doSetConfigCmd(c *Config) error {
+	req := newIPVSRequest(ipvsCmdSetConfig)
+	req.Seq = atomic.AddUint32(&i.seq, 1)
+
+	req.AddData(nl.NewRtAttr(ipvsCmdAttrTimeoutTCP, nl.Uint32Attr(uint32(c.TimeoutTCP.Seconds()))))
+	req.AddData(nl.NewRtAttr(ipvsCmdAttrTimeoutTCPFin, nl.Uint32Attr(uint32(c.TimeoutTCPFin.Seconds()))))
+	req.AddData(nl.NewRtAttr(ipvsCmdAttrTimeoutUDP, nl.Uint32Attr(uint32(c.TimeoutUDP.Seconds()))))
+
+	_, err := execute(i.sock, req, 0)
+
+	return err
+}
+
+func fillDaemon(d *SyncDaemon, cmd uint8) nl.NetlinkRequestData {
+	cmdAttr := nl.NewRtAttr(ipvsCmdAttrDaemon, nil)
+
+	nl.NewRtAttrChild(cmdAttr, ipvsDaemonAttrState, nl.Uint32Attr(d.State))
+	// Stopping a daemon only needs its state.
+	if cmd == ipvsCmdNewDaemon {
+		nl.NewRtAttrChild(cmdAttr, ipvsDaemonAttrMcastIfn, nl.ZeroTerminated(d.McastIfn))
+		nl.NewRtAttrChild(cmdAttr, ipvsDaemonAttrSyncID, nl.Uint32Attr(d.SyncID))
+	}
+
+	return cmdAttr
+}
+
+// doDaemonCmd a wrapper function to be used by StartSyncDaemon and StopSyncDaemon
+func (i *Handle) doDaemonCmd(d *SyncDaemon, cmd uint8) error {
+	req := newIPVSRequest(cmd)
+	req.Seq = atomic.AddUint32(&i.seq, 1)
+	req.AddData(fillDaemon(d, cmd))
+
+	_, err := execute(i.sock, req, 0)
+
+	return err
+}
+
+func assembleSyncDaemon(attrs []syscall.NetlinkRouteAttr) (*SyncDaemon, error) {
+
+	var d SyncDaemon
+
+	for _, attr := range attrs {
+
+		attrType := int(attr.Attr.Type)
+
+		switch attrType {
+		case ipvsDaemonAttrState:
+			d.State = native.Uint32(attr.Value)
+		case ipvsDaemonAttrMcastIfn:
+			d.McastIfn = nl.BytesToString(attr.Value)
+		case ipvsDaemonAttrSyncID:
+			d.SyncID = native.Uint32(attr.Value)
+		}
+	}
+	return &d, nil
+}
+
+// parseSyncDaemon given a ipvs netlink response this function will respond with a valid sync daemon entry, an error otherwise
+func (i *Handle) parseSyncDaemon(msg []byte) (*SyncDaemon, error) {
+	//Remove General header for this message
+	hdr := deserializeGenlMsg(msg)
+	NetLinkAttrs, err := nl.ParseRouteAttr(msg[hdr.Len():])
+	if err != nil {
+		return nil, err
+	}
+	if len(NetLinkAttrs) == 0 {
+		return nil, fmt.Errorf("error no valid netlink message found while parsing sync daemon record")
+	}
+
+	//Now Parse and get IPVS related attributes messages packed in this message.
+	ipvsAttrs, err := nl.ParseRouteAttr(NetLinkAttrs[0].Value)
+	if err != nil {
+		return nil, err
+	}
+
+	return assembleSyncDaemon(ipvsAttrs)
+}
+
+// doGetSyncDaemonsCmd a wrapper function to be used by GetSyncDaemons
+func (i *Handle) doGetSyncDaemonsCmd() ([]*SyncDaemon, error) {
+	var res []*SyncDaemon
+
+	req := newIPVSRequest(ipvsCmdGetDaemon)
+	req.Seq = atomic.AddUint32(&i.seq, 1)
+	req.Flags |= syscall.NLM_F_DUMP
+
+	msgs, err := execute(i.sock, req, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, msg := range msgs {
+		d, err := i.parseSyncDaemon(msg)
+		if err != nil {
+			return res, err
+		}
+		res = append(res, d)
+	}
+	return res, nil
+}
+
 // IPVS related netlink message format explained
 
 /* EACH NETLINK MSG is of the below format, this is what we will receive from execute() api.
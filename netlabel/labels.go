@@ -21,6 +21,10 @@ const (
 	// PortMap constant represents Port Mapping
 	PortMap = Prefix + ".portmap"
 
+	// PortMapProxyStats constant represents the counters of the userland proxies
+	// of the Port Mapping, in the same order
+	PortMapProxyStats = Prefix + ".portmap.proxystats"
+
 	// MacAddress constant represents Mac Address config of a Container
 	MacAddress = Prefix + ".endpoint.macaddress"
 
@@ -198,6 +198,20 @@ func (i *IpamInfo) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// lbTimeouts holds the connection timeouts programmed in the load
+// balancers of a network.
+type lbTimeouts struct {
+	TCP    time.Duration
+	TCPFin time.Duration
+	UDP    time.Duration
+}
+
+// lbSync holds the ipvs connection synchronization daemons run in the
+// load balancers of a network.
+type lbSync struct {
+	SyncID uint32
+}
+
 type network struct {
 	ctrlr          *controller
 	name           string
@@ -233,6 +247,8 @@ type network struct {
 	configOnly     bool
 	configFrom     string
 	loadBalancerIP net.IP
+	lbTimeouts     *lbTimeouts
+	lbSync         *lbSync
 	sync.Mutex
 }
 
@@ -475,6 +491,14 @@ func (n *network) CopyTo(o datastore.KVObject) error {
 	dstN.configOnly = n.configOnly
 	dstN.configFrom = n.configFrom
 	dstN.loadBalancerIP = n.loadBalancerIP
+	if n.lbTimeouts != nil {
+		t := *n.lbTimeouts
+		dstN.lbTimeouts = &t
+	}
+	if n.lbSync != nil {
+		s := *n.lbSync
+		dstN.lbSync = &s
+	}
 
 	// copy labels
 	if dstN.labels == nil {
@@ -592,6 +616,20 @@ func (n *network) MarshalJSON() ([]byte, error) {
 	netMap["configOnly"] = n.configOnly
 	netMap["configFrom"] = n.configFrom
 	netMap["loadBalancerIP"] = n.loadBalancerIP
+	if n.lbTimeouts != nil {
+		lbt, err := json.Marshal(n.lbTimeouts)
+		if err != nil {
+			return nil, err
+		}
+		netMap["lbTimeouts"] = string(lbt)
+	}
+	if n.lbSync != nil {
+		lbs, err := json.Marshal(n.lbSync)
+		if err != nil {
+			return nil, err
+		}
+		netMap["lbSync"] = string(lbs)
+	}
 	return json.Marshal(netMap)
 }
 
@@ -705,6 +743,16 @@ func (n *network) UnmarshalJSON(b []byte) (err error) {
 	if v, ok := netMap["loadBalancerIP"]; ok {
 		n.loadBalancerIP = net.ParseIP(v.(string))
 	}
+	if v, ok := netMap["lbTimeouts"]; ok {
+		if err := json.Unmarshal([]byte(v.(string)), &n.lbTimeouts); err != nil {
+			return err
+		}
+	}
+	if v, ok := netMap["lbSync"]; ok {
+		if err := json.Unmarshal([]byte(v.(string)), &n.lbSync); err != nil {
+			return err
+		}
+	}
 	// Reconcile old networks with the recently added `--ipv6` flag
 	if !n.enableIPv6 {
 		n.enableIPv6 = len(n.ipamV6Info) > 0
@@ -812,6 +860,29 @@ func NetworkOptionLBEndpoint(ip net.IP) NetworkOption {
 	}
 }
 
+// NetworkOptionLBTimeouts function returns an option setter for the TCP,
+// TCP FIN and UDP connection timeouts of the load balancers of this network.
+// A zero timeout leaves the kernel default in place.
+func NetworkOptionLBTimeouts(tcp, tcpFin, udp time.Duration) NetworkOption {
+	return func(n *network) {
+		n.lbTimeouts = &lbTimeouts{
+			TCP:    tcp,
+			TCPFin: tcpFin,
+			UDP:    udp,
+		}
+	}
+}
+
+// NetworkOptionLBSyncDaemon function returns an option setter to run the
+// ipvs connection synchronization daemons, master and backup, with the
+// passed sync ID in the load balancers of this network, so the
+// connections survive a load balancer failover.
+func NetworkOptionLBSyncDaemon(syncID uint32) NetworkOption {
+	return func(n *network) {
+		n.lbSync = &lbSync{SyncID: syncID}
+	}
+}
+
 // NetworkOptionDriverOpts function returns an option setter for any driver parameter described by a map
 func NetworkOptionDriverOpts(opts map[string]string) NetworkOption {
 	return func(n *network) {
@@ -997,6 +1068,8 @@ func (n *network) delete(force bool) error {
 		goto removeFromStore
 	}
 
+	c.deleteNetworkPeerings(n.ID())
+
 	if err = n.deleteNetwork(); err != nil {
 		if !force {
 			return err
@@ -1041,6 +1114,11 @@ removeFromStore:
 		return fmt.Errorf("error deleting network from store: %v", err)
 	}
 
+	// Forget the encryption keys of the network
+	c.Lock()
+	delete(c.networkKeys, n.ID())
+	c.Unlock()
+
 	return nil
 }
 
@@ -1103,6 +1181,57 @@ func (n *network) CreateEndpoint(name string, options ...EndpointOption) (Endpoi
 
 }
 
+// inIPv6Pool returns whether the passed address belongs to one of the
+// network's IPv6 pools.
+func (n *network) inIPv6Pool(ip net.IP) bool {
+	n.Lock()
+	defer n.Unlock()
+
+	for _, info := range n.ipamV6Info {
+		if info.Pool != nil && info.Pool.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// reserveVIPv6 allocates the passed IPv6 virtual IP of a service from the
+// network's IPv6 pool it belongs to, so that it is not given to any endpoint.
+func (n *network) reserveVIPv6(vip net.IP) error {
+	ipam, _, err := n.getController().getIPAMDriver(n.ipamType)
+	if err != nil {
+		return err
+	}
+
+	for _, d := range n.getIPInfo(6) {
+		if d.Pool.Contains(vip) {
+			_, _, err := ipam.RequestAddress(d.PoolID, vip, nil)
+			return err
+		}
+	}
+
+	return types.BadRequestErrorf("IPv6 virtual IP %s is not part of any IPv6 pool of network %s", vip, n.Name())
+}
+
+// releaseVIPv6 releases the passed IPv6 virtual IP of a service to the
+// network's IPv6 pool it was allocated from.
+func (n *network) releaseVIPv6(vip net.IP) {
+	ipam, _, err := n.getController().getIPAMDriver(n.ipamType)
+	if err != nil {
+		logrus.Warnf("Failed to retrieve ipam driver to release virtual IP %s on network %s: %v", vip, n.Name(), err)
+		return
+	}
+
+	for _, d := range n.getIPInfo(6) {
+		if d.Pool.Contains(vip) {
+			if err := ipam.ReleaseAddress(d.PoolID, vip); err != nil {
+				logrus.Warnf("Failed to release virtual IP %s on network %s: %v", vip, n.Name(), err)
+			}
+			return
+		}
+	}
+}
+
 func (n *network) createEndpoint(name string, options ...EndpointOption) (Endpoint, error) {
 	var err error
 
@@ -1127,6 +1256,23 @@ func (n *network) createEndpoint(name string, options ...EndpointOption) (Endpoi
 		}
 	}
 
+	if len(ep.virtualIPv6) != 0 && !n.inIPv6Pool(ep.virtualIPv6) {
+		return nil, types.BadRequestErrorf("IPv6 virtual IP %s is not part of any IPv6 pool of network %s", ep.virtualIPv6, n.Name())
+	}
+
+	switch ep.lbMode {
+	case "", LBModeNAT:
+	case LBModeTunnel, LBModeDirectRoute:
+		if n.ingress {
+			return nil, types.BadRequestErrorf("load balancing mode %s is not supported on ingress network %s", ep.lbMode, n.Name())
+		}
+		if !dsrDrivers[n.Type()] {
+			return nil, types.BadRequestErrorf("load balancing mode %s is not supported by network driver %s", ep.lbMode, n.Type())
+		}
+	default:
+		return nil, types.BadRequestErrorf("invalid load balancing mode %q", ep.lbMode)
+	}
+
 	if opt, ok := ep.generic[netlabel.MacAddress]; ok {
 		if mac, ok := opt.(net.HardwareAddr); ok {
 			ep.iface.mac = mac
@@ -29,6 +29,7 @@ type neigh struct {
 	linkName string
 	linkDst  string
 	family   int
+	master   bool
 }
 
 func (n *networkNamespace) findNeighbor(dstIP net.IP, dstMac net.HardwareAddr) *neigh {
@@ -77,6 +78,9 @@ func (n *networkNamespace) DeleteNeighbor(dstIP net.IP, dstMac net.HardwareAddr,
 		if nlnh.Family > 0 {
 			nlnh.HardwareAddr = dstMac
 			nlnh.Flags = netlink.NTF_SELF
+			if nh.master {
+				nlnh.Flags = netlink.NTF_MASTER
+			}
 		}
 
 		if nh.linkDst != "" {
@@ -91,7 +95,7 @@ func (n *networkNamespace) DeleteNeighbor(dstIP net.IP, dstMac net.HardwareAddr,
 		}
 
 		// Delete the dynamic entry in the bridge
-		if nlnh.Family > 0 {
+		if nlnh.Family > 0 && !nh.master {
 			nlnh := &netlink.Neigh{
 				IP:     dstIP,
 				Family: nh.family,
@@ -171,6 +175,12 @@ func (n *networkNamespace) AddNeighbor(dstIP net.IP, dstMac net.HardwareAddr, fo
 
 	if nlnh.Family > 0 {
 		nlnh.Flags = netlink.NTF_SELF
+		if nh.master {
+			// Permanent entries of the bridge forwarding database are
+			// local to the bridge, the forwarded ones are static
+			nlnh.Flags = netlink.NTF_MASTER
+			nlnh.State = netlink.NUD_NOARP
+		}
 	}
 
 	if nh.linkDst != "" {
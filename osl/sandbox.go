@@ -78,6 +78,11 @@ type NeighborOptionSetter interface {
 	// Family returns an option setter to set the address family for the neighbor
 	// entry. eg. AF_BRIDGE
 	Family(int) NeighOption
+
+	// MasterFDB returns an option setter to program a bridge family entry into
+	// the forwarding database of the bridge the link is enslaved to, rather
+	// than into the one of the link itself
+	MasterFDB(bool) NeighOption
 }
 
 // IfaceOptionSetter interface defines the option setter methods for interface options.
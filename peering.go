@@ -0,0 +1,452 @@
+package libnetwork
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/docker/docker/pkg/stringid"
+	"github.com/docker/libnetwork/datastore"
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+// NetworkPeering allows the traffic between two networks across their
+// isolation, optionally limited to protocols and ports.
+type NetworkPeering interface {
+	// ID returns the unique identity of the peering
+	ID() string
+
+	// Name returns the user given name of the peering
+	Name() string
+
+	// Networks returns the IDs of the peered networks
+	Networks() (string, string)
+
+	// Rules returns the rules limiting the traffic the peering allows.
+	// Without rules all the traffic is allowed.
+	Rules() []types.PeeringRule
+
+	// Delete revokes the peering
+	Delete() error
+}
+
+// PeeringController is implemented by the network controllers managing
+// network peerings.
+type PeeringController interface {
+	// NewNetworkPeering allows the traffic between two networks, limited to the rules if any.
+	NewNetworkPeering(name string, nw1, nw2 Network, rules []types.PeeringRule) (NetworkPeering, error)
+
+	// NetworkPeerings returns the list of NetworkPeering(s) managed by this controller.
+	NetworkPeerings() []NetworkPeering
+
+	// WalkNetworkPeerings uses the provided function to walk the NetworkPeering(s) managed by this controller.
+	WalkNetworkPeerings(walker PeeringWalker)
+
+	// NetworkPeeringByName returns the NetworkPeering which has the passed name. If not found, a types.NotFoundError is returned.
+	NetworkPeeringByName(name string) (NetworkPeering, error)
+
+	// NetworkPeeringByID returns the NetworkPeering which has the passed id. If not found, a types.NotFoundError is returned.
+	NetworkPeeringByID(id string) (NetworkPeering, error)
+}
+
+// PeeringWalker is a client provided function which will be used to walk the network peerings.
+// When the function returns true, the walk will stop.
+type PeeringWalker func(p NetworkPeering) bool
+
+const peeringKeyPrefix = "network_peering"
+
+type networkPeering struct {
+	id       string
+	name     string
+	network1 string
+	network2 string
+	rules    []types.PeeringRule
+	ctrlr    *controller
+	dbIndex  uint64
+	dbExists bool
+	sync.Mutex
+}
+
+// peeringState is the persisted form of a network peering
+type peeringState struct {
+	ID       string
+	Name     string
+	Network1 string
+	Network2 string
+	Rules    []types.PeeringRule
+}
+
+func (p *networkPeering) ID() string {
+	p.Lock()
+	defer p.Unlock()
+	return p.id
+}
+
+func (p *networkPeering) Name() string {
+	p.Lock()
+	defer p.Unlock()
+	return p.name
+}
+
+func (p *networkPeering) Networks() (string, string) {
+	p.Lock()
+	defer p.Unlock()
+	return p.network1, p.network2
+}
+
+func (p *networkPeering) Rules() []types.PeeringRule {
+	p.Lock()
+	defer p.Unlock()
+	rules := make([]types.PeeringRule, len(p.rules))
+	copy(rules, p.rules)
+	return rules
+}
+
+func (p *networkPeering) peers(nid string) bool {
+	n1, n2 := p.Networks()
+	return n1 == nid || n2 == nid
+}
+
+func (p *networkPeering) MarshalJSON() ([]byte, error) {
+	p.Lock()
+	defer p.Unlock()
+	return json.Marshal(peeringState{
+		ID:       p.id,
+		Name:     p.name,
+		Network1: p.network1,
+		Network2: p.network2,
+		Rules:    p.rules,
+	})
+}
+
+func (p *networkPeering) UnmarshalJSON(b []byte) error {
+	var s peeringState
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	p.Lock()
+	defer p.Unlock()
+	p.id, p.name, p.network1, p.network2, p.rules = s.ID, s.Name, s.Network1, s.Network2, s.Rules
+	return nil
+}
+
+func (p *networkPeering) Key() []string {
+	return []string{peeringKeyPrefix, p.ID()}
+}
+
+func (p *networkPeering) KeyPrefix() []string {
+	return []string{peeringKeyPrefix}
+}
+
+func (p *networkPeering) Value() []byte {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+func (p *networkPeering) SetValue(value []byte) error {
+	return json.Unmarshal(value, p)
+}
+
+func (p *networkPeering) Index() uint64 {
+	p.Lock()
+	defer p.Unlock()
+	return p.dbIndex
+}
+
+func (p *networkPeering) SetIndex(index uint64) {
+	p.Lock()
+	p.dbIndex = index
+	p.dbExists = true
+	p.Unlock()
+}
+
+func (p *networkPeering) Exists() bool {
+	p.Lock()
+	defer p.Unlock()
+	return p.dbExists
+}
+
+func (p *networkPeering) Skip() bool {
+	return false
+}
+
+func (p *networkPeering) New() datastore.KVObject {
+	return &networkPeering{ctrlr: p.ctrlr}
+}
+
+func (p *networkPeering) CopyTo(o datastore.KVObject) error {
+	p.Lock()
+	defer p.Unlock()
+
+	dstP := o.(*networkPeering)
+	dstP.id = p.id
+	dstP.name = p.name
+	dstP.network1 = p.network1
+	dstP.network2 = p.network2
+	dstP.rules = make([]types.PeeringRule, len(p.rules))
+	copy(dstP.rules, p.rules)
+	dstP.ctrlr = p.ctrlr
+	dstP.dbIndex = p.dbIndex
+	dstP.dbExists = p.dbExists
+	return nil
+}
+
+func (p *networkPeering) DataScope() string {
+	return datastore.LocalScope
+}
+
+// peeringDriver returns the driver of the network if it supports peerings.
+func peeringDriver(n *network) (driverapi.Peerer, error) {
+	d, err := n.driver(true)
+	if err != nil {
+		return nil, err
+	}
+	pd, ok := d.(driverapi.Peerer)
+	if !ok {
+		return nil, types.NotImplementedErrorf("network %s of type %s does not support peerings", n.Name(), n.Type())
+	}
+	return pd, nil
+}
+
+func (c *controller) NewNetworkPeering(name string, nw1, nw2 Network, rules []types.PeeringRule) (NetworkPeering, error) {
+	if name == "" {
+		return nil, ErrInvalidName(name)
+	}
+	if nw1 == nil || nw2 == nil {
+		return nil, types.BadRequestErrorf("a network peering requires two networks")
+	}
+	if nw1.ID() == nw2.ID() {
+		return nil, types.BadRequestErrorf("cannot peer network %s with itself", nw1.Name())
+	}
+	if nw1.Type() != nw2.Type() {
+		return nil, types.BadRequestErrorf("cannot peer network %s of type %s with network %s of type %s", nw1.Name(), nw1.Type(), nw2.Name(), nw2.Type())
+	}
+	for _, r := range rules {
+		if err := r.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Lock the networks in a consistent order against their deletion
+	id1, id2 := nw1.ID(), nw2.ID()
+	if id2 < id1 {
+		id1, id2 = id2, id1
+	}
+	c.networkLocker.Lock(id1)
+	defer c.networkLocker.Unlock(id1)
+	c.networkLocker.Lock(id2)
+	defer c.networkLocker.Unlock(id2)
+
+	n1, err := c.getNetworkFromStore(nw1.ID())
+	if err != nil || n1.inDelete {
+		return nil, ErrNoSuchNetwork(nw1.Name())
+	}
+	n2, err := c.getNetworkFromStore(nw2.ID())
+	if err != nil || n2.inDelete {
+		return nil, ErrNoSuchNetwork(nw2.Name())
+	}
+
+	peerings, err := c.getNetworkPeeringsFromStore()
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range peerings {
+		if p.Name() == name {
+			return nil, types.ForbiddenErrorf("network peering with name %s already exists", name)
+		}
+		if p.peers(n1.ID()) && p.peers(n2.ID()) {
+			return nil, types.ForbiddenErrorf("networks %s and %s are already peered by %s", n1.Name(), n2.Name(), p.Name())
+		}
+	}
+
+	d, err := peeringDriver(n1)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &networkPeering{
+		id:       stringid.GenerateRandomID(),
+		name:     name,
+		network1: n1.ID(),
+		network2: n2.ID(),
+		rules:    rules,
+		ctrlr:    c,
+	}
+	if err := d.PeerNetworks(p.id, p.network1, p.network2, p.rules); err != nil {
+		return nil, err
+	}
+	if err := c.updateToStore(p); err != nil {
+		if errRb := d.UnpeerNetworks(p.id); errRb != nil {
+			logrus.Warnf("Failed to revoke network peering %s on failure: %v", name, errRb)
+		}
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func (c *controller) NetworkPeerings() []NetworkPeering {
+	var list []NetworkPeering
+	c.WalkNetworkPeerings(func(p NetworkPeering) bool {
+		list = append(list, p)
+		return false
+	})
+	return list
+}
+
+func (c *controller) WalkNetworkPeerings(walker PeeringWalker) {
+	peerings, err := c.getNetworkPeeringsFromStore()
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	for _, p := range peerings {
+		if walker(p) {
+			return
+		}
+	}
+}
+
+func (c *controller) NetworkPeeringByName(name string) (NetworkPeering, error) {
+	if name == "" {
+		return nil, ErrInvalidName(name)
+	}
+	var p NetworkPeering
+	c.WalkNetworkPeerings(func(current NetworkPeering) bool {
+		if current.Name() == name {
+			p = current
+			return true
+		}
+		return false
+	})
+	if p == nil {
+		return nil, types.NotFoundErrorf("network peering %s not found", name)
+	}
+	return p, nil
+}
+
+func (c *controller) NetworkPeeringByID(id string) (NetworkPeering, error) {
+	if id == "" {
+		return nil, ErrInvalidID(id)
+	}
+	p := &networkPeering{id: id, ctrlr: c}
+	store := c.getStore(p.DataScope())
+	if store == nil {
+		return nil, ErrDataStoreNotInitialized(p.DataScope())
+	}
+	if err := store.GetObject(datastore.Key(p.Key()...), p); err != nil {
+		if err == datastore.ErrKeyNotFound {
+			return nil, types.NotFoundErrorf("network peering %s not found", id)
+		}
+		return nil, fmt.Errorf("could not get network peering %s from store: %v", id, err)
+	}
+	return p, nil
+}
+
+func (p *networkPeering) Delete() error {
+	c := p.ctrlr
+	id1, id2 := p.Networks()
+	if id2 < id1 {
+		id1, id2 = id2, id1
+	}
+	c.networkLocker.Lock(id1)
+	defer c.networkLocker.Unlock(id1)
+	c.networkLocker.Lock(id2)
+	defer c.networkLocker.Unlock(id2)
+
+	return p.delete()
+}
+
+// delete revokes the peering in the driver of its networks and removes it
+// from the store. The caller holds the locks of the networks.
+func (p *networkPeering) delete() error {
+	c := p.ctrlr
+	nid, _ := p.Networks()
+	if n, err := c.getNetworkFromStore(nid); err == nil {
+		if d, err := peeringDriver(n); err == nil {
+			if err := d.UnpeerNetworks(p.ID()); err != nil {
+				if _, ok := err.(types.NotFoundError); !ok {
+					return err
+				}
+			}
+		}
+	}
+	if err := c.deleteFromStore(p); err != nil {
+		return fmt.Errorf("error deleting network peering %s from store: %v", p.Name(), err)
+	}
+	return nil
+}
+
+// deleteNetworkPeerings removes the peerings of a network being deleted.
+// The caller holds the lock of the network.
+func (c *controller) deleteNetworkPeerings(nid string) {
+	peerings, err := c.getNetworkPeeringsFromStore()
+	if err != nil {
+		logrus.Warnf("Failed to get the peerings of network %s: %v", nid, err)
+		return
+	}
+	for _, p := range peerings {
+		if !p.peers(nid) {
+			continue
+		}
+		if err := p.delete(); err != nil {
+			logrus.Warnf("Failed to delete network peering %s of network %s: %v", p.Name(), nid, err)
+		}
+	}
+}
+
+// restoreNetworkPeerings programs the stored peerings again in the drivers
+// of their networks, and deletes the ones of the networks no longer present.
+func (c *controller) restoreNetworkPeerings() {
+	peerings, err := c.getNetworkPeeringsFromStore()
+	if err != nil {
+		logrus.Warnf("Failed to restore the network peerings: %v", err)
+		return
+	}
+	for _, p := range peerings {
+		nid1, nid2 := p.Networks()
+		n1, err1 := c.getNetworkFromStore(nid1)
+		n2, err2 := c.getNetworkFromStore(nid2)
+		if err1 != nil || err2 != nil || n1.inDelete || n2.inDelete {
+			logrus.Debugf("Deleting stale network peering %s from store", p.Name())
+			if err := c.deleteFromStore(p); err != nil {
+				logrus.Warnf("Failed to delete stale network peering %s from store: %v", p.Name(), err)
+			}
+			continue
+		}
+		d, err := peeringDriver(n1)
+		if err == nil {
+			err = d.PeerNetworks(p.ID(), nid1, nid2, p.Rules())
+		}
+		if err != nil {
+			logrus.Warnf("Failed to restore network peering %s: %v", p.Name(), err)
+		}
+	}
+}
+
+func (c *controller) getNetworkPeeringsFromStore() ([]*networkPeering, error) {
+	store := c.getStore(datastore.LocalScope)
+	if store == nil {
+		return nil, ErrDataStoreNotInitialized(datastore.LocalScope)
+	}
+	kvol, err := store.List(datastore.Key(peeringKeyPrefix), &networkPeering{ctrlr: c})
+	if err != nil {
+		if err == datastore.ErrKeyNotFound {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get network peerings from store: %v", err)
+	}
+
+	peerings := make([]*networkPeering, 0, len(kvol))
+	for _, kvo := range kvol {
+		p := kvo.(*networkPeering)
+		p.ctrlr = c
+		peerings = append(peerings, p)
+	}
+	return peerings, nil
+}
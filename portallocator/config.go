@@ -0,0 +1,189 @@
+package portallocator
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PortRange is an inclusive range of transport ports
+type PortRange struct {
+	Begin int
+	End   int
+}
+
+// Contains tells whether port belongs to the range
+func (r PortRange) Contains(port int) bool {
+	return port >= r.Begin && port <= r.End
+}
+
+func (r PortRange) String() string {
+	if r.Begin == r.End {
+		return strconv.Itoa(r.Begin)
+	}
+	return getRangeKey(r.Begin, r.End)
+}
+
+// Config holds the settings of the automatic port allocation
+type Config struct {
+	// DynamicRanges are the ranges ports are picked from when no host port
+	// is requested, by host IP. IPs without an entry use the ephemeral range
+	// of the host.
+	DynamicRanges map[string]PortRange
+	// Excluded are the ports which are never picked by the automatic
+	// allocation, on any host IP. They can still be requested explicitly.
+	Excluded []PortRange
+}
+
+// ParsePortRange parses a port range in the form "begin-end", or a single port.
+func ParsePortRange(s string) (PortRange, error) {
+	var (
+		r   PortRange
+		err error
+	)
+	parts := strings.SplitN(strings.TrimSpace(s), "-", 2)
+	if r.Begin, err = strconv.Atoi(parts[0]); err != nil {
+		return PortRange{}, fmt.Errorf("invalid port range %q", s)
+	}
+	r.End = r.Begin
+	if len(parts) == 2 {
+		if r.End, err = strconv.Atoi(parts[1]); err != nil {
+			return PortRange{}, fmt.Errorf("invalid port range %q", s)
+		}
+	}
+	if err := r.validate(); err != nil {
+		return PortRange{}, err
+	}
+	return r, nil
+}
+
+// ParseConfig builds a Config from the dynamic port ranges by host IP and the
+// excluded ports in their string form, as accepted by ParsePortRange.
+func ParseConfig(dynamicRanges map[string]string, excluded []string) (Config, error) {
+	var cfg Config
+	if len(dynamicRanges) > 0 {
+		cfg.DynamicRanges = make(map[string]PortRange, len(dynamicRanges))
+		for ip, s := range dynamicRanges {
+			r, err := ParsePortRange(s)
+			if err != nil {
+				return Config{}, err
+			}
+			cfg.DynamicRanges[ip] = r
+		}
+	}
+	for _, s := range excluded {
+		r, err := ParsePortRange(s)
+		if err != nil {
+			return Config{}, err
+		}
+		cfg.Excluded = append(cfg.Excluded, r)
+	}
+	if err := cfg.validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+func (r PortRange) validate() error {
+	if r.Begin <= 0 || r.End > 65535 || r.End < r.Begin {
+		return fmt.Errorf("invalid port range: %s", getRangeKey(r.Begin, r.End))
+	}
+	return nil
+}
+
+func (cfg Config) validate() error {
+	for ip, r := range cfg.DynamicRanges {
+		if net.ParseIP(ip) == nil {
+			return fmt.Errorf("invalid host IP %q for port range %s", ip, r)
+		}
+		if err := r.validate(); err != nil {
+			return err
+		}
+	}
+	for _, r := range cfg.Excluded {
+		if err := r.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetConfig replaces the automatic allocation settings provided by source.
+// The settings of all the sources are combined: the excluded ports are merged,
+// and a host IP can only be given a dynamic range by one of them, or the
+// same range by several. Ports already allocated are left untouched.
+func (p *PortAllocator) SetConfig(source string, cfg Config) error {
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	configs := make(map[string]Config, len(p.configs)+1)
+	for s, c := range p.configs {
+		configs[s] = c
+	}
+	configs[source] = cfg
+
+	// Walk the sources in a stable order, for reproducible errors
+	sources := make([]string, 0, len(configs))
+	for s := range configs {
+		sources = append(sources, s)
+	}
+	sort.Strings(sources)
+
+	dynamicRanges := map[string]PortRange{}
+	owners := map[string]string{}
+	var excluded []PortRange
+	for _, s := range sources {
+		for ip, r := range configs[s].DynamicRanges {
+			ipstr := net.ParseIP(ip).String()
+			if prev, ok := dynamicRanges[ipstr]; ok && prev != r {
+				return fmt.Errorf("conflicting port ranges %s (%s) and %s (%s) for host IP %s", prev, owners[ipstr], r, s, ipstr)
+			}
+			dynamicRanges[ipstr] = r
+			owners[ipstr] = s
+		}
+		excluded = append(excluded, configs[s].Excluded...)
+	}
+
+	p.configs = configs
+	p.dynamicRanges = dynamicRanges
+	p.excluded = excluded
+	for ipstr, protomap := range p.ipMap {
+		r := p.dynamicRange(ipstr)
+		for _, mapping := range protomap {
+			mapping.setDefaultRange(r.Begin, r.End)
+		}
+	}
+	return nil
+}
+
+// dynamicRange returns the range ports are picked from on the host IP when
+// no host port is requested.
+func (p *PortAllocator) dynamicRange(ipstr string) PortRange {
+	if r, ok := p.dynamicRanges[ipstr]; ok {
+		return r
+	}
+	return PortRange{Begin: p.Begin, End: p.End}
+}
+
+func (p *PortAllocator) isExcluded(port int) bool {
+	for _, r := range p.excluded {
+		if r.Contains(port) {
+			return true
+		}
+	}
+	return false
+}
+
+func (pm *portMap) setDefaultRange(portStart, portEnd int) {
+	key := getRangeKey(portStart, portEnd)
+	if _, ok := pm.portRanges[key]; !ok {
+		pm.portRanges[key] = newPortRange(portStart, portEnd)
+	}
+	pm.defaultRange = key
+}
@@ -29,14 +29,16 @@ var (
 
 // ErrPortAlreadyAllocated is the returned error information when a requested port is already being used
 type ErrPortAlreadyAllocated struct {
-	ip   string
-	port int
+	ip    string
+	port  int
+	owner Owner
 }
 
-func newErrPortAlreadyAllocated(ip string, port int) ErrPortAlreadyAllocated {
+func newErrPortAlreadyAllocated(ip string, port int, owner Owner) ErrPortAlreadyAllocated {
 	return ErrPortAlreadyAllocated{
-		ip:   ip,
-		port: port,
+		ip:    ip,
+		port:  port,
+		owner: owner,
 	}
 }
 
@@ -55,9 +57,17 @@ func (e ErrPortAlreadyAllocated) IPPort() string {
 	return fmt.Sprintf("%s:%d", e.ip, e.port)
 }
 
+// Owner returns the owner of the used port, empty when it is not known
+func (e ErrPortAlreadyAllocated) Owner() Owner {
+	return e.owner
+}
+
 // Error is the implementation of error.Error interface
 func (e ErrPortAlreadyAllocated) Error() string {
-	return fmt.Sprintf("Bind for %s:%d failed: port is already allocated", e.ip, e.port)
+	if e.owner.EndpointID == "" {
+		return fmt.Sprintf("Bind for %s:%d failed: port is already allocated", e.ip, e.port)
+	}
+	return fmt.Sprintf("Bind for %s:%d failed: port is already allocated to endpoint %s", e.ip, e.port, shortID(e.owner.EndpointID))
 }
 
 type (
@@ -67,6 +77,11 @@ type (
 		ipMap ipMapping
 		Begin int
 		End   int
+		// configs are the automatic allocation settings by source, and
+		// dynamicRanges and excluded their combination
+		configs       map[string]Config
+		dynamicRanges map[string]PortRange
+		excluded      []PortRange
 	}
 	portRange struct {
 		begin int
@@ -74,11 +89,24 @@ type (
 		last  int
 	}
 	portMap struct {
-		p            map[int]struct{}
+		p            map[int]allocation
 		defaultRange string
 		portRanges   map[string]*portRange
 	}
 	protoMap map[string]*portMap
+	// Owner identifies the endpoint a port is allocated to, and the
+	// port of the endpoint it is mapped to
+	Owner struct {
+		NetworkID     string
+		EndpointID    string
+		ContainerPort int
+	}
+	allocation struct {
+		owner Owner
+		// reserved is set for the allocations restored from a previous
+		// run, until their owner requests them again
+		reserved bool
+	}
 )
 
 // Get returns the default instance of PortAllocator
@@ -112,11 +140,19 @@ func (p *PortAllocator) RequestPort(ip net.IP, proto string, port int) (int, err
 }
 
 // RequestPortInRange requests new port from global ports pool for specified ip and proto.
-// If portStart and portEnd are 0 it returns the first free port in the default ephemeral range.
+// If portStart and portEnd are 0 it returns the first free port in the dynamic range of ip.
 // If portStart != portEnd it returns the first free port in the requested range.
+// The excluded ports are skipped in both cases.
 // Otherwise (portStart == portEnd) it checks port availability in the requested proto's port-pool
 // and returns that port or error if port is already busy.
 func (p *PortAllocator) RequestPortInRange(ip net.IP, proto string, portStart, portEnd int) (int, error) {
+	return p.RequestPortInRangeForOwner(ip, proto, portStart, portEnd, Owner{})
+}
+
+// RequestPortInRangeForOwner behaves like RequestPortInRange and records the
+// owner of the port. A port reserved for the owner in the requested range is
+// returned first.
+func (p *PortAllocator) RequestPortInRangeForOwner(ip net.IP, proto string, portStart, portEnd int, owner Owner) (int, error) {
 	p.mutex.Lock()
 	defer p.mutex.Unlock()
 
@@ -128,32 +164,173 @@ func (p *PortAllocator) RequestPortInRange(ip net.IP, proto string, portStart, p
 		ip = defaultIP
 	}
 	ipstr := ip.String()
-	protomap, ok := p.ipMap[ipstr]
-	if !ok {
-		protomap = protoMap{
-			"tcp":  p.newPortMap(),
-			"udp":  p.newPortMap(),
-			"sctp": p.newPortMap(),
-		}
-
-		p.ipMap[ipstr] = protomap
+	mapping := p.getPortMap(ipstr, proto)
+	if port, ok := mapping.claimReserved(portStart, portEnd, owner); ok {
+		return port, nil
 	}
-	mapping := protomap[proto]
 	if portStart > 0 && portStart == portEnd {
-		if _, ok := mapping.p[portStart]; !ok {
-			mapping.p[portStart] = struct{}{}
+		a, ok := mapping.p[portStart]
+		if !ok {
+			mapping.p[portStart] = allocation{owner: owner}
 			return portStart, nil
 		}
-		return 0, newErrPortAlreadyAllocated(ipstr, portStart)
+		return 0, newErrPortAlreadyAllocated(ipstr, portStart, a.owner)
 	}
 
-	port, err := mapping.findPort(portStart, portEnd)
+	port, err := mapping.findPort(portStart, portEnd, owner, p.isExcluded)
 	if err != nil {
 		return 0, err
 	}
 	return port, nil
 }
 
+// Reserve allocates the port to owner until the owner requests it, or it is
+// released. It is used to restore the allocations of a previous run before
+// any new port is handed out.
+func (p *PortAllocator) Reserve(ip net.IP, proto string, port int, owner Owner) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if proto != "tcp" && proto != "udp" && proto != "sctp" {
+		return ErrUnknownProtocol
+	}
+
+	if ip == nil {
+		ip = defaultIP
+	}
+	ipstr := ip.String()
+	mapping := p.getPortMap(ipstr, proto)
+	if a, ok := mapping.p[port]; ok {
+		if a.owner != owner {
+			return newErrPortAlreadyAllocated(ipstr, port, a.owner)
+		}
+		return nil
+	}
+	mapping.p[port] = allocation{owner: owner, reserved: true}
+	return nil
+}
+
+// ReservePortInRange reserves for owner the same port on every ip, typically
+// the unspecified address of each family, so that a binding can be published
+// on all of them. The port is picked like RequestPortInRange does, in the
+// dynamic range of the first ip when portStart and portEnd are 0, and ports
+// already reserved for owner on every ip are returned first. The owner then
+// claims the reservations by requesting the port on each ip.
+func (p *PortAllocator) ReservePortInRange(ips []net.IP, proto string, portStart, portEnd int, owner Owner) (int, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if proto != "tcp" && proto != "udp" && proto != "sctp" {
+		return 0, ErrUnknownProtocol
+	}
+	if len(ips) == 0 || owner == (Owner{}) {
+		return 0, fmt.Errorf("cannot reserve a port without addresses or owner")
+	}
+
+	ipstrs := make([]string, 0, len(ips))
+	mappings := make([]*portMap, 0, len(ips))
+	for _, ip := range ips {
+		if ip == nil {
+			ip = defaultIP
+		}
+		ipstrs = append(ipstrs, ip.String())
+		mappings = append(mappings, p.getPortMap(ip.String(), proto))
+	}
+
+	// usable tells whether the port is free, or reserved for owner, on every ip
+	usable := func(port int) error {
+		for i, mapping := range mappings {
+			if a, ok := mapping.p[port]; ok && (!a.reserved || a.owner != owner) {
+				return newErrPortAlreadyAllocated(ipstrs[i], port, a.owner)
+			}
+		}
+		return nil
+	}
+	reserve := func(port int) int {
+		for _, mapping := range mappings {
+			mapping.p[port] = allocation{owner: owner, reserved: true}
+		}
+		return port
+	}
+
+	if portStart > 0 && portStart == portEnd {
+		if err := usable(portStart); err != nil {
+			return 0, err
+		}
+		return reserve(portStart), nil
+	}
+
+	pr, err := mappings[0].getPortRange(portStart, portEnd)
+	if err != nil {
+		return 0, err
+	}
+	for port, a := range mappings[0].p {
+		if a.reserved && a.owner == owner && port >= pr.begin && port <= pr.end && usable(port) == nil {
+			return reserve(port), nil
+		}
+	}
+	port := pr.last
+	for i := 0; i <= pr.end-pr.begin; i++ {
+		port++
+		if port > pr.end {
+			port = pr.begin
+		}
+		if !p.isExcluded(port) && usable(port) == nil {
+			pr.last = port
+			return reserve(port), nil
+		}
+	}
+	return 0, ErrAllPortsAllocated
+}
+
+// CancelReservation releases the port if it is still reserved for owner on ip.
+func (p *PortAllocator) CancelReservation(ip net.IP, proto string, port int, owner Owner) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if ip == nil {
+		ip = defaultIP
+	}
+	protomap, ok := p.ipMap[ip.String()]
+	if !ok || protomap[proto] == nil {
+		return
+	}
+	if a, ok := protomap[proto].p[port]; ok && a.reserved && a.owner == owner {
+		delete(protomap[proto].p, port)
+	}
+}
+
+// ReleaseReservations releases the ports still reserved for the endpoint of
+// owner, whatever their container port, which it did not request again.
+func (p *PortAllocator) ReleaseReservations(owner Owner) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for _, protomap := range p.ipMap {
+		for _, mapping := range protomap {
+			for port, a := range mapping.p {
+				if a.reserved && a.owner.sameEndpoint(owner) {
+					delete(mapping.p, port)
+				}
+			}
+		}
+	}
+}
+
+func (p *PortAllocator) getPortMap(ipstr, proto string) *portMap {
+	protomap, ok := p.ipMap[ipstr]
+	if !ok {
+		protomap = protoMap{
+			"tcp":  p.newPortMap(ipstr),
+			"udp":  p.newPortMap(ipstr),
+			"sctp": p.newPortMap(ipstr),
+		}
+
+		p.ipMap[ipstr] = protomap
+	}
+	return protomap[proto]
+}
+
 // ReleasePort releases port from global ports pool for specified ip and proto.
 func (p *PortAllocator) ReleasePort(ip net.IP, proto string, port int) error {
 	p.mutex.Lock()
@@ -170,13 +347,14 @@ func (p *PortAllocator) ReleasePort(ip net.IP, proto string, port int) error {
 	return nil
 }
 
-func (p *PortAllocator) newPortMap() *portMap {
-	defaultKey := getRangeKey(p.Begin, p.End)
+func (p *PortAllocator) newPortMap(ipstr string) *portMap {
+	r := p.dynamicRange(ipstr)
+	defaultKey := getRangeKey(r.Begin, r.End)
 	pm := &portMap{
-		p:            map[int]struct{}{},
+		p:            map[int]allocation{},
 		defaultRange: defaultKey,
 		portRanges: map[string]*portRange{
-			defaultKey: newPortRange(p.Begin, p.End),
+			defaultKey: newPortRange(r.Begin, r.End),
 		},
 	}
 	return pm
@@ -190,6 +368,17 @@ func (p *PortAllocator) ReleaseAll() error {
 	return nil
 }
 
+func (o Owner) sameEndpoint(other Owner) bool {
+	return o.NetworkID == other.NetworkID && o.EndpointID == other.EndpointID
+}
+
+func shortID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
+
 func getRangeKey(portStart, portEnd int) string {
 	return fmt.Sprintf("%d-%d", portStart, portEnd)
 }
@@ -226,7 +415,41 @@ func (pm *portMap) getPortRange(portStart, portEnd int) (*portRange, error) {
 	return pr, nil
 }
 
-func (pm *portMap) findPort(portStart, portEnd int) (int, error) {
+// claimReserved hands over to owner a port reserved for it in the range. The
+// reservation must be for the same container port as the request, the lowest
+// such port is handed over when several match.
+func (pm *portMap) claimReserved(portStart, portEnd int, owner Owner) (int, bool) {
+	if owner == (Owner{}) {
+		return 0, false
+	}
+	if portStart > 0 && portStart == portEnd {
+		if a, ok := pm.p[portStart]; !ok || !a.reserved || a.owner != owner {
+			return 0, false
+		}
+		pm.p[portStart] = allocation{owner: owner}
+		return portStart, true
+	}
+
+	claimed := 0
+	for port, a := range pm.p {
+		if !a.reserved || a.owner != owner {
+			continue
+		}
+		if (portStart != 0 || portEnd != 0) && (port < portStart || port > portEnd) {
+			continue
+		}
+		if claimed == 0 || port < claimed {
+			claimed = port
+		}
+	}
+	if claimed == 0 {
+		return 0, false
+	}
+	pm.p[claimed] = allocation{owner: owner}
+	return claimed, true
+}
+
+func (pm *portMap) findPort(portStart, portEnd int, owner Owner, excluded func(int) bool) (int, error) {
 	pr, err := pm.getPortRange(portStart, portEnd)
 	if err != nil {
 		return 0, err
@@ -239,8 +462,8 @@ func (pm *portMap) findPort(portStart, portEnd int) (int, error) {
 			port = pr.begin
 		}
 
-		if _, ok := pm.p[port]; !ok {
-			pm.p[port] = struct{}{}
+		if _, ok := pm.p[port]; !ok && !excluded(port) {
+			pm.p[port] = allocation{owner: owner}
 			pr.last = port
 			return port, nil
 		}
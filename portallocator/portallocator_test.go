@@ -321,3 +321,247 @@ func TestNoDuplicateBPR(t *testing.T) {
 		t.Fatalf("Acquire(0) allocated the same port twice: %d", port)
 	}
 }
+
+func TestReservePortForOwner(t *testing.T) {
+	p := Get()
+	defer resetPortAllocator()
+
+	owner := Owner{NetworkID: "net1", EndpointID: "ep1"}
+	other := Owner{NetworkID: "net1", EndpointID: "ep2"}
+
+	if err := p.Reserve(defaultIP, "tcp", 5000, owner); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Reserve(defaultIP, "tcp", 5001, owner); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Reserve(defaultIP, "tcp", 5000, other); err == nil {
+		t.Fatal("Expected an error reserving a port reserved for another owner")
+	}
+
+	// Reserved ports are not handed out to anybody else
+	if _, err := p.RequestPort(defaultIP, "tcp", 5000); err == nil {
+		t.Fatal("Expected an error requesting a reserved port")
+	}
+	if _, err := p.RequestPortInRangeForOwner(defaultIP, "tcp", 5000, 5000, other); err == nil {
+		t.Fatal("Expected an error requesting a port reserved for another owner")
+	}
+
+	// The owner gets its reserved port back, even out of a range
+	port, err := p.RequestPortInRangeForOwner(defaultIP, "tcp", 5000, 5010, owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port != 5000 && port != 5001 {
+		t.Fatalf("Expected a reserved port, got %d", port)
+	}
+	claimed, unclaimed := port, 5001
+	if port == 5001 {
+		unclaimed = 5000
+	}
+
+	// Releasing the remaining reservations does not touch the claimed port
+	p.ReleaseReservations(owner)
+	if _, err := p.RequestPort(defaultIP, "tcp", unclaimed); err != nil {
+		t.Fatalf("Expected the unclaimed port to be released: %v", err)
+	}
+	if _, err := p.RequestPort(defaultIP, "tcp", claimed); err == nil {
+		t.Fatal("Expected the claimed port to stay allocated")
+	}
+}
+
+func TestClaimReservedPortByContainerPort(t *testing.T) {
+	p := Get()
+	defer resetPortAllocator()
+
+	web := Owner{NetworkID: "net1", EndpointID: "ep1", ContainerPort: 80}
+	tls := Owner{NetworkID: "net1", EndpointID: "ep1", ContainerPort: 443}
+
+	if err := p.Reserve(defaultIP, "tcp", 5001, tls); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Reserve(defaultIP, "tcp", 5000, web); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Reserve(defaultIP, "tcp", 5000, tls); err == nil {
+		t.Fatal("Expected an error reserving a port reserved for another container port")
+	}
+
+	// Each binding gets the port reserved for its container port
+	for _, tc := range []struct {
+		owner Owner
+		port  int
+	}{{web, 5000}, {tls, 5001}} {
+		port, err := p.RequestPortInRangeForOwner(defaultIP, "tcp", 0, 0, tc.owner)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if port != tc.port {
+			t.Fatalf("Expected port %d for container port %d, got %d", tc.port, tc.owner.ContainerPort, port)
+		}
+	}
+
+	// Reservations are only claimed for their protocol
+	if err := p.Reserve(defaultIP, "udp", 5000, web); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.RequestPortInRangeForOwner(defaultIP, "tcp", 5000, 5000, web); err == nil {
+		t.Fatal("Expected an error requesting an allocated port")
+	}
+	p.ReleaseReservations(Owner{NetworkID: "net1", EndpointID: "ep1"})
+	if _, err := p.RequestPort(defaultIP, "udp", 5000); err != nil {
+		t.Fatalf("Expected the udp reservation to be released: %v", err)
+	}
+}
+
+func TestDynamicRangesAndExcludedPorts(t *testing.T) {
+	p := Get()
+	defer resetPortAllocator()
+
+	ip := net.ParseIP("192.168.100.1")
+	// An existing port map must pick up the new range
+	if _, err := p.RequestPort(ip, "tcp", 80); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := ParseConfig(map[string]string{"192.168.100.1": "40000-40004"}, []string{"40001", "40003-40004"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetConfig("test", cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []int{40000, 40002} {
+		port, err := p.RequestPort(ip, "tcp", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if port != expected {
+			t.Fatalf("Expected port %d got %d", expected, port)
+		}
+	}
+	if _, err := p.RequestPort(ip, "tcp", 0); err != ErrAllPortsAllocated {
+		t.Fatalf("Expected error %s got %v", ErrAllPortsAllocated, err)
+	}
+
+	// Excluded ports can still be requested explicitly
+	if _, err := p.RequestPort(ip, "tcp", 40001); err != nil {
+		t.Fatal(err)
+	}
+
+	// Other IPs keep the default range, without the excluded ports
+	if port, err := p.RequestPortInRange(defaultIP, "tcp", 40001, 40002); err != nil {
+		t.Fatal(err)
+	} else if port != 40002 {
+		t.Fatalf("Expected port 40002 got %d", port)
+	}
+	if port, err := p.RequestPort(defaultIP, "tcp", 0); err != nil {
+		t.Fatal(err)
+	} else if port < p.Begin || port > p.End {
+		t.Fatalf("Expected a port between %d and %d, got %d", p.Begin, p.End, port)
+	}
+
+	conflict := Config{DynamicRanges: map[string]PortRange{"192.168.100.1": {Begin: 41000, End: 42000}}}
+	if err := p.SetConfig("other", conflict); err == nil {
+		t.Fatal("Expected an error setting a conflicting range for the same IP")
+	}
+	// Replacing the settings of the same source is fine
+	if err := p.SetConfig("test", conflict); err != nil {
+		t.Fatal(err)
+	}
+	if port, err := p.RequestPort(ip, "tcp", 0); err != nil {
+		t.Fatal(err)
+	} else if port != 41000 {
+		t.Fatalf("Expected port 41000 got %d", port)
+	}
+
+	for _, s := range []string{"", "abc", "0", "70000", "2000-1000", "1-2-3"} {
+		if _, err := ParsePortRange(s); err == nil {
+			t.Fatalf("Expected an error parsing port range %q", s)
+		}
+	}
+	if _, err := ParseConfig(map[string]string{"invalid": "1000-2000"}, nil); err == nil {
+		t.Fatal("Expected an error for an invalid host IP")
+	}
+}
+
+func TestPortAlreadyAllocatedOwner(t *testing.T) {
+	p := Get()
+	defer resetPortAllocator()
+
+	owner := Owner{NetworkID: "net1", EndpointID: "0123456789abcdef"}
+	if _, err := p.RequestPortInRangeForOwner(defaultIP, "tcp", 5000, 5000, owner); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := p.RequestPort(defaultIP, "tcp", 5000)
+	pErr, ok := err.(ErrPortAlreadyAllocated)
+	if !ok {
+		t.Fatalf("Expected port allocation error got %v", err)
+	}
+	if pErr.Owner() != owner {
+		t.Fatalf("Expected owner %v got %v", owner, pErr.Owner())
+	}
+	if expected := "Bind for 0.0.0.0:5000 failed: port is already allocated to endpoint 0123456789ab"; err.Error() != expected {
+		t.Fatalf("Expected error %q got %q", expected, err.Error())
+	}
+}
+
+func TestReservePortInRangeOnBothFamilies(t *testing.T) {
+	p := Get()
+	defer resetPortAllocator()
+
+	owner := Owner{NetworkID: "net1", EndpointID: "ep1"}
+	ips := []net.IP{defaultIP, net.IPv6unspecified}
+
+	// The first port of the range is only taken on IPv6
+	if _, err := p.RequestPort(net.IPv6unspecified, "tcp", 8000); err != nil {
+		t.Fatal(err)
+	}
+
+	port, err := p.ReservePortInRange(ips, "tcp", 8000, 8001, owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port != 8001 {
+		t.Fatalf("Expected port 8001 got %d", port)
+	}
+
+	// The reservations are claimed by the owner on each family
+	for _, ip := range ips {
+		if got, err := p.RequestPortInRangeForOwner(ip, "tcp", port, port, owner); err != nil {
+			t.Fatal(err)
+		} else if got != port {
+			t.Fatalf("Expected port %d got %d", port, got)
+		}
+	}
+
+	if _, err := p.ReservePortInRange(ips, "tcp", 8000, 8001, owner); err != ErrAllPortsAllocated {
+		t.Fatalf("Expected error %s got %v", ErrAllPortsAllocated, err)
+	}
+	if _, err := p.ReservePortInRange(ips, "tcp", 8000, 8000, owner); err == nil {
+		t.Fatal("Expected an error reserving a port allocated on one of the families")
+	}
+	if _, err := p.ReservePortInRange(ips, "tcp", 0, 0, Owner{}); err == nil {
+		t.Fatal("Expected an error reserving a port without owner")
+	}
+
+	// A cancelled reservation frees the port, a claimed one is kept
+	port, err = p.ReservePortInRange(ips, "tcp", 9000, 9000, owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.RequestPortInRangeForOwner(defaultIP, "tcp", port, port, owner); err != nil {
+		t.Fatal(err)
+	}
+	for _, ip := range ips {
+		p.CancelReservation(ip, "tcp", port, owner)
+	}
+	if _, err := p.RequestPort(defaultIP, "tcp", port); err == nil {
+		t.Fatal("Expected the claimed port to stay allocated")
+	}
+	if _, err := p.RequestPort(net.IPv6unspecified, "tcp", port); err != nil {
+		t.Fatal(err)
+	}
+}
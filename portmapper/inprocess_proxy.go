@@ -0,0 +1,88 @@
+package portmapper
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+
+	"github.com/docker/libnetwork/proxy"
+	"github.com/ishidawataru/sctp"
+)
+
+// inProcessProxy runs a userland proxy as goroutines of the current process
+// instead of a separate docker-proxy process. It serves the listener bound
+// by the PortMapper when the host port was allocated, from a goroutine of its
+// own.
+type inProcessProxy struct {
+	proxy   proxy.Proxy
+	stopped chan struct{}
+}
+
+func newInProcessProxy(listener io.Closer, container net.Addr, config proxy.Config) (userlandProxy, error) {
+	var (
+		p   proxy.Proxy
+		err error
+	)
+
+	switch l := listener.(type) {
+	case *net.TCPListener:
+		p, err = proxy.NewTCPProxyFromListener(l, container.(*net.TCPAddr), config)
+	case *net.UDPConn:
+		p, err = proxy.NewUDPProxyFromConn(l, container.(*net.UDPAddr), config)
+	case *sctp.SCTPListener:
+		p, err = proxy.NewSCTPProxyFromListener(l, container.(*sctp.SCTPAddr), config)
+	default:
+		err = fmt.Errorf("Unknown listener type: %T", listener)
+	}
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	return &inProcessProxy{proxy: p}, nil
+}
+
+func (p *inProcessProxy) Start() error {
+	p.stopped = make(chan struct{})
+	go func() {
+		p.proxy.Run()
+		close(p.stopped)
+	}()
+	return nil
+}
+
+// Stop closes the listener and waits for the goroutine serving it to exit.
+func (p *inProcessProxy) Stop() error {
+	p.proxy.Close()
+	if p.stopped != nil {
+		<-p.stopped
+		p.stopped = nil
+	}
+	return nil
+}
+
+// listen binds the host address of a mapping for the in-process mode.
+func listen(proto string, hostIP net.IP, hostPort int, singleFamily bool) (io.Closer, error) {
+	switch proto {
+	case "tcp":
+		return net.ListenTCP(proxy.ListenNetwork("tcp", hostIP, singleFamily), &net.TCPAddr{IP: hostIP, Port: hostPort})
+	case "udp":
+		return net.ListenUDP(proxy.ListenNetwork("udp", hostIP, singleFamily), &net.UDPAddr{IP: hostIP, Port: hostPort})
+	case "sctp":
+		return sctp.ListenSCTP("sctp", &sctp.SCTPAddr{IP: []net.IP{hostIP}, Port: hostPort})
+	default:
+		return nil, fmt.Errorf("Unknown addr type: %s", proto)
+	}
+}
+
+func isAddrInUse(err error) bool {
+	if opErr, ok := err.(*net.OpError); ok {
+		err = opErr.Err
+	}
+	if sysErr, ok := err.(*os.SyscallError); ok {
+		err = sysErr.Err
+	}
+	return err == syscall.EADDRINUSE
+}
@@ -3,11 +3,15 @@ package portmapper
 import (
 	"errors"
 	"fmt"
+	"io"
 	"net"
+	"strconv"
 	"sync"
+	"time"
 
-	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/firewall"
 	"github.com/docker/libnetwork/portallocator"
+	"github.com/docker/libnetwork/proxy"
 	"github.com/ishidawataru/sctp"
 	"github.com/sirupsen/logrus"
 )
@@ -17,10 +21,22 @@ type mapping struct {
 	userlandProxy userlandProxy
 	host          net.Addr
 	container     net.Addr
+	proxyProtocol int
 }
 
 var newProxy = newProxyCommand
 
+// ProxyMode selects how the userland proxies of the port mappings are run.
+type ProxyMode string
+
+const (
+	// ProxyModeExternal runs a docker-proxy process for each mapped port.
+	ProxyModeExternal ProxyMode = "external"
+	// ProxyModeInProcess runs the userland proxies as goroutines of the
+	// current process.
+	ProxyModeInProcess ProxyMode = "in-process"
+)
+
 var (
 	// ErrUnknownBackendAddressType refers to an unknown container or unsupported address type
 	ErrUnknownBackendAddressType = errors.New("unknown container address type not supported")
@@ -30,18 +46,26 @@ var (
 	ErrPortNotMapped = errors.New("port is not mapped")
 	// ErrSCTPAddrNoIP refers to a SCTP address without IP address.
 	ErrSCTPAddrNoIP = errors.New("sctp address does not contain any IP address")
+	// ErrUnknownProxyMode refers to an unsupported userland proxy mode
+	ErrUnknownProxyMode = errors.New("unknown userland proxy mode")
+	// ErrProxyProtocolNotSupported refers to a PROXY protocol request for a non TCP mapping
+	// or without the userland proxy
+	ErrProxyProtocolNotSupported = errors.New("PROXY protocol is only supported for tcp with the userland proxy")
 )
 
 // PortMapper manages the network address translation
 type PortMapper struct {
-	chain      *iptables.ChainInfo
+	chain      *firewall.Chain
+	chainV6    *firewall.Chain
 	bridgeName string
 
 	// udp:ip:port
 	currentMappings map[string]*mapping
 	lock            sync.Mutex
 
-	proxyPath string
+	proxyPath   string
+	proxyMode   ProxyMode
+	proxyLimits proxy.Config
 
 	Allocator *portallocator.PortAllocator
 }
@@ -57,58 +81,143 @@ func NewWithPortAllocator(allocator *portallocator.PortAllocator, proxyPath stri
 		currentMappings: make(map[string]*mapping),
 		Allocator:       allocator,
 		proxyPath:       proxyPath,
+		proxyMode:       ProxyModeExternal,
 	}
 }
 
-// SetIptablesChain sets the specified chain into portmapper
-func (pm *PortMapper) SetIptablesChain(c *iptables.ChainInfo, bridgeName string) {
+// SetProxyMode selects how the userland proxies of the new mappings are run
+func (pm *PortMapper) SetProxyMode(mode ProxyMode) error {
+	switch mode {
+	case "":
+		mode = ProxyModeExternal
+	case ProxyModeExternal, ProxyModeInProcess:
+	default:
+		return ErrUnknownProxyMode
+	}
+
+	pm.lock.Lock()
+	pm.proxyMode = mode
+	pm.lock.Unlock()
+	return nil
+}
+
+// SetIptablesChain sets the specified firewall chain into portmapper
+func (pm *PortMapper) SetIptablesChain(c *firewall.Chain, bridgeName string) {
 	pm.chain = c
 	pm.bridgeName = bridgeName
 }
 
+// SetIP6tablesChain sets the specified IPv6 firewall chain into portmapper, for
+// the mappings of IPv6 host addresses to IPv6 container addresses
+func (pm *PortMapper) SetIP6tablesChain(c *firewall.Chain, bridgeName string) {
+	pm.chainV6 = c
+	pm.bridgeName = bridgeName
+}
+
+// SetProxyLimits sets the connection limits and timeouts of the userland proxies
+// of the new mappings. Zero values keep the proxy defaults.
+func (pm *PortMapper) SetProxyLimits(maxConnections int, idleTimeout, udpFlowTimeout time.Duration) {
+	pm.lock.Lock()
+	pm.proxyLimits = proxy.Config{
+		MaxConnections: maxConnections,
+		IdleTimeout:    idleTimeout,
+		UDPFlowTimeout: udpFlowTimeout,
+	}
+	pm.lock.Unlock()
+}
+
+// ProxyStats returns the counters of the in-process userland proxies, keyed by
+// the mapped host address in the proto/ip:port form. The proxies run in the
+// external mode are not reported.
+func (pm *PortMapper) ProxyStats() map[string]proxy.Stats {
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+
+	stats := make(map[string]proxy.Stats)
+	for _, m := range pm.currentMappings {
+		p, ok := m.userlandProxy.(*inProcessProxy)
+		if !ok {
+			continue
+		}
+		hostIP, hostPort := getIPAndPort(m.host)
+		stats[fmt.Sprintf("%s/%s", m.proto, net.JoinHostPort(hostIP.String(), strconv.Itoa(hostPort)))] = p.proxy.Stats()
+	}
+	return stats
+}
+
 // Map maps the specified container transport address to the host's network address and transport port
 func (pm *PortMapper) Map(container net.Addr, hostIP net.IP, hostPort int, useProxy bool) (host net.Addr, err error) {
-	return pm.MapRange(container, hostIP, hostPort, hostPort, useProxy)
+	return pm.MapRange(container, hostIP, hostPort, hostPort, useProxy, 0, portallocator.Owner{})
+}
+
+// MapRange maps the specified container transport address to the host's network address and transport port range.
+// A non zero proxyProtocol makes the userland proxy send a PROXY protocol header of that version to the container.
+// All the traffic of such a mapping goes through the userland proxy, no iptables forwarding is programmed for it.
+// The host port is allocated to owner, which gets back the ports reserved for it first.
+func (pm *PortMapper) MapRange(container net.Addr, hostIP net.IP, hostPortStart, hostPortEnd int, useProxy bool, proxyProtocol int, owner portallocator.Owner) (host net.Addr, err error) {
+	return pm.mapRange(container, hostIP, hostPortStart, hostPortEnd, useProxy, proxyProtocol, owner, false)
 }
 
-// MapRange maps the specified container transport address to the host's network address and transport port range
-func (pm *PortMapper) MapRange(container net.Addr, hostIP net.IP, hostPortStart, hostPortEnd int, useProxy bool) (host net.Addr, err error) {
+// MapRangeSingleFamily is MapRange for a host address whose other IP family
+// is mapped separately: the port of an unspecified host address is then only
+// bound for the family of the address.
+func (pm *PortMapper) MapRangeSingleFamily(container net.Addr, hostIP net.IP, hostPortStart, hostPortEnd int, useProxy bool, proxyProtocol int, owner portallocator.Owner) (host net.Addr, err error) {
+	return pm.mapRange(container, hostIP, hostPortStart, hostPortEnd, useProxy, proxyProtocol, owner, true)
+}
+
+func (pm *PortMapper) mapRange(container net.Addr, hostIP net.IP, hostPortStart, hostPortEnd int, useProxy bool, proxyProtocol int, owner portallocator.Owner, singleFamily bool) (host net.Addr, err error) {
 	pm.lock.Lock()
 	defer pm.lock.Unlock()
 
+	if proxyProtocol != 0 {
+		if _, ok := container.(*net.TCPAddr); !ok || !useProxy {
+			return nil, ErrProxyProtocolNotSupported
+		}
+	}
+
 	var (
 		m                 *mapping
 		proto             string
 		allocatedHostPort int
+		listener          io.Closer
 	)
 
+	// release the allocated port on any error during return, including the
+	// failures to create or start the userland proxy.
+	defer func() {
+		if err != nil && allocatedHostPort != 0 {
+			pm.Allocator.ReleasePort(hostIP, proto, allocatedHostPort)
+		}
+	}()
+
 	switch container.(type) {
 	case *net.TCPAddr:
 		proto = "tcp"
-		if allocatedHostPort, err = pm.Allocator.RequestPortInRange(hostIP, proto, hostPortStart, hostPortEnd); err != nil {
+		if allocatedHostPort, listener, err = pm.allocateHostPort(hostIP, proto, hostPortStart, hostPortEnd, owner, singleFamily); err != nil {
 			return nil, err
 		}
 
 		m = &mapping{
-			proto:     proto,
-			host:      &net.TCPAddr{IP: hostIP, Port: allocatedHostPort},
-			container: container,
+			proto:         proto,
+			host:          &net.TCPAddr{IP: hostIP, Port: allocatedHostPort},
+			container:     container,
+			proxyProtocol: proxyProtocol,
 		}
 
 		if useProxy {
-			m.userlandProxy, err = newProxy(proto, hostIP, allocatedHostPort, container.(*net.TCPAddr).IP, container.(*net.TCPAddr).Port, pm.proxyPath)
+			m.userlandProxy, err = pm.newUserlandProxy(listener, proto, hostIP, allocatedHostPort, container, container.(*net.TCPAddr).IP, container.(*net.TCPAddr).Port, proxyProtocol, singleFamily)
 			if err != nil {
 				return nil, err
 			}
 		} else {
-			m.userlandProxy, err = newDummyProxy(proto, hostIP, allocatedHostPort)
+			m.userlandProxy, err = newDummyProxy(proto, hostIP, allocatedHostPort, listener, singleFamily)
 			if err != nil {
 				return nil, err
 			}
 		}
 	case *net.UDPAddr:
 		proto = "udp"
-		if allocatedHostPort, err = pm.Allocator.RequestPortInRange(hostIP, proto, hostPortStart, hostPortEnd); err != nil {
+		if allocatedHostPort, listener, err = pm.allocateHostPort(hostIP, proto, hostPortStart, hostPortEnd, owner, singleFamily); err != nil {
 			return nil, err
 		}
 
@@ -119,19 +228,23 @@ func (pm *PortMapper) MapRange(container net.Addr, hostIP net.IP, hostPortStart,
 		}
 
 		if useProxy {
-			m.userlandProxy, err = newProxy(proto, hostIP, allocatedHostPort, container.(*net.UDPAddr).IP, container.(*net.UDPAddr).Port, pm.proxyPath)
+			m.userlandProxy, err = pm.newUserlandProxy(listener, proto, hostIP, allocatedHostPort, container, container.(*net.UDPAddr).IP, container.(*net.UDPAddr).Port, 0, singleFamily)
 			if err != nil {
 				return nil, err
 			}
 		} else {
-			m.userlandProxy, err = newDummyProxy(proto, hostIP, allocatedHostPort)
+			m.userlandProxy, err = newDummyProxy(proto, hostIP, allocatedHostPort, listener, singleFamily)
 			if err != nil {
 				return nil, err
 			}
 		}
 	case *sctp.SCTPAddr:
 		proto = "sctp"
-		if allocatedHostPort, err = pm.Allocator.RequestPortInRange(hostIP, proto, hostPortStart, hostPortEnd); err != nil {
+		sctpAddr := container.(*sctp.SCTPAddr)
+		if useProxy && len(sctpAddr.IP) == 0 {
+			return nil, ErrSCTPAddrNoIP
+		}
+		if allocatedHostPort, listener, err = pm.allocateHostPort(hostIP, proto, hostPortStart, hostPortEnd, owner, singleFamily); err != nil {
 			return nil, err
 		}
 
@@ -142,16 +255,12 @@ func (pm *PortMapper) MapRange(container net.Addr, hostIP net.IP, hostPortStart,
 		}
 
 		if useProxy {
-			sctpAddr := container.(*sctp.SCTPAddr)
-			if len(sctpAddr.IP) == 0 {
-				return nil, ErrSCTPAddrNoIP
-			}
-			m.userlandProxy, err = newProxy(proto, hostIP, allocatedHostPort, sctpAddr.IP[0], sctpAddr.Port, pm.proxyPath)
+			m.userlandProxy, err = pm.newUserlandProxy(listener, proto, hostIP, allocatedHostPort, container, sctpAddr.IP[0], sctpAddr.Port, 0, singleFamily)
 			if err != nil {
 				return nil, err
 			}
 		} else {
-			m.userlandProxy, err = newDummyProxy(proto, hostIP, allocatedHostPort)
+			m.userlandProxy, err = newDummyProxy(proto, hostIP, allocatedHostPort, listener, singleFamily)
 			if err != nil {
 				return nil, err
 			}
@@ -160,41 +269,25 @@ func (pm *PortMapper) MapRange(container net.Addr, hostIP net.IP, hostPortStart,
 		return nil, ErrUnknownBackendAddressType
 	}
 
-	// release the allocated port on any further error during return.
-	defer func() {
-		if err != nil {
-			pm.Allocator.ReleasePort(hostIP, proto, allocatedHostPort)
-		}
-	}()
-
 	key := getKey(m.host)
 	if _, exists := pm.currentMappings[key]; exists {
+		m.userlandProxy.Stop()
 		return nil, ErrPortMappedForIP
 	}
 
 	containerIP, containerPort := getIPAndPort(m.container)
-	if hostIP.To4() != nil {
-		if err := pm.forward(iptables.Append, m.proto, hostIP, allocatedHostPort, containerIP.String(), containerPort); err != nil {
+	if m.proxyProtocol == 0 {
+		if err := pm.forward(firewall.Append, m.proto, hostIP, allocatedHostPort, containerIP, containerPort); err != nil {
 			return nil, err
 		}
 	}
 
-	cleanup := func() error {
-		// need to undo the iptables rules before we return
+	if err = m.userlandProxy.Start(); err != nil {
+		// need to undo the iptables rules before we return, the port
+		// is released by the deferred function
 		m.userlandProxy.Stop()
-		if hostIP.To4() != nil {
-			pm.forward(iptables.Delete, m.proto, hostIP, allocatedHostPort, containerIP.String(), containerPort)
-			if err := pm.Allocator.ReleasePort(hostIP, m.proto, allocatedHostPort); err != nil {
-				return err
-			}
-		}
-
-		return nil
-	}
-
-	if err := m.userlandProxy.Start(); err != nil {
-		if err := cleanup(); err != nil {
-			return nil, fmt.Errorf("Error during port allocation cleanup: %v", err)
+		if m.proxyProtocol == 0 {
+			pm.forward(firewall.Delete, m.proto, hostIP, allocatedHostPort, containerIP, containerPort)
 		}
 		return nil, err
 	}
@@ -222,8 +315,10 @@ func (pm *PortMapper) Unmap(host net.Addr) error {
 
 	containerIP, containerPort := getIPAndPort(data.container)
 	hostIP, hostPort := getIPAndPort(data.host)
-	if err := pm.forward(iptables.Delete, data.proto, hostIP, hostPort, containerIP.String(), containerPort); err != nil {
-		logrus.Errorf("Error on iptables delete: %s", err)
+	if data.proxyProtocol == 0 {
+		if err := pm.forward(firewall.Delete, data.proto, hostIP, hostPort, containerIP, containerPort); err != nil {
+			logrus.Errorf("Error on iptables delete: %s", err)
+		}
 	}
 
 	switch a := host.(type) {
@@ -240,20 +335,91 @@ func (pm *PortMapper) Unmap(host net.Addr) error {
 	return ErrUnknownBackendAddressType
 }
 
-//ReMapAll will re-apply all port mappings
+// ReMapAll will re-apply all port mappings
 func (pm *PortMapper) ReMapAll() {
 	pm.lock.Lock()
 	defer pm.lock.Unlock()
 	logrus.Debugln("Re-applying all port mappings.")
 	for _, data := range pm.currentMappings {
+		if data.proxyProtocol != 0 {
+			continue
+		}
 		containerIP, containerPort := getIPAndPort(data.container)
 		hostIP, hostPort := getIPAndPort(data.host)
-		if err := pm.forward(iptables.Append, data.proto, hostIP, hostPort, containerIP.String(), containerPort); err != nil {
+		if err := pm.forward(firewall.Append, data.proto, hostIP, hostPort, containerIP, containerPort); err != nil {
 			logrus.Errorf("Error on iptables add: %s", err)
 		}
 	}
 }
 
+// RuleSet returns the firewall rules of the current port mappings.
+func (pm *PortMapper) RuleSet() firewall.RuleSet {
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+
+	var set firewall.RuleSet
+	for _, data := range pm.currentMappings {
+		if data.proxyProtocol != 0 {
+			continue
+		}
+		containerIP, containerPort := getIPAndPort(data.container)
+		hostIP, hostPort := getIPAndPort(data.host)
+		chain := pm.forwardChain(hostIP, containerIP)
+		if chain == nil {
+			continue
+		}
+		set.Add(chain.Backend.Family(), chain.ForwardRules(hostIP, hostPort, data.proto, containerIP.String(), containerPort, pm.bridgeName)...)
+	}
+	return set
+}
+
+// allocateHostPort allocates a host port in the range. In the in-process mode
+// the port is also bound, and the returned listener is then used by the
+// userland proxy or kept to reserve the port. Ports of the range which are
+// bound by other processes are skipped.
+func (pm *PortMapper) allocateHostPort(hostIP net.IP, proto string, hostPortStart, hostPortEnd int, owner portallocator.Owner, singleFamily bool) (int, io.Closer, error) {
+	if pm.proxyMode != ProxyModeInProcess {
+		port, err := pm.Allocator.RequestPortInRangeForOwner(hostIP, proto, hostPortStart, hostPortEnd, owner)
+		return port, nil, err
+	}
+
+	var inUse []int
+	defer func() {
+		for _, port := range inUse {
+			pm.Allocator.ReleasePort(hostIP, proto, port)
+		}
+	}()
+
+	for {
+		port, err := pm.Allocator.RequestPortInRangeForOwner(hostIP, proto, hostPortStart, hostPortEnd, owner)
+		if err != nil {
+			return 0, nil, err
+		}
+		listener, err := listen(proto, hostIP, port, singleFamily)
+		if err == nil {
+			return port, listener, nil
+		}
+		// The port stays allocated until a free one is found, so that
+		// the allocator does not hand it out again.
+		inUse = append(inUse, port)
+		if (hostPortStart != 0 && hostPortStart == hostPortEnd) || !isAddrInUse(err) {
+			return 0, nil, err
+		}
+		logrus.Debugf("Host port %s/%d is in use, trying the next one of the range", proto, port)
+	}
+}
+
+// newUserlandProxy returns the userland proxy of a mapping for the proxy mode
+func (pm *PortMapper) newUserlandProxy(listener io.Closer, proto string, hostIP net.IP, hostPort int, container net.Addr, containerIP net.IP, containerPort int, proxyProtocol int, singleFamily bool) (userlandProxy, error) {
+	config := pm.proxyLimits
+	config.ProxyProtocol = proxyProtocol
+	config.SingleFamily = singleFamily
+	if pm.proxyMode == ProxyModeInProcess {
+		return newInProcessProxy(listener, container, config)
+	}
+	return newProxy(proto, hostIP, hostPort, containerIP, containerPort, pm.proxyPath, config)
+}
+
 func getKey(a net.Addr) string {
 	switch t := a.(type) {
 	case *net.TCPAddr:
@@ -286,9 +452,26 @@ func getIPAndPort(a net.Addr) (net.IP, int) {
 	return nil, 0
 }
 
-func (pm *PortMapper) forward(action iptables.Action, proto string, sourceIP net.IP, sourcePort int, containerIP string, containerPort int) error {
-	if pm.chain == nil {
+// forwardChain returns the chain of the DNAT rules of a mapping, the chain
+// of its address family. The mappings between addresses of different
+// families are only served by the userland proxy.
+func (pm *PortMapper) forwardChain(sourceIP, containerIP net.IP) *firewall.Chain {
+	chain := pm.chain
+	if sourceIP.To4() == nil {
+		chain = pm.chainV6
+	}
+	if chain == nil || (sourceIP.To4() == nil) != (containerIP.To4() == nil) {
+		return nil
+	}
+	return chain
+}
+
+// forward programs the DNAT rules of a mapping in the chain of its address
+// family.
+func (pm *PortMapper) forward(action firewall.Action, proto string, sourceIP net.IP, sourcePort int, containerIP net.IP, containerPort int) error {
+	chain := pm.forwardChain(sourceIP, containerIP)
+	if chain == nil {
 		return nil
 	}
-	return pm.chain.Forward(action, sourceIP, sourcePort, proto, containerIP, containerPort, pm.bridgeName)
+	return chain.Forward(action, sourceIP, sourcePort, proto, containerIP.String(), containerPort, pm.bridgeName)
 }
@@ -1,11 +1,16 @@
 package portmapper
 
 import (
+	"errors"
+	"io"
 	"net"
 	"strings"
 	"testing"
+	"time"
 
-	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/firewall"
+	"github.com/docker/libnetwork/portallocator"
+	"github.com/docker/libnetwork/proxy"
 	_ "github.com/docker/libnetwork/testutils"
 )
 
@@ -17,7 +22,7 @@ func init() {
 func TestSetIptablesChain(t *testing.T) {
 	pm := New("")
 
-	c := &iptables.ChainInfo{
+	c := &firewall.Chain{
 		Name: "TEST",
 	}
 
@@ -268,3 +273,207 @@ func TestMapUDPDummyListen(t *testing.T) {
 		}
 	}
 }
+
+func TestMapProxyProtocol(t *testing.T) {
+	pm := New("")
+	hostIP := net.ParseIP("192.168.0.1")
+
+	udpAddr := &net.UDPAddr{Port: 53, IP: net.ParseIP("172.16.0.1")}
+	if _, err := pm.MapRange(udpAddr, hostIP, 5353, 5353, true, 1, portallocator.Owner{}); err != ErrProxyProtocolNotSupported {
+		t.Fatalf("Expected ErrProxyProtocolNotSupported for udp, got %v", err)
+	}
+
+	tcpAddr := &net.TCPAddr{Port: 80, IP: net.ParseIP("172.16.0.1")}
+	if _, err := pm.MapRange(tcpAddr, hostIP, 8080, 8080, false, 1, portallocator.Owner{}); err != ErrProxyProtocolNotSupported {
+		t.Fatalf("Expected ErrProxyProtocolNotSupported without userland proxy, got %v", err)
+	}
+
+	host, err := pm.MapRange(tcpAddr, hostIP, 8080, 8080, true, 2, portallocator.Owner{})
+	if err != nil {
+		t.Fatalf("Failed to allocate port: %s", err)
+	}
+	if err := pm.Unmap(host); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMapInProcessProxy(t *testing.T) {
+	pm := New("")
+	if err := pm.SetProxyMode(ProxyModeInProcess); err != nil {
+		t.Fatal(err)
+	}
+
+	backend, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	go func() {
+		conn, err := backend.Accept()
+		if err != nil {
+			return
+		}
+		conn.Write([]byte("hello"))
+		conn.Close()
+	}()
+
+	hostIP := net.ParseIP("127.0.0.1")
+	host, err := pm.MapRange(backend.Addr(), hostIP, 0, 0, true, 0, portallocator.Owner{})
+	if err != nil {
+		t.Fatalf("Failed to allocate port: %s", err)
+	}
+
+	conn, err := net.Dial("tcp", host.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if string(buf) != "hello" {
+		t.Fatalf("Unexpected reply through the proxy: %q", buf)
+	}
+
+	// The counters are updated right after the data is forwarded
+	key := "tcp/" + host.String()
+	for i := 0; pm.ProxyStats()[key].BytesOut != 5; i++ {
+		if i == 100 {
+			t.Fatalf("Unexpected proxy stats for %s: %+v", key, pm.ProxyStats())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := pm.Unmap(host); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := net.Dial("tcp", host.String()); err == nil {
+		t.Fatal("Proxy should not accept connections after unmap")
+	}
+}
+
+func TestMapInProcessSkipsPortsInUse(t *testing.T) {
+	pm := New("")
+	if err := pm.SetProxyMode(ProxyModeInProcess); err != nil {
+		t.Fatal(err)
+	}
+
+	hostIP := net.ParseIP("127.0.0.1")
+	busy, err := net.ListenTCP("tcp", &net.TCPAddr{IP: hostIP})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	busyPort := busy.Addr().(*net.TCPAddr).Port
+
+	container := &net.TCPAddr{IP: net.ParseIP("172.16.0.1"), Port: 80}
+	if _, err := pm.MapRange(container, hostIP, busyPort, busyPort, false, 0, portallocator.Owner{}); err == nil {
+		t.Fatal("Mapping a single port in use should have failed")
+	}
+
+	host, err := pm.MapRange(container, hostIP, busyPort, busyPort+1, false, 0, portallocator.Owner{})
+	if err != nil {
+		t.Fatalf("Failed to allocate port: %s", err)
+	}
+	if port := host.(*net.TCPAddr).Port; port != busyPort+1 {
+		t.Fatalf("Expected port %d, got %d", busyPort+1, port)
+	}
+	if err := pm.Unmap(host); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := pm.SetProxyMode("invalid"); err != ErrUnknownProxyMode {
+		t.Fatalf("Expected ErrUnknownProxyMode, got %v", err)
+	}
+}
+
+func TestMapInProcessProxies(t *testing.T) {
+	pm := New("")
+	if err := pm.SetProxyMode(ProxyModeInProcess); err != nil {
+		t.Fatal(err)
+	}
+
+	backend, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			n, from, err := backend.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			backend.WriteToUDP(buf[:n], from)
+		}
+	}()
+
+	hostIP := net.ParseIP("127.0.0.1")
+	var hosts []net.Addr
+	for i := 0; i < 3; i++ {
+		host, err := pm.MapRange(backend.LocalAddr(), hostIP, 0, 0, true, 0, portallocator.Owner{})
+		if err != nil {
+			t.Fatalf("Failed to allocate port: %s", err)
+		}
+		hosts = append(hosts, host)
+	}
+	for _, host := range hosts {
+		conn, err := net.Dial("udp", host.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		if _, err := conn.Write([]byte(host.String())); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 64)
+		n, err := conn.Read(buf)
+		conn.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf[:n]) != host.String() {
+			t.Fatalf("Unexpected reply through the proxy of %s: %q", host, buf[:n])
+		}
+	}
+
+	// Unmap returns once the proxy stopped serving the port
+	for _, host := range hosts {
+		if err := pm.Unmap(host); err != nil {
+			t.Fatal(err)
+		}
+		l, err := net.ListenUDP("udp", host.(*net.UDPAddr))
+		if err != nil {
+			t.Fatalf("Expected the port of %s to be free after Unmap, got %v", host, err)
+		}
+		l.Close()
+	}
+}
+
+func TestMapReleasesPortOnProxyError(t *testing.T) {
+	defer func() { newProxy = newMockProxyCommand }()
+	newProxy = func(proto string, hostIP net.IP, hostPort int, containerIP net.IP, containerPort int, proxyPath string, config proxy.Config) (userlandProxy, error) {
+		return nil, errors.New("proxy error")
+	}
+
+	pm := New("")
+	hostIP := net.ParseIP("127.0.0.1")
+	container := &net.TCPAddr{IP: net.ParseIP("172.16.0.1"), Port: 80}
+	port, err := pm.Allocator.RequestPort(hostIP, "tcp", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pm.Allocator.ReleasePort(hostIP, "tcp", port); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := pm.MapRange(container, hostIP, port, port, true, 0, portallocator.Owner{}); err == nil {
+		t.Fatal("Mapping with a failing userland proxy should have failed")
+	}
+	if _, err := pm.Allocator.RequestPort(hostIP, "tcp", port); err != nil {
+		t.Fatalf("Expected the port to be released, got %v", err)
+	}
+	pm.Allocator.ReleasePort(hostIP, "tcp", port)
+}
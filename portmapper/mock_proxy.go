@@ -1,8 +1,12 @@
 package portmapper
 
-import "net"
+import (
+	"net"
 
-func newMockProxyCommand(proto string, hostIP net.IP, hostPort int, containerIP net.IP, containerPort int, userlandProxyPath string) (userlandProxy, error) {
+	"github.com/docker/libnetwork/proxy"
+)
+
+func newMockProxyCommand(proto string, hostIP net.IP, hostPort int, containerIP net.IP, containerPort int, userlandProxyPath string, config proxy.Config) (userlandProxy, error) {
 	return &mockProxyCommand{}, nil
 }
 
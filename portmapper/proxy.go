@@ -9,6 +9,7 @@ import (
 	"os/exec"
 	"time"
 
+	"github.com/docker/libnetwork/proxy"
 	"github.com/ishidawataru/sctp"
 )
 
@@ -77,36 +78,45 @@ func (p *proxyCommand) Stop() error {
 // port allocations on bound port, because without userland proxy we using
 // iptables rules and not net.Listen
 type dummyProxy struct {
-	listener io.Closer
-	addr     net.Addr
+	listener     io.Closer
+	addr         net.Addr
+	singleFamily bool
 }
 
-func newDummyProxy(proto string, hostIP net.IP, hostPort int) (userlandProxy, error) {
+// newDummyProxy returns a dummyProxy for the host port. If the port is already
+// bound, listener is kept open instead of binding it again on Start.
+func newDummyProxy(proto string, hostIP net.IP, hostPort int, listener io.Closer, singleFamily bool) (userlandProxy, error) {
+	if listener != nil {
+		return &dummyProxy{listener: listener}, nil
+	}
 	switch proto {
 	case "tcp":
 		addr := &net.TCPAddr{IP: hostIP, Port: hostPort}
-		return &dummyProxy{addr: addr}, nil
+		return &dummyProxy{addr: addr, singleFamily: singleFamily}, nil
 	case "udp":
 		addr := &net.UDPAddr{IP: hostIP, Port: hostPort}
-		return &dummyProxy{addr: addr}, nil
+		return &dummyProxy{addr: addr, singleFamily: singleFamily}, nil
 	case "sctp":
 		addr := &sctp.SCTPAddr{IP: []net.IP{hostIP}, Port: hostPort}
-		return &dummyProxy{addr: addr}, nil
+		return &dummyProxy{addr: addr, singleFamily: singleFamily}, nil
 	default:
 		return nil, fmt.Errorf("Unknown addr type: %s", proto)
 	}
 }
 
 func (p *dummyProxy) Start() error {
+	if p.listener != nil {
+		return nil
+	}
 	switch addr := p.addr.(type) {
 	case *net.TCPAddr:
-		l, err := net.ListenTCP("tcp", addr)
+		l, err := net.ListenTCP(proxy.ListenNetwork("tcp", addr.IP, p.singleFamily), addr)
 		if err != nil {
 			return err
 		}
 		p.listener = l
 	case *net.UDPAddr:
-		l, err := net.ListenUDP("udp", addr)
+		l, err := net.ListenUDP(proxy.ListenNetwork("udp", addr.IP, p.singleFamily), addr)
 		if err != nil {
 			return err
 		}
@@ -5,9 +5,11 @@ import (
 	"os/exec"
 	"strconv"
 	"syscall"
+
+	"github.com/docker/libnetwork/proxy"
 )
 
-func newProxyCommand(proto string, hostIP net.IP, hostPort int, containerIP net.IP, containerPort int, proxyPath string) (userlandProxy, error) {
+func newProxyCommand(proto string, hostIP net.IP, hostPort int, containerIP net.IP, containerPort int, proxyPath string, config proxy.Config) (userlandProxy, error) {
 	path := proxyPath
 	if proxyPath == "" {
 		cmd, err := exec.LookPath(userlandProxyCommandName)
@@ -25,6 +27,21 @@ func newProxyCommand(proto string, hostIP net.IP, hostPort int, containerIP net.
 		"-container-ip", containerIP.String(),
 		"-container-port", strconv.Itoa(containerPort),
 	}
+	if config.ProxyProtocol != 0 {
+		args = append(args, "-proxy-protocol", strconv.Itoa(config.ProxyProtocol))
+	}
+	if config.MaxConnections != 0 {
+		args = append(args, "-max-connections", strconv.Itoa(config.MaxConnections))
+	}
+	if config.IdleTimeout != 0 {
+		args = append(args, "-idle-timeout", config.IdleTimeout.String())
+	}
+	if config.UDPFlowTimeout != 0 {
+		args = append(args, "-udp-flow-timeout", config.UDPFlowTimeout.String())
+	}
+	if config.SingleFamily {
+		args = append(args, "-single-family")
+	}
 
 	return &proxyCommand{
 		cmd: &exec.Cmd{
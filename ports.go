@@ -0,0 +1,203 @@
+package libnetwork
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/docker/libnetwork/common"
+	"github.com/docker/libnetwork/diagnostic"
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/portallocator"
+	"github.com/docker/libnetwork/proxy"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+// PortMapping describes a host port published for an endpoint, along with the
+// sandbox and the container the endpoint is attached to.
+type PortMapping struct {
+	types.PortBinding
+	NetworkID   string
+	EndpointID  string
+	SandboxID   string
+	ContainerID string
+	// ProxyStats are the counters of the userland proxy serving the
+	// mapping, if the driver runs it in-process.
+	ProxyStats *proxy.Stats `json:",omitempty"`
+}
+
+func (pm PortMapping) String() string {
+	s := fmt.Sprintf("%s %s:%d -> %s:%d network:%s endpoint:%s sandbox:%s container:%s",
+		pm.Proto.String(), pm.HostIP, pm.HostPort, pm.IP, pm.Port,
+		pm.NetworkID, pm.EndpointID, pm.SandboxID, pm.ContainerID)
+	if pm.ProxyStats != nil {
+		s += fmt.Sprintf(" proxy:active=%d,rejected=%d,in=%d,out=%d",
+			pm.ProxyStats.ActiveConnections, pm.ProxyStats.RejectedConnections,
+			pm.ProxyStats.BytesIn, pm.ProxyStats.BytesOut)
+	}
+	return s
+}
+
+// holds tells whether the mapping holds the port on the host IP. A mapping on
+// the unspecified address holds the port on every IP, and an unspecified IP
+// matches the mappings of every IP.
+func (pm PortMapping) holds(hostIP net.IP, proto types.Protocol, port uint16) bool {
+	if pm.Proto != proto || pm.HostPort != port {
+		return false
+	}
+	return hostIP == nil || hostIP.IsUnspecified() || pm.HostIP == nil || pm.HostIP.IsUnspecified() || pm.HostIP.Equal(hostIP)
+}
+
+func (c *controller) PortMappings() []PortMapping {
+	var list []PortMapping
+
+	networks, err := c.getNetworksFromStore()
+	if err != nil {
+		logrus.Error(err)
+	}
+
+	for _, n := range networks {
+		if n.inDelete {
+			continue
+		}
+		d, err := n.driver(false)
+		if err != nil {
+			continue
+		}
+		endpoints, err := n.getEndpointsFromStore()
+		if err != nil {
+			logrus.Error(err)
+			continue
+		}
+		for _, ep := range endpoints {
+			info, err := d.EndpointOperInfo(n.ID(), ep.ID())
+			if err != nil {
+				// Not an endpoint of this host
+				continue
+			}
+			bindings, ok := info[netlabel.PortMap].([]types.PortBinding)
+			if !ok {
+				continue
+			}
+			stats, _ := info[netlabel.PortMapProxyStats].([]*proxy.Stats)
+			var sbID, containerID string
+			if sb, ok := ep.getSandbox(); ok {
+				sbID, containerID = sb.ID(), sb.ContainerID()
+			}
+			for i, b := range bindings {
+				pm := PortMapping{
+					PortBinding: b,
+					NetworkID:   n.ID(),
+					EndpointID:  ep.ID(),
+					SandboxID:   sbID,
+					ContainerID: containerID,
+				}
+				if i < len(stats) {
+					pm.ProxyStats = stats[i]
+				}
+				list = append(list, pm)
+			}
+		}
+	}
+
+	return list
+}
+
+func (c *controller) PortOwner(hostIP net.IP, proto types.Protocol, port uint16) (PortMapping, error) {
+	for _, pm := range c.PortMappings() {
+		if pm.holds(hostIP, proto, port) {
+			return pm, nil
+		}
+	}
+	return PortMapping{}, types.NotFoundErrorf("no mapping holds host port %s/%d on %s", proto.String(), port, hostIP)
+}
+
+// describePortOwner returns the container holding the host port when err is
+// a port allocation failure, in a form suitable to be appended to the error.
+func (c *controller) describePortOwner(err error) string {
+	pErr, ok := err.(portallocator.ErrPortAlreadyAllocated)
+	if !ok || pErr.Owner().EndpointID == "" {
+		return ""
+	}
+	n, err := c.getNetworkFromStore(pErr.Owner().NetworkID)
+	if err != nil {
+		return ""
+	}
+	ep, err := n.getEndpointFromStore(pErr.Owner().EndpointID)
+	if err != nil {
+		return ""
+	}
+	sb, ok := ep.getSandbox()
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf(" (port held by container %s)", sb.ContainerID())
+}
+
+var portsDiagPaths2Func = map[string]diagnostic.HTTPHandlerFunc{
+	"/ports": diagGetPorts,
+}
+
+// portMappingsResult lists the port mappings on the diagnostic server
+type portMappingsResult struct {
+	Length   int           `json:"size"`
+	Elements []PortMapping `json:"entries"`
+}
+
+func (r *portMappingsResult) String() string {
+	output := fmt.Sprintf("total entries: %d\n", r.Length)
+	for i, pm := range r.Elements {
+		output += fmt.Sprintf("%d) %s\n", i, pm)
+	}
+	return output
+}
+
+func diagGetPorts(ctx interface{}, w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	diagnostic.DebugHTTPForm(r)
+	_, json := diagnostic.ParseHTTPFormOptions(r)
+
+	// audit logs
+	log := logrus.WithFields(logrus.Fields{"component": "diagnostic", "remoteIP": r.RemoteAddr, "method": common.CallerName(0), "url": r.URL.String()})
+	log.Info("get ports")
+
+	c, ok := ctx.(*controller)
+	if !ok {
+		diagnostic.HTTPReply(w, diagnostic.FailCommand(fmt.Errorf("controller not available")), json)
+		return
+	}
+
+	list := c.PortMappings()
+
+	// Optionally look for the owner of a host port
+	if len(r.Form["port"]) > 0 {
+		port, err := strconv.ParseUint(r.Form["port"][0], 10, 16)
+		if err != nil {
+			rsp := diagnostic.WrongCommand("invalid port", fmt.Sprintf("%s?port=port&proto=tcp|udp|sctp&ip=host_ip", r.URL.Path))
+			log.Error("get ports failed, wrong input")
+			diagnostic.HTTPReply(w, rsp, json)
+			return
+		}
+		proto := types.Protocol(types.TCP)
+		if len(r.Form["proto"]) > 0 {
+			proto = types.ParseProtocol(r.Form["proto"][0])
+		}
+		var hostIP net.IP
+		if len(r.Form["ip"]) > 0 {
+			hostIP = net.ParseIP(r.Form["ip"][0])
+		}
+		var owners []PortMapping
+		for _, pm := range list {
+			if pm.holds(hostIP, proto, uint16(port)) {
+				owners = append(owners, pm)
+			}
+		}
+		list = owners
+	}
+
+	rsp := &portMappingsResult{Length: len(list), Elements: list}
+	log.WithField("response", fmt.Sprintf("%+v", rsp)).Info("get ports done")
+	diagnostic.HTTPReply(w, diagnostic.CommandSucceed(rsp), json)
+}
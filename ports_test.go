@@ -0,0 +1,124 @@
+package libnetwork
+
+import (
+	"errors"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/docker/libnetwork/portallocator"
+	"github.com/docker/libnetwork/testutils"
+	"github.com/docker/libnetwork/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPortMappingHolds(t *testing.T) {
+	pm := PortMapping{PortBinding: types.PortBinding{Proto: types.TCP, HostIP: net.ParseIP("10.0.0.1"), HostPort: 8080}}
+	assert.True(t, pm.holds(net.ParseIP("10.0.0.1"), types.TCP, 8080))
+	assert.True(t, pm.holds(nil, types.TCP, 8080))
+	assert.True(t, pm.holds(net.IPv4zero, types.TCP, 8080))
+	assert.False(t, pm.holds(net.ParseIP("10.0.0.2"), types.TCP, 8080))
+	assert.False(t, pm.holds(net.ParseIP("10.0.0.1"), types.UDP, 8080))
+	assert.False(t, pm.holds(net.ParseIP("10.0.0.1"), types.TCP, 8081))
+
+	// A mapping on the unspecified address holds the port on every IP
+	pm.HostIP = net.IPv4zero
+	assert.True(t, pm.holds(net.ParseIP("10.0.0.2"), types.TCP, 8080))
+	pm.HostIP = nil
+	assert.True(t, pm.holds(net.ParseIP("10.0.0.2"), types.TCP, 8080))
+}
+
+func TestPortMappings(t *testing.T) {
+	defer testutils.SetupTestOSContext(t)()
+
+	ctrlr, err := New()
+	require.NoError(t, err)
+	defer ctrlr.Stop()
+	c := ctrlr.(*controller)
+
+	n, err := c.NewNetwork("bridge", "net1", "", nil)
+	require.NoError(t, err)
+	defer n.Delete()
+
+	ep, err := n.CreateEndpoint("ep1")
+	require.NoError(t, err)
+	defer ep.Delete(false)
+
+	sb, err := c.NewSandbox("ctr1", OptionPortMapping([]types.PortBinding{{Proto: types.TCP, Port: 80, HostPort: 18080}}))
+	require.NoError(t, err)
+	defer sb.Delete()
+
+	require.NoError(t, ep.Join(sb))
+	defer ep.Leave(sb)
+
+	list := c.PortMappings()
+	require.Len(t, list, 1)
+	assert.Equal(t, uint16(18080), list[0].HostPort)
+	assert.Equal(t, n.ID(), list[0].NetworkID)
+	assert.Equal(t, ep.ID(), list[0].EndpointID)
+	assert.Equal(t, sb.ID(), list[0].SandboxID)
+	assert.Equal(t, "ctr1", list[0].ContainerID)
+
+	pm, err := c.PortOwner(net.ParseIP("127.0.0.1"), types.TCP, 18080)
+	require.NoError(t, err)
+	assert.Equal(t, ep.ID(), pm.EndpointID)
+
+	_, err = c.PortOwner(nil, types.UDP, 18080)
+	_, ok := err.(types.NotFoundError)
+	assert.True(t, ok, "Expected a NotFoundError, got %v", err)
+
+	// The allocation failure of the port names its container
+	_, err = portallocator.Get().RequestPort(nil, "tcp", 18080)
+	require.Error(t, err)
+	assert.Equal(t, " (port held by container ctr1)", c.describePortOwner(err))
+	assert.Equal(t, "", c.describePortOwner(errors.New("unrelated error")))
+}
+
+func TestDiagGetPorts(t *testing.T) {
+	defer testutils.SetupTestOSContext(t)()
+
+	ctrlr, err := New()
+	require.NoError(t, err)
+	defer ctrlr.Stop()
+	c := ctrlr.(*controller)
+
+	n, err := c.NewNetwork("bridge", "net1", "", nil)
+	require.NoError(t, err)
+	defer n.Delete()
+
+	ep, err := n.CreateEndpoint("ep1")
+	require.NoError(t, err)
+	defer ep.Delete(false)
+
+	sb, err := c.NewSandbox("ctr1", OptionPortMapping([]types.PortBinding{{Proto: types.TCP, Port: 80, HostPort: 18080}}))
+	require.NoError(t, err)
+	defer sb.Delete()
+
+	require.NoError(t, ep.Join(sb))
+	defer ep.Leave(sb)
+
+	get := func(url string) string {
+		w := httptest.NewRecorder()
+		diagGetPorts(c, w, httptest.NewRequest("GET", url, nil))
+		return w.Body.String()
+	}
+
+	out := get("/ports")
+	assert.True(t, strings.Contains(out, "total entries: 1"), out)
+	assert.True(t, strings.Contains(out, "container:ctr1"), out)
+
+	out = get("/ports?port=18080&proto=tcp")
+	assert.True(t, strings.Contains(out, "endpoint:"+ep.ID()), out)
+
+	out = get("/ports?port=18080&proto=udp")
+	assert.True(t, strings.Contains(out, "total entries: 0"), out)
+
+	out = get("/ports?port=invalid")
+	assert.True(t, strings.Contains(out, "invalid port"), out)
+
+	w := httptest.NewRecorder()
+	diagGetPorts(nil, w, httptest.NewRequest("GET", "/ports", nil))
+	assert.True(t, strings.Contains(w.Body.String(), "controller not available"), w.Body.String())
+}
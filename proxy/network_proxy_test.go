@@ -1,4 +1,4 @@
-package main
+package proxy
 
 import (
 	"bytes"
@@ -176,7 +176,7 @@ func testTCP4Proxy(t *testing.T, halfClose bool) {
 	defer backend.Close()
 	backend.Run()
 	frontendAddr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0}
-	proxy, err := NewProxy(frontendAddr, backend.LocalAddr())
+	proxy, err := NewProxy(frontendAddr, backend.LocalAddr(), Config{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -197,7 +197,7 @@ func TestTCP6Proxy(t *testing.T) {
 	defer backend.Close()
 	backend.Run()
 	frontendAddr := &net.TCPAddr{IP: net.IPv6loopback, Port: 0}
-	proxy, err := NewProxy(frontendAddr, backend.LocalAddr())
+	proxy, err := NewProxy(frontendAddr, backend.LocalAddr(), Config{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -212,7 +212,7 @@ func TestTCPDualStackProxy(t *testing.T) {
 	defer backend.Close()
 	backend.Run()
 	frontendAddr := &net.TCPAddr{IP: net.IPv6loopback, Port: 0}
-	proxy, err := NewProxy(frontendAddr, backend.LocalAddr())
+	proxy, err := NewProxy(frontendAddr, backend.LocalAddr(), Config{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -228,7 +228,7 @@ func TestUDP4Proxy(t *testing.T) {
 	defer backend.Close()
 	backend.Run()
 	frontendAddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0}
-	proxy, err := NewProxy(frontendAddr, backend.LocalAddr())
+	proxy, err := NewProxy(frontendAddr, backend.LocalAddr(), Config{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -241,7 +241,7 @@ func TestUDP6Proxy(t *testing.T) {
 	defer backend.Close()
 	backend.Run()
 	frontendAddr := &net.UDPAddr{IP: net.IPv6loopback, Port: 0}
-	proxy, err := NewProxy(frontendAddr, backend.LocalAddr())
+	proxy, err := NewProxy(frontendAddr, backend.LocalAddr(), Config{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -252,7 +252,7 @@ func TestUDPWriteError(t *testing.T) {
 	frontendAddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0}
 	// Hopefully, this port will be free: */
 	backendAddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 25587}
-	proxy, err := NewProxy(frontendAddr, backendAddr)
+	proxy, err := NewProxy(frontendAddr, backendAddr, Config{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -287,7 +287,7 @@ func TestSCTP4Proxy(t *testing.T) {
 	defer backend.Close()
 	backend.Run()
 	frontendAddr := &sctp.SCTPAddr{IP: []net.IP{net.IPv4(127, 0, 0, 1)}, Port: 0}
-	proxy, err := NewProxy(frontendAddr, backend.LocalAddr())
+	proxy, err := NewProxy(frontendAddr, backend.LocalAddr(), Config{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -300,9 +300,218 @@ func TestSCTP6Proxy(t *testing.T) {
 	defer backend.Close()
 	backend.Run()
 	frontendAddr := &sctp.SCTPAddr{IP: []net.IP{net.IPv6loopback}, Port: 0}
-	proxy, err := NewProxy(frontendAddr, backend.LocalAddr())
+	proxy, err := NewProxy(frontendAddr, backend.LocalAddr(), Config{})
 	if err != nil {
 		t.Fatal(err)
 	}
 	testProxy(t, "sctp", proxy, false)
 }
+
+func testTCP4ProxyProtocol(t *testing.T, version int, expectedHeader func(client, frontend *net.TCPAddr) []byte) {
+	backend := NewEchoServer(t, "tcp", "127.0.0.1:0", EchoServerOptions{})
+	defer backend.Close()
+	backend.Run()
+	frontendAddr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0}
+	proxy, err := NewProxy(frontendAddr, backend.LocalAddr(), Config{ProxyProtocol: version})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxy.Close()
+	go proxy.Run()
+
+	client, err := net.Dial("tcp", proxy.FrontendAddr().String())
+	if err != nil {
+		t.Fatalf("Can't connect to the proxy: %v", err)
+	}
+	defer client.Close()
+	client.SetDeadline(time.Now().Add(10 * time.Second))
+	if _, err = client.Write(testBuf); err != nil {
+		t.Fatal(err)
+	}
+
+	// The echo server sends back the PROXY protocol header along with
+	// the data.
+	header := expectedHeader(client.LocalAddr().(*net.TCPAddr), proxy.FrontendAddr().(*net.TCPAddr))
+	recvBuf := make([]byte, len(header)+testBufSize)
+	if _, err = io.ReadFull(client, recvBuf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(header, recvBuf[:len(header)]) {
+		t.Fatal(fmt.Errorf("Expected header [%q] but got [%q]", header, recvBuf[:len(header)]))
+	}
+	if !bytes.Equal(testBuf, recvBuf[len(header):]) {
+		t.Fatal(fmt.Errorf("Expected [%v] but got [%v]", testBuf, recvBuf[len(header):]))
+	}
+}
+
+func TestTCP4ProxyProtocolV1(t *testing.T) {
+	testTCP4ProxyProtocol(t, ProxyProtocolV1, func(client, frontend *net.TCPAddr) []byte {
+		return []byte(fmt.Sprintf("PROXY TCP4 127.0.0.1 127.0.0.1 %d %d\r\n", client.Port, frontend.Port))
+	})
+}
+
+func TestTCP4ProxyProtocolV2(t *testing.T) {
+	testTCP4ProxyProtocol(t, ProxyProtocolV2, func(client, frontend *net.TCPAddr) []byte {
+		return []byte{
+			0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A,
+			0x21, 0x11, 0x00, 0x0C,
+			127, 0, 0, 1,
+			127, 0, 0, 1,
+			byte(client.Port >> 8), byte(client.Port),
+			byte(frontend.Port >> 8), byte(frontend.Port),
+		}
+	})
+}
+
+func TestProxyProtocolV1Mixed(t *testing.T) {
+	src := &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 1234}
+	dst := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 80}
+	header, err := proxyProtocolHeader(ProxyProtocolV1, src, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(header) != "PROXY UNKNOWN\r\n" {
+		t.Fatalf("Unexpected header for mixed families: %q", header)
+	}
+}
+
+func TestProxyProtocolUnsupported(t *testing.T) {
+	frontendAddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0}
+	backendAddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 25587}
+	if _, err := NewProxy(frontendAddr, backendAddr, Config{ProxyProtocol: ProxyProtocolV1}); err == nil {
+		t.Fatal("Expected an error for the PROXY protocol over udp")
+	}
+
+	tcpFrontendAddr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0}
+	tcpBackendAddr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 25587}
+	if _, err := NewProxy(tcpFrontendAddr, tcpBackendAddr, Config{ProxyProtocol: 3}); err == nil {
+		t.Fatal("Expected an error for an unknown PROXY protocol version")
+	}
+}
+
+func TestListenNetwork(t *testing.T) {
+	for _, c := range []struct {
+		ip           net.IP
+		singleFamily bool
+		expected     string
+	}{
+		{nil, true, "tcp"},
+		{net.IPv4zero, false, "tcp"},
+		{net.IPv6unspecified, false, "tcp"},
+		{net.IPv4zero, true, "tcp4"},
+		{net.IPv6unspecified, true, "tcp6"},
+		{net.IPv4(127, 0, 0, 1), true, "tcp"},
+		{net.IPv6loopback, true, "tcp"},
+	} {
+		if network := ListenNetwork("tcp", c.ip, c.singleFamily); network != c.expected {
+			t.Fatalf("Unexpected network for %v (single family %v): %s", c.ip, c.singleFamily, network)
+		}
+	}
+}
+
+func waitForStats(t *testing.T, proxy Proxy, check func(Stats) bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !check(proxy.Stats()) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Unexpected proxy stats: %+v", proxy.Stats())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTCPProxyLimits(t *testing.T) {
+	backend := NewEchoServer(t, "tcp", "127.0.0.1:0", EchoServerOptions{})
+	defer backend.Close()
+	backend.Run()
+	frontendAddr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0}
+	proxy, err := NewProxy(frontendAddr, backend.LocalAddr(), Config{MaxConnections: 1, IdleTimeout: 500 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxy.Close()
+	go proxy.Run()
+
+	client, err := net.Dial("tcp", proxy.FrontendAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.SetDeadline(time.Now().Add(10 * time.Second))
+	if _, err = client.Write(testBuf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = io.ReadFull(client, make([]byte, testBufSize)); err != nil {
+		t.Fatal(err)
+	}
+	waitForStats(t, proxy, func(s Stats) bool {
+		return s.ActiveConnections == 1 && s.BytesIn == uint64(testBufSize) && s.BytesOut == uint64(testBufSize)
+	})
+
+	// The second connection exceeds the limit and is closed by the proxy
+	rejected, err := net.Dial("tcp", proxy.FrontendAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rejected.Close()
+	rejected.SetDeadline(time.Now().Add(10 * time.Second))
+	if _, err := rejected.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("Expected the connection over the limit to be closed, got %v", err)
+	}
+	waitForStats(t, proxy, func(s Stats) bool { return s.RejectedConnections == 1 })
+
+	// The first connection is closed once idle
+	if _, err := client.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("Expected the idle connection to be closed, got %v", err)
+	}
+	waitForStats(t, proxy, func(s Stats) bool { return s.ActiveConnections == 0 })
+}
+
+func TestUDPProxyLimits(t *testing.T) {
+	backend := NewEchoServer(t, "udp", "127.0.0.1:0", EchoServerOptions{})
+	defer backend.Close()
+	backend.Run()
+	frontendAddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0}
+	proxy, err := NewProxy(frontendAddr, backend.LocalAddr(), Config{MaxConnections: 1, UDPFlowTimeout: 500 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxy.Close()
+	go proxy.Run()
+
+	exchange := func(client net.Conn) error {
+		client.SetDeadline(time.Now().Add(250 * time.Millisecond))
+		if _, err := client.Write(testBuf); err != nil {
+			return err
+		}
+		_, err := client.Read(make([]byte, testBufSize))
+		return err
+	}
+
+	client1, err := net.Dial("udp", proxy.FrontendAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client1.Close()
+	if err := exchange(client1); err != nil {
+		t.Fatal(err)
+	}
+
+	client2, err := net.Dial("udp", proxy.FrontendAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client2.Close()
+	if err := exchange(client2); err == nil {
+		t.Fatal("Expected the flow over the limit to be dropped")
+	}
+	waitForStats(t, proxy, func(s Stats) bool {
+		return s.ActiveConnections == 1 && s.RejectedConnections >= 1 &&
+			s.BytesIn == uint64(testBufSize) && s.BytesOut == uint64(testBufSize)
+	})
+
+	// Once the first flow timed out, the second one goes through
+	waitForStats(t, proxy, func(s Stats) bool { return s.ActiveConnections == 0 })
+	if err := exchange(client2); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,84 @@
+// Package proxy provides a network Proxy interface and implementations for TCP,
+// UDP and SCTP. It is used by docker-proxy and by the in-process proxies of the
+// portmapper.
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/ishidawataru/sctp"
+)
+
+// Proxy defines the behavior of a proxy. It forwards traffic back and forth
+// between two endpoints : the frontend and the backend.
+// It can be used to do software port-mapping between two addresses.
+// e.g. forward all traffic between the frontend (host) 127.0.0.1:3000
+// to the backend (container) at 172.17.42.108:4000.
+type Proxy interface {
+	// Run starts forwarding traffic back and forth between the front
+	// and back-end addresses.
+	Run()
+	// Close stops forwarding traffic and close both ends of the Proxy.
+	Close()
+	// FrontendAddr returns the address on which the proxy is listening.
+	FrontendAddr() net.Addr
+	// BackendAddr returns the proxied address.
+	BackendAddr() net.Addr
+	// Stats returns the counters of the proxy.
+	Stats() Stats
+}
+
+// Config holds the settings of a proxy.
+type Config struct {
+	// ProxyProtocol is the PROXY protocol header version sent to the
+	// backend. It is only supported for TCP.
+	ProxyProtocol int
+	// MaxConnections caps the number of concurrent connections, or UDP
+	// flows. The new ones are rejected once it is reached. 0 means no limit.
+	MaxConnections int
+	// IdleTimeout closes the TCP connections which had no traffic in
+	// either direction for that long. 0 disables it.
+	IdleTimeout time.Duration
+	// UDPFlowTimeout is how long a UDP flow is kept without traffic from
+	// the backend. UDPConnTrackTimeout is used if 0.
+	UDPFlowTimeout time.Duration
+	// SingleFamily restricts the listener of an unspecified frontend address
+	// to the family of the address, when the other family is published
+	// separately on the same port.
+	SingleFamily bool
+}
+
+// ListenNetwork returns the network to listen on ip with for the "tcp" or
+// "udp" network. With singleFamily, the network of an unspecified ip is
+// restricted to its family: the unspecified IPv4 address would otherwise
+// also bind the IPv6 one.
+func ListenNetwork(network string, ip net.IP, singleFamily bool) string {
+	switch {
+	case !singleFamily || ip == nil || !ip.IsUnspecified():
+		return network
+	case ip.To4() != nil:
+		return network + "4"
+	default:
+		return network + "6"
+	}
+}
+
+// NewProxy creates a Proxy according to the specified frontendAddr and backendAddr.
+func NewProxy(frontendAddr, backendAddr net.Addr, config Config) (Proxy, error) {
+	if _, ok := frontendAddr.(*net.TCPAddr); !ok && config.ProxyProtocol != ProxyProtocolNone {
+		return nil, fmt.Errorf("PROXY protocol is only supported for tcp")
+	}
+
+	switch frontendAddr.(type) {
+	case *net.UDPAddr:
+		return NewUDPProxy(frontendAddr.(*net.UDPAddr), backendAddr.(*net.UDPAddr), config)
+	case *net.TCPAddr:
+		return NewTCPProxy(frontendAddr.(*net.TCPAddr), backendAddr.(*net.TCPAddr), config)
+	case *sctp.SCTPAddr:
+		return NewSCTPProxy(frontendAddr.(*sctp.SCTPAddr), backendAddr.(*sctp.SCTPAddr), config)
+	default:
+		panic("Unsupported protocol")
+	}
+}
@@ -0,0 +1,119 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+)
+
+const (
+	// ProxyProtocolNone disables the PROXY protocol header.
+	ProxyProtocolNone = 0
+	// ProxyProtocolV1 prepends the human readable PROXY protocol header.
+	ProxyProtocolV1 = 1
+	// ProxyProtocolV2 prepends the binary PROXY protocol header.
+	ProxyProtocolV2 = 2
+)
+
+// proxyProtocolV2Signature starts every PROXY protocol v2 header.
+var proxyProtocolV2Signature = []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+
+const (
+	proxyProtocolV2Proxy   = 0x21 // version 2, PROXY command
+	proxyProtocolV2Local   = 0x20 // version 2, LOCAL command
+	proxyProtocolV2TCPv4   = 0x11 // AF_INET, STREAM
+	proxyProtocolV2TCPv6   = 0x21 // AF_INET6, STREAM
+	proxyProtocolV2Unknown = 0x00 // AF_UNSPEC, UNSPEC
+)
+
+// validProxyProtocol returns an error if the PROXY protocol version is not
+// supported.
+func validProxyProtocol(version int) error {
+	switch version {
+	case ProxyProtocolNone, ProxyProtocolV1, ProxyProtocolV2:
+		return nil
+	default:
+		return fmt.Errorf("unsupported PROXY protocol version %d", version)
+	}
+}
+
+// proxyProtocolHeader builds the PROXY protocol header announcing a
+// connection from src to dst.
+func proxyProtocolHeader(version int, src, dst *net.TCPAddr) ([]byte, error) {
+	switch version {
+	case ProxyProtocolV1:
+		return proxyProtocolV1Header(src, dst), nil
+	case ProxyProtocolV2:
+		return proxyProtocolV2Header(src, dst), nil
+	default:
+		return nil, fmt.Errorf("unsupported PROXY protocol version %d", version)
+	}
+}
+
+func proxyProtocolV1Header(src, dst *net.TCPAddr) []byte {
+	var family string
+	switch {
+	case src.IP.To4() != nil && dst.IP.To4() != nil:
+		family = "TCP4"
+	case src.IP.To4() == nil && dst.IP.To4() == nil:
+		family = "TCP6"
+	default:
+		return []byte("PROXY UNKNOWN\r\n")
+	}
+
+	return []byte(fmt.Sprintf("PROXY %s %s %s %d %d\r\n", family, src.IP, dst.IP, src.Port, dst.Port))
+}
+
+func proxyProtocolV2Header(src, dst *net.TCPAddr) []byte {
+	var (
+		family   byte
+		srcIP    net.IP
+		dstIP    net.IP
+		buf      bytes.Buffer
+		addrsLen uint16
+	)
+
+	switch {
+	case src.IP.To4() != nil && dst.IP.To4() != nil:
+		family = proxyProtocolV2TCPv4
+		srcIP, dstIP = src.IP.To4(), dst.IP.To4()
+	case src.IP.To4() == nil && dst.IP.To4() == nil:
+		family = proxyProtocolV2TCPv6
+		srcIP, dstIP = src.IP.To16(), dst.IP.To16()
+	default:
+		family = proxyProtocolV2Unknown
+	}
+
+	buf.Write(proxyProtocolV2Signature)
+	if family == proxyProtocolV2Unknown {
+		buf.WriteByte(proxyProtocolV2Local)
+		buf.WriteByte(family)
+		binary.Write(&buf, binary.BigEndian, addrsLen)
+		return buf.Bytes()
+	}
+
+	addrsLen = uint16(2*len(srcIP) + 4)
+	buf.WriteByte(proxyProtocolV2Proxy)
+	buf.WriteByte(family)
+	binary.Write(&buf, binary.BigEndian, addrsLen)
+	buf.Write(srcIP)
+	buf.Write(dstIP)
+	binary.Write(&buf, binary.BigEndian, uint16(src.Port))
+	binary.Write(&buf, binary.BigEndian, uint16(dst.Port))
+
+	return buf.Bytes()
+}
+
+// writeProxyProtocolHeader sends to the backend the PROXY protocol header of
+// the client connection.
+func writeProxyProtocolHeader(w io.Writer, version int, client *net.TCPConn) error {
+	header, err := proxyProtocolHeader(version, client.RemoteAddr().(*net.TCPAddr), client.LocalAddr().(*net.TCPAddr))
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(header)
+	return err
+}
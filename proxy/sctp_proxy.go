@@ -1,7 +1,6 @@
-package main
+package proxy
 
 import (
-	"io"
 	"log"
 	"net"
 	"sync"
@@ -12,27 +11,38 @@ import (
 // SCTPProxy is a proxy for SCTP connections. It implements the Proxy interface to
 // handle SCTP traffic forwarding between the frontend and backend addresses.
 type SCTPProxy struct {
+	counters     counters
 	listener     *sctp.SCTPListener
 	frontendAddr *sctp.SCTPAddr
 	backendAddr  *sctp.SCTPAddr
+	config       Config
 }
 
 // NewSCTPProxy creates a new SCTPProxy.
-func NewSCTPProxy(frontendAddr, backendAddr *sctp.SCTPAddr) (*SCTPProxy, error) {
+func NewSCTPProxy(frontendAddr, backendAddr *sctp.SCTPAddr, config Config) (*SCTPProxy, error) {
 	listener, err := sctp.ListenSCTP("sctp", frontendAddr)
 	if err != nil {
 		return nil, err
 	}
+	return NewSCTPProxyFromListener(listener, backendAddr, config)
+}
+
+// NewSCTPProxyFromListener creates a new SCTPProxy accepting the connections
+// of an existing listener. The proxy takes ownership of the listener.
+func NewSCTPProxyFromListener(listener *sctp.SCTPListener, backendAddr *sctp.SCTPAddr, config Config) (*SCTPProxy, error) {
 	// If the port in frontendAddr was 0 then ListenSCTP will have a picked
 	// a port to listen on, hence the call to Addr to get that actual port:
 	return &SCTPProxy{
 		listener:     listener,
 		frontendAddr: listener.Addr().(*sctp.SCTPAddr),
 		backendAddr:  backendAddr,
+		config:       config,
 	}, nil
 }
 
 func (proxy *SCTPProxy) clientLoop(client *sctp.SCTPConn, quit chan bool) {
+	defer proxy.counters.release()
+
 	backend, err := sctp.DialSCTP("sctp", nil, proxy.backendAddr)
 	if err != nil {
 		log.Printf("Can't forward traffic to backend sctp/%v: %s\n", proxy.backendAddr, err)
@@ -43,16 +53,17 @@ func (proxy *SCTPProxy) clientLoop(client *sctp.SCTPConn, quit chan bool) {
 	backendC := sctp.NewSCTPSndRcvInfoWrappedConn(backend)
 
 	var wg sync.WaitGroup
-	var broker = func(to, from net.Conn) {
-		io.Copy(to, from)
+	var broker = func(to, from net.Conn, count *uint64) {
+		// SCTP connections do not support deadlines, hence no idle timeout
+		pipe(to, from, count, nil)
 		from.Close()
 		to.Close()
 		wg.Done()
 	}
 
 	wg.Add(2)
-	go broker(clientC, backendC)
-	go broker(backendC, clientC)
+	go broker(clientC, backendC, &proxy.counters.bytesOut)
+	go broker(backendC, clientC, &proxy.counters.bytesIn)
 
 	finish := make(chan struct{})
 	go func() {
@@ -79,6 +90,10 @@ func (proxy *SCTPProxy) Run() {
 			log.Printf("Stopping proxy on sctp/%v for sctp/%v (%s)", proxy.frontendAddr, proxy.backendAddr, err)
 			return
 		}
+		if !proxy.counters.acquire(proxy.config.MaxConnections) {
+			client.Close()
+			continue
+		}
 		go proxy.clientLoop(client.(*sctp.SCTPConn), quit)
 	}
 }
@@ -91,3 +106,6 @@ func (proxy *SCTPProxy) FrontendAddr() net.Addr { return proxy.frontendAddr }
 
 // BackendAddr returns the SCTP proxied address.
 func (proxy *SCTPProxy) BackendAddr() net.Addr { return proxy.backendAddr }
+
+// Stats returns the counters of the proxy.
+func (proxy *SCTPProxy) Stats() Stats { return proxy.counters.stats() }
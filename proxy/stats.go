@@ -0,0 +1,114 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// Stats holds the counters of a proxy.
+type Stats struct {
+	// ActiveConnections is the number of open connections, or UDP flows.
+	ActiveConnections int64
+	// RejectedConnections counts the connections, or new UDP flows, refused
+	// because the proxy reached its maximum number of connections.
+	RejectedConnections uint64
+	// BytesIn counts the bytes forwarded from the frontend to the backend.
+	BytesIn uint64
+	// BytesOut counts the bytes forwarded from the backend to the frontend.
+	BytesOut uint64
+}
+
+// counters are the Stats of a proxy, updated atomically by its goroutines.
+type counters struct {
+	active   int64
+	rejected uint64
+	bytesIn  uint64
+	bytesOut uint64
+}
+
+// acquire accounts for a new connection. It returns false, and counts the
+// connection as rejected, if maxConnections connections are already open.
+func (c *counters) acquire(maxConnections int) bool {
+	active := atomic.AddInt64(&c.active, 1)
+	if maxConnections > 0 && active > int64(maxConnections) {
+		atomic.AddInt64(&c.active, -1)
+		atomic.AddUint64(&c.rejected, 1)
+		return false
+	}
+	return true
+}
+
+// release accounts for a closed connection.
+func (c *counters) release() {
+	atomic.AddInt64(&c.active, -1)
+}
+
+func (c *counters) stats() Stats {
+	return Stats{
+		ActiveConnections:   atomic.LoadInt64(&c.active),
+		RejectedConnections: atomic.LoadUint64(&c.rejected),
+		BytesIn:             atomic.LoadUint64(&c.bytesIn),
+		BytesOut:            atomic.LoadUint64(&c.bytesOut),
+	}
+}
+
+// idleTimer tracks the last traffic on a proxied connection. It is shared by
+// both directions, so that a connection is only idle when neither of them
+// carries traffic.
+type idleTimer struct {
+	timeout time.Duration
+	last    int64
+}
+
+func newIdleTimer(timeout time.Duration) *idleTimer {
+	if timeout <= 0 {
+		return nil
+	}
+	t := &idleTimer{timeout: timeout}
+	t.touch()
+	return t
+}
+
+func (t *idleTimer) touch() {
+	atomic.StoreInt64(&t.last, time.Now().UnixNano())
+}
+
+func (t *idleTimer) deadline() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&t.last)).Add(t.timeout)
+}
+
+type readDeadliner interface {
+	io.Reader
+	SetReadDeadline(t time.Time) error
+}
+
+// pipe copies the traffic of one direction of a proxied connection and adds
+// the forwarded bytes to count. If idle is not nil, it returns once the
+// connection had no traffic for the idle timeout.
+func pipe(to io.Writer, from readDeadliner, count *uint64, idle *idleTimer) {
+	buf := make([]byte, 32*1024)
+	for {
+		if idle != nil {
+			from.SetReadDeadline(idle.deadline())
+		}
+		n, err := from.Read(buf)
+		if n > 0 {
+			if idle != nil {
+				idle.touch()
+			}
+			if _, err := to.Write(buf[:n]); err != nil {
+				return
+			}
+			atomic.AddUint64(count, uint64(n))
+		}
+		if err != nil {
+			if nerr, ok := err.(net.Error); ok && nerr.Timeout() && idle != nil && time.Now().Before(idle.deadline()) {
+				// The other direction had traffic in the meantime
+				continue
+			}
+			return
+		}
+	}
+}
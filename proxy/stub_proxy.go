@@ -1,4 +1,4 @@
-package main
+package proxy
 
 import (
 	"net"
@@ -22,6 +22,9 @@ func (p *StubProxy) FrontendAddr() net.Addr { return p.frontendAddr }
 // BackendAddr returns the backend address.
 func (p *StubProxy) BackendAddr() net.Addr { return p.backendAddr }
 
+// Stats returns empty counters.
+func (p *StubProxy) Stats() Stats { return Stats{} }
+
 // NewStubProxy creates a new StubProxy
 func NewStubProxy(frontendAddr, backendAddr net.Addr) (Proxy, error) {
 	return &StubProxy{
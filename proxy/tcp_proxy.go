@@ -1,7 +1,6 @@
-package main
+package proxy
 
 import (
-	"io"
 	"log"
 	"net"
 	"sync"
@@ -10,27 +9,46 @@ import (
 // TCPProxy is a proxy for TCP connections. It implements the Proxy interface to
 // handle TCP traffic forwarding between the frontend and backend addresses.
 type TCPProxy struct {
+	counters     counters
 	listener     *net.TCPListener
 	frontendAddr *net.TCPAddr
 	backendAddr  *net.TCPAddr
+	config       Config
 }
 
-// NewTCPProxy creates a new TCPProxy.
-func NewTCPProxy(frontendAddr, backendAddr *net.TCPAddr) (*TCPProxy, error) {
-	listener, err := net.ListenTCP("tcp", frontendAddr)
+// NewTCPProxy creates a new TCPProxy. If config.ProxyProtocol is ProxyProtocolV1
+// or ProxyProtocolV2, each backend connection starts with a PROXY protocol header
+// carrying the client address.
+func NewTCPProxy(frontendAddr, backendAddr *net.TCPAddr, config Config) (*TCPProxy, error) {
+	if err := validProxyProtocol(config.ProxyProtocol); err != nil {
+		return nil, err
+	}
+	listener, err := net.ListenTCP(ListenNetwork("tcp", frontendAddr.IP, config.SingleFamily), frontendAddr)
 	if err != nil {
 		return nil, err
 	}
+	return NewTCPProxyFromListener(listener, backendAddr, config)
+}
+
+// NewTCPProxyFromListener creates a new TCPProxy accepting the connections
+// of an existing listener. The proxy takes ownership of the listener.
+func NewTCPProxyFromListener(listener *net.TCPListener, backendAddr *net.TCPAddr, config Config) (*TCPProxy, error) {
+	if err := validProxyProtocol(config.ProxyProtocol); err != nil {
+		return nil, err
+	}
 	// If the port in frontendAddr was 0 then ListenTCP will have a picked
 	// a port to listen on, hence the call to Addr to get that actual port:
 	return &TCPProxy{
 		listener:     listener,
 		frontendAddr: listener.Addr().(*net.TCPAddr),
 		backendAddr:  backendAddr,
+		config:       config,
 	}, nil
 }
 
 func (proxy *TCPProxy) clientLoop(client *net.TCPConn, quit chan bool) {
+	defer proxy.counters.release()
+
 	backend, err := net.DialTCP("tcp", nil, proxy.backendAddr)
 	if err != nil {
 		log.Printf("Can't forward traffic to backend tcp/%v: %s\n", proxy.backendAddr, err)
@@ -38,17 +56,27 @@ func (proxy *TCPProxy) clientLoop(client *net.TCPConn, quit chan bool) {
 		return
 	}
 
+	if proxy.config.ProxyProtocol != ProxyProtocolNone {
+		if err := writeProxyProtocolHeader(backend, proxy.config.ProxyProtocol, client); err != nil {
+			log.Printf("Can't send PROXY protocol header to backend tcp/%v: %s\n", proxy.backendAddr, err)
+			client.Close()
+			backend.Close()
+			return
+		}
+	}
+
 	var wg sync.WaitGroup
-	var broker = func(to, from *net.TCPConn) {
-		io.Copy(to, from)
+	idle := newIdleTimer(proxy.config.IdleTimeout)
+	var broker = func(to, from *net.TCPConn, count *uint64) {
+		pipe(to, from, count, idle)
 		from.CloseRead()
 		to.CloseWrite()
 		wg.Done()
 	}
 
 	wg.Add(2)
-	go broker(client, backend)
-	go broker(backend, client)
+	go broker(client, backend, &proxy.counters.bytesOut)
+	go broker(backend, client, &proxy.counters.bytesIn)
 
 	finish := make(chan struct{})
 	go func() {
@@ -75,6 +103,10 @@ func (proxy *TCPProxy) Run() {
 			log.Printf("Stopping proxy on tcp/%v for tcp/%v (%s)", proxy.frontendAddr, proxy.backendAddr, err)
 			return
 		}
+		if !proxy.counters.acquire(proxy.config.MaxConnections) {
+			client.Close()
+			continue
+		}
 		go proxy.clientLoop(client.(*net.TCPConn), quit)
 	}
 }
@@ -87,3 +119,6 @@ func (proxy *TCPProxy) FrontendAddr() net.Addr { return proxy.frontendAddr }
 
 // BackendAddr returns the TCP proxied address.
 func (proxy *TCPProxy) BackendAddr() net.Addr { return proxy.backendAddr }
+
+// Stats returns the counters of the proxy.
+func (proxy *TCPProxy) Stats() Stats { return proxy.counters.stats() }
@@ -1,4 +1,4 @@
-package main
+package proxy
 
 import (
 	"encoding/binary"
@@ -6,12 +6,13 @@ import (
 	"net"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
 
 const (
-	// UDPConnTrackTimeout is the timeout used for UDP connection tracking
+	// UDPConnTrackTimeout is the default timeout used for UDP connection tracking
 	UDPConnTrackTimeout = 90 * time.Second
 	// UDPBufSize is the buffer size for the UDP proxy
 	UDPBufSize = 65507
@@ -46,23 +47,35 @@ type connTrackMap map[connTrackKey]*net.UDPConn
 // interface to handle UDP traffic forwarding between the frontend and backend
 // addresses.
 type UDPProxy struct {
+	counters       counters
 	listener       *net.UDPConn
 	frontendAddr   *net.UDPAddr
 	backendAddr    *net.UDPAddr
+	config         Config
 	connTrackTable connTrackMap
 	connTrackLock  sync.Mutex
 }
 
 // NewUDPProxy creates a new UDPProxy.
-func NewUDPProxy(frontendAddr, backendAddr *net.UDPAddr) (*UDPProxy, error) {
-	listener, err := net.ListenUDP("udp", frontendAddr)
+func NewUDPProxy(frontendAddr, backendAddr *net.UDPAddr, config Config) (*UDPProxy, error) {
+	listener, err := net.ListenUDP(ListenNetwork("udp", frontendAddr.IP, config.SingleFamily), frontendAddr)
 	if err != nil {
 		return nil, err
 	}
+	return NewUDPProxyFromConn(listener, backendAddr, config)
+}
+
+// NewUDPProxyFromConn creates a new UDPProxy reading the datagrams of an
+// existing connection. The proxy takes ownership of the connection.
+func NewUDPProxyFromConn(listener *net.UDPConn, backendAddr *net.UDPAddr, config Config) (*UDPProxy, error) {
+	if config.UDPFlowTimeout <= 0 {
+		config.UDPFlowTimeout = UDPConnTrackTimeout
+	}
 	return &UDPProxy{
 		listener:       listener,
 		frontendAddr:   listener.LocalAddr().(*net.UDPAddr),
 		backendAddr:    backendAddr,
+		config:         config,
 		connTrackTable: make(connTrackMap),
 	}, nil
 }
@@ -73,11 +86,12 @@ func (proxy *UDPProxy) replyLoop(proxyConn *net.UDPConn, clientAddr *net.UDPAddr
 		delete(proxy.connTrackTable, *clientKey)
 		proxy.connTrackLock.Unlock()
 		proxyConn.Close()
+		proxy.counters.release()
 	}()
 
 	readBuf := make([]byte, UDPBufSize)
 	for {
-		proxyConn.SetReadDeadline(time.Now().Add(UDPConnTrackTimeout))
+		proxyConn.SetReadDeadline(time.Now().Add(proxy.config.UDPFlowTimeout))
 	again:
 		read, err := proxyConn.Read(readBuf)
 		if err != nil {
@@ -85,7 +99,7 @@ func (proxy *UDPProxy) replyLoop(proxyConn *net.UDPConn, clientAddr *net.UDPAddr
 				// This will happen if the last write failed
 				// (e.g: nothing is actually listening on the
 				// proxied port on the container), ignore it
-				// and continue until UDPConnTrackTimeout
+				// and continue until the flow timeout
 				// expires:
 				goto again
 			}
@@ -97,6 +111,7 @@ func (proxy *UDPProxy) replyLoop(proxyConn *net.UDPConn, clientAddr *net.UDPAddr
 				return
 			}
 			i += written
+			atomic.AddUint64(&proxy.counters.bytesOut, uint64(written))
 		}
 	}
 }
@@ -120,9 +135,14 @@ func (proxy *UDPProxy) Run() {
 		proxy.connTrackLock.Lock()
 		proxyConn, hit := proxy.connTrackTable[*fromKey]
 		if !hit {
+			if !proxy.counters.acquire(proxy.config.MaxConnections) {
+				proxy.connTrackLock.Unlock()
+				continue
+			}
 			proxyConn, err = net.DialUDP("udp", nil, proxy.backendAddr)
 			if err != nil {
 				log.Printf("Can't proxy a datagram to udp/%s: %s\n", proxy.backendAddr, err)
+				proxy.counters.release()
 				proxy.connTrackLock.Unlock()
 				continue
 			}
@@ -137,6 +157,7 @@ func (proxy *UDPProxy) Run() {
 				break
 			}
 			i += written
+			atomic.AddUint64(&proxy.counters.bytesIn, uint64(written))
 		}
 	}
 }
@@ -157,6 +178,9 @@ func (proxy *UDPProxy) FrontendAddr() net.Addr { return proxy.frontendAddr }
 // BackendAddr returns the proxied UDP address.
 func (proxy *UDPProxy) BackendAddr() net.Addr { return proxy.backendAddr }
 
+// Stats returns the counters of the proxy.
+func (proxy *UDPProxy) Stats() Stats { return proxy.counters.stats() }
+
 func isClosedError(err error) bool {
 	/* This comparison is ugly, but unfortunately, net.go doesn't export errClosing.
 	 * See:
@@ -83,6 +83,8 @@ type sandbox struct {
 	inDelete           bool
 	ingress            bool
 	ndotsSet           bool
+	lbTimeouts         *lbTimeouts
+	lbSync             *lbSync
 	sync.Mutex
 	// This mutex is used to serialize service related operation for an endpoint
 	// The lock is here because the endpoint is saved into the store so is not unique
@@ -741,6 +743,7 @@ func releaseOSSboxResources(osSbox osl.Sandbox, ep *endpoint) {
 	ep.Lock()
 	joinInfo := ep.joinInfo
 	vip := ep.virtualIP
+	vip6 := ep.virtualIPv6
 	ep.Unlock()
 
 	if len(vip) != 0 {
@@ -749,6 +752,12 @@ func releaseOSSboxResources(osSbox osl.Sandbox, ep *endpoint) {
 		}
 	}
 
+	if len(vip6) != 0 {
+		if err := osSbox.RemoveLoopbackAliasIP(&net.IPNet{IP: vip6, Mask: net.CIDRMask(128, 128)}); err != nil {
+			logrus.Warnf("Remove virtual IPv6 %v failed: %v", vip6, err)
+		}
+	}
+
 	if joinInfo == nil {
 		return
 	}
@@ -868,6 +877,19 @@ func (sb *sandbox) populateNetworkResources(ep *endpoint) error {
 		}
 	}
 
+	if len(ep.virtualIPv6) != 0 {
+		err := sb.osSbox.AddLoopbackAliasIP(&net.IPNet{IP: ep.virtualIPv6, Mask: net.CIDRMask(128, 128)})
+		if err != nil {
+			return fmt.Errorf("failed to add virtual IPv6 %v: %v", ep.virtualIPv6, err)
+		}
+	}
+
+	if len(ep.virtualIP) != 0 && isDSRMode(ep.lbMode) {
+		if err := sb.setupDSRBackend(ep.lbMode); err != nil {
+			return fmt.Errorf("failed to set up %s load balancing mode for virtual IP %v: %v", ep.lbMode, ep.virtualIP, err)
+		}
+	}
+
 	if joinInfo != nil {
 		// Set up non-interface routes.
 		for _, r := range joinInfo.StaticRoutes {
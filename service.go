@@ -15,6 +15,35 @@ var (
 	fwMarkCtrMu sync.Mutex
 )
 
+// Load balancing modes of a service. They select the ipvs forwarding
+// method used to reach the service tasks behind the virtual IP.
+const (
+	// LBModeNAT forwards the virtual IP traffic to the tasks through the
+	// ipvs NAT path. Replies go back through the load balancing sandbox.
+	LBModeNAT = "nat"
+	// LBModeTunnel encapsulates the virtual IP traffic in IPIP towards the
+	// tasks, which reply directly to the client.
+	LBModeTunnel = "tunnel"
+	// LBModeDirectRoute forwards the virtual IP traffic to the tasks by
+	// rewriting the destination MAC address. The tasks reply directly to the
+	// client and must share the layer 2 segment of the load balancer.
+	LBModeDirectRoute = "dr"
+)
+
+// dsrDrivers lists the network drivers supporting the direct server return
+// load balancing modes. Both modes need the tasks to be reachable at layer
+// 2 or by IPIP from every load balancing sandbox on the network.
+var dsrDrivers = map[string]bool{
+	"bridge":  true,
+	"overlay": true,
+}
+
+// isDSRMode returns whether the load balancing mode lets the service tasks
+// reply directly to the clients.
+func isDSRMode(mode string) bool {
+	return mode == LBModeTunnel || mode == LBModeDirectRoute
+}
+
 type portConfigs []*PortConfig
 
 func (p portConfigs) String() string {
@@ -50,6 +79,9 @@ type service struct {
 	// Service aliases
 	aliases []string
 
+	// Load balancing mode
+	lbMode string
+
 	// This maps tracks for each IP address the list of endpoints ID
 	// associated with it. At stable state the endpoint ID expected is 1
 	// but during transition and service change it is possible to have
@@ -81,12 +113,18 @@ func (s *service) printIPToEndpoint(ip string) (string, bool) {
 
 type lbBackend struct {
 	ip       net.IP
+	ip6      net.IP
+	local    bool
 	disabled bool
 }
 
 type loadBalancer struct {
 	vip    net.IP
+	vip6   net.IP
 	fwMark uint32
+	// vip6Reserved tells whether vip6 was allocated from the network's
+	// pool by this node
+	vip6Reserved bool
 
 	// Map of backend IPs backing this loadbalancer on this
 	// network. It is keyed with endpoint ID.
@@ -3,6 +3,7 @@
 package libnetwork
 
 import (
+	"fmt"
 	"net"
 
 	"github.com/docker/libnetwork/common"
@@ -11,7 +12,13 @@ import (
 
 const maxSetStringLen = 350
 
-func (c *controller) addEndpointNameResolution(svcName, svcID, nID, eID, containerName string, vip net.IP, serviceAliases, taskAliases []string, ip net.IP, addService bool, method string) error {
+// hasVIP returns whether the service has an IPv4 or an IPv6 virtual IP,
+// otherwise it is resolved with DNS round robin.
+func hasVIP(vip, vip6 net.IP) bool {
+	return len(vip) != 0 || len(vip6) != 0
+}
+
+func (c *controller) addEndpointNameResolution(svcName, svcID, nID, eID, containerName string, vip, vip6 net.IP, serviceAliases, taskAliases []string, ip, ip6 net.IP, addService bool, method string) error {
 	n, err := c.NetworkByID(nID)
 	if err != nil {
 		return err
@@ -29,23 +36,23 @@ func (c *controller) addEndpointNameResolution(svcName, svcID, nID, eID, contain
 	}
 
 	// Add endpoint IP to special "tasks.svc_name" so that the applications have access to DNS RR.
-	n.(*network).addSvcRecords(eID, "tasks."+svcName, serviceID, ip, nil, false, method)
+	n.(*network).addSvcRecords(eID, "tasks."+svcName, serviceID, ip, ip6, false, method)
 	for _, alias := range serviceAliases {
-		n.(*network).addSvcRecords(eID, "tasks."+alias, serviceID, ip, nil, false, method)
+		n.(*network).addSvcRecords(eID, "tasks."+alias, serviceID, ip, ip6, false, method)
 	}
 
 	// Add service name to vip in DNS, if vip is valid. Otherwise resort to DNS RR
-	if len(vip) == 0 {
-		n.(*network).addSvcRecords(eID, svcName, serviceID, ip, nil, false, method)
+	if !hasVIP(vip, vip6) {
+		n.(*network).addSvcRecords(eID, svcName, serviceID, ip, ip6, false, method)
 		for _, alias := range serviceAliases {
-			n.(*network).addSvcRecords(eID, alias, serviceID, ip, nil, false, method)
+			n.(*network).addSvcRecords(eID, alias, serviceID, ip, ip6, false, method)
 		}
 	}
 
-	if addService && len(vip) != 0 {
-		n.(*network).addSvcRecords(eID, svcName, serviceID, vip, nil, false, method)
+	if addService && hasVIP(vip, vip6) {
+		n.(*network).addSvcRecords(eID, svcName, serviceID, vip, vip6, false, method)
 		for _, alias := range serviceAliases {
-			n.(*network).addSvcRecords(eID, alias, serviceID, vip, nil, false, method)
+			n.(*network).addSvcRecords(eID, alias, serviceID, vip, vip6, false, method)
 		}
 	}
 
@@ -70,7 +77,7 @@ func (c *controller) addContainerNameResolution(nID, eID, containerName string,
 	return nil
 }
 
-func (c *controller) deleteEndpointNameResolution(svcName, svcID, nID, eID, containerName string, vip net.IP, serviceAliases, taskAliases []string, ip net.IP, rmService, multipleEntries bool, method string) error {
+func (c *controller) deleteEndpointNameResolution(svcName, svcID, nID, eID, containerName string, vip, vip6 net.IP, serviceAliases, taskAliases []string, ip, ip6 net.IP, rmService, multipleEntries bool, method string) error {
 	n, err := c.NetworkByID(nID)
 	if err != nil {
 		return err
@@ -89,25 +96,25 @@ func (c *controller) deleteEndpointNameResolution(svcName, svcID, nID, eID, cont
 
 	// Delete the special "tasks.svc_name" backend record.
 	if !multipleEntries {
-		n.(*network).deleteSvcRecords(eID, "tasks."+svcName, serviceID, ip, nil, false, method)
+		n.(*network).deleteSvcRecords(eID, "tasks."+svcName, serviceID, ip, ip6, false, method)
 		for _, alias := range serviceAliases {
-			n.(*network).deleteSvcRecords(eID, "tasks."+alias, serviceID, ip, nil, false, method)
+			n.(*network).deleteSvcRecords(eID, "tasks."+alias, serviceID, ip, ip6, false, method)
 		}
 	}
 
 	// If we are doing DNS RR delete the endpoint IP from DNS record right away.
-	if !multipleEntries && len(vip) == 0 {
-		n.(*network).deleteSvcRecords(eID, svcName, serviceID, ip, nil, false, method)
+	if !multipleEntries && !hasVIP(vip, vip6) {
+		n.(*network).deleteSvcRecords(eID, svcName, serviceID, ip, ip6, false, method)
 		for _, alias := range serviceAliases {
-			n.(*network).deleteSvcRecords(eID, alias, serviceID, ip, nil, false, method)
+			n.(*network).deleteSvcRecords(eID, alias, serviceID, ip, ip6, false, method)
 		}
 	}
 
 	// Remove the DNS record for VIP only if we are removing the service
-	if rmService && len(vip) != 0 && !multipleEntries {
-		n.(*network).deleteSvcRecords(eID, svcName, serviceID, vip, nil, false, method)
+	if rmService && hasVIP(vip, vip6) && !multipleEntries {
+		n.(*network).deleteSvcRecords(eID, svcName, serviceID, vip, vip6, false, method)
 		for _, alias := range serviceAliases {
-			n.(*network).deleteSvcRecords(eID, alias, serviceID, vip, nil, false, method)
+			n.(*network).deleteSvcRecords(eID, alias, serviceID, vip, vip6, false, method)
 		}
 	}
 
@@ -132,13 +139,14 @@ func (c *controller) delContainerNameResolution(nID, eID, containerName string,
 	return nil
 }
 
-func newService(name string, id string, ingressPorts []*PortConfig, serviceAliases []string) *service {
+func newService(name string, id string, ingressPorts []*PortConfig, serviceAliases []string, lbMode string) *service {
 	return &service{
 		name:          name,
 		id:            id,
 		ingressPorts:  ingressPorts,
 		loadBalancers: make(map[string]*loadBalancer),
 		aliases:       serviceAliases,
+		lbMode:        lbMode,
 		ipToEndpoint:  common.NewSetMatrix(),
 	}
 }
@@ -199,7 +207,7 @@ func (c *controller) cleanupServiceBindings(cleanupNID string) {
 				continue
 			}
 			for eid, be := range lb.backEnds {
-				cleanupFuncs = append(cleanupFuncs, makeServiceCleanupFunc(c, s, nid, eid, lb.vip, be.ip))
+				cleanupFuncs = append(cleanupFuncs, makeServiceCleanupFunc(c, s, nid, eid, lb.vip, lb.vip6, be.ip, be.ip6))
 			}
 		}
 		s.Unlock()
@@ -211,18 +219,18 @@ func (c *controller) cleanupServiceBindings(cleanupNID string) {
 
 }
 
-func makeServiceCleanupFunc(c *controller, s *service, nID, eID string, vip net.IP, ip net.IP) func() {
+func makeServiceCleanupFunc(c *controller, s *service, nID, eID string, vip, vip6 net.IP, ip, ip6 net.IP) func() {
 	// ContainerName and taskAliases are not available here, this is still fine because the Service discovery
 	// cleanup already happened before. The only thing that rmServiceBinding is still doing here a part from the Load
 	// Balancer bookeeping, is to keep consistent the mapping of endpoint to IP.
 	return func() {
-		if err := c.rmServiceBinding(s.name, s.id, nID, eID, "", vip, s.ingressPorts, s.aliases, []string{}, ip, "cleanupServiceBindings", false, true); err != nil {
+		if err := c.rmServiceBinding(s.name, s.id, nID, eID, "", vip, vip6, s.ingressPorts, s.aliases, []string{}, ip, ip6, "cleanupServiceBindings", false, true); err != nil {
 			logrus.Errorf("Failed to remove service bindings for service %s network %s endpoint %s while cleanup: %v", s.id, nID, eID, err)
 		}
 	}
 }
 
-func (c *controller) addServiceBinding(svcName, svcID, nID, eID, containerName string, vip net.IP, ingressPorts []*PortConfig, serviceAliases, taskAliases []string, ip net.IP, method string) error {
+func (c *controller) addServiceBinding(svcName, svcID, nID, eID, containerName string, vip, vip6 net.IP, ingressPorts []*PortConfig, serviceAliases, taskAliases []string, ip, ip6 net.IP, lbMode string, local bool, method string) error {
 	var addService bool
 
 	n, err := c.NetworkByID(nID)
@@ -243,7 +251,7 @@ func (c *controller) addServiceBinding(svcName, svcID, nID, eID, containerName s
 		if !ok {
 			// Create a new service if we are seeing this service
 			// for the first time.
-			s = newService(svcName, svcID, ingressPorts, serviceAliases)
+			s = newService(svcName, svcID, ingressPorts, serviceAliases, lbMode)
 			c.serviceBindings[skey] = s
 		}
 		c.Unlock()
@@ -258,6 +266,26 @@ func (c *controller) addServiceBinding(svcName, svcID, nID, eID, containerName s
 	defer s.Unlock()
 
 	lb, ok := s.loadBalancers[nID]
+	if ok {
+		vip6 = lb.vip6
+	}
+
+	// The IPv6 virtual IP is allocated from the network's pool by the nodes
+	// running a task of the service, and released with the load balancer.
+	reserveVIP6 := local && len(vip6) != 0 && (!ok || !lb.vip6Reserved)
+	if reserveVIP6 {
+		if err := n.(*network).reserveVIPv6(vip6); err != nil {
+			if len(s.loadBalancers) == 0 {
+				// Do not leave behind the service created for the binding
+				c.Lock()
+				s.deleted = true
+				delete(c.serviceBindings, skey)
+				c.Unlock()
+			}
+			return fmt.Errorf("failed to allocate IPv6 virtual IP %s of service %s: %v", vip6, svcName, err)
+		}
+	}
+
 	if !ok {
 		// Create a new load balancer if we are seeing this
 		// network attachment on the service for the first
@@ -266,6 +294,7 @@ func (c *controller) addServiceBinding(svcName, svcID, nID, eID, containerName s
 
 		lb = &loadBalancer{
 			vip:      vip,
+			vip6:     vip6,
 			fwMark:   fwMarkCtr,
 			backEnds: make(map[string]*lbBackend),
 			service:  s,
@@ -277,8 +306,11 @@ func (c *controller) addServiceBinding(svcName, svcID, nID, eID, containerName s
 		s.loadBalancers[nID] = lb
 		addService = true
 	}
+	if reserveVIP6 {
+		lb.vip6Reserved = true
+	}
 
-	lb.backEnds[eID] = &lbBackend{ip, false}
+	lb.backEnds[eID] = &lbBackend{ip: ip, ip6: ip6, local: local}
 
 	ok, entries := s.assignIPToEndpoint(ip.String(), eID)
 	if !ok || entries > 1 {
@@ -291,19 +323,19 @@ func (c *controller) addServiceBinding(svcName, svcID, nID, eID, containerName s
 
 	// Add loadbalancer service and backend in all sandboxes in
 	// the network only if vip is valid.
-	if len(vip) != 0 {
-		n.(*network).addLBBackend(ip, vip, lb, ingressPorts)
+	if hasVIP(vip, vip6) {
+		n.(*network).addLBBackend(ip, ip6, vip, local, lb, ingressPorts)
 	}
 
 	// Add the appropriate name resolutions
-	c.addEndpointNameResolution(svcName, svcID, nID, eID, containerName, vip, serviceAliases, taskAliases, ip, addService, "addServiceBinding")
+	c.addEndpointNameResolution(svcName, svcID, nID, eID, containerName, vip, vip6, serviceAliases, taskAliases, ip, ip6, addService, "addServiceBinding")
 
 	logrus.Debugf("addServiceBinding from %s END for %s %s", method, svcName, eID)
 
 	return nil
 }
 
-func (c *controller) rmServiceBinding(svcName, svcID, nID, eID, containerName string, vip net.IP, ingressPorts []*PortConfig, serviceAliases []string, taskAliases []string, ip net.IP, method string, deleteSvcRecords bool, fullRemove bool) error {
+func (c *controller) rmServiceBinding(svcName, svcID, nID, eID, containerName string, vip, vip6 net.IP, ingressPorts []*PortConfig, serviceAliases []string, taskAliases []string, ip, ip6 net.IP, method string, deleteSvcRecords bool, fullRemove bool) error {
 
 	var rmService bool
 
@@ -355,7 +387,12 @@ func (c *controller) rmServiceBinding(svcName, svcID, nID, eID, containerName st
 
 		delete(s.loadBalancers, nID)
 		logrus.Debugf("rmServiceBinding %s delete %s, p:%p in loadbalancers len:%d", eID, nID, lb, len(s.loadBalancers))
+
+		if lb.vip6Reserved {
+			n.(*network).releaseVIPv6(lb.vip6)
+		}
 	}
+	vip6 = lb.vip6
 
 	ok, entries := s.removeIPToEndpoint(ip.String(), eID)
 	if !ok || entries > 0 {
@@ -368,13 +405,13 @@ func (c *controller) rmServiceBinding(svcName, svcID, nID, eID, containerName st
 
 	// Remove loadbalancer service(if needed) and backend in all
 	// sandboxes in the network only if the vip is valid.
-	if len(vip) != 0 && entries == 0 {
-		n.(*network).rmLBBackend(ip, vip, lb, ingressPorts, rmService, fullRemove)
+	if hasVIP(vip, vip6) && entries == 0 {
+		n.(*network).rmLBBackend(ip, ip6, vip, lb, ingressPorts, rmService, fullRemove)
 	}
 
 	// Delete the name resolutions
 	if deleteSvcRecords {
-		c.deleteEndpointNameResolution(svcName, svcID, nID, eID, containerName, vip, serviceAliases, taskAliases, ip, rmService, entries > 0, "rmServiceBinding")
+		c.deleteEndpointNameResolution(svcName, svcID, nID, eID, containerName, vip, vip6, serviceAliases, taskAliases, ip, ip6, rmService, entries > 0, "rmServiceBinding")
 	}
 
 	if len(s.loadBalancers) == 0 {
@@ -4,7 +4,9 @@ import (
 	"net"
 	"testing"
 
+	"github.com/docker/libnetwork/ipamapi"
 	"github.com/docker/libnetwork/resolvconf"
+	"github.com/docker/libnetwork/types"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -82,3 +84,56 @@ func TestDNSOptions(t *testing.T) {
 	assert.Equal(t, 1, len(dnsOptionsList), "There should be only 1 option instead:", dnsOptionsList)
 	assert.Equal(t, "ndots:5", dnsOptionsList[0], "The option must be ndots:5 instead:", dnsOptionsList[0])
 }
+
+func TestServiceBindingVIPv6(t *testing.T) {
+	ctrlr, err := New()
+	require.NoError(t, err)
+	defer ctrlr.Stop()
+	c := ctrlr.(*controller)
+
+	n, err := c.NewNetwork("bridge", "net1", "",
+		NetworkOptionEnableIPv6(true),
+		NetworkOptionIpam(ipamapi.DefaultIPAM, "",
+			[]*IpamConf{{PreferredPool: "192.168.100.0/24"}},
+			[]*IpamConf{{PreferredPool: "2001:db8:abcd::/64"}}, nil))
+	require.NoError(t, err)
+	defer n.Delete()
+
+	vip6 := net.ParseIP("2001:db8:abcd::100")
+	ip := net.ParseIP("192.168.100.2")
+	ip6 := net.ParseIP("2001:db8:abcd::2")
+
+	// The remote bindings do not allocate the virtual IP
+	err = c.addServiceBinding("svc", "svcID", n.ID(), "ep0", "ctr0", nil, vip6, nil, nil, nil, net.ParseIP("192.168.100.3"), net.ParseIP("2001:db8:abcd::3"), "", false, "test")
+	require.NoError(t, err)
+	require.NoError(t, n.(*network).reserveVIPv6(vip6))
+	n.(*network).releaseVIPv6(vip6)
+
+	// The service only has an IPv6 virtual IP
+	err = c.addServiceBinding("svc", "svcID", n.ID(), "ep1", "ctr1", nil, vip6, nil, nil, nil, ip, ip6, "", true, "test")
+	require.NoError(t, err)
+
+	// The virtual IP is allocated from the network's pool
+	assert.Error(t, n.(*network).reserveVIPv6(vip6))
+	c.Lock()
+	lb := c.serviceBindings[serviceKey{id: "svcID", ports: portConfigs(nil).String()}].loadBalancers[n.ID()]
+	c.Unlock()
+	assert.True(t, lb.vip6.Equal(vip6))
+
+	ips, _ := n.(*network).ResolveName("svc", types.IPv6)
+	require.Len(t, ips, 1)
+	assert.True(t, ips[0].Equal(vip6), "Expected the service name to resolve to the IPv6 virtual IP")
+
+	err = c.rmServiceBinding("svc", "svcID", n.ID(), "ep1", "ctr1", nil, vip6, nil, nil, nil, ip, ip6, "test", true, true)
+	require.NoError(t, err)
+	err = c.rmServiceBinding("svc", "svcID", n.ID(), "ep0", "ctr0", nil, vip6, nil, nil, nil, net.ParseIP("192.168.100.3"), net.ParseIP("2001:db8:abcd::3"), "test", true, true)
+	require.NoError(t, err)
+
+	// The virtual IP is released with the load balancer
+	require.NoError(t, n.(*network).reserveVIPv6(vip6))
+
+	// A virtual IP taken in the pool fails the local binding
+	err = c.addServiceBinding("svc", "svcID", n.ID(), "ep1", "ctr1", nil, vip6, nil, nil, nil, ip, ip6, "", true, "test")
+	assert.Error(t, err)
+	n.(*network).releaseVIPv6(vip6)
+}
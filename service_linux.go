@@ -13,18 +13,41 @@ import (
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/docker/docker/pkg/reexec"
-	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/firewall"
 	"github.com/docker/libnetwork/ipvs"
 	"github.com/docker/libnetwork/ns"
 	"github.com/gogo/protobuf/proto"
 	"github.com/ishidawataru/sctp"
 	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
 	"github.com/vishvananda/netlink/nl"
 	"github.com/vishvananda/netns"
 )
 
+const (
+	// ingressLocalFwMarkFlag is set in the firewall mark of the
+	// traffic received on the ingress ports with the local traffic
+	// policy.
+	ingressLocalFwMarkFlag = 1 << 30
+
+	// ingressLocalConnMark marks in the task sandboxes the
+	// connections received on the ingress ports with the local
+	// traffic policy. They are not source natted, so their replies
+	// are routed back to the ingress sandbox with this mark.
+	ingressLocalConnMark = 1 << 29
+
+	// ingressLocalRouteTable is the routing table of the replies of
+	// the connections marked with ingressLocalConnMark.
+	ingressLocalRouteTable = 200
+)
+
+// dsrTunnelDevice is the IPIP device receiving the traffic of the
+// services in tunnel load balancing mode.
+const dsrTunnelDevice = "tunl0"
+
 func init() {
 	reexec.Register("fwmarker", fwMarker)
 	reexec.Register("redirecter", redirecter)
@@ -62,7 +85,7 @@ func (n *network) connectedLoadbalancers() []*loadBalancer {
 // Populate all loadbalancers on the network that the passed endpoint
 // belongs to, into this sandbox.
 func (sb *sandbox) populateLoadbalancers(ep *endpoint) {
-	var gwIP net.IP
+	var gwIP, gwIP6 net.IP
 
 	// This is an interface less endpoint. Nothing to do.
 	if ep.Iface() == nil {
@@ -71,9 +94,14 @@ func (sb *sandbox) populateLoadbalancers(ep *endpoint) {
 
 	n := ep.getNetwork()
 	eIP := ep.Iface().Address()
+	eIP6 := ep.Iface().AddressIPv6()
 
 	if n.ingress {
-		if err := addRedirectRules(sb.Key(), eIP, ep.ingressPorts); err != nil {
+		var lbIP, lbIP6 net.IP
+		if hasLocalTrafficPolicy(ep.ingressPorts) {
+			lbIP, lbIP6 = n.getController().ingressLBAddresses(n)
+		}
+		if err := addRedirectRules(sb.Key(), eIP, eIP6, lbIP, lbIP6, ep.ingressPorts); err != nil {
 			logrus.Errorf("Failed to add redirect rules for ep %s (%s): %v", ep.Name(), ep.ID()[0:7], err)
 		}
 	}
@@ -88,24 +116,28 @@ func (sb *sandbox) populateLoadbalancers(ep *endpoint) {
 		// This is the gateway endpoint. Now get the ingress
 		// network and plumb the loadbalancers.
 		gwIP = ep.Iface().Address().IP
+		if ep.Iface().AddressIPv6() != nil {
+			gwIP6 = ep.Iface().AddressIPv6().IP
+		}
 		for _, ep := range sb.getConnectedEndpoints() {
 			if !ep.endpointInGWNetwork() {
 				n = ep.getNetwork()
 				eIP = ep.Iface().Address()
+				eIP6 = ep.Iface().AddressIPv6()
 			}
 		}
 	}
 
 	for _, lb := range n.connectedLoadbalancers() {
 		// Skip if vip is not valid.
-		if len(lb.vip) == 0 {
+		if !hasVIP(lb.vip, lb.vip6) {
 			continue
 		}
 
 		lb.service.Lock()
 		for _, be := range lb.backEnds {
 			if !be.disabled {
-				sb.addLBBackend(be.ip, lb.vip, lb.fwMark, lb.service.ingressPorts, eIP, gwIP, n.ingress)
+				sb.addLBBackend(be.ip, be.ip6, lb.vip, lb.vip6, lb.fwMark, lb.service.lbMode, be.local, lb.service.ingressPorts, eIP, eIP6, gwIP, gwIP6, n)
 			}
 		}
 		lb.service.Unlock()
@@ -114,7 +146,7 @@ func (sb *sandbox) populateLoadbalancers(ep *endpoint) {
 
 // Add loadbalancer backend to all sandboxes which has a connection to
 // this network. If needed add the service as well.
-func (n *network) addLBBackend(ip, vip net.IP, lb *loadBalancer, ingressPorts []*PortConfig) {
+func (n *network) addLBBackend(ip, ip6, vip net.IP, isLocal bool, lb *loadBalancer, ingressPorts []*PortConfig) {
 	n.WalkEndpoints(func(e Endpoint) bool {
 		ep := e.(*endpoint)
 		if sb, ok := ep.getSandbox(); ok {
@@ -122,12 +154,9 @@ func (n *network) addLBBackend(ip, vip net.IP, lb *loadBalancer, ingressPorts []
 				return false
 			}
 
-			var gwIP net.IP
-			if ep := sb.getGatewayEndpoint(); ep != nil {
-				gwIP = ep.Iface().Address().IP
-			}
+			gwIP, gwIP6 := sb.getGatewayAddresses()
 
-			sb.addLBBackend(ip, vip, lb.fwMark, ingressPorts, ep.Iface().Address(), gwIP, n.ingress)
+			sb.addLBBackend(ip, ip6, vip, lb.vip6, lb.fwMark, lb.service.lbMode, isLocal, ingressPorts, ep.Iface().Address(), ep.Iface().AddressIPv6(), gwIP, gwIP6, n)
 		}
 
 		return false
@@ -137,7 +166,7 @@ func (n *network) addLBBackend(ip, vip net.IP, lb *loadBalancer, ingressPorts []
 // Remove loadbalancer backend from all sandboxes which has a
 // connection to this network. If needed remove the service entry as
 // well, as specified by the rmService bool.
-func (n *network) rmLBBackend(ip, vip net.IP, lb *loadBalancer, ingressPorts []*PortConfig, rmService bool, fullRemove bool) {
+func (n *network) rmLBBackend(ip, ip6, vip net.IP, lb *loadBalancer, ingressPorts []*PortConfig, rmService bool, fullRemove bool) {
 	n.WalkEndpoints(func(e Endpoint) bool {
 		ep := e.(*endpoint)
 		if sb, ok := ep.getSandbox(); ok {
@@ -145,25 +174,272 @@ func (n *network) rmLBBackend(ip, vip net.IP, lb *loadBalancer, ingressPorts []*
 				return false
 			}
 
-			var gwIP net.IP
-			if ep := sb.getGatewayEndpoint(); ep != nil {
-				gwIP = ep.Iface().Address().IP
-			}
+			gwIP, gwIP6 := sb.getGatewayAddresses()
 
-			sb.rmLBBackend(ip, vip, lb.fwMark, ingressPorts, ep.Iface().Address(), gwIP, rmService, fullRemove, n.ingress)
+			sb.rmLBBackend(ip, ip6, vip, lb.vip6, lb.fwMark, lb.service.lbMode, ingressPorts, ep.Iface().Address(), ep.Iface().AddressIPv6(), gwIP, gwIP6, rmService, fullRemove, n.ingress)
 		}
 
 		return false
 	})
 }
 
+// hasLocalTrafficPolicy returns whether any of the ingress ports only
+// forwards its traffic to the tasks running on this node.
+func hasLocalTrafficPolicy(ingressPorts []*PortConfig) bool {
+	for _, iPort := range ingressPorts {
+		if iPort.TrafficPolicy == TrafficPolicyLocal {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ingressFwMark returns the firewall mark of the traffic received on the
+// ingress port for the service with the passed firewall mark.
+func ingressFwMark(fwMark uint32, iPort *PortConfig) uint32 {
+	if iPort.TrafficPolicy == TrafficPolicyLocal {
+		return fwMark | ingressLocalFwMarkFlag
+	}
+
+	return fwMark
+}
+
+// lbForwardingMethod returns the ipvs forwarding method of the
+// destinations for the passed load balancing mode.
+func lbForwardingMethod(mode string) uint32 {
+	switch mode {
+	case LBModeTunnel:
+		return ipvs.ConnectionFlagTunnel
+	case LBModeDirectRoute:
+		return ipvs.ConnectionFlagDirectRoute
+	default:
+		return ipvs.ConnectionFlagMasq
+	}
+}
+
+// setupDSRBackend prepares the sandbox of a service task to receive the
+// direct server return traffic of the virtual IP. The virtual IP is on the
+// loopback interface and must not be announced, and tunnel mode needs the
+// IPIP fallback device to decapsulate the traffic.
+func (sb *sandbox) setupDSRBackend(mode string) error {
+	var err error
+
+	if ierr := sb.osSbox.InvokeFunc(func() {
+		for _, sysctl := range []struct{ name, value string }{
+			{"arp_ignore", "1"},
+			{"arp_announce", "2"},
+		} {
+			path := filepath.Join("/proc/sys/net/ipv4/conf/all", sysctl.name)
+			if err = ioutil.WriteFile(path, []byte(sysctl.value+"\n"), 0644); err != nil {
+				err = fmt.Errorf("could not write to %s: %v", path, err)
+				return
+			}
+		}
+
+		if mode != LBModeTunnel {
+			return
+		}
+
+		// Loading the ipip module creates tunl0 in every namespace.
+		link, lerr := netlink.LinkByName(dsrTunnelDevice)
+		if lerr != nil {
+			if lerr = netlink.LinkAdd(&netlink.Iptun{LinkAttrs: netlink.LinkAttrs{Name: dsrTunnelDevice}}); lerr != nil && lerr != syscall.EEXIST {
+				err = fmt.Errorf("could not create %s: %v", dsrTunnelDevice, lerr)
+				return
+			}
+			if link, lerr = netlink.LinkByName(dsrTunnelDevice); lerr != nil {
+				err = fmt.Errorf("could not find %s: %v", dsrTunnelDevice, lerr)
+				return
+			}
+		}
+
+		if lerr = netlink.LinkSetUp(link); lerr != nil {
+			err = fmt.Errorf("could not bring up %s: %v", dsrTunnelDevice, lerr)
+			return
+		}
+
+		// The decapsulated traffic comes from the client, which is not
+		// routed through the tunnel device.
+		path := filepath.Join("/proc/sys/net/ipv4/conf", dsrTunnelDevice, "rp_filter")
+		if lerr = ioutil.WriteFile(path, []byte{'0', '\n'}, 0644); lerr != nil {
+			err = fmt.Errorf("could not write to %s: %v", path, lerr)
+		}
+	}); ierr != nil {
+		return ierr
+	}
+
+	return err
+}
+
+// ingressLBAddresses returns the IPv4 and IPv6 addresses of the ingress
+// sandbox of this node on the ingress network, if any.
+func (c *controller) ingressLBAddresses(n *network) (net.IP, net.IP) {
+	c.Lock()
+	sb := c.ingressSandbox
+	c.Unlock()
+
+	if sb == nil {
+		return nil, nil
+	}
+
+	var lbIP, lbIP6 net.IP
+	for _, ep := range sb.getConnectedEndpoints() {
+		if ep.getNetwork().ID() != n.ID() || ep.Iface() == nil {
+			continue
+		}
+		if ep.Iface().Address() != nil {
+			lbIP = ep.Iface().Address().IP
+		}
+		if ep.Iface().AddressIPv6() != nil {
+			lbIP6 = ep.Iface().AddressIPv6().IP
+		}
+		break
+	}
+
+	return lbIP, lbIP6
+}
+
+// getGatewayAddresses returns the IPv4 and IPv6 addresses of the
+// sandbox gateway endpoint, if any.
+func (sb *sandbox) getGatewayAddresses() (net.IP, net.IP) {
+	var gwIP, gwIP6 net.IP
+
+	if ep := sb.getGatewayEndpoint(); ep != nil {
+		gwIP = ep.Iface().Address().IP
+		if ep.Iface().AddressIPv6() != nil {
+			gwIP6 = ep.Iface().AddressIPv6().IP
+		}
+	}
+
+	return gwIP, gwIP6
+}
+
+// lbSettings returns the ipvs timeouts and the connection synchronization
+// daemon of the load balancers of the sandbox, and the network endpoint
+// sending the synchronization messages. Both are per namespace, so when the
+// networks of the sandbox disagree the longest timeouts and the lowest sync
+// ID win.
+func (sb *sandbox) lbSettings() (*lbTimeouts, *lbSync, *endpoint) {
+	var (
+		t    *lbTimeouts
+		s    *lbSync
+		sEp  *endpoint
+		maxD = func(a, b time.Duration) time.Duration {
+			if a > b {
+				return a
+			}
+			return b
+		}
+	)
+
+	for _, ep := range sb.getConnectedEndpoints() {
+		n := ep.getNetwork()
+		n.Lock()
+		nt, ns := n.lbTimeouts, n.lbSync
+		n.Unlock()
+
+		if nt != nil {
+			if t == nil {
+				t = &lbTimeouts{}
+			}
+			t.TCP = maxD(t.TCP, nt.TCP)
+			t.TCPFin = maxD(t.TCPFin, nt.TCPFin)
+			t.UDP = maxD(t.UDP, nt.UDP)
+		}
+
+		if ns == nil || ep.Iface() == nil || (s != nil && s.SyncID <= ns.SyncID) {
+			continue
+		}
+		if s != nil {
+			logrus.Warnf("Conflicting ipvs sync IDs %d and %d in sbox %s (%s), using %d", s.SyncID, ns.SyncID, sb.ID()[0:7], sb.ContainerID()[0:7], ns.SyncID)
+		}
+		s, sEp = ns, ep
+	}
+
+	return t, s, sEp
+}
+
+// programLBConfig applies the ipvs timeouts and connection synchronization
+// daemons of the networks of the sandbox to its namespace, if they changed
+// since last applied.
+func (sb *sandbox) programLBConfig(i *ipvs.Handle) {
+	t, s, sEp := sb.lbSettings()
+
+	sb.Lock()
+	curTimeouts, curSync := sb.lbTimeouts, sb.lbSync
+	sb.Unlock()
+
+	if t != nil && (curTimeouts == nil || *t != *curTimeouts) {
+		c := &ipvs.Config{
+			TimeoutTCP:    t.TCP,
+			TimeoutTCPFin: t.TCPFin,
+			TimeoutUDP:    t.UDP,
+		}
+		if err := i.SetConfig(c); err != nil {
+			logrus.Errorf("Failed to set ipvs timeouts in sbox %s (%s): %v", sb.ID()[0:7], sb.ContainerID()[0:7], err)
+		} else {
+			curTimeouts = t
+		}
+	}
+
+	if s == nil && curSync == nil || s != nil && curSync != nil && *s == *curSync {
+		sb.Lock()
+		sb.lbTimeouts = curTimeouts
+		sb.Unlock()
+		return
+	}
+
+	// The namespace runs a single master and a single backup daemon
+	if curSync != nil {
+		for _, state := range []uint32{ipvs.SyncStateMaster, ipvs.SyncStateBackup} {
+			if err := i.StopSyncDaemon(&ipvs.SyncDaemon{State: state}); err != nil {
+				logrus.Errorf("Failed to stop ipvs sync daemon in sbox %s (%s): %v", sb.ID()[0:7], sb.ContainerID()[0:7], err)
+			}
+		}
+		curSync = nil
+	}
+
+	if s != nil {
+		ifName, err := sb.ifaceName(sEp)
+		if err != nil {
+			logrus.Errorf("Failed to start ipvs sync daemon in sbox %s (%s): %v", sb.ID()[0:7], sb.ContainerID()[0:7], err)
+		} else {
+			curSync = s
+			for _, state := range []uint32{ipvs.SyncStateMaster, ipvs.SyncStateBackup} {
+				d := &ipvs.SyncDaemon{State: state, McastIfn: ifName, SyncID: s.SyncID}
+				if err := i.StartSyncDaemon(d); err != nil && err != syscall.EEXIST {
+					logrus.Errorf("Failed to start ipvs sync daemon in sbox %s (%s): %v", sb.ID()[0:7], sb.ContainerID()[0:7], err)
+					curSync = nil
+				}
+			}
+		}
+	}
+
+	sb.Lock()
+	sb.lbTimeouts, sb.lbSync = curTimeouts, curSync
+	sb.Unlock()
+}
+
+// ifaceName returns the name in the sandbox of the interface of the
+// endpoint.
+func (sb *sandbox) ifaceName(ep *endpoint) (string, error) {
+	addr := ep.Iface().Address()
+	for _, i := range sb.osSbox.Info().Interfaces() {
+		if addr != nil && i.Address() != nil && i.Address().IP.Equal(addr.IP) {
+			return i.DstName(), nil
+		}
+	}
+	return "", fmt.Errorf("no interface of endpoint %s in sbox", ep.Name())
+}
+
 // Add loadbalancer backend into one connected sandbox.
-func (sb *sandbox) addLBBackend(ip, vip net.IP, fwMark uint32, ingressPorts []*PortConfig, eIP *net.IPNet, gwIP net.IP, isIngressNetwork bool) {
+func (sb *sandbox) addLBBackend(ip, ip6, vip, vip6 net.IP, fwMark uint32, lbMode string, isLocal bool, ingressPorts []*PortConfig, eIP, eIP6 *net.IPNet, gwIP, gwIP6 net.IP, n *network) {
 	if sb.osSbox == nil {
 		return
 	}
 
-	if isIngressNetwork && !sb.ingress {
+	if n.ingress && !sb.ingress {
 		return
 	}
 
@@ -174,28 +450,88 @@ func (sb *sandbox) addLBBackend(ip, vip net.IP, fwMark uint32, ingressPorts []*P
 	}
 	defer i.Close()
 
+	sb.programLBConfig(i)
+
 	s := &ipvs.Service{
 		AddressFamily: nl.FAMILY_V4,
 		FWMark:        fwMark,
 		SchedName:     ipvs.RoundRobin,
 	}
+	s6 := &ipvs.Service{
+		AddressFamily: nl.FAMILY_V6,
+		FWMark:        fwMark,
+		SchedName:     ipvs.RoundRobin,
+	}
 
-	if !i.IsServicePresent(s) {
+	// The firewall mark rules are programmed along with the first
+	// service, the IPv6 one if the service has no IPv4 virtual IP.
+	hasVIP4 := len(vip) != 0
+	first := s
+	if !hasVIP4 {
+		first = s6
+	}
+
+	if !i.IsServicePresent(first) {
 		var filteredPorts []*PortConfig
 		if sb.ingress {
 			filteredPorts = filterPortConfigs(ingressPorts, false)
-			if err := programIngress(gwIP, filteredPorts, false); err != nil {
+			if err := programIngress(gwIP, gwIP6, filteredPorts, false); err != nil {
 				logrus.Errorf("Failed to add ingress: %v", err)
 				return
 			}
 		}
 
-		logrus.Debugf("Creating service for vip %s fwMark %d ingressPorts %#v in sbox %s (%s)", vip, fwMark, ingressPorts, sb.ID()[0:7], sb.ContainerID()[0:7])
-		if err := invokeFWMarker(sb.Key(), vip, fwMark, ingressPorts, eIP, false); err != nil {
+		logrus.Debugf("Creating service for vip %s vip6 %s fwMark %d ingressPorts %#v in sbox %s (%s)", vip, vip6, fwMark, ingressPorts, sb.ID()[0:7], sb.ContainerID()[0:7])
+		if err := invokeFWMarker(sb.Key(), vip, vip6, fwMark, ingressPorts, eIP, eIP6, false); err != nil {
 			logrus.Errorf("Failed to add firewall mark rule in sbox %s (%s): %v", sb.ID()[0:7], sb.ContainerID()[0:7], err)
 			return
 		}
+	}
+
+	if hasVIP4 {
+		sb.addLBBackendIPv4(i, s, ip, vip, fwMark, lbMode, isLocal, ingressPorts)
+	}
+
+	// IPv6 traffic to the service is marked with the same firewall
+	// mark, so it is balanced by a separate AF_INET6 ipvs service.
+	// Direct server return is only done for the IPv4 virtual IP, the
+	// IPv6 destinations are always masqueraded. The service is created
+	// even for a backend without IPv6 address, its presence tells the
+	// firewall mark rules are programmed.
+	if len(vip6) == 0 {
+		return
+	}
+
+	if !i.IsServicePresent(s6) {
+		if err := i.NewService(s6); err != nil && err != syscall.EEXIST {
+			logrus.Errorf("Failed to create a new service for vip6 %s fwmark %d in sbox %s (%s): %v", vip6, fwMark, sb.ID()[0:7], sb.ContainerID()[0:7], err)
+			return
+		}
+	}
+
+	var d6 *ipvs.Destination
+	if ip6 != nil {
+		d6 = &ipvs.Destination{
+			AddressFamily: nl.FAMILY_V6,
+			Address:       ip6,
+			Weight:        1,
+		}
+
+		s6.SchedName = ""
+		if err := i.NewDestination(s6, d6); err != nil && err != syscall.EEXIST {
+			logrus.Errorf("Failed to create real server %s for vip6 %s fwmark %d in sbox %s (%s): %v", ip6, vip6, fwMark, sb.ID()[0:7], sb.ContainerID()[0:7], err)
+		}
+	}
+
+	if sb.ingress && hasLocalTrafficPolicy(ingressPorts) {
+		sb.addLocalLBBackend(i, nl.FAMILY_V6, d6, vip6, fwMark, isLocal)
+	}
+}
 
+// addLBBackendIPv4 adds the backend to the IPv4 service of the virtual IP,
+// creating the service if needed.
+func (sb *sandbox) addLBBackendIPv4(i *ipvs.Handle, s *ipvs.Service, ip, vip net.IP, fwMark uint32, lbMode string, isLocal bool, ingressPorts []*PortConfig) {
+	if !i.IsServicePresent(s) {
 		if err := i.NewService(s); err != nil && err != syscall.EEXIST {
 			logrus.Errorf("Failed to create a new service for vip %s fwmark %d in sbox %s (%s): %v", vip, fwMark, sb.ID()[0:7], sb.ContainerID()[0:7], err)
 			return
@@ -203,9 +539,10 @@ func (sb *sandbox) addLBBackend(ip, vip net.IP, fwMark uint32, ingressPorts []*P
 	}
 
 	d := &ipvs.Destination{
-		AddressFamily: nl.FAMILY_V4,
-		Address:       ip,
-		Weight:        1,
+		AddressFamily:   nl.FAMILY_V4,
+		Address:         ip,
+		Weight:          1,
+		ConnectionFlags: lbForwardingMethod(lbMode),
 	}
 
 	// Remove the sched name before using the service to add
@@ -214,10 +551,40 @@ func (sb *sandbox) addLBBackend(ip, vip net.IP, fwMark uint32, ingressPorts []*P
 	if err := i.NewDestination(s, d); err != nil && err != syscall.EEXIST {
 		logrus.Errorf("Failed to create real server %s for vip %s fwmark %d in sbox %s (%s): %v", ip, vip, fwMark, sb.ID()[0:7], sb.ContainerID()[0:7], err)
 	}
+
+	if sb.ingress && hasLocalTrafficPolicy(ingressPorts) {
+		sb.addLocalLBBackend(i, nl.FAMILY_V4, d, vip, fwMark, isLocal)
+	}
+}
+
+// addLocalLBBackend adds the local backend to the service of the family
+// balancing the ingress ports with the local traffic policy. That service
+// holds only the backends of this node. It is created even without any local
+// backend, ipvs then rejects the traffic instead of forwarding it to another
+// node.
+func (sb *sandbox) addLocalLBBackend(i *ipvs.Handle, family uint16, d *ipvs.Destination, vip net.IP, fwMark uint32, isLocal bool) {
+	ls := &ipvs.Service{
+		AddressFamily: family,
+		FWMark:        fwMark | ingressLocalFwMarkFlag,
+		SchedName:     ipvs.RoundRobin,
+	}
+
+	if !i.IsServicePresent(ls) {
+		if err := i.NewService(ls); err != nil && err != syscall.EEXIST {
+			logrus.Errorf("Failed to create a new local service for vip %s fwmark %d in sbox %s (%s): %v", vip, ls.FWMark, sb.ID()[0:7], sb.ContainerID()[0:7], err)
+		}
+	}
+
+	if isLocal && d != nil {
+		ls.SchedName = ""
+		if err := i.NewDestination(ls, d); err != nil && err != syscall.EEXIST {
+			logrus.Errorf("Failed to create local real server %s for vip %s fwmark %d in sbox %s (%s): %v", d.Address, vip, ls.FWMark, sb.ID()[0:7], sb.ContainerID()[0:7], err)
+		}
+	}
 }
 
 // Remove loadbalancer backend from one connected sandbox.
-func (sb *sandbox) rmLBBackend(ip, vip net.IP, fwMark uint32, ingressPorts []*PortConfig, eIP *net.IPNet, gwIP net.IP, rmService bool, fullRemove bool, isIngressNetwork bool) {
+func (sb *sandbox) rmLBBackend(ip, ip6, vip, vip6 net.IP, fwMark uint32, lbMode string, ingressPorts []*PortConfig, eIP, eIP6 *net.IPNet, gwIP, gwIP6 net.IP, rmService bool, fullRemove bool, isIngressNetwork bool) {
 	if sb.osSbox == nil {
 		return
 	}
@@ -239,42 +606,129 @@ func (sb *sandbox) rmLBBackend(ip, vip net.IP, fwMark uint32, ingressPorts []*Po
 	}
 
 	d := &ipvs.Destination{
-		AddressFamily: nl.FAMILY_V4,
-		Address:       ip,
-		Weight:        1,
+		AddressFamily:   nl.FAMILY_V4,
+		Address:         ip,
+		Weight:          1,
+		ConnectionFlags: lbForwardingMethod(lbMode),
 	}
 
-	if fullRemove {
+	hasVIP4 := len(vip) != 0
+	if hasVIP4 && fullRemove {
 		if err := i.DelDestination(s, d); err != nil && err != syscall.ENOENT {
 			logrus.Errorf("Failed to delete real server %s for vip %s fwmark %d in sbox %s (%s): %v", ip, vip, fwMark, sb.ID()[0:7], sb.ContainerID()[0:7], err)
 		}
-	} else {
+	} else if hasVIP4 {
 		d.Weight = 0
 		if err := i.UpdateDestination(s, d); err != nil && err != syscall.ENOENT {
 			logrus.Errorf("Failed to set LB weight of real server %s to 0 for vip %s fwmark %d in sbox %s (%s): %v", ip, vip, fwMark, sb.ID()[0:7], sb.ContainerID()[0:7], err)
 		}
 	}
 
+	localPolicy := sb.ingress && hasLocalTrafficPolicy(ingressPorts)
+
+	var ls *ipvs.Service
+	if hasVIP4 && localPolicy {
+		ls = sb.rmLocalLBBackend(i, nl.FAMILY_V4, d, vip, fwMark, fullRemove)
+	}
+
+	var s6 *ipvs.Service
+	if len(vip6) != 0 {
+		s6 = &ipvs.Service{
+			AddressFamily: nl.FAMILY_V6,
+			FWMark:        fwMark,
+		}
+	}
+
+	var d6 *ipvs.Destination
+	if s6 != nil && ip6 != nil {
+		d6 = &ipvs.Destination{
+			AddressFamily: nl.FAMILY_V6,
+			Address:       ip6,
+			Weight:        1,
+		}
+
+		if fullRemove {
+			if err := i.DelDestination(s6, d6); err != nil && err != syscall.ENOENT {
+				logrus.Errorf("Failed to delete real server %s for vip6 %s fwmark %d in sbox %s (%s): %v", ip6, vip6, fwMark, sb.ID()[0:7], sb.ContainerID()[0:7], err)
+			}
+		} else {
+			d6.Weight = 0
+			if err := i.UpdateDestination(s6, d6); err != nil && err != syscall.ENOENT {
+				logrus.Errorf("Failed to set LB weight of real server %s to 0 for vip6 %s fwmark %d in sbox %s (%s): %v", ip6, vip6, fwMark, sb.ID()[0:7], sb.ContainerID()[0:7], err)
+			}
+		}
+	}
+
+	var ls6 *ipvs.Service
+	if s6 != nil && localPolicy {
+		ls6 = sb.rmLocalLBBackend(i, nl.FAMILY_V6, d6, vip6, fwMark, fullRemove)
+	}
+
 	if rmService {
-		s.SchedName = ipvs.RoundRobin
-		if err := i.DelService(s); err != nil && err != syscall.ENOENT {
-			logrus.Errorf("Failed to delete service for vip %s fwmark %d in sbox %s (%s): %v", vip, fwMark, sb.ID()[0:7], sb.ContainerID()[0:7], err)
+		if hasVIP4 {
+			s.SchedName = ipvs.RoundRobin
+			if err := i.DelService(s); err != nil && err != syscall.ENOENT {
+				logrus.Errorf("Failed to delete service for vip %s fwmark %d in sbox %s (%s): %v", vip, fwMark, sb.ID()[0:7], sb.ContainerID()[0:7], err)
+			}
+		}
+
+		if s6 != nil {
+			s6.SchedName = ipvs.RoundRobin
+			if err := i.DelService(s6); err != nil && err != syscall.ENOENT {
+				logrus.Errorf("Failed to delete service for vip6 %s fwmark %d in sbox %s (%s): %v", vip6, fwMark, sb.ID()[0:7], sb.ContainerID()[0:7], err)
+			}
+		}
+
+		for _, ls := range []*ipvs.Service{ls, ls6} {
+			if ls == nil {
+				continue
+			}
+			ls.SchedName = ipvs.RoundRobin
+			if err := i.DelService(ls); err != nil && err != syscall.ENOENT {
+				logrus.Errorf("Failed to delete local service for fwmark %d in sbox %s (%s): %v", ls.FWMark, sb.ID()[0:7], sb.ContainerID()[0:7], err)
+			}
 		}
 
 		var filteredPorts []*PortConfig
 		if sb.ingress {
 			filteredPorts = filterPortConfigs(ingressPorts, true)
-			if err := programIngress(gwIP, filteredPorts, true); err != nil {
+			if err := programIngress(gwIP, gwIP6, filteredPorts, true); err != nil {
 				logrus.Errorf("Failed to delete ingress: %v", err)
 			}
 		}
 
-		if err := invokeFWMarker(sb.Key(), vip, fwMark, ingressPorts, eIP, true); err != nil {
+		if err := invokeFWMarker(sb.Key(), vip, vip6, fwMark, ingressPorts, eIP, eIP6, true); err != nil {
 			logrus.Errorf("Failed to delete firewall mark rule in sbox %s (%s): %v", sb.ID()[0:7], sb.ContainerID()[0:7], err)
 		}
 	}
 }
 
+// rmLocalLBBackend removes the backend from the service of the family
+// balancing the ingress ports with the local traffic policy, and returns
+// that service. The backend may not be local, so there may be nothing to
+// remove from it.
+func (sb *sandbox) rmLocalLBBackend(i *ipvs.Handle, family uint16, d *ipvs.Destination, vip net.IP, fwMark uint32, fullRemove bool) *ipvs.Service {
+	ls := &ipvs.Service{
+		AddressFamily: family,
+		FWMark:        fwMark | ingressLocalFwMarkFlag,
+	}
+
+	if d == nil {
+		return ls
+	}
+
+	if fullRemove {
+		if err := i.DelDestination(ls, d); err != nil && err != syscall.ENOENT {
+			logrus.Errorf("Failed to delete local real server %s for vip %s fwmark %d in sbox %s (%s): %v", d.Address, vip, ls.FWMark, sb.ID()[0:7], sb.ContainerID()[0:7], err)
+		}
+	} else {
+		if err := i.UpdateDestination(ls, d); err != nil && err != syscall.ENOENT {
+			logrus.Errorf("Failed to set LB weight of local real server %s to 0 for vip %s fwmark %d in sbox %s (%s): %v", d.Address, vip, ls.FWMark, sb.ID()[0:7], sb.ContainerID()[0:7], err)
+		}
+	}
+	return ls
+}
+
 const ingressChain = "DOCKER-INGRESS"
 
 var (
@@ -283,8 +737,32 @@ var (
 	ingressProxyTbl = make(map[string]io.Closer)
 	portConfigMu    sync.Mutex
 	portConfigTbl   = make(map[PortConfig]int)
+	// ingressRuleSets are the rules programmed for the ingress, by family,
+	// and ingressPortRules the ones of the published ports, by family,
+	// protocol and port.
+	ingressRuleSets  = make(map[firewall.Family]firewall.RuleSet)
+	ingressPortRules = make(map[string][]firewall.ChainRule)
 )
 
+// ingressRuleSet returns the ingress chains and rules, verified along with
+// the other rules of the host firewall.
+func ingressRuleSet() firewall.RuleSet {
+	ingressMu.Lock()
+	defer ingressMu.Unlock()
+
+	var set firewall.RuleSet
+	for family, s := range ingressRuleSets {
+		set.Chains = append(set.Chains, s.Chains...)
+		set.Rules = append(set.Rules, s.Rules...)
+		for key, rules := range ingressPortRules {
+			if strings.HasPrefix(key, string(family)+"/") {
+				set.Add(family, rules...)
+			}
+		}
+	}
+	return set
+}
+
 func filterPortConfigs(ingressPorts []*PortConfig, isDelete bool) []*PortConfig {
 	portConfigMu.Lock()
 	iPorts := make([]*PortConfig, 0, len(ingressPorts))
@@ -322,67 +800,81 @@ func filterPortConfigs(ingressPorts []*PortConfig, isDelete bool) []*PortConfig
 	return iPorts
 }
 
-func programIngress(gwIP net.IP, ingressPorts []*PortConfig, isDelete bool) error {
-	addDelOpt := "-I"
-	if isDelete {
-		addDelOpt = "-D"
-	}
-
+func programIngress(gwIP, gwIP6 net.IP, ingressPorts []*PortConfig, isDelete bool) error {
 	ingressMu.Lock()
 	defer ingressMu.Unlock()
 
-	chainExists := iptables.ExistChain(ingressChain, iptables.Nat)
-	filterChainExists := iptables.ExistChain(ingressChain, iptables.Filter)
-
 	ingressOnce.Do(func() {
 		// Flush nat table and filter table ingress chain rules during init if it
 		// exists. It might contain stale rules from previous life.
-		if chainExists {
-			if err := iptables.RawCombinedOutput("-t", "nat", "-F", ingressChain); err != nil {
-				logrus.Errorf("Could not flush nat table ingress chain rules during init: %v", err)
+		for _, fw := range []firewall.Backend{firewall.Get(firewall.IPv4), firewall.Get(firewall.IPv6)} {
+			if fw.ExistChain(firewall.Nat, ingressChain) {
+				if err := fw.FlushChain(firewall.Nat, ingressChain); err != nil {
+					logrus.Errorf("Could not flush nat table ingress chain rules during init: %v", err)
+				}
 			}
-		}
-		if filterChainExists {
-			if err := iptables.RawCombinedOutput("-F", ingressChain); err != nil {
-				logrus.Errorf("Could not flush filter table ingress chain rules during init: %v", err)
+			if fw.ExistChain(firewall.Filter, ingressChain) {
+				if err := fw.FlushChain(firewall.Filter, ingressChain); err != nil {
+					logrus.Errorf("Could not flush filter table ingress chain rules during init: %v", err)
+				}
 			}
 		}
 	})
 
+	firewall.RegisterRuleSet(ingressChain, ingressRuleSet)
+
+	if err := programIngressRules(firewall.Get(firewall.IPv4), gwIP, ingressPorts, isDelete); err != nil {
+		return err
+	}
+
+	// Publish the ports on the host IPv6 addresses as well when the
+	// gateway network has IPv6 connectivity.
+	if gwIP6 != nil {
+		if err := programIngressRules(firewall.Get(firewall.IPv6), gwIP6, ingressPorts, isDelete); err != nil {
+			return err
+		}
+	}
+
+	for _, iPort := range ingressPorts {
+		if err := plumbProxy(iPort, isDelete); err != nil {
+			logrus.Warnf("failed to create proxy for port %d: %v", iPort.PublishedPort, err)
+		}
+	}
+
+	return nil
+}
+
+func programIngressRules(fw firewall.Backend, gwIP net.IP, ingressPorts []*PortConfig, isDelete bool) error {
+	action := firewall.Insert
+	if isDelete {
+		action = firewall.Delete
+	}
+
 	if !isDelete {
-		if !chainExists {
-			if err := iptables.RawCombinedOutput("-t", "nat", "-N", ingressChain); err != nil {
-				return fmt.Errorf("failed to create ingress chain: %v", err)
-			}
+		if err := fw.NewChain(firewall.Nat, ingressChain); err != nil {
+			return fmt.Errorf("failed to create ingress chain: %v", err)
 		}
-		if !filterChainExists {
-			if err := iptables.RawCombinedOutput("-N", ingressChain); err != nil {
-				return fmt.Errorf("failed to create filter table ingress chain: %v", err)
-			}
+		if err := fw.NewChain(firewall.Filter, ingressChain); err != nil {
+			return fmt.Errorf("failed to create filter table ingress chain: %v", err)
 		}
 
-		if !iptables.Exists(iptables.Nat, ingressChain, "-j", "RETURN") {
-			if err := iptables.RawCombinedOutput("-t", "nat", "-A", ingressChain, "-j", "RETURN"); err != nil {
-				return fmt.Errorf("failed to add return rule in nat table ingress chain: %v", err)
-			}
+		if err := fw.ProgramRule(firewall.Nat, ingressChain, firewall.Append, firewall.Rule{Jump: "RETURN"}); err != nil {
+			return fmt.Errorf("failed to add return rule in nat table ingress chain: %v", err)
 		}
 
-		if !iptables.Exists(iptables.Filter, ingressChain, "-j", "RETURN") {
-			if err := iptables.RawCombinedOutput("-A", ingressChain, "-j", "RETURN"); err != nil {
-				return fmt.Errorf("failed to add return rule to filter table ingress chain: %v", err)
-			}
+		if err := fw.ProgramRule(firewall.Filter, ingressChain, firewall.Append, firewall.Rule{Jump: "RETURN"}); err != nil {
+			return fmt.Errorf("failed to add return rule to filter table ingress chain: %v", err)
 		}
 
 		for _, chain := range []string{"OUTPUT", "PREROUTING"} {
-			if !iptables.Exists(iptables.Nat, chain, "-m", "addrtype", "--dst-type", "LOCAL", "-j", ingressChain) {
-				if err := iptables.RawCombinedOutput("-t", "nat", "-I", chain, "-m", "addrtype", "--dst-type", "LOCAL", "-j", ingressChain); err != nil {
-					return fmt.Errorf("failed to add jump rule in %s to ingress chain: %v", chain, err)
-				}
+			if err := fw.ProgramRule(firewall.Nat, chain, firewall.Insert, firewall.Rule{DstType: "LOCAL", Jump: ingressChain}); err != nil {
+				return fmt.Errorf("failed to add jump rule in %s to ingress chain: %v", chain, err)
 			}
 		}
 
-		if !iptables.Exists(iptables.Filter, "FORWARD", "-j", ingressChain) {
-			if err := iptables.RawCombinedOutput("-I", "FORWARD", "-j", ingressChain); err != nil {
+		jump := firewall.Rule{Jump: ingressChain}
+		if !fw.Exists(firewall.Filter, "FORWARD", jump) {
+			if err := fw.Apply(firewall.Filter, "FORWARD", firewall.Insert, jump); err != nil {
 				return fmt.Errorf("failed to add jump rule to %s in filter table forward chain: %v", ingressChain, err)
 			}
 			arrangeUserFilterRule()
@@ -393,59 +885,70 @@ func programIngress(gwIP net.IP, ingressPorts []*PortConfig, isDelete bool) erro
 			return fmt.Errorf("failed to find gateway bridge interface name for %s: %v", gwIP, err)
 		}
 
-		path := filepath.Join("/proc/sys/net/ipv4/conf", oifName, "route_localnet")
-		if err := ioutil.WriteFile(path, []byte{'1', '\n'}, 0644); err != nil {
-			return fmt.Errorf("could not write to %s: %v", path, err)
+		// There is no IPv6 equivalent of route_localnet, IPv6
+		// loopback traffic can not be forwarded to the ingress
+		// sandbox.
+		if gwIP.To4() != nil {
+			path := filepath.Join("/proc/sys/net/ipv4/conf", oifName, "route_localnet")
+			if err := ioutil.WriteFile(path, []byte{'1', '\n'}, 0644); err != nil {
+				return fmt.Errorf("could not write to %s: %v", path, err)
+			}
 		}
 
-		ruleArgs := strings.Fields(fmt.Sprintf("-m addrtype --src-type LOCAL -o %s -j MASQUERADE", oifName))
-		if !iptables.Exists(iptables.Nat, "POSTROUTING", ruleArgs...) {
-			if err := iptables.RawCombinedOutput(append([]string{"-t", "nat", "-I", "POSTROUTING"}, ruleArgs...)...); err != nil {
-				return fmt.Errorf("failed to add ingress localhost POSTROUTING rule for %s: %v", oifName, err)
-			}
+		masq := firewall.Rule{SrcType: "LOCAL", OutIface: oifName, Jump: "MASQUERADE"}
+		if err := fw.ProgramRule(firewall.Nat, "POSTROUTING", firewall.Insert, masq); err != nil {
+			return fmt.Errorf("failed to add ingress localhost POSTROUTING rule for %s: %v", oifName, err)
 		}
+
+		var set firewall.RuleSet
+		set.AddChain(fw.Family(), firewall.Nat, ingressChain)
+		set.AddChain(fw.Family(), firewall.Filter, ingressChain)
+		set.AddBottom(fw.Family(), firewall.Nat, ingressChain, firewall.Rule{Jump: "RETURN"})
+		set.AddBottom(fw.Family(), firewall.Filter, ingressChain, firewall.Rule{Jump: "RETURN"})
+		set.Add(fw.Family(),
+			firewall.ChainRule{Table: firewall.Nat, Chain: "OUTPUT", Rule: firewall.Rule{DstType: "LOCAL", Jump: ingressChain}},
+			firewall.ChainRule{Table: firewall.Nat, Chain: "PREROUTING", Rule: firewall.Rule{DstType: "LOCAL", Jump: ingressChain}},
+			firewall.ChainRule{Table: firewall.Nat, Chain: "POSTROUTING", Rule: masq})
+		set.AddTop(fw.Family(), firewall.Filter, "FORWARD", firewall.RankIngress, jump)
+		ingressRuleSets[fw.Family()] = set
 	}
 
 	for _, iPort := range ingressPorts {
-		if iptables.ExistChain(ingressChain, iptables.Nat) {
-			rule := strings.Fields(fmt.Sprintf("-t nat %s %s -p %s --dport %d -j DNAT --to-destination %s:%d",
-				addDelOpt, ingressChain, strings.ToLower(PortConfig_Protocol_name[int32(iPort.Protocol)]), iPort.PublishedPort, gwIP, iPort.PublishedPort))
-			if err := iptables.RawCombinedOutput(rule...); err != nil {
-				errStr := fmt.Sprintf("setting up rule failed, %v: %v", rule, err)
-				if !isDelete {
-					return fmt.Errorf("%s", errStr)
-				}
-
-				logrus.Infof("%s", errStr)
-			}
+		proto := strings.ToLower(PortConfig_Protocol_name[int32(iPort.Protocol)])
+		port := int(iPort.PublishedPort)
+		key := fmt.Sprintf("%s/%s/%d", fw.Family(), proto, port)
+
+		var rules []firewall.ChainRule
+		if fw.ExistChain(firewall.Nat, ingressChain) {
+			rules = append(rules, firewall.ChainRule{Table: firewall.Nat, Chain: ingressChain, Rule: firewall.Rule{
+				Proto:         proto,
+				DstPort:       port,
+				Jump:          "DNAT",
+				ToDestination: net.JoinHostPort(gwIP.String(), strconv.Itoa(port)),
+			}})
 		}
 
 		// Filter table rules to allow a published service to be accessible in the local node from..
 		// 1) service tasks attached to other networks
 		// 2) unmanaged containers on bridge networks
-		rule := strings.Fields(fmt.Sprintf("%s %s -m state -p %s --sport %d --state ESTABLISHED,RELATED -j ACCEPT",
-			addDelOpt, ingressChain, strings.ToLower(PortConfig_Protocol_name[int32(iPort.Protocol)]), iPort.PublishedPort))
-		if err := iptables.RawCombinedOutput(rule...); err != nil {
-			errStr := fmt.Sprintf("setting up rule failed, %v: %v", rule, err)
-			if !isDelete {
-				return fmt.Errorf("%s", errStr)
-			}
-			logrus.Warnf("%s", errStr)
-		}
+		rules = append(rules,
+			firewall.ChainRule{Table: firewall.Filter, Chain: ingressChain, Rule: firewall.Rule{Proto: proto, SrcPort: port, CtState: []string{"ESTABLISHED", "RELATED"}, Jump: "ACCEPT"}},
+			firewall.ChainRule{Table: firewall.Filter, Chain: ingressChain, Rule: firewall.Rule{Proto: proto, DstPort: port, Jump: "ACCEPT"}})
 
-		rule = strings.Fields(fmt.Sprintf("%s %s -p %s --dport %d -j ACCEPT",
-			addDelOpt, ingressChain, strings.ToLower(PortConfig_Protocol_name[int32(iPort.Protocol)]), iPort.PublishedPort))
-		if err := iptables.RawCombinedOutput(rule...); err != nil {
-			errStr := fmt.Sprintf("setting up rule failed, %v: %v", rule, err)
-			if !isDelete {
-				return fmt.Errorf("%s", errStr)
+		for _, r := range rules {
+			if err := fw.Apply(r.Table, r.Chain, action, r.Rule); err != nil {
+				errStr := fmt.Sprintf("setting up rule failed, %v: %v", r.Rule, err)
+				if !isDelete {
+					return fmt.Errorf("%s", errStr)
+				}
+				logrus.Warnf("%s", errStr)
 			}
-
-			logrus.Warnf("%s", errStr)
 		}
 
-		if err := plumbProxy(iPort, isDelete); err != nil {
-			logrus.Warnf("failed to create proxy for port %d: %v", iPort.PublishedPort, err)
+		if isDelete {
+			delete(ingressPortRules, key)
+		} else {
+			ingressPortRules[key] = rules
 		}
 	}
 
@@ -458,15 +961,12 @@ func programIngress(gwIP net.IP, ingressPorts []*PortConfig, isDelete bool) erro
 // This chain has the rules to allow access to the published ports for swarm tasks
 // from local bridge networks and docker_gwbridge (ie:taks on other swarm netwroks)
 func arrangeIngressFilterRule() {
-	if iptables.ExistChain(ingressChain, iptables.Filter) {
-		if iptables.Exists(iptables.Filter, "FORWARD", "-j", ingressChain) {
-			if err := iptables.RawCombinedOutput("-D", "FORWARD", "-j", ingressChain); err != nil {
-				logrus.Warnf("failed to delete jump rule to ingressChain in filter table: %v", err)
+	for _, fw := range []firewall.Backend{firewall.Get(firewall.IPv4), firewall.Get(firewall.IPv6)} {
+		if fw.ExistChain(firewall.Filter, ingressChain) {
+			if err := firewall.EnsureJumpRule(fw, "FORWARD", ingressChain); err != nil {
+				logrus.Warnf("failed to add jump rule to ingressChain in filter table: %v", err)
 			}
 		}
-		if err := iptables.RawCombinedOutput("-I", "FORWARD", "-j", ingressChain); err != nil {
-			logrus.Warnf("failed to add jump rule to ingressChain in filter table: %v", err)
-		}
 	}
 }
 
@@ -569,7 +1069,7 @@ func readPortsFromFile(fileName string) ([]*PortConfig, error) {
 
 // Invoke fwmarker reexec routine to mark vip destined packets with
 // the passed firewall mark.
-func invokeFWMarker(path string, vip net.IP, fwMark uint32, ingressPorts []*PortConfig, eIP *net.IPNet, isDelete bool) error {
+func invokeFWMarker(path string, vip, vip6 net.IP, fwMark uint32, ingressPorts []*PortConfig, eIP, eIP6 *net.IPNet, isDelete bool) error {
 	var ingressPortsFile string
 
 	if len(ingressPorts) != 0 {
@@ -587,11 +1087,23 @@ func invokeFWMarker(path string, vip net.IP, fwMark uint32, ingressPorts []*Port
 		addDelOpt = "-D"
 	}
 
+	var vipStr, vip6Str, eIP6Str string
+	if len(vip) != 0 {
+		vipStr = vip.String()
+	}
+	if len(vip6) != 0 {
+		vip6Str = vip6.String()
+	}
+	if eIP6 != nil {
+		eIP6Str = eIP6.String()
+	}
+
 	cmd := &exec.Cmd{
 		Path:   reexec.Self(),
-		Args:   append([]string{"fwmarker"}, path, vip.String(), fmt.Sprintf("%d", fwMark), addDelOpt, ingressPortsFile, eIP.String()),
+		Args:   append([]string{"fwmarker"}, path, vipStr, fmt.Sprintf("%d", fwMark), addDelOpt, ingressPortsFile, eIP.String(), vip6Str, eIP6Str),
 		Stdout: os.Stdout,
 		Stderr: os.Stderr,
+		Env:    firewall.ReexecEnv(),
 	}
 
 	if err := cmd.Run(); err != nil {
@@ -606,6 +1118,11 @@ func fwMarker() {
 	runtime.LockOSThread()
 	defer runtime.UnlockOSThread()
 
+	if err := firewall.SetBackendFromEnv(); err != nil {
+		logrus.Error(err)
+		os.Exit(1)
+	}
+
 	if len(os.Args) < 7 {
 		logrus.Error("invalid number of arguments..")
 		os.Exit(1)
@@ -629,11 +1146,22 @@ func fwMarker() {
 	}
 	addDelOpt := os.Args[4]
 
-	rules := [][]string{}
+	// The IPv6 VIP and endpoint address are optional.
+	var vip6, eIP6Str string
+	if len(os.Args) > 8 {
+		vip6 = os.Args[7]
+		eIP6Str = os.Args[8]
+	}
+
+	action := firewall.Action(addDelOpt)
+	rules := []nsRule{}
 	for _, iPort := range ingressPorts {
-		rule := strings.Fields(fmt.Sprintf("-t mangle %s PREROUTING -p %s --dport %d -j MARK --set-mark %d",
-			addDelOpt, strings.ToLower(PortConfig_Protocol_name[int32(iPort.Protocol)]), iPort.PublishedPort, fwMark))
-		rules = append(rules, rule)
+		rules = append(rules, nsRule{firewall.Mangle, "PREROUTING", action, firewall.Rule{
+			Proto:   strings.ToLower(PortConfig_Protocol_name[int32(iPort.Protocol)]),
+			DstPort: int(iPort.PublishedPort),
+			Jump:    "MARK",
+			SetMark: ingressFwMark(uint32(fwMark), iPort),
+		}})
 	}
 
 	ns, err := netns.GetFromPath(os.Args[1])
@@ -648,17 +1176,23 @@ func fwMarker() {
 		os.Exit(4)
 	}
 
-	if addDelOpt == "-A" {
+	fw := firewall.Get(firewall.IPv4).Native()
+	// The rules matching the IPVS connections are programmed with iptables,
+	// nftables cannot express them.
+	ipvsFw := firewall.IPTables(firewall.IPv4).Native()
+	ipvsRules := []nsRule{}
+	if action == firewall.Append {
 		eIP, subnet, err := net.ParseCIDR(os.Args[6])
 		if err != nil {
 			logrus.Errorf("Failed to parse endpoint IP %s: %v", os.Args[6], err)
 			os.Exit(9)
 		}
 
-		ruleParams := strings.Fields(fmt.Sprintf("-m ipvs --ipvs -d %s -j SNAT --to-source %s", subnet, eIP))
-		if !iptables.Exists("nat", "POSTROUTING", ruleParams...) {
-			rule := append(strings.Fields("-t nat -A POSTROUTING"), ruleParams...)
-			rules = append(rules, rule)
+		// Only masqueraded connections are source natted, direct server
+		// return connections must keep the client address.
+		snat := firewall.Rule{IPVS: true, IPVSMethod: "MASQ", Dst: subnet.String(), Jump: "SNAT", ToSource: eIP.String()}
+		if !ipvsFw.Exists(firewall.Nat, "POSTROUTING", snat) {
+			ipvsRules = append(ipvsRules, nsRule{firewall.Nat, "POSTROUTING", firewall.Append, snat})
 
 			err := ioutil.WriteFile("/proc/sys/net/ipv4/vs/conntrack", []byte{'1', '\n'}, 0644)
 			if err != nil {
@@ -666,23 +1200,106 @@ func fwMarker() {
 				os.Exit(8)
 			}
 		}
+
+		// The connections of the ingress ports with the local traffic
+		// policy keep the client address.
+		if hasLocalTrafficPolicy(ingressPorts) {
+			accept := firewall.Rule{IPVS: true, Mark: ingressLocalFwMarkFlag, MarkMask: ingressLocalFwMarkFlag, Jump: "ACCEPT"}
+			if !ipvsFw.Exists(firewall.Nat, "POSTROUTING", accept) {
+				ipvsRules = append(ipvsRules, nsRule{firewall.Nat, "POSTROUTING", firewall.Insert, accept})
+			}
+		}
 	}
 
-	rule := strings.Fields(fmt.Sprintf("-t mangle %s OUTPUT -d %s/32 -j MARK --set-mark %d", addDelOpt, vip, fwMark))
-	rules = append(rules, rule)
+	// The service may only have an IPv6 virtual IP.
+	if vip != "" {
+		rules = append(rules,
+			nsRule{firewall.Mangle, "OUTPUT", action, firewall.Rule{Dst: vip + "/32", Jump: "MARK", SetMark: uint32(fwMark)}},
+			nsRule{firewall.Nat, "OUTPUT", action, firewall.Rule{Proto: "icmp", ICMPEcho: true, Dst: vip, Jump: "DNAT", ToDestination: "127.0.0.1"}})
+	}
 
-	rule = strings.Fields(fmt.Sprintf("-t nat %s OUTPUT -p icmp --icmp echo-request -d %s -j DNAT --to 127.0.0.1", addDelOpt, vip))
-	rules = append(rules, rule)
+	if err := applyNSRules(fw, rules); err != nil {
+		logrus.Errorf("setting up rules failed: %v", err)
+		os.Exit(5)
+	}
+	if err := applyNSRules(ipvsFw, ipvsRules); err != nil {
+		logrus.Errorf("setting up rules failed: %v", err)
+		os.Exit(5)
+	}
 
-	for _, rule := range rules {
-		if err := iptables.RawCombinedOutputNative(rule...); err != nil {
-			logrus.Errorf("setting up rule failed, %v: %v", rule, err)
-			os.Exit(5)
+	if vip6 == "" && eIP6Str == "" {
+		return
+	}
+
+	fw6 := firewall.Get(firewall.IPv6).Native()
+	ipvsFw6 := firewall.IPTables(firewall.IPv6).Native()
+	rules6 := []nsRule{}
+	ipvsRules6 := []nsRule{}
+	if eIP6Str != "" {
+		for _, iPort := range ingressPorts {
+			rules6 = append(rules6, nsRule{firewall.Mangle, "PREROUTING", action, firewall.Rule{
+				Proto:   strings.ToLower(PortConfig_Protocol_name[int32(iPort.Protocol)]),
+				DstPort: int(iPort.PublishedPort),
+				Jump:    "MARK",
+				SetMark: ingressFwMark(uint32(fwMark), iPort),
+			}})
+		}
+
+		if action == firewall.Append {
+			eIP6, subnet6, err := net.ParseCIDR(eIP6Str)
+			if err != nil {
+				logrus.Errorf("Failed to parse endpoint IPv6 %s: %v", eIP6Str, err)
+				os.Exit(9)
+			}
+
+			snat := firewall.Rule{IPVS: true, IPVSMethod: "MASQ", Dst: subnet6.String(), Jump: "SNAT", ToSource: eIP6.String()}
+			if !ipvsFw6.Exists(firewall.Nat, "POSTROUTING", snat) {
+				ipvsRules6 = append(ipvsRules6, nsRule{firewall.Nat, "POSTROUTING", firewall.Append, snat})
+			}
+
+			if hasLocalTrafficPolicy(ingressPorts) {
+				accept := firewall.Rule{IPVS: true, Mark: ingressLocalFwMarkFlag, MarkMask: ingressLocalFwMarkFlag, Jump: "ACCEPT"}
+				if !ipvsFw6.Exists(firewall.Nat, "POSTROUTING", accept) {
+					ipvsRules6 = append(ipvsRules6, nsRule{firewall.Nat, "POSTROUTING", firewall.Insert, accept})
+				}
+			}
 		}
 	}
+
+	if vip6 != "" {
+		rules6 = append(rules6,
+			nsRule{firewall.Mangle, "OUTPUT", action, firewall.Rule{Dst: vip6 + "/128", Jump: "MARK", SetMark: uint32(fwMark)}},
+			nsRule{firewall.Nat, "OUTPUT", action, firewall.Rule{Proto: "icmpv6", ICMPEcho: true, Dst: vip6, Jump: "DNAT", ToDestination: "::1"}})
+	}
+
+	if err := applyNSRules(fw6, rules6); err != nil {
+		logrus.Errorf("setting up rules failed: %v", err)
+		os.Exit(5)
+	}
+	if err := applyNSRules(ipvsFw6, ipvsRules6); err != nil {
+		logrus.Errorf("setting up rules failed: %v", err)
+		os.Exit(5)
+	}
+}
+
+// nsRule is a rule change programmed in a container namespace.
+type nsRule struct {
+	table  firewall.Table
+	chain  string
+	action firewall.Action
+	rule   firewall.Rule
+}
+
+// applyNSRules applies the rule changes in a single transaction.
+func applyNSRules(fw firewall.Backend, rules []nsRule) error {
+	tx := fw.NewTransaction()
+	for _, r := range rules {
+		tx.Add(r.table, r.chain, r.action, r.rule)
+	}
+	return tx.Commit()
 }
 
-func addRedirectRules(path string, eIP *net.IPNet, ingressPorts []*PortConfig) error {
+func addRedirectRules(path string, eIP, eIP6 *net.IPNet, lbIP, lbIP6 net.IP, ingressPorts []*PortConfig) error {
 	var ingressPortsFile string
 
 	if len(ingressPorts) != 0 {
@@ -694,11 +1311,23 @@ func addRedirectRules(path string, eIP *net.IPNet, ingressPorts []*PortConfig) e
 		defer os.Remove(ingressPortsFile)
 	}
 
+	var eIP6Str, lbIPStr, lbIP6Str string
+	if eIP6 != nil {
+		eIP6Str = eIP6.String()
+	}
+	if lbIP != nil {
+		lbIPStr = lbIP.String()
+	}
+	if lbIP6 != nil {
+		lbIP6Str = lbIP6.String()
+	}
+
 	cmd := &exec.Cmd{
 		Path:   reexec.Self(),
-		Args:   append([]string{"redirecter"}, path, eIP.String(), ingressPortsFile),
+		Args:   append([]string{"redirecter"}, path, eIP.String(), ingressPortsFile, eIP6Str, lbIPStr, lbIP6Str),
 		Stdout: os.Stdout,
 		Stderr: os.Stderr,
+		Env:    firewall.ReexecEnv(),
 	}
 
 	if err := cmd.Run(); err != nil {
@@ -713,6 +1342,11 @@ func redirecter() {
 	runtime.LockOSThread()
 	defer runtime.UnlockOSThread()
 
+	if err := firewall.SetBackendFromEnv(); err != nil {
+		logrus.Error(err)
+		os.Exit(1)
+	}
+
 	if len(os.Args) < 4 {
 		logrus.Error("invalid number of arguments..")
 		os.Exit(1)
@@ -734,19 +1368,30 @@ func redirecter() {
 		os.Exit(3)
 	}
 
-	rules := [][]string{}
-	for _, iPort := range ingressPorts {
-		rule := strings.Fields(fmt.Sprintf("-t nat -A PREROUTING -d %s -p %s --dport %d -j REDIRECT --to-port %d",
-			eIP.String(), strings.ToLower(PortConfig_Protocol_name[int32(iPort.Protocol)]), iPort.PublishedPort, iPort.TargetPort))
-		rules = append(rules, rule)
-		// Allow only incoming connections to exposed ports
-		iRule := strings.Fields(fmt.Sprintf("-I INPUT -d %s -p %s --dport %d -m conntrack --ctstate NEW,ESTABLISHED -j ACCEPT",
-			eIP.String(), strings.ToLower(PortConfig_Protocol_name[int32(iPort.Protocol)]), iPort.TargetPort))
-		rules = append(rules, iRule)
-		// Allow only outgoing connections from exposed ports
-		oRule := strings.Fields(fmt.Sprintf("-I OUTPUT -s %s -p %s --sport %d -m conntrack --ctstate ESTABLISHED -j ACCEPT",
-			eIP.String(), strings.ToLower(PortConfig_Protocol_name[int32(iPort.Protocol)]), iPort.TargetPort))
-		rules = append(rules, oRule)
+	// The IPv6 endpoint address is optional.
+	var eIP6 net.IP
+	if len(os.Args) > 4 && os.Args[4] != "" {
+		eIP6, _, err = net.ParseCIDR(os.Args[4])
+		if err != nil {
+			logrus.Errorf("Failed to parse endpoint IPv6 %s: %v", os.Args[4], err)
+			os.Exit(3)
+		}
+	}
+
+	// The address of the ingress sandbox is only passed for the
+	// ports with the local traffic policy.
+	var lbIP, lbIP6 net.IP
+	if len(os.Args) > 5 && os.Args[5] != "" {
+		if lbIP = net.ParseIP(os.Args[5]); lbIP == nil {
+			logrus.Errorf("Failed to parse ingress sandbox IP %s", os.Args[5])
+			os.Exit(3)
+		}
+	}
+	if len(os.Args) > 6 && os.Args[6] != "" {
+		if lbIP6 = net.ParseIP(os.Args[6]); lbIP6 == nil {
+			logrus.Errorf("Failed to parse ingress sandbox IPv6 %s", os.Args[6])
+			os.Exit(3)
+		}
 	}
 
 	ns, err := netns.GetFromPath(os.Args[1])
@@ -761,35 +1406,119 @@ func redirecter() {
 		os.Exit(5)
 	}
 
-	for _, rule := range rules {
-		if err := iptables.RawCombinedOutputNative(rule...); err != nil {
-			logrus.Errorf("setting up rule failed, %v: %v", rule, err)
-			os.Exit(6)
+	programRedirectRules(firewall.Get(firewall.IPv4).Native(), eIP, ingressPorts)
+	if lbIP != nil {
+		programLocalReplyRoute(firewall.IPv4, eIP, lbIP, ingressPorts)
+	}
+	if eIP6 != nil {
+		programRedirectRules(firewall.Get(firewall.IPv6).Native(), eIP6, ingressPorts)
+		if lbIP6 != nil {
+			programLocalReplyRoute(firewall.IPv6, eIP6, lbIP6, ingressPorts)
 		}
 	}
+}
+
+func programRedirectRules(fw firewall.Backend, eIP net.IP, ingressPorts []*PortConfig) {
+	rules := []nsRule{}
+	for _, iPort := range ingressPorts {
+		proto := strings.ToLower(PortConfig_Protocol_name[int32(iPort.Protocol)])
+		rules = append(rules, nsRule{firewall.Nat, "PREROUTING", firewall.Append, firewall.Rule{
+			Dst:     eIP.String(),
+			Proto:   proto,
+			DstPort: int(iPort.PublishedPort),
+			Jump:    "REDIRECT",
+			ToPort:  int(iPort.TargetPort),
+		}})
+		// Allow only incoming connections to exposed ports
+		rules = append(rules, nsRule{firewall.Filter, "INPUT", firewall.Insert, firewall.Rule{
+			Dst:     eIP.String(),
+			Proto:   proto,
+			DstPort: int(iPort.TargetPort),
+			CtState: []string{"NEW", "ESTABLISHED"},
+			Jump:    "ACCEPT",
+		}})
+		// Allow only outgoing connections from exposed ports
+		rules = append(rules, nsRule{firewall.Filter, "OUTPUT", firewall.Insert, firewall.Rule{
+			Src:     eIP.String(),
+			Proto:   proto,
+			SrcPort: int(iPort.TargetPort),
+			CtState: []string{"ESTABLISHED"},
+			Jump:    "ACCEPT",
+		}})
+	}
+
+	if err := applyNSRules(fw, rules); err != nil {
+		logrus.Errorf("setting up rules failed: %v", err)
+		os.Exit(6)
+	}
 
 	if len(ingressPorts) == 0 {
 		return
 	}
 
 	// Ensure blocking rules for anything else in/to ingress network
-	for _, rule := range [][]string{
-		{"-d", eIP.String(), "-p", "sctp", "-j", "DROP"},
-		{"-d", eIP.String(), "-p", "udp", "-j", "DROP"},
-		{"-d", eIP.String(), "-p", "tcp", "-j", "DROP"},
-	} {
-		if !iptables.ExistsNative(iptables.Filter, "INPUT", rule...) {
-			if err := iptables.RawCombinedOutputNative(append([]string{"-A", "INPUT"}, rule...)...); err != nil {
-				logrus.Errorf("setting up rule failed, %v: %v", rule, err)
-				os.Exit(7)
-			}
-		}
-		rule[0] = "-s"
-		if !iptables.ExistsNative(iptables.Filter, "OUTPUT", rule...) {
-			if err := iptables.RawCombinedOutputNative(append([]string{"-A", "OUTPUT"}, rule...)...); err != nil {
-				logrus.Errorf("setting up rule failed, %v: %v", rule, err)
-				os.Exit(8)
-			}
+	for _, proto := range []string{"sctp", "udp", "tcp"} {
+		rule := firewall.Rule{Dst: eIP.String(), Proto: proto, Jump: "DROP"}
+		if err := fw.ProgramRule(firewall.Filter, "INPUT", firewall.Append, rule); err != nil {
+			logrus.Errorf("setting up rule failed, %v: %v", rule, err)
+			os.Exit(7)
+		}
+		rule = firewall.Rule{Src: eIP.String(), Proto: proto, Jump: "DROP"}
+		if err := fw.ProgramRule(firewall.Filter, "OUTPUT", firewall.Append, rule); err != nil {
+			logrus.Errorf("setting up rule failed, %v: %v", rule, err)
+			os.Exit(8)
+		}
+	}
+}
+
+// programLocalReplyRoute routes the replies of the connections received on
+// the ingress ports with the local traffic policy back to the ingress
+// sandbox. Those connections keep the client address, so the default route
+// of the task would bypass the ingress sandbox.
+func programLocalReplyRoute(family firewall.Family, eIP, lbIP net.IP, ingressPorts []*PortConfig) {
+	fw := firewall.Get(family).Native()
+	rules := []nsRule{}
+	for _, iPort := range ingressPorts {
+		if iPort.TrafficPolicy != TrafficPolicyLocal {
+			continue
 		}
+		rules = append(rules, nsRule{firewall.Mangle, "PREROUTING", firewall.Append, firewall.Rule{
+			Dst:     eIP.String(),
+			Proto:   strings.ToLower(PortConfig_Protocol_name[int32(iPort.Protocol)]),
+			DstPort: int(iPort.PublishedPort),
+			Jump:    "CONNMARK",
+			SetMark: ingressLocalConnMark,
+		}})
+	}
+
+	restore := firewall.Rule{ConnMark: ingressLocalConnMark, Jump: "CONNMARK", RestoreMark: true}
+	if !fw.Exists(firewall.Mangle, "OUTPUT", restore) {
+		rules = append(rules, nsRule{firewall.Mangle, "OUTPUT", firewall.Append, restore})
+	}
+
+	if err := applyNSRules(fw, rules); err != nil {
+		logrus.Errorf("setting up rules failed: %v", err)
+		os.Exit(9)
+	}
+
+	rule := netlink.NewRule()
+	rule.Family = nl.FAMILY_V4
+	if family == firewall.IPv6 {
+		rule.Family = nl.FAMILY_V6
+	}
+	rule.Mark = ingressLocalConnMark
+	rule.Table = ingressLocalRouteTable
+	if err := netlink.RuleAdd(rule); err != nil && err != syscall.EEXIST {
+		logrus.Errorf("Failed to add ingress reply rule: %v", err)
+		os.Exit(10)
+	}
+
+	route := &netlink.Route{
+		Gw:    lbIP,
+		Table: ingressLocalRouteTable,
+	}
+	if err := netlink.RouteReplace(route); err != nil {
+		logrus.Errorf("Failed to add ingress reply route via %s: %v", lbIP, err)
+		os.Exit(11)
 	}
 }
@@ -0,0 +1,33 @@
+package libnetwork
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIngressLocalTrafficPolicy(t *testing.T) {
+	ports := []*PortConfig{
+		{Protocol: ProtocolTCP, TargetPort: 80, PublishedPort: 8080},
+		{Protocol: ProtocolUDP, TargetPort: 53, PublishedPort: 5353, TrafficPolicy: TrafficPolicyLocal},
+	}
+
+	assert.False(t, hasLocalTrafficPolicy(ports[:1]))
+	assert.True(t, hasLocalTrafficPolicy(ports))
+
+	assert.Equal(t, uint32(256), ingressFwMark(256, ports[0]))
+	assert.Equal(t, uint32(256|ingressLocalFwMarkFlag), ingressFwMark(256, ports[1]))
+
+	// The policy must survive the trip to the reexec'd fwmarker.
+	fileName, err := writePortsToFile(ports)
+	require.NoError(t, err)
+	defer os.Remove(fileName)
+
+	readPorts, err := readPortsFromFile(fileName)
+	require.NoError(t, err)
+	require.Len(t, readPorts, 2)
+	assert.Equal(t, TrafficPolicyCluster, readPorts[0].TrafficPolicy)
+	assert.Equal(t, TrafficPolicyLocal, readPorts[1].TrafficPolicy)
+}
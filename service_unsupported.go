@@ -21,5 +21,9 @@ func (c *controller) rmServiceBinding(name, sid, nid, eid string, vip net.IP, in
 func (sb *sandbox) populateLoadbalancers(ep *endpoint) {
 }
 
+func (sb *sandbox) setupDSRBackend(mode string) error {
+	return fmt.Errorf("load balancing mode %s is not supported", mode)
+}
+
 func arrangeIngressFilterRule() {
 }
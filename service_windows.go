@@ -1,6 +1,7 @@
 package libnetwork
 
 import (
+	"fmt"
 	"net"
 
 	"github.com/Microsoft/hcsshim"
@@ -19,7 +20,11 @@ func init() {
 	lbPolicylistMap = make(map[*loadBalancer]*policyLists)
 }
 
-func (n *network) addLBBackend(ip, vip net.IP, lb *loadBalancer, ingressPorts []*PortConfig) {
+func (n *network) addLBBackend(ip, ip6, vip net.IP, isLocal bool, lb *loadBalancer, ingressPorts []*PortConfig) {
+	// Only the IPv4 virtual IP is balanced by HNS.
+	if len(vip) == 0 {
+		return
+	}
 
 	if system.GetOSVersion().Build > 16236 {
 		lb.Lock()
@@ -117,11 +122,15 @@ func (n *network) addLBBackend(ip, vip net.IP, lb *loadBalancer, ingressPorts []
 	}
 }
 
-func (n *network) rmLBBackend(ip, vip net.IP, lb *loadBalancer, ingressPorts []*PortConfig, rmService bool, fullRemove bool) {
+func (n *network) rmLBBackend(ip, ip6, vip net.IP, lb *loadBalancer, ingressPorts []*PortConfig, rmService bool, fullRemove bool) {
+	if len(vip) == 0 {
+		return
+	}
+
 	if system.GetOSVersion().Build > 16236 {
 		if numEnabledBackends(lb) > 0 {
 			//Reprogram HNS (actually VFP) with the existing backends.
-			n.addLBBackend(ip, vip, lb, ingressPorts)
+			n.addLBBackend(ip, ip6, vip, false, lb, ingressPorts)
 		} else {
 			lb.Lock()
 			defer lb.Unlock()
@@ -159,5 +168,9 @@ func numEnabledBackends(lb *loadBalancer) int {
 func (sb *sandbox) populateLoadbalancers(ep *endpoint) {
 }
 
+func (sb *sandbox) setupDSRBackend(mode string) error {
+	return fmt.Errorf("load balancing mode %s is not supported", mode)
+}
+
 func arrangeIngressFilterRule() {
 }
@@ -89,6 +89,10 @@ type PortBinding struct {
 	HostIP      net.IP
 	HostPort    uint16
 	HostPortEnd uint16
+	// ProxyProtocol is the PROXY protocol version (1 or 2) used by the
+	// userland proxy to pass the client address to the container, 0 if
+	// disabled. It is only supported for TCP.
+	ProxyProtocol uint8
 }
 
 // HostAddr returns the host side transport address
@@ -122,12 +126,13 @@ func (p PortBinding) ContainerAddr() (net.Addr, error) {
 // GetCopy returns a copy of this PortBinding structure instance
 func (p *PortBinding) GetCopy() PortBinding {
 	return PortBinding{
-		Proto:       p.Proto,
-		IP:          GetIPCopy(p.IP),
-		Port:        p.Port,
-		HostIP:      GetIPCopy(p.HostIP),
-		HostPort:    p.HostPort,
-		HostPortEnd: p.HostPortEnd,
+		Proto:         p.Proto,
+		IP:            GetIPCopy(p.IP),
+		Port:          p.Port,
+		HostIP:        GetIPCopy(p.HostIP),
+		HostPort:      p.HostPort,
+		HostPortEnd:   p.HostPortEnd,
+		ProxyProtocol: p.ProxyProtocol,
 	}
 }
 
@@ -201,7 +206,8 @@ func (p *PortBinding) Equal(o *PortBinding) bool {
 	}
 
 	if p.Proto != o.Proto || p.Port != o.Port ||
-		p.HostPort != o.HostPort || p.HostPortEnd != o.HostPortEnd {
+		p.HostPort != o.HostPort || p.HostPortEnd != o.HostPortEnd ||
+		p.ProxyProtocol != o.ProxyProtocol {
 		return false
 	}
 
@@ -280,6 +286,39 @@ func ParseProtocol(s string) Protocol {
 	}
 }
 
+// PeeringRule limits the traffic a network peering allows to a protocol
+// and, for tcp, udp and sctp, to a destination port. A zero protocol or
+// port matches any.
+type PeeringRule struct {
+	Proto Protocol
+	Port  uint16
+}
+
+// Validate checks the protocol and the port of the peering rule.
+func (r PeeringRule) Validate() error {
+	switch r.Proto {
+	case 0, ICMP:
+		if r.Port != 0 {
+			return BadRequestErrorf("a port requires the tcp, udp or sctp protocol: %s", r)
+		}
+	case TCP, UDP, SCTP:
+	default:
+		return BadRequestErrorf("unsupported peering protocol: %s", r.Proto)
+	}
+	return nil
+}
+
+func (r PeeringRule) String() string {
+	proto := "any"
+	if r.Proto != 0 {
+		proto = r.Proto.String()
+	}
+	if r.Port == 0 {
+		return proto
+	}
+	return fmt.Sprintf("%s/%d", proto, r.Port)
+}
+
 // GetMacCopy returns a copy of the passed MAC address
 func GetMacCopy(from net.HardwareAddr) net.HardwareAddr {
 	if from == nil {
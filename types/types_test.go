@@ -416,3 +416,34 @@ func TestParseCIDR(t *testing.T) {
 		}
 	}
 }
+
+func TestPeeringRuleValidate(t *testing.T) {
+	input := []struct {
+		rule  PeeringRule
+		valid bool
+		str   string
+	}{
+		{PeeringRule{}, true, "any"},
+		{PeeringRule{Proto: ICMP}, true, "icmp"},
+		{PeeringRule{Proto: TCP, Port: 80}, true, "tcp/80"},
+		{PeeringRule{Proto: UDP}, true, "udp"},
+		{PeeringRule{Port: 80}, false, "any/80"},
+		{PeeringRule{Proto: ICMP, Port: 8}, false, "icmp/8"},
+		{PeeringRule{Proto: 47}, false, "47"},
+	}
+
+	for _, i := range input {
+		err := i.rule.Validate()
+		if i.valid && err != nil {
+			t.Fatalf("Unexpected error validating %s: %v", i.rule, err)
+		}
+		if !i.valid {
+			if _, ok := err.(BadRequestError); !ok {
+				t.Fatalf("Expected a bad request error validating %s, got: %v", i.rule, err)
+			}
+		}
+		if i.rule.String() != i.str {
+			t.Fatalf("Unexpected string %q for %s", i.rule.String(), i.str)
+		}
+	}
+}